@@ -0,0 +1,167 @@
+package emailtracker
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AccessLogFormat selects the line format AccessLogConfig.Writer receives.
+type AccessLogFormat int
+
+const (
+	// AccessLogCombined is the Apache combined format followed by the
+	// latency in microseconds, as with "%D".
+	AccessLogCombined AccessLogFormat = iota
+	// AccessLogCommon is the Apache common format.
+	AccessLogCommon
+	// AccessLogJSON writes one JSON object per line, keyed like the Logger
+	// records, with the latency in microseconds.
+	AccessLogJSON
+)
+
+// Attribute keys of access log records, in addition to LogKeyIP.
+const (
+	LogKeyMethod    = "method"
+	LogKeyURI       = "uri"
+	LogKeyProto     = "proto"
+	LogKeyStatus    = "status"
+	LogKeyBytes     = "bytes"
+	LogKeyLatency   = "latency"
+	LogKeyReferer   = "referer"
+	LogKeyUserAgent = "user_agent"
+)
+
+// AccessLogConfig enables an access log of every request to the tracker's
+// routes, whether or not it produced an event. At least one of Writer and
+// Logger is required. The client IP is the one events get, reduced by
+// AnonymizeIP, and logged as "-" in PrivacyMode.
+type AccessLogConfig struct {
+	Writer io.Writer // receives one line per request in Format
+	Format AccessLogFormat
+
+	// Logger receives one Info record "access" per request. Latency is a
+	// time.Duration.
+	Logger *slog.Logger
+}
+
+// accessLog writes the access log; writes to Writer are serialized.
+type accessLog struct {
+	cfg AccessLogConfig
+	mu  sync.Mutex
+}
+
+type accessEntry struct {
+	r        *http.Request
+	ip       string
+	time     time.Time
+	status   int
+	bytes    int64
+	duration time.Duration
+}
+
+func (c *AccessLogConfig) validate() error {
+	if c.Writer == nil && c.Logger == nil {
+		return errors.New("emailtracker: AccessLog requires Writer or Logger")
+	}
+	if c.Format < AccessLogCombined || c.Format > AccessLogJSON {
+		return errors.New("emailtracker: unknown AccessLog.Format")
+	}
+	return nil
+}
+
+// logAccess wraps h to write the access log, if enabled.
+func (t *Tracker) logAccess(h http.HandlerFunc) http.HandlerFunc {
+	if t.access == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		ip := "-"
+		if !t.config.PrivacyMode {
+			ip, _ = t.clientIP(r)
+			ip = cmp.Or(t.anonymizeIP(ip), "-")
+		}
+		t.access.write(accessEntry{r: r, ip: ip, time: start, status: rec.code(), bytes: rec.bytes, duration: time.Since(start)})
+	}
+}
+
+func (l *accessLog) write(e accessEntry) {
+	r := e.r
+	if l.cfg.Logger != nil {
+		l.cfg.Logger.LogAttrs(r.Context(), slog.LevelInfo, "access",
+			slog.String(LogKeyIP, e.ip),
+			slog.String(LogKeyMethod, r.Method),
+			slog.String(LogKeyURI, r.RequestURI),
+			slog.String(LogKeyProto, r.Proto),
+			slog.Int(LogKeyStatus, e.status),
+			slog.Int64(LogKeyBytes, e.bytes),
+			slog.Duration(LogKeyLatency, e.duration),
+			slog.String(LogKeyReferer, r.Referer()),
+			slog.String(LogKeyUserAgent, r.UserAgent()),
+		)
+	}
+	if l.cfg.Writer == nil {
+		return
+	}
+	var b bytes.Buffer
+	switch l.cfg.Format {
+	case AccessLogJSON:
+		json.NewEncoder(&b).Encode(map[string]any{
+			"time":          e.time.Format(jsonTimeFormat),
+			LogKeyIP:        e.ip,
+			LogKeyMethod:    r.Method,
+			LogKeyURI:       r.RequestURI,
+			LogKeyProto:     r.Proto,
+			LogKeyStatus:    e.status,
+			LogKeyBytes:     e.bytes,
+			LogKeyLatency:   e.duration.Microseconds(),
+			LogKeyReferer:   r.Referer(),
+			LogKeyUserAgent: r.UserAgent(),
+		})
+	default:
+		b.WriteString(e.ip)
+		b.WriteString(" - - [")
+		b.WriteString(e.time.Format("02/Jan/2006:15:04:05 -0700"))
+		b.WriteString("] ")
+		b.WriteString(strconv.Quote(r.Method + " " + r.RequestURI + " " + r.Proto))
+		b.WriteByte(' ')
+		b.WriteString(strconv.Itoa(e.status))
+		b.WriteByte(' ')
+		if e.bytes == 0 {
+			b.WriteByte('-')
+		} else {
+			b.WriteString(strconv.FormatInt(e.bytes, 10))
+		}
+		if l.cfg.Format == AccessLogCombined {
+			b.WriteByte(' ')
+			b.WriteString(quoteOrDash(r.Referer()))
+			b.WriteByte(' ')
+			b.WriteString(quoteOrDash(r.UserAgent()))
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatInt(e.duration.Microseconds(), 10))
+		}
+		b.WriteByte('\n')
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.cfg.Writer.Write(b.Bytes())
+}
+
+// quoteOrDash quotes s for a log line, or returns "-" in quotes when empty,
+// as Apache does.
+func quoteOrDash(s string) string {
+	if s == "" {
+		return `"-"`
+	}
+	return strconv.Quote(s)
+}
@@ -0,0 +1,255 @@
+package emailtracker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RouteAdmin is the Metrics route label of the admin API.
+const RouteAdmin = "admin"
+
+const (
+	defaultAdminLimit  = 100
+	maxAdminLimit      = 1000
+	defaultAdminWindow = 24 * time.Hour
+)
+
+// EventPager is an optional extension of Store. If the configured Store
+// implements it, the admin API pages through an ID's events without
+// loading them all, as the sqlitestore and pgstore stores do; otherwise
+// each page is cut from ByID.
+type EventPager interface {
+	// PageByID returns at most limit events recorded for id, in the order
+	// they were stored, starting after cursor, "" for the first page. next
+	// is the cursor of the following page, "" after the last one.
+	PageByID(id, cursor string, limit int) (events []OpenEvent, next string, err error)
+}
+
+// Summary counts the stored events of a recent window, for the admin API.
+type Summary struct {
+	From       time.Time         `json:"from"`
+	To         time.Time         `json:"to"`
+	Events     int               `json:"events"`
+	Kinds      map[EventKind]int `json:"kinds"`
+	UniqueIDs  int               `json:"unique_ids"`
+	UniqueIPs  int               `json:"unique_ips"`
+	Bots       int               `json:"bots"`
+	FirstOpens int               `json:"first_opens"`
+}
+
+// rangeStore is implemented by stores that can read a time range directly,
+// such as those in the sqlitestore and pgstore sub-packages.
+type rangeStore interface {
+	Range(from, to time.Time) ([]OpenEvent, error)
+}
+
+// AdminHandler serves a read-only JSON API over the Store under
+// Config.AdminPath, for clients presenting Config.AdminToken as a bearer
+// token:
+//
+//	GET {AdminPath}/events?id=ID[&limit=N][&cursor=C]  an ID's events, a page at a time
+//	GET {AdminPath}/stats?id=ID                         Stats for an ID
+//	GET {AdminPath}/summary[?window=24h]                a Summary of the last window
+//
+// Pages hold at most limit events (default 100, at most 1000); the response
+// carries next_cursor until the last page. Admin requests never count as
+// opens. Without a Store every endpoint answers 501, and without an
+// AdminToken every request is refused.
+func (t *Tracker) AdminHandler() http.HandlerFunc {
+	return t.instrument(RouteAdmin, func(w http.ResponseWriter, r *http.Request) {
+		if !t.adminAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeAdminError(w, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		if t.config.Store == nil {
+			writeAdminError(w, http.StatusNotImplemented, "no Store configured")
+			return
+		}
+		query := r.URL.Query()
+		switch strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(t.config.AdminPath, "/")) {
+		case "/events":
+			t.adminEvents(w, query.Get("id"), query.Get("cursor"), query.Get("limit"))
+		case "/stats":
+			t.adminStats(w, query.Get("id"))
+		case "/summary":
+			t.adminSummary(w, query.Get("window"))
+		default:
+			writeAdminError(w, http.StatusNotFound, "not found")
+		}
+	})
+}
+
+func (t *Tracker) adminAuthorized(r *http.Request) bool {
+	if t.config.AdminToken == "" {
+		return false // an empty token would match an empty bearer
+	}
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return ok && subtle.ConstantTimeCompare([]byte(token), []byte(t.config.AdminToken)) == 1
+}
+
+func (t *Tracker) adminEvents(w http.ResponseWriter, id, cursor, limitParam string) {
+	if id == "" {
+		writeAdminError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	limit := defaultAdminLimit
+	if limitParam != "" {
+		n, err := strconv.Atoi(limitParam)
+		if err != nil || n <= 0 {
+			writeAdminError(w, http.StatusBadRequest, "bad limit")
+			return
+		}
+		limit = min(n, maxAdminLimit)
+	}
+	events, next, err := t.pageByID(id, cursor, limit)
+	if errors.Is(err, ErrBadCursor) {
+		writeAdminError(w, http.StatusBadRequest, "bad cursor")
+		return
+	}
+	if err != nil {
+		t.adminStoreError(w, err)
+		return
+	}
+	if events == nil {
+		events = []OpenEvent{}
+	}
+	writeAdminJSON(w, struct {
+		Events     []OpenEvent `json:"events"`
+		NextCursor string      `json:"next_cursor,omitempty"`
+	}{events, next})
+}
+
+// ErrBadCursor is returned by EventPager implementations for a cursor they
+// didn't produce.
+var ErrBadCursor = errors.New("emailtracker: bad cursor")
+
+// pageByID reads a page through the Store's EventPager, or by offset into
+// ByID.
+func (t *Tracker) pageByID(id, cursor string, limit int) ([]OpenEvent, string, error) {
+	if p, ok := t.config.Store.(EventPager); ok {
+		return p.PageByID(id, cursor, limit)
+	}
+	offset := 0
+	if cursor != "" {
+		n, err := strconv.Atoi(cursor)
+		if err != nil || n < 0 {
+			return nil, "", ErrBadCursor
+		}
+		offset = n
+	}
+	events, err := t.config.Store.ByID(id)
+	if err != nil || offset >= len(events) {
+		return nil, "", err
+	}
+	end := min(offset+limit, len(events))
+	next := ""
+	if end < len(events) {
+		next = strconv.Itoa(end)
+	}
+	return events[offset:end], next, nil
+}
+
+func (t *Tracker) adminStats(w http.ResponseWriter, id string) {
+	if id == "" {
+		writeAdminError(w, http.StatusBadRequest, "missing id")
+		return
+	}
+	st, err := t.Stats(id)
+	if err != nil {
+		t.adminStoreError(w, err)
+		return
+	}
+	writeAdminJSON(w, st)
+}
+
+func (t *Tracker) adminSummary(w http.ResponseWriter, windowParam string) {
+	window := defaultAdminWindow
+	if windowParam != "" {
+		d, err := time.ParseDuration(windowParam)
+		if err != nil || d <= 0 {
+			writeAdminError(w, http.StatusBadRequest, "bad window")
+			return
+		}
+		window = d
+	}
+	to := t.now()
+	s := Summary{From: to.Add(-window), To: to, Kinds: make(map[EventKind]int)}
+	ids := make(map[string]struct{})
+	ips := make(map[string]struct{})
+	add := func(e OpenEvent) {
+		kind := e.Kind
+		if kind == "" {
+			kind = EventOpen
+		}
+		s.Events++
+		s.Kinds[kind]++
+		ids[e.ID] = struct{}{}
+		if e.IP != "" {
+			ips[e.IP] = struct{}{}
+		}
+		if e.IsBot {
+			s.Bots++
+		}
+		if e.FirstOpen {
+			s.FirstOpens++
+		}
+	}
+	var err error
+	if rs, ok := t.config.Store.(rangeStore); ok {
+		var events []OpenEvent
+		if events, err = rs.Range(s.From, s.To); err == nil {
+			for _, e := range events {
+				add(e)
+			}
+		}
+	} else {
+		in := TimeRange{From: s.From, To: s.To}
+		err = t.config.Store.Each(func(e OpenEvent) bool {
+			if in.Contains(e.Time) {
+				add(e)
+			}
+			return true
+		})
+	}
+	if err != nil {
+		t.adminStoreError(w, err)
+		return
+	}
+	s.UniqueIDs, s.UniqueIPs = len(ids), len(ips)
+	writeAdminJSON(w, s)
+}
+
+func (t *Tracker) adminStoreError(w http.ResponseWriter, err error) {
+	if !errors.Is(err, ErrStoreRead) {
+		err = fmt.Errorf("%w: %w", ErrStoreRead, err)
+	}
+	t.reportError("store", err, nil)
+	writeAdminError(w, http.StatusInternalServerError, "store read failed")
+}
+
+func writeAdminJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeAdminError(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{msg})
+}
@@ -0,0 +1,112 @@
+package emailtracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestAdminHandlerWithoutTokenRefusesEveryone(t *testing.T) {
+	// AdminHandler mounted by hand, with no AdminPath to make Validate
+	// demand an AdminToken.
+	tr, _ := newTestTracker(t, Config{Store: NewMemoryStore(0)})
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	admin := tr.AdminHandler()
+	for _, header := range [][]string{
+		nil,
+		{"Authorization", "Bearer "},
+		{"Authorization", "Bearer"},
+		{"Authorization", ""},
+	} {
+		w := get(admin, "/events?id=msg-1", header...)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("with %q: status %d, want 401; body %s", header, w.Code, w.Body)
+		}
+	}
+}
+
+func TestAdminHandlerToken(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{Store: NewMemoryStore(0), AdminPath: "/admin", AdminToken: "s3cret"})
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	admin := tr.AdminHandler()
+	for _, tt := range []struct {
+		auth string
+		code int
+	}{
+		{"", http.StatusUnauthorized},
+		{"Bearer wrong", http.StatusUnauthorized},
+		{"Basic s3cret", http.StatusUnauthorized},
+		{"Bearer s3cret", http.StatusOK},
+	} {
+		w := get(admin, "/admin/events?id=msg-1", "Authorization", tt.auth)
+		if w.Code != tt.code {
+			t.Errorf("Authorization %q: status %d, want %d", tt.auth, w.Code, tt.code)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("Authorization %q: missing WWW-Authenticate", tt.auth)
+		}
+	}
+}
+
+func TestAdminEventsPages(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{Store: NewMemoryStore(0), AdminPath: "/admin", AdminToken: "s3cret"})
+	for i := range 5 {
+		get(tr.Handler(), tr.GenerateLink("msg-1"), "User-Agent", fmt.Sprint("client-", i))
+	}
+	admin := tr.AdminHandler()
+	var agents []string
+	cursor := ""
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatal("paging doesn't end")
+		}
+		w := get(admin, "/admin/events?id=msg-1&limit=2&cursor="+cursor, "Authorization", "Bearer s3cret")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+		var page struct {
+			Events     []OpenEvent `json:"events"`
+			NextCursor string      `json:"next_cursor"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+		if len(page.Events) > 2 {
+			t.Fatalf("page of %d events, limit 2", len(page.Events))
+		}
+		for _, e := range page.Events {
+			agents = append(agents, e.UserAgent)
+		}
+		if cursor = page.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if fmt.Sprint(agents) != "[client-0 client-1 client-2 client-3 client-4]" {
+		t.Errorf("paged events %v, want all five in order", agents)
+	}
+}
+
+func TestAdminErrors(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{Store: NewMemoryStore(0), AdminPath: "/admin", AdminToken: "s3cret"})
+	admin := tr.AdminHandler()
+	for path, code := range map[string]int{
+		"/admin/events":               http.StatusBadRequest,
+		"/admin/events?id=a&limit=0":  http.StatusBadRequest,
+		"/admin/events?id=a&cursor=x": http.StatusBadRequest,
+		"/admin/stats":                http.StatusBadRequest,
+		"/admin/summary?window=-1h":   http.StatusBadRequest,
+		"/admin/summary":              http.StatusOK,
+		"/admin/stats?id=a":           http.StatusOK,
+		"/admin/nope":                 http.StatusNotFound,
+	} {
+		if w := get(admin, path, "Authorization", "Bearer s3cret"); w.Code != code {
+			t.Errorf("GET %s: status %d, want %d", path, w.Code, code)
+		}
+	}
+
+	noStore, _ := newTestTracker(t, Config{AdminPath: "/admin", AdminToken: "s3cret"})
+	if w := get(noStore.AdminHandler(), "/admin/summary", "Authorization", "Bearer s3cret"); w.Code != http.StatusNotImplemented {
+		t.Errorf("without a Store: status %d, want 501", w.Code)
+	}
+}
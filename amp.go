@@ -0,0 +1,76 @@
+package emailtracker
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// SourceAMP is the OpenEvent.Source of opens from links built by
+// GenerateAMPPixelLink.
+const SourceAMP = "amp"
+
+// AMP link parameters. amp marks the link; the others carry values the AMP
+// runtime substitutes for its CLIENT_ID and RANDOM macros.
+const (
+	ampParam             = "amp"
+	ampClientIDParam     = "amp_cid"
+	ampRandomParam       = "amp_rnd"
+	ampSourceOriginParam = "__amp_source_origin"
+)
+
+// ampMacros is appended unencoded: the AMP runtime only substitutes macros
+// written out literally.
+const ampMacros = ampParam + "=1&" + ampClientIDParam + "=CLIENT_ID(emailtracker)&" + ampRandomParam + "=RANDOM"
+
+// GenerateAMPPixelLink is like GenerateLink but returns a src for
+// <amp-pixel> in the AMP part of an email. The AMP runtime fills in a
+// client ID and a cache-busting random number, recorded in OpenEvent.Params
+// as amp_cid and amp_rnd, and opens from the link have Source SourceAMP.
+func (t *Tracker) GenerateAMPPixelLink(id string, opts ...LinkOption) string {
+	link := t.GenerateLink(id, opts...)
+	sep := "&"
+	if !strings.Contains(link, "?") {
+		sep = "?"
+	}
+	return link + sep + ampMacros
+}
+
+// ampParams records the macro values of an AMP open in e.Params, leaving
+// out macros the client didn't substitute.
+func (t *Tracker) ampParams(e *OpenEvent, query url.Values) {
+	for _, name := range []string{ampClientIDParam, ampRandomParam} {
+		v := query.Get(name)
+		if v == "" || strings.Contains(v, "CLIENT_ID(") || v == "RANDOM" {
+			continue
+		}
+		if e.Params == nil {
+			e.Params = make(map[string]string, 2)
+		}
+		e.Params[name] = t.truncateParam(v)
+	}
+}
+
+// setAMPHeaders answers the AMP for Email CORS checks: version 1, where the
+// runtime passes the sender's origin in __amp_source_origin, and version
+// 2, where it sends AMP-Email-Sender. With AllowedOrigins, only allowed
+// origins are echoed back.
+func (t *Tracker) setAMPHeaders(w http.ResponseWriter, r *http.Request, query url.Values) {
+	h := w.Header()
+	if sender := r.Header.Get("Amp-Email-Sender"); sender != "" { // canonical key: no allocation
+		h.Set("AMP-Email-Allow-Sender", sender)
+	}
+	source := query.Get(ampSourceOriginParam)
+	if source == "" || (t.cors != nil && !t.cors.allows(source)) {
+		return
+	}
+	h.Set("AMP-Access-Control-Allow-Source-Origin", source)
+	h.Set("Access-Control-Expose-Headers", "AMP-Access-Control-Allow-Source-Origin")
+	if origin := r.Header.Get("Origin"); origin != "" && (t.cors == nil || t.cors.allows(origin)) {
+		if t.cors == nil {
+			h.Add("Vary", "Origin")
+		}
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,67 @@
+package emailtracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/netip"
+)
+
+// IPAnonymization selects how client IPs are reduced before events reach
+// subscribers.
+type IPAnonymization int
+
+const (
+	// AnonymizeNone keeps the full address.
+	AnonymizeNone IPAnonymization = iota
+	// AnonymizeTruncate zeroes the last octet of IPv4 and the last 80 bits
+	// of IPv6 addresses.
+	AnonymizeTruncate
+	// AnonymizeHash replaces the address with a salted HMAC-SHA256 digest.
+	AnonymizeHash
+)
+
+// anonymize reduces e's IP according to the configured mode. The raw
+// forwarding header and the hostname are dropped as they carry the same
+// information.
+func (t *Tracker) anonymize(e *OpenEvent) {
+	mode := t.config.AnonymizeIP
+	if mode == AnonymizeNone {
+		return
+	}
+	e.XForwardedFor = ""
+	e.Hostname = ""
+	e.IP = t.anonymizeIP(e.IP)
+}
+
+// anonymizeIP reduces ip according to the configured mode.
+func (t *Tracker) anonymizeIP(ip string) string {
+	if ip == "" {
+		return ""
+	}
+	switch t.config.AnonymizeIP {
+	case AnonymizeTruncate:
+		return truncateIP(ip)
+	case AnonymizeHash:
+		mac := hmac.New(sha256.New, t.config.AnonymizeSalt)
+		mac.Write([]byte(ip))
+		return hex.EncodeToString(mac.Sum(nil)[:16])
+	}
+	return ip
+}
+
+// truncateIP keeps the /24 of IPv4 and the /48 of IPv6 addresses. Values
+// that don't parse as an IP are dropped rather than passed through.
+func truncateIP(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap().WithZone("")
+	bits := 24
+	if addr.Is6() {
+		bits = 48
+	}
+	p, _ := addr.Prefix(bits)
+	return p.Addr().String()
+}
@@ -0,0 +1,82 @@
+package emailtracker
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestTruncateIP(t *testing.T) {
+	for ip, want := range map[string]string{
+		"203.0.113.77":                    "203.0.113.0",
+		"10.1.2.3":                        "10.1.2.0",
+		"::ffff:203.0.113.77":             "203.0.113.0",
+		"2001:db8:1234:5678:9abc:def0::1": "2001:db8:1234::",
+		"2001:db8::1":                     "2001:db8::",
+		"fe80::1%eth0":                    "fe80::",
+		"not an ip":                       "",
+	} {
+		if got := truncateIP(ip); got != want {
+			t.Errorf("truncateIP(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
+
+// ipRecorder is a GeoResolver remembering the addresses it was asked about.
+type ipRecorder struct {
+	mu  sync.Mutex
+	ips []string
+}
+
+func (r *ipRecorder) Resolve(ip string) (Geo, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ips = append(r.ips, ip)
+	return Geo{Country: "DE", City: "Berlin"}, nil
+}
+
+func TestAnonymizeTruncate(t *testing.T) {
+	for _, tt := range []struct{ ip, want string }{
+		{"203.0.113.77", "203.0.113.0"},
+		{"2001:db8:1234:5678::77", "2001:db8:1234::"},
+	} {
+		geo, store := &ipRecorder{}, NewMemoryStore(0)
+		tr, log := newTestTracker(t, Config{AnonymizeIP: AnonymizeTruncate, GeoResolver: geo, Store: store})
+		get(tr.Handler(), tr.GenerateLink("msg-1"), "X-Forwarded-For", tt.ip)
+
+		e := log.wait(t, 1)[0]
+		if e.IP != tt.want || e.XForwardedFor != "" {
+			t.Errorf("IP %q, XForwardedFor %q; want %q and none", e.IP, e.XForwardedFor, tt.want)
+		}
+		if e.Geo.Country != "DE" || len(geo.ips) != 1 || geo.ips[0] != tt.ip {
+			t.Errorf("geo %+v from lookups %q, want DE from the full address", e.Geo, geo.ips)
+		}
+		stored, _ := store.ByID("msg-1")
+		data, _ := json.Marshal(stored)
+		if len(stored) != 1 || strings.Contains(string(data), tt.ip) {
+			t.Errorf("store holds the raw address: %s", data)
+		}
+	}
+}
+
+func TestAnonymizeHash(t *testing.T) {
+	hash := func(salt, ip string) string {
+		tr, log := newTestTracker(t, Config{AnonymizeIP: AnonymizeHash, AnonymizeSalt: []byte(salt)})
+		get(tr.Handler(), tr.GenerateLink("msg-1"), "X-Forwarded-For", ip)
+		return log.wait(t, 1)[0].IP
+	}
+	a, b := hash("salt", "203.0.113.77"), hash("salt", "203.0.113.77")
+	if a != b || len(a) != 32 || strings.Contains(a, "203") {
+		t.Errorf("hashes %q and %q, want one stable 32 digit hex digest", a, b)
+	}
+	if c := hash("salt", "203.0.113.78"); c == a {
+		t.Error("two addresses hash alike")
+	}
+	if d := hash("pepper", "203.0.113.77"); d == a {
+		t.Error("the salt doesn't change the hash")
+	}
+	if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", AnonymizeIP: AnonymizeHash}); err == nil {
+		t.Error("New accepted AnonymizeHash without AnonymizeSalt")
+	}
+}
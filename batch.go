@@ -0,0 +1,163 @@
+package emailtracker
+
+import (
+	"context"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize     = 100
+	defaultBatchInterval = time.Second
+)
+
+// batcher buffers events for a SubscribeBatch callback and flushes them from
+// its own goroutine.
+type batcher struct {
+	t        *Tracker
+	size     int
+	interval time.Duration
+	fn       func([]OpenEvent)
+
+	mu     sync.Mutex
+	buf    []OpenEvent
+	closed bool
+
+	full chan struct{} // signalled when buf reaches size
+	stop chan struct{}
+	done chan struct{}
+}
+
+// SubscribeBatch registers fn to receive events in batches of at most size,
+// flushed as soon as size events are buffered or interval has passed,
+// whichever comes first. size and interval default to 100 and one second.
+// fn runs on a dedicated goroutine, one batch at a time, so a slow flush
+// never delays the pixel; events keep buffering meanwhile. Shutdown flushes
+// whatever is left.
+func (t *Tracker) SubscribeBatch(size int, interval time.Duration, fn func([]OpenEvent)) {
+	if fn == nil {
+		return
+	}
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	if interval <= 0 {
+		interval = defaultBatchInterval
+	}
+	b := &batcher{
+		t:        t,
+		size:     size,
+		interval: interval,
+		fn:       fn,
+		full:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	t.batchMu.Lock()
+	t.batchers = append(t.batchers, b)
+	t.batchMu.Unlock()
+	go b.run()
+	t.SubscribeContext(func(_ context.Context, e OpenEvent) error {
+		b.add(e)
+		return nil
+	})
+}
+
+func (b *batcher) add(e OpenEvent) {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		b.t.metrics.IncDropped(DropQueueFull)
+		return
+	}
+	b.buf = append(b.buf, e)
+	n := len(b.buf)
+	b.mu.Unlock()
+	b.t.addBuffered(1)
+	if n >= b.size {
+		select {
+		case b.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (b *batcher) run() {
+	defer close(b.done)
+	tick, stop := b.t.newTicker(b.interval)
+	defer stop()
+	for {
+		select {
+		case <-tick:
+		case <-b.full:
+		case <-b.stop:
+			b.flush()
+			return
+		}
+		b.flush()
+	}
+}
+
+// flush hands everything buffered to fn in chunks of at most size.
+func (b *batcher) flush() {
+	b.mu.Lock()
+	events := b.buf
+	b.buf = nil
+	b.mu.Unlock()
+	b.t.addBuffered(-len(events))
+	for len(events) > 0 {
+		n := min(len(events), b.size)
+		b.deliver(events[:n:n])
+		events = events[n:]
+	}
+}
+
+func (b *batcher) deliver(batch []OpenEvent) {
+	defer func() {
+		if p := recover(); p != nil {
+			b.t.reportError("callback", &PanicError{Value: p, Stack: debug.Stack()}, nil)
+		}
+	}()
+	b.fn(batch)
+}
+
+// close flushes the buffer and stops the flush goroutine.
+func (b *batcher) close(ctx context.Context) error {
+	b.mu.Lock()
+	if !b.closed {
+		b.closed = true
+		close(b.stop)
+	}
+	b.mu.Unlock()
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// addBuffered tracks the number of events waiting in batch buffers.
+func (t *Tracker) addBuffered(delta int) {
+	if delta == 0 {
+		return
+	}
+	n := t.buffered.Add(int64(delta))
+	if m, ok := t.config.Metrics.(BatchMetrics); ok {
+		m.SetBatchBuffered(int(n))
+	}
+}
+
+// closeBatchers flushes every SubscribeBatch buffer.
+func (t *Tracker) closeBatchers(ctx context.Context) error {
+	t.batchMu.Lock()
+	batchers := t.batchers
+	t.batchMu.Unlock()
+	for _, b := range batchers {
+		if err := b.close(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package emailtracker
+
+import "net/http"
+
+// modeParam selects the response with Config.Beacon, e.g. "mode=beacon".
+const modeParam = "mode"
+
+// beacon reports whether r asks for a 204 beacon response.
+func (t *Tracker) beacon(r *http.Request) bool {
+	return t.config.Beacon && r.URL.Query().Get(modeParam) == "beacon"
+}
+
+// writeResponse answers a pixel request: with 204 No Content for beacons,
+// otherwise with the pixel.
+func (t *Tracker) writeResponse(w http.ResponseWriter, r *http.Request, beacon bool) {
+	if !beacon {
+		t.writePixel(w, r)
+		return
+	}
+	t.setCacheHeaders(w)
+	w.WriteHeader(http.StatusNoContent)
+}
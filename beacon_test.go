@@ -0,0 +1,77 @@
+package emailtracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBeacon(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		cfg    Config
+		method string
+		mode   string
+		header []string
+		want   int // status; 200 means the gif
+	}{
+		{name: "beacon", cfg: Config{Beacon: true}, mode: "&mode=beacon", want: http.StatusNoContent},
+		{name: "beacon POST", cfg: Config{Beacon: true}, method: http.MethodPost, mode: "&mode=beacon", want: http.StatusNoContent},
+		{name: "beacon signed", cfg: Config{Beacon: true, SigningKey: []byte("secret")}, mode: "&mode=beacon", want: http.StatusNoContent},
+		{name: "no mode", cfg: Config{Beacon: true}, want: http.StatusOK},
+		{name: "other mode", cfg: Config{Beacon: true}, mode: "&mode=image", want: http.StatusOK},
+		{name: "Accept header", cfg: Config{Beacon: true}, header: []string{"Accept", "application/json"}, want: http.StatusOK},
+		{name: "disabled", mode: "&mode=beacon", want: http.StatusOK},
+		{name: "disabled POST", method: http.MethodPost, mode: "&mode=beacon", want: http.StatusMethodNotAllowed},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tr, log := newTestTracker(t, c.cfg)
+			method := c.method
+			if method == "" {
+				method = http.MethodGet
+			}
+			r := httptest.NewRequest(method, tr.GenerateLink("msg-1")+c.mode, nil)
+			for i := 0; i+1 < len(c.header); i += 2 {
+				r.Header.Set(c.header[i], c.header[i+1])
+			}
+			w := httptest.NewRecorder()
+			tr.Handler().ServeHTTP(w, r)
+			if w.Code != c.want {
+				t.Fatalf("status %d, want %d", w.Code, c.want)
+			}
+			events := len(log.all())
+			switch c.want {
+			case http.StatusNoContent:
+				if w.Body.Len() != 0 || w.Header().Get("Content-Type") != "" {
+					t.Errorf("beacon has %d body bytes, Content-Type %q", w.Body.Len(), w.Header().Get("Content-Type"))
+				}
+				if w.Header().Get("Cache-Control") == "" {
+					t.Error("beacon lacks Cache-Control")
+				}
+			case http.StatusOK:
+				if w.Header().Get("Content-Type") != "image/gif" || w.Body.Len() == 0 {
+					t.Errorf("Content-Type %q, %d body bytes, want the gif", w.Header().Get("Content-Type"), w.Body.Len())
+				}
+			default:
+				if events != 0 {
+					t.Errorf("%d events from a rejected request", events)
+				}
+				return
+			}
+			if events != 1 {
+				t.Errorf("%d events, want 1", events)
+			}
+		})
+	}
+}
+
+func TestBeaconWhenDropped(t *testing.T) {
+	tr, log := newTestTracker(t, Config{Beacon: true, DropBots: true})
+	w := get(tr.Handler(), tr.GenerateLink("msg-1")+"&mode=beacon", "User-Agent", "curl/8.0")
+	if w.Code != http.StatusNoContent || w.Body.Len() != 0 {
+		t.Errorf("dropped beacon: status %d, %d body bytes", w.Code, w.Body.Len())
+	}
+	if n := len(log.all()); n != 0 {
+		t.Errorf("%d events from a dropped bot", n)
+	}
+}
@@ -0,0 +1,71 @@
+package emailtracker
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// BotPattern names a User-Agent regular expression that identifies an
+// automated fetcher such as a link scanner. Patterns match case-insensitively.
+type BotPattern struct {
+	Name    string
+	Pattern string
+}
+
+// DefaultBotPatterns lists known security scanners, link checkers and
+// generic HTTP clients. Image proxies that fetch on behalf of a real reader,
+// like Gmail's, are deliberately not listed.
+var DefaultBotPatterns = []BotPattern{
+	{"Barracuda", `barracuda`},
+	{"Proofpoint", `proofpoint`},
+	{"Mimecast", `mimecast`},
+	{"Microsoft SafeLinks", `safelinks|microsoft office existence discovery`},
+	{"Cisco IronPort", `ironport`},
+	{"Symantec", `symantec|messagelabs`},
+	{"Trend Micro", `trend ?micro`},
+	{"Forcepoint", `forcepoint|websense`},
+	{"Sophos", `sophos`},
+	{"Fortinet", `fortinet|fortiguard`},
+	{"Zscaler", `zscaler`},
+	{"Google Safe Browsing", `google-safety|google-inspectiontool`},
+	{"Headless Chrome", `headlesschrome`},
+	{"PhantomJS", `phantomjs`},
+	{"curl", `^curl/`},
+	{"Wget", `^wget/`},
+	{"Python", `python-requests|python-urllib|aiohttp`},
+	{"Go", `^go-http-client/`},
+	{"Java", `^java/|apache-httpclient|okhttp`},
+	{"Generic bot", `\b(bot|scanner)\b|[a-z]bot/|crawler|spider`},
+}
+
+type botMatcher struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// compileBots compiles the configured patterns followed by the defaults.
+func compileBots(extra []BotPattern) ([]botMatcher, error) {
+	all := append(append([]BotPattern(nil), extra...), DefaultBotPatterns...)
+	out := make([]botMatcher, 0, len(all))
+	for _, p := range all {
+		re, err := regexp.Compile("(?i)" + p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("emailtracker: BotPatterns %q: %w", p.Name, err)
+		}
+		out = append(out, botMatcher{p.Name, re})
+	}
+	return out, nil
+}
+
+// detectBot returns the name of the first pattern matching ua, or "".
+func (t *Tracker) detectBot(ua string) string {
+	if ua == "" {
+		return ""
+	}
+	for _, b := range t.bots {
+		if b.re.MatchString(ua) {
+			return b.name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package emailtracker
+
+import "testing"
+
+func TestDetectBot(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{BotPatterns: []BotPattern{{"Acme", `acme-scanner`}}})
+	for _, tt := range []struct {
+		ua, want string
+	}{
+		{"", ""},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36", ""},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148", ""},
+		{"Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)", ""},
+		{"YahooMailProxy; https://help.yahoo.com/kb/yahoo-mail-proxy-SLN28749.html", ""},
+		{"Microsoft Office/16.0 (Windows NT 10.0; Microsoft Outlook 16.0.17328; Pro)", ""},
+		{"Mozilla/5.0 (Linux; Android 11; CUBOT X30) AppleWebKit/537.36 Chrome/96.0 Mobile Safari/537.36", ""},
+		{"Mozilla/5.0 (Linux; Android 10; Robotic Build) AppleWebKit/537.36", ""},
+		{"Microsoft Office Existence Discovery", "Microsoft SafeLinks"},
+		{"Mozilla/4.0 (compatible; ms-office; MSOffice 16) SafeLinks", "Microsoft SafeLinks"},
+		{"Barracuda Sentinel (EE)", "Barracuda"},
+		{"Mozilla/5.0 (compatible; TrendMicro)", "Trend Micro"},
+		{"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/120.0 Safari/537.36", "Headless Chrome"},
+		{"curl/8.4.0", "curl"},
+		{"Mozilla/5.0 curl/8.4.0", ""},
+		{"python-requests/2.31.0", "Python"},
+		{"Go-http-client/1.1", "Go"},
+		{"okhttp/4.12.0", "Java"},
+		{"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", "Generic bot"},
+		{"Mozilla/5.0 (compatible; Vulnerability Scanner)", "Generic bot"},
+		{"acme-scanner/1.0", "Acme"},
+	} {
+		if got := tr.detectBot(tt.ua); got != tt.want {
+			t.Errorf("detectBot(%q) = %q, want %q", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestBotEvents(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	get(tr.Handler(), tr.GenerateLink("msg-1"), "User-Agent", "Barracuda Sentinel (EE)")
+	get(tr.Handler(), tr.GenerateLink("msg-1"), "User-Agent", "Mozilla/5.0 (Macintosh) AppleWebKit/605.1.15")
+	events := log.wait(t, 2)
+	if !events[0].IsBot || events[0].BotName != "Barracuda" {
+		t.Errorf("scanner: IsBot %v, BotName %q", events[0].IsBot, events[0].BotName)
+	}
+	if events[1].IsBot || events[1].BotName != "" {
+		t.Errorf("browser: IsBot %v, BotName %q", events[1].IsBot, events[1].BotName)
+	}
+
+	drop, dropped := newTestTracker(t, Config{DropBots: true})
+	get(drop.Handler(), drop.GenerateLink("msg-1"), "User-Agent", "curl/8.4.0")
+	if got := len(dropped.all()); got != 0 {
+		t.Errorf("DropBots delivered %d events", got)
+	}
+}
+
+func TestInvalidBotPattern(t *testing.T) {
+	if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", BotPatterns: []BotPattern{{"bad", `(`}}}); err == nil {
+		t.Error("New accepted an invalid BotPatterns regexp")
+	}
+}
@@ -0,0 +1,56 @@
+package emailtracker
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+)
+
+// campaignPrefix marks tracking IDs built by CampaignID.
+const campaignPrefix = "cr1."
+
+// CampaignID packs campaign and recipient into one URL-safe tracking ID.
+// Both values are length-prefixed before encoding, so they may contain any
+// character, delimiters included.
+func CampaignID(campaign, recipient string) string {
+	var b []byte
+	for _, p := range [...]string{campaign, recipient} {
+		b = binary.AppendUvarint(b, uint64(len(p)))
+		b = append(b, p...)
+	}
+	return campaignPrefix + base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCampaignID unpacks an ID built by CampaignID. ok is false for any
+// other ID.
+func ParseCampaignID(id string) (campaign, recipient string, ok bool) {
+	enc, found := strings.CutPrefix(id, campaignPrefix)
+	if !found {
+		return "", "", false
+	}
+	b, err := base64.RawURLEncoding.DecodeString(enc)
+	if err != nil {
+		return "", "", false
+	}
+	var parts [2]string
+	for i := range parts {
+		n, k := binary.Uvarint(b)
+		if k <= 0 || n > uint64(len(b)-k) {
+			return "", "", false
+		}
+		parts[i] = string(b[k : k+int(n)])
+		b = b[k+int(n):]
+	}
+	if len(b) != 0 {
+		return "", "", false
+	}
+	return parts[0], parts[1], true
+}
+
+// GenerateLinkFor returns a pixel link whose ID is CampaignID(campaign,
+// recipient); the handler decodes it into OpenEvent.CampaignID and
+// RecipientID. params, which may be nil, are added as by
+// GenerateLinkWithParams.
+func (t *Tracker) GenerateLinkFor(campaign, recipient string, params map[string]string, opts ...LinkOption) string {
+	return t.GenerateLinkWithParams(CampaignID(campaign, recipient), params, opts...)
+}
@@ -0,0 +1,74 @@
+package emailtracker
+
+import (
+	"strings"
+	"testing"
+)
+
+var weirdPairs = [][2]string{
+	{"spring", "ana@example.com"},
+	{"", ""},
+	{"a:b", "c:d"},
+	{"cr1.x", "~"},
+	{"has\x00nul", "new\nline"},
+	{"日本語", "émoji 👋"},
+	{strings.Repeat("long", 300), "r"},
+	{"a", ""},
+	{"", "b"},
+}
+
+func TestCampaignIDRoundTrip(t *testing.T) {
+	seen := make(map[string][2]string)
+	for _, p := range weirdPairs {
+		id := CampaignID(p[0], p[1])
+		if other, dup := seen[id]; dup {
+			t.Errorf("%q and %q share ID %q", p, other, id)
+		}
+		seen[id] = p
+		if strings.ContainsAny(id, "&=?/# %+") {
+			t.Errorf("ID %q isn't URL-safe", id)
+		}
+		campaign, recipient, ok := ParseCampaignID(id)
+		if !ok || campaign != p[0] || recipient != p[1] {
+			t.Errorf("ParseCampaignID(CampaignID(%q, %q)) = %q, %q, %v", p[0], p[1], campaign, recipient, ok)
+		}
+	}
+}
+
+func TestParseCampaignIDRejects(t *testing.T) {
+	valid := CampaignID("spring", "ana")
+	for _, id := range []string{
+		"",
+		"msg-1",
+		"spring:ana",
+		"cr1.",
+		"cr1.!!!",
+		valid + "A",          // trailing data
+		valid[:len(valid)-2], // truncated
+		"cr1." + strings.Repeat("_", 8),
+	} {
+		if c, r, ok := ParseCampaignID(id); ok {
+			t.Errorf("ParseCampaignID(%q) = %q, %q, true", id, c, r)
+		}
+	}
+}
+
+func TestGenerateLinkFor(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	for _, p := range weirdPairs {
+		before := len(log.all())
+		get(tr.Handler(), tr.GenerateLinkFor(p[0], p[1], map[string]string{"variant": "b"}))
+		events := log.all()[before:]
+		if len(events) != 1 {
+			t.Fatalf("%q: %d events", p, len(events))
+		}
+		e := events[0]
+		if e.ID != CampaignID(p[0], p[1]) || e.CampaignID != p[0] || e.RecipientID != p[1] || e.Params["variant"] != "b" {
+			t.Errorf("%q: ID %q, CampaignID %q, RecipientID %q, Params %v", p, e.ID, e.CampaignID, e.RecipientID, e.Params)
+		}
+	}
+	get(tr.Handler(), tr.GenerateLink("plain"))
+	if e := log.all()[len(weirdPairs)]; e.CampaignID != "" || e.RecipientID != "" {
+		t.Errorf("plain ID decoded as campaign %q, recipient %q", e.CampaignID, e.RecipientID)
+	}
+}
@@ -0,0 +1,135 @@
+package emailtracker
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// CampaignTopN is the length of the top lists in a CampaignReport.
+const CampaignTopN = 10
+
+// TimeRange is the half-open interval [From, To). A zero bound leaves that
+// side open.
+type TimeRange struct {
+	From, To time.Time
+}
+
+// Contains reports whether t lies in r.
+func (r TimeRange) Contains(t time.Time) bool {
+	return (r.From.IsZero() || !t.Before(r.From)) && (r.To.IsZero() || t.Before(r.To))
+}
+
+// CampaignReport summarizes one campaign over a TimeRange: the sends
+// registered with SentAt in it and the opens that occurred in it.
+type CampaignReport struct {
+	Campaign        string
+	Window          TimeRange
+	Sends           int
+	TotalOpens      int
+	UniqueOpens     int            // IDs opened at least once
+	OpenRate        float64        // UniqueOpens over Sends, 0 without sends
+	OpensByDay      map[string]int // per UTC day, keyed "2006-01-02"
+	TopEmailClients []NameCount    // by OpenEvent.EmailClient, most opens first
+	TopCountries    []NameCount    // by OpenEvent.Geo.Country; needs a GeoResolver
+}
+
+// NameCount is the number of opens attributed to one name.
+type NameCount struct {
+	Name  string
+	Opens int
+}
+
+// CampaignStore is an optional extension of Store. If the configured Store
+// implements it, Report leaves the aggregation to it, as the sqlitestore and
+// pgstore stores do in SQL; otherwise Report scans every stored event. The
+// store fills in Sends from its SendStore, the counts and the top lists,
+// each at most CampaignTopN long, ordered by opens and then name.
+type CampaignStore interface {
+	CampaignReport(campaign string, window TimeRange) (CampaignReport, error)
+}
+
+// Report summarizes campaign over window from the Store: sends, unique and
+// total opens, the open rate, opens per day and the top email clients and
+// countries. Opens are events of kind EventOpen whose CampaignID, decoded
+// from IDs built by CampaignID, is campaign. A campaign without sends or
+// opens yields a zero report.
+func (t *Tracker) Report(campaign string, window TimeRange) (CampaignReport, error) {
+	if t.config.Store == nil {
+		return CampaignReport{}, ErrNoStore
+	}
+	var rep CampaignReport
+	var err error
+	if cs, ok := t.config.Store.(CampaignStore); ok {
+		rep, err = cs.CampaignReport(campaign, window)
+	} else {
+		rep, err = t.scanCampaign(campaign, window)
+	}
+	if err != nil {
+		return CampaignReport{}, fmt.Errorf("%w: %w", ErrStoreRead, err)
+	}
+	rep.Campaign, rep.Window = campaign, window
+	if rep.OpensByDay == nil {
+		rep.OpensByDay = make(map[string]int)
+	}
+	if rep.Sends > 0 {
+		rep.OpenRate = float64(rep.UniqueOpens) / float64(rep.Sends)
+	}
+	return rep, nil
+}
+
+// scanCampaign aggregates a campaign report by reading every stored event
+// and send once, for stores that don't implement CampaignStore.
+func (t *Tracker) scanCampaign(campaign string, window TimeRange) (CampaignReport, error) {
+	rep := CampaignReport{OpensByDay: make(map[string]int)}
+	err := t.sends.EachSend(func(s Send) bool {
+		if s.Campaign == campaign && window.Contains(s.SentAt) {
+			rep.Sends++
+		}
+		return true
+	})
+	if err != nil {
+		return rep, err
+	}
+	ids := make(map[string]struct{})
+	clients := make(map[string]int)
+	countries := make(map[string]int)
+	err = t.config.Store.Each(func(e OpenEvent) bool {
+		if (e.Kind != EventOpen && e.Kind != "") || e.CampaignID != campaign || !window.Contains(e.Time) {
+			return true
+		}
+		rep.TotalOpens++
+		ids[e.ID] = struct{}{}
+		rep.OpensByDay[e.Time.UTC().Format(time.DateOnly)]++
+		if e.EmailClient != "" {
+			clients[e.EmailClient]++
+		}
+		if e.Geo.Country != "" {
+			countries[e.Geo.Country]++
+		}
+		return true
+	})
+	rep.UniqueOpens = len(ids)
+	rep.TopEmailClients = topCounts(clients)
+	rep.TopCountries = topCounts(countries)
+	return rep, err
+}
+
+// topCounts returns the CampaignTopN names with the most opens.
+func topCounts(counts map[string]int) []NameCount {
+	var out []NameCount
+	for name, n := range counts {
+		out = append(out, NameCount{name, n})
+	}
+	slices.SortFunc(out, func(a, b NameCount) int {
+		if c := cmp.Compare(b.Opens, a.Opens); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	if len(out) > CampaignTopN {
+		out = out[:CampaignTopN]
+	}
+	return out
+}
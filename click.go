@@ -0,0 +1,84 @@
+package emailtracker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// urlParam is the query parameter carrying a click link's destination.
+const urlParam = "url"
+
+// GenerateClickLink returns a link to the click endpoint that records a click
+// for id and redirects to target. The target is signed into the link so the
+// endpoint can't be used as an open redirect. It requires Config.ClickPath
+// and Config.SigningKey.
+func (t *Tracker) GenerateClickLink(id, target string) (string, error) {
+	if t.config.ClickPath == "" {
+		return "", errors.New("emailtracker: GenerateClickLink requires Config.ClickPath")
+	}
+	if err := validTarget(target); err != nil {
+		return "", err
+	}
+	q := url.Values{}
+	q.Set(t.idParam, id)
+	q.Set(urlParam, target)
+	t.signQuery(q, string(EventClick), id, target)
+	return fmt.Sprintf("%s://%s%s?%s", t.scheme(), t.host(), t.config.ClickPath, q.Encode()), nil
+}
+
+func validTarget(target string) error {
+	u, err := url.Parse(target)
+	if err != nil {
+		return fmt.Errorf("emailtracker: click target: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("emailtracker: click target %q must be an absolute http(s) URL", target)
+	}
+	return nil
+}
+
+// ClickHandler records a click event and redirects to the signed target URL.
+// Links with a missing or invalid signature get 400 Bad Request, and methods
+// other than GET and HEAD get 405.
+func (t *Tracker) ClickHandler() http.HandlerFunc {
+	return t.instrument(RouteClick, func(w http.ResponseWriter, r *http.Request) {
+		if !allowGetHead(w, r) {
+			return
+		}
+		query := r.URL.Query()
+		id, target := t.queryID(query), query.Get(urlParam)
+		if !t.verifyQuery(query, string(EventClick), id, target) || validTarget(target) != nil {
+			t.warnRequest(r, "invalid click signature", id, nil)
+			t.reportError("signature", fmt.Errorf("%w: click id %q", ErrBadSignature, id), nil)
+			t.drop(r, DropInvalidSignature)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		t.traceID(r, id)
+		if !t.sampled(id) {
+			t.drop(r, DropSampled)
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		ip, ipSource := t.clientIP(r)
+		if t.deniedIP(ip) {
+			t.drop(r, DropDeniedIP)
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+		event := t.newEvent(r, id, ip, ipSource)
+		event.Kind = EventClick
+		event.URL = target
+		event.SampleRate = t.sampleRate()
+		t.traceEvent(r, &event)
+		if t.filtered(&event) {
+			t.traceOutcome(r, string(DropFiltered))
+		} else {
+			t.traceOutcome(r, outcomeTracked)
+			t.emit(r.Context(), event)
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
@@ -0,0 +1,71 @@
+package emailtracker
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// UnknownEmailClient is the EmailClient and EmailClientFamily of opens no
+// rule matches.
+const UnknownEmailClient = "unknown"
+
+// EmailClientRule names the mail client behind an open. It matches when its
+// User-Agent regular expression (case-insensitive) matches, or when Proxy
+// equals the event's ProxiedBy, for clients that always fetch through their
+// provider's image proxy. Family groups versions and platforms of a client,
+// e.g. "Outlook".
+type EmailClientRule struct {
+	Name    string
+	Family  string
+	Pattern string
+	Proxy   string
+}
+
+// DefaultEmailClientRules recognizes common mail clients. They are tried in
+// order, so specific clients come before the generic browser rule.
+var DefaultEmailClientRules = []EmailClientRule{
+	{Name: "Gmail", Family: "Gmail", Proxy: "gmail"},
+	{Name: "Yahoo Mail", Family: "Yahoo", Pattern: `yahoomailproxy`},
+	{Name: "Outlook mobile", Family: "Outlook", Pattern: `^outlook-(?:ios|android)/`},
+	{Name: "Outlook desktop", Family: "Outlook", Pattern: `microsoft outlook|\bms-office\b|\bmsoffice\b`},
+	{Name: "Thunderbird", Family: "Thunderbird", Pattern: `thunderbird/`},
+	{Name: "Apple Mail", Family: "Apple Mail", Pattern: appleMailUA.String()},
+	{Name: "Web browser", Family: "Webmail", Pattern: `^mozilla/5\.0 .*(?:chrome|firefox|safari|edg)/`},
+}
+
+type clientMatcher struct {
+	name, family, proxy string
+	re                  *regexp.Regexp
+}
+
+// compileClients compiles the configured rules followed by the defaults.
+func compileClients(extra []EmailClientRule) ([]clientMatcher, error) {
+	all := append(append([]EmailClientRule(nil), extra...), DefaultEmailClientRules...)
+	out := make([]clientMatcher, 0, len(all))
+	for _, c := range all {
+		m := clientMatcher{name: c.Name, family: c.Family, proxy: c.Proxy}
+		if m.family == "" {
+			m.family = c.Name
+		}
+		if c.Pattern != "" {
+			re, err := regexp.Compile("(?i)" + c.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("emailtracker: EmailClientRules %q: %w", c.Name, err)
+			}
+			m.re = re
+		}
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// detectClient returns the mail client and family of the first rule
+// matching e, or UnknownEmailClient for both.
+func (t *Tracker) detectClient(e *OpenEvent) (name, family string) {
+	for _, c := range t.clients {
+		if c.proxy != "" && c.proxy == e.ProxiedBy || c.re != nil && c.re.MatchString(e.UserAgent) {
+			return c.name, c.family
+		}
+	}
+	return UnknownEmailClient, UnknownEmailClient
+}
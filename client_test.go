@@ -0,0 +1,88 @@
+package emailtracker
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"testing"
+)
+
+// clientCorpus reads testdata/email_clients.txt.
+func clientCorpus(t *testing.T) [][2]string {
+	t.Helper()
+	f, err := os.Open("testdata/email_clients.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var corpus [][2]string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		client, ua, ok := strings.Cut(line, "\t")
+		if !ok {
+			t.Fatalf("malformed corpus line %q", line)
+		}
+		if ua == "-" {
+			ua = ""
+		}
+		corpus = append(corpus, [2]string{client, ua})
+	}
+	if err := sc.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return corpus
+}
+
+func TestEmailClientCorpus(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	families := map[string]string{
+		"Gmail": "Gmail", "Yahoo Mail": "Yahoo", "Outlook mobile": "Outlook", "Outlook desktop": "Outlook",
+		"Thunderbird": "Thunderbird", "Apple Mail": "Apple Mail", "Web browser": "Webmail", UnknownEmailClient: UnknownEmailClient,
+	}
+	for _, c := range clientCorpus(t) {
+		before := len(log.all())
+		get(tr.Handler(), tr.GenerateLink("msg-1"), "User-Agent", c[1])
+		e := log.all()[before]
+		if e.EmailClient != c[0] || e.EmailClientFamily != families[c[0]] {
+			t.Errorf("%q: EmailClient %q, family %q; want %q, %q", c[1], e.EmailClient, e.EmailClientFamily, c[0], families[c[0]])
+		}
+	}
+}
+
+func TestEmailClientRules(t *testing.T) {
+	tr, log := newTestTracker(t, Config{
+		ImageProxies: []ImageProxy{{Name: "relay", CIDRs: []string{"198.51.100.0/24"}}},
+		EmailClientRules: []EmailClientRule{
+			{Name: "Superhuman", Pattern: `superhuman/`},
+			{Name: "Relay client", Family: "Relay", Proxy: "relay"},
+			{Name: "Thunderbird beta", Family: "Thunderbird", Pattern: `thunderbird/\d+\.0b`}, // before the default
+		},
+	})
+	for _, c := range []struct {
+		ua, ip, client, family string
+	}{
+		{ua: "Superhuman/1.0", client: "Superhuman", family: "Superhuman"},
+		{ua: "Mozilla/5.0", ip: "198.51.100.9", client: "Relay client", family: "Relay"},
+		{ua: "Mozilla/5.0 (X11; Linux x86_64; rv:129.0) Gecko/20100101 Thunderbird/129.0b2", client: "Thunderbird beta", family: "Thunderbird"},
+		{ua: "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Thunderbird/128.3.1", client: "Thunderbird", family: "Thunderbird"},
+		{ua: "Outlook-Android/2.0", client: "Outlook mobile", family: "Outlook"},
+	} {
+		header := []string{"User-Agent", c.ua}
+		if c.ip != "" {
+			header = append(header, "X-Forwarded-For", c.ip)
+		}
+		before := len(log.all())
+		get(tr.Handler(), tr.GenerateLink("msg-1"), header...)
+		if e := log.all()[before]; e.EmailClient != c.client || e.EmailClientFamily != c.family {
+			t.Errorf("%q: EmailClient %q, family %q; want %q, %q", c.ua, e.EmailClient, e.EmailClientFamily, c.client, c.family)
+		}
+	}
+
+	if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", EmailClientRules: []EmailClientRule{{Name: "bad", Pattern: `[`}}}); err == nil {
+		t.Error("New accepted an invalid EmailClientRules pattern")
+	}
+}
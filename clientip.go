@@ -0,0 +1,249 @@
+package emailtracker
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// IPSourceRemoteAddr is the OpenEvent.IPSource value used when the client IP
+// came from the connection itself rather than a forwarding header.
+const IPSourceRemoteAddr = "RemoteAddr"
+
+// DefaultClientIPHeaders is the order in which forwarding headers are
+// consulted when Config.ClientIPHeaders is empty.
+var DefaultClientIPHeaders = []string{"Forwarded", "X-Forwarded-For", "X-Real-IP"}
+
+// cdnIPHeaders are the headers read with Config.CDNClientIP, in order of
+// precedence: Cloudflare's, Akamai's, then Fastly's.
+var cdnIPHeaders = []struct{ name, key string }{
+	{"CF-Connecting-IP", "Cf-Connecting-Ip"},
+	{"True-Client-IP", "True-Client-Ip"},
+	{"Fastly-Client-IP", "Fastly-Client-Ip"},
+}
+
+// parsePrefixes parses CIDRs or bare IPs (treated as single-host prefixes).
+func parsePrefixes(field string, values []string) ([]netip.Prefix, error) {
+	var out []netip.Prefix
+	for _, v := range values {
+		v = strings.TrimSpace(v)
+		if p, err := netip.ParsePrefix(v); err == nil {
+			out = append(out, p.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("emailtracker: %s: invalid CIDR or IP %q", field, v)
+		}
+		addr = addr.Unmap()
+		out = append(out, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return out, nil
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// overUnixSocket reports whether r arrived on a unix domain socket, where
+// the peer is the local reverse proxy and RemoteAddr names no client.
+func overUnixSocket(r *http.Request) bool {
+	_, ok := r.Context().Value(http.LocalAddrContextKey).(*net.UnixAddr)
+	return ok
+}
+
+// remoteAddr returns the direct peer's address without the port.
+func remoteAddr(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// clientIP resolves the address of the client that loaded the pixel and the
+// header (or IPSourceRemoteAddr) it was taken from. With CDNClientIP the CDN
+// headers come first, from trusted peers only; then headers are consulted in
+// ClientIPHeaders order. Without TrustedProxies the left-most public address
+// wins; with them, headers are only read when the peer is trusted and the
+// chain is walked right to left past trusted hops. Over a unix socket the
+// headers are all there is: without one the IP is left empty.
+func (t *Tracker) clientIP(r *http.Request) (ip, source string) {
+	peer, peerSource := remoteAddr(r), IPSourceRemoteAddr
+	if overUnixSocket(r) {
+		peer, peerSource = "", ""
+	}
+	trusted := len(t.trusted) > 0
+	if !t.trustedPeer(r) {
+		return peer, peerSource
+	}
+	if t.config.CDNClientIP && trusted {
+		for _, h := range cdnIPHeaders {
+			// A CDN sets the header once; more than one wasn't its doing.
+			if v := r.Header[h.key]; len(v) == 1 {
+				if addr, ok := parseHop(v[0]); ok {
+					return addr.String(), h.name
+				}
+			}
+		}
+	}
+	for i, name := range t.ipHeaders {
+		hops := headerHops(r, t.ipKeys[i])
+		if len(hops) == 0 {
+			continue
+		}
+		var addr netip.Addr
+		var ok bool
+		if trusted {
+			addr, ok = t.rightmostUntrusted(hops)
+		} else {
+			addr, ok = leftmostPublic(hops)
+		}
+		if ok {
+			return addr.String(), name
+		}
+	}
+	return peer, peerSource
+}
+
+// cdnCountry returns the CF-IPCountry code of a request from a trusted
+// peer, or "" for none and for Cloudflare's XX (unknown) and T1 (Tor).
+func (t *Tracker) cdnCountry(r *http.Request) string {
+	if len(t.trusted) == 0 || !t.trustedPeer(r) {
+		return ""
+	}
+	v := r.Header.Get("Cf-Ipcountry")
+	if len(v) != 2 || !isLetter(v[0]) || !isLetter(v[1]) || strings.EqualFold(v, "XX") {
+		return ""
+	}
+	return strings.ToUpper(v)
+}
+
+func isLetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+// trustedPeer reports whether forwarding headers from r's direct peer are
+// honored: always without TrustedProxies or over a unix socket, otherwise
+// only from those.
+func (t *Tracker) trustedPeer(r *http.Request) bool {
+	if len(t.trusted) == 0 || overUnixSocket(r) {
+		return true
+	}
+	addr, err := netip.ParseAddr(remoteAddr(r))
+	return err == nil && containsAddr(t.trusted, addr)
+}
+
+// headerHops returns the raw address entries of a forwarding header, given
+// by its canonical key, client first.
+func headerHops(r *http.Request, key string) []string {
+	values := r.Header[key]
+	if len(values) == 0 {
+		return nil
+	}
+	if key == "Forwarded" {
+		return forwardedFor(values)
+	}
+	return strings.Split(strings.Join(values, ","), ",")
+}
+
+// forwardedFor extracts the for= parameters of RFC 7239 Forwarded headers.
+func forwardedFor(values []string) []string {
+	return forwardedParam(values, "for")
+}
+
+// forwardedParam extracts the key= parameters of RFC 7239 Forwarded
+// headers, client first.
+func forwardedParam(values []string, key string) []string {
+	var hops []string
+	for _, v := range values {
+		for _, elem := range splitQuoted(v, ',') {
+			for _, pair := range splitQuoted(elem, ';') {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, key) {
+					hops = append(hops, strings.Trim(val, `"`))
+				}
+			}
+		}
+	}
+	return hops
+}
+
+// splitQuoted splits s on sep, ignoring separators inside double quotes.
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// rightmostUntrusted walks hops right to left past trusted proxies and returns
+// the first address that isn't one; if every hop is trusted it returns the
+// left-most. An unparseable hop ends the walk, since nothing left of it can be
+// vouched for.
+func (t *Tracker) rightmostUntrusted(hops []string) (netip.Addr, bool) {
+	var client netip.Addr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, ok := parseHop(hops[i])
+		if !ok {
+			break
+		}
+		client = hop
+		if !containsAddr(t.trusted, hop) {
+			break
+		}
+	}
+	return client, client.IsValid()
+}
+
+// leftmostPublic returns the left-most public address in hops, falling back
+// to the left-most valid one.
+func leftmostPublic(hops []string) (netip.Addr, bool) {
+	var first netip.Addr
+	for _, h := range hops {
+		addr, ok := parseHop(h)
+		if !ok {
+			continue
+		}
+		if isPublic(addr) {
+			return addr, true
+		}
+		if !first.IsValid() {
+			first = addr
+		}
+	}
+	return first, first.IsValid()
+}
+
+// parseHop parses one forwarded address, tolerating ports and brackets.
+func parseHop(s string) (netip.Addr, bool) {
+	s = strings.TrimSpace(s)
+	if ap, err := netip.ParseAddrPort(s); err == nil {
+		return ap.Addr().Unmap(), true
+	}
+	addr, err := netip.ParseAddr(strings.Trim(s, "[]"))
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return addr.Unmap(), true
+}
+
+func isPublic(addr netip.Addr) bool {
+	return addr.IsGlobalUnicast() && !addr.IsPrivate()
+}
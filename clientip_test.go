@@ -0,0 +1,235 @@
+package emailtracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// cloudflare is a range of Cloudflare's edge, trusted by the CDN tests.
+const cloudflare = "173.245.48.0/20"
+
+func TestClientIPForwardedFor(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	for _, tt := range []struct {
+		name string
+		xff  string
+		ip   string
+	}{
+		{"single", "203.0.113.7", "203.0.113.7"},
+		{"left-most public", "203.0.113.7, 10.0.0.1, 172.16.0.2", "203.0.113.7"},
+		{"private first", "10.0.0.1, 192.168.1.1, 203.0.113.7", "203.0.113.7"},
+		{"only private", "10.0.0.1, 192.168.1.1", "10.0.0.1"},
+		{"malformed entries", "unknown, , 999.1.1.1, 203.0.113.7", "203.0.113.7"},
+		{"port", "203.0.113.7:5000", "203.0.113.7"},
+		{"IPv6", "2001:db8::7", "2001:db8::7"},
+		{"bracketed IPv6 with port", "[2001:db8::7]:5000, 10.0.0.1", "2001:db8::7"},
+		{"bracketed IPv6", "[2001:db8::7]", "2001:db8::7"},
+		{"IPv4-mapped", "::ffff:203.0.113.7", "203.0.113.7"},
+		{"loopback skipped", "127.0.0.1, 198.51.100.4", "198.51.100.4"},
+		{"nothing valid", "junk, also junk", "192.0.2.1"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(log.all())
+			get(tr.Handler(), tr.GenerateLink("msg-1"), "X-Forwarded-For", tt.xff)
+			e := log.all()[before]
+			if e.IP != tt.ip {
+				t.Errorf("IP %q, want %q", e.IP, tt.ip)
+			}
+			if e.XForwardedFor != tt.xff {
+				t.Errorf("XForwardedFor %q, want the raw header %q", e.XForwardedFor, tt.xff)
+			}
+		})
+	}
+}
+
+func TestClientIPTrustedProxies(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{TrustedProxies: []string{"10.0.0.0/8", "2001:db8:ffff::/48"}})
+	for _, tt := range []struct {
+		name       string
+		remote     string
+		xff        string
+		ip, source string
+	}{
+		{"no header", "10.0.0.1:1234", "", "10.0.0.1", IPSourceRemoteAddr},
+		{"trusted proxy", "10.0.0.1:1234", "203.0.113.7", "203.0.113.7", "X-Forwarded-For"},
+		{"spoofed from untrusted peer", "198.51.100.9:1234", "203.0.113.7", "198.51.100.9", IPSourceRemoteAddr},
+		{"spoofed from untrusted IPv6 peer", "[2001:db8::1]:1234", "203.0.113.7", "2001:db8::1", IPSourceRemoteAddr},
+		{"multi-hop", "10.0.0.1:1234", "203.0.113.7, 10.0.0.3, 10.0.0.2", "203.0.113.7", "X-Forwarded-For"},
+		{"client-forged left entry", "10.0.0.1:1234", "1.2.3.4, 203.0.113.7, 10.0.0.2", "203.0.113.7", "X-Forwarded-For"},
+		{"all hops trusted", "10.0.0.1:1234", "10.0.0.3, 10.0.0.2", "10.0.0.3", "X-Forwarded-For"},
+		{"garbage stops the walk", "10.0.0.1:1234", "203.0.113.7, junk, 10.0.0.2", "10.0.0.2", "X-Forwarded-For"},
+		{"trusted IPv6 proxy", "[2001:db8:ffff::1]:443", "2001:db8:1::7", "2001:db8:1::7", "X-Forwarded-For"},
+		{"IPv6 hops", "[2001:db8:ffff::1]:443", "[2001:db8:1::7]:5000, 2001:db8:ffff::2", "2001:db8:1::7", "X-Forwarded-For"},
+		{"IPv4-mapped peer", "[::ffff:10.0.0.1]:1234", "203.0.113.7", "203.0.113.7", "X-Forwarded-For"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/pixel", nil)
+			r.RemoteAddr = tt.remote
+			if tt.xff != "" {
+				r.Header.Set("X-Forwarded-For", tt.xff)
+			}
+			ip, source := tr.clientIP(r)
+			if ip != tt.ip || source != tt.source {
+				t.Errorf("clientIP = %s from %s, want %s from %s", ip, source, tt.ip, tt.source)
+			}
+		})
+	}
+}
+
+func TestInvalidTrustedProxies(t *testing.T) {
+	if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", TrustedProxies: []string{"10.0.0.0/33"}}); err == nil {
+		t.Error("New accepted an invalid TrustedProxies CIDR")
+	}
+}
+
+func TestClientIPCDNHeaders(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{TrustedProxies: []string{cloudflare}, CDNClientIP: true})
+	for _, tt := range []struct {
+		name       string
+		remote     string
+		header     http.Header
+		ip, source string
+	}{
+		{
+			name:   "cloudflare",
+			remote: "173.245.48.5:443",
+			header: http.Header{"Cf-Connecting-Ip": {"203.0.113.7"}, "X-Forwarded-For": {"198.51.100.1"}},
+			ip:     "203.0.113.7", source: "CF-Connecting-IP",
+		},
+		{
+			name:   "cloudflare first",
+			remote: "173.245.48.5:443",
+			header: http.Header{"Cf-Connecting-Ip": {"203.0.113.7"}, "True-Client-Ip": {"203.0.113.8"}, "Fastly-Client-Ip": {"203.0.113.9"}},
+			ip:     "203.0.113.7", source: "CF-Connecting-IP",
+		},
+		{
+			name:   "akamai",
+			remote: "173.245.48.5:443",
+			header: http.Header{"True-Client-Ip": {"203.0.113.8"}, "Fastly-Client-Ip": {"203.0.113.9"}},
+			ip:     "203.0.113.8", source: "True-Client-IP",
+		},
+		{
+			name:   "fastly",
+			remote: "173.245.48.5:443",
+			header: http.Header{"Fastly-Client-Ip": {"2001:db8::9"}},
+			ip:     "2001:db8::9", source: "Fastly-Client-IP",
+		},
+		{
+			name:   "invalid CDN header falls through",
+			remote: "173.245.48.5:443",
+			header: http.Header{"Cf-Connecting-Ip": {"not-an-ip"}, "X-Forwarded-For": {"198.51.100.1"}},
+			ip:     "198.51.100.1", source: "X-Forwarded-For",
+		},
+		{
+			name:   "repeated CDN header is ignored",
+			remote: "173.245.48.5:443",
+			header: http.Header{"Cf-Connecting-Ip": {"203.0.113.7", "203.0.113.66"}, "X-Forwarded-For": {"198.51.100.1"}},
+			ip:     "198.51.100.1", source: "X-Forwarded-For",
+		},
+		{
+			name:   "spoofed cloudflare header from an untrusted peer",
+			remote: "198.18.0.1:50000",
+			header: http.Header{"Cf-Connecting-Ip": {"203.0.113.7"}},
+			ip:     "198.18.0.1", source: IPSourceRemoteAddr,
+		},
+		{
+			name:   "spoofed akamai header from an untrusted peer",
+			remote: "198.18.0.1:50000",
+			header: http.Header{"True-Client-Ip": {"203.0.113.8"}},
+			ip:     "198.18.0.1", source: IPSourceRemoteAddr,
+		},
+		{
+			name:   "spoofed fastly header from an untrusted peer",
+			remote: "[2001:db8::1]:50000",
+			header: http.Header{"Fastly-Client-Ip": {"203.0.113.9"}, "X-Forwarded-For": {"203.0.113.10"}},
+			ip:     "2001:db8::1", source: IPSourceRemoteAddr,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/pixel", nil)
+			r.RemoteAddr, r.Header = tt.remote, tt.header
+			ip, source := tr.clientIP(r)
+			if ip != tt.ip || source != tt.source {
+				t.Errorf("clientIP = %s from %s, want %s from %s", ip, source, tt.ip, tt.source)
+			}
+		})
+	}
+}
+
+func TestClientIPCDNHeadersOff(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{TrustedProxies: []string{cloudflare}})
+	r := httptest.NewRequest(http.MethodGet, "/pixel", nil)
+	r.RemoteAddr = "173.245.48.5:443"
+	r.Header = http.Header{"Cf-Connecting-Ip": {"203.0.113.7"}, "X-Forwarded-For": {"198.51.100.1"}}
+	if ip, source := tr.clientIP(r); ip != "198.51.100.1" || source != "X-Forwarded-For" {
+		t.Errorf("clientIP = %s from %s, want the X-Forwarded-For address", ip, source)
+	}
+}
+
+func TestCDNCountry(t *testing.T) {
+	tr, log := newTestTracker(t, Config{TrustedProxies: []string{cloudflare}, CDNClientIP: true, CDNCountry: true})
+	for _, tt := range []struct {
+		name, remote, country, want string
+	}{
+		{"trusted", "173.245.48.5:443", "DE", "DE"},
+		{"lower case", "173.245.48.5:443", "fr", "FR"},
+		{"unknown", "173.245.48.5:443", "XX", ""},
+		{"tor", "173.245.48.5:443", "T1", ""},
+		{"malformed", "173.245.48.5:443", "DEU", ""},
+		{"spoofed from an untrusted peer", "198.18.0.1:50000", "DE", ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, tr.GenerateLink("msg-1"), nil)
+			r.RemoteAddr = tt.remote
+			r.Header.Set("CF-Connecting-IP", "203.0.113.7")
+			r.Header.Set("CF-IPCountry", tt.country)
+			n := len(log.all())
+			tr.Handler().ServeHTTP(httptest.NewRecorder(), r)
+			events := log.wait(t, n+1)
+			if got := events[n].Geo.Country; got != tt.want {
+				t.Errorf("Geo.Country = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+type countryResolver string
+
+func (c countryResolver) Resolve(string) (Geo, error) {
+	return Geo{Country: string(c), City: "Somewhere"}, nil
+}
+
+func TestCDNCountryWithResolver(t *testing.T) {
+	for _, tt := range []struct {
+		resolved, want string
+	}{
+		{"FR", "FR"}, // the resolver's country wins
+		{"", "DE"},   // the CDN's fills in
+	} {
+		tr, log := newTestTracker(t, Config{
+			TrustedProxies: []string{cloudflare},
+			CDNClientIP:    true,
+			CDNCountry:     true,
+			GeoResolver:    countryResolver(tt.resolved),
+		})
+		r := httptest.NewRequest(http.MethodGet, tr.GenerateLink("msg-1"), nil)
+		r.RemoteAddr = "173.245.48.5:443"
+		r.Header.Set("CF-Connecting-IP", "203.0.113.7")
+		r.Header.Set("CF-IPCountry", "DE")
+		tr.Handler().ServeHTTP(httptest.NewRecorder(), r)
+		geo := log.wait(t, 1)[0].Geo
+		if geo.Country != tt.want || geo.City != "Somewhere" {
+			t.Errorf("resolver finding %q: Geo = %+v, want country %q", tt.resolved, geo, tt.want)
+		}
+	}
+}
+
+func TestCDNOptionsRequireTrustedProxies(t *testing.T) {
+	for _, cfg := range []Config{{CDNClientIP: true}, {CDNCountry: true}} {
+		cfg.Domain, cfg.Path = "tracker.test", "/pixel"
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) succeeded without TrustedProxies", cfg)
+		}
+	}
+}
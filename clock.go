@@ -0,0 +1,67 @@
+package emailtracker
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the tracker the time. Event timestamps, dedup, replay and
+// rate limit windows, link expiry, send times and OnReport windows all read
+// it, so tests can control them; see the trackertest sub-package.
+// Request durations, uptime and retry backoff use the real clock.
+// Implementations must be safe for concurrent use.
+type Clock interface {
+	Now() time.Time
+}
+
+// TickerClock is an optional extension of Clock. If Config.Clock implements
+// it, OnReport, OnThreshold and SubscribeBatch windows and DebounceInterval
+// tick on its tickers instead of real ones.
+type TickerClock interface {
+	Clock
+	// NewTicker returns a channel delivering the time every d, and a
+	// function stopping it.
+	NewTicker(d time.Duration) (c <-chan time.Time, stop func())
+}
+
+// realClock is the default Clock.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// now reads the tracker's Clock.
+func (t *Tracker) now() time.Time {
+	return t.clock.Now()
+}
+
+// newTicker starts a ticker on the tracker's Clock if it is a TickerClock,
+// and a real one otherwise.
+func (t *Tracker) newTicker(d time.Duration) (<-chan time.Time, func()) {
+	if tc, ok := t.clock.(TickerClock); ok {
+		return tc.NewTicker(d)
+	}
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+// afterFunc calls f once d has passed on the tracker's Clock, using a
+// ticker if it is a TickerClock and a real timer otherwise. The returned
+// function cancels the call if it hasn't started.
+func (t *Tracker) afterFunc(d time.Duration, f func()) (stop func()) {
+	if _, ok := t.clock.(TickerClock); !ok {
+		timer := time.AfterFunc(d, f)
+		return func() { timer.Stop() }
+	}
+	c, stopTicker := t.newTicker(d)
+	done := make(chan struct{})
+	go func() {
+		defer stopTicker()
+		select {
+		case <-c:
+			f()
+		case <-done:
+		}
+	}()
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
@@ -0,0 +1,58 @@
+package emailtracker
+
+import (
+	"testing"
+	"time"
+)
+
+func TestClockDrivesEvents(t *testing.T) {
+	clock := newTestClock()
+	t0 := clock.Now()
+	tr, log := newTestTracker(t, Config{Clock: clock, DedupWindow: time.Minute})
+	link := tr.GenerateLink("msg-1")
+	get(tr.Handler(), link)
+	clock.advance(59 * time.Second)
+	get(tr.Handler(), link) // inside the window by the clock
+	clock.advance(2 * time.Second)
+	get(tr.Handler(), link)
+
+	events := log.all()
+	if len(events) != 2 || !events[0].Time.Equal(t0) || !events[1].Time.Equal(t0.Add(61*time.Second)) {
+		t.Fatalf("events %+v, want opens at %v and a minute and a second later", events, t0)
+	}
+
+	sent := clock.Now().Add(-2 * time.Hour)
+	get(tr.Handler(), tr.GenerateLink("msg-2", WithSentAt(sent)))
+	if e := log.all()[2]; e.TimeToOpen != 2*time.Hour || e.TimeToOpenSkewed {
+		t.Errorf("TimeToOpen %v, skewed %v; want 2h by the clock", e.TimeToOpen, e.TimeToOpenSkewed)
+	}
+
+	if err := tr.RegisterSend("msg-3", SendMeta{}); err != nil {
+		t.Fatal(err)
+	}
+	if s, ok, err := tr.LookupSend("msg-3"); err != nil || !ok || !s.SentAt.Equal(clock.Now()) {
+		t.Errorf("LookupSend = %+v, %v, %v; want SentAt %v", s, ok, err, clock.Now())
+	}
+}
+
+func TestClockDrivesRateLimit(t *testing.T) {
+	clock := newTestClock()
+	tr, log := newTestTracker(t, Config{Clock: clock, RateLimit: &RateLimit{Rate: 1, Burst: 1}})
+	for i, step := range []struct {
+		advance time.Duration
+		allowed bool
+	}{
+		{0, true},
+		{0, false},
+		{500 * time.Millisecond, false},
+		{500 * time.Millisecond, true},
+		{10 * time.Second, true},
+	} {
+		clock.advance(step.advance)
+		before := len(log.all())
+		get(tr.Handler(), tr.GenerateLink("msg-1"))
+		if got := len(log.all()) > before; got != step.allowed {
+			t.Errorf("request %d at %v: allowed %v, want %v", i, clock.Now(), got, step.allowed)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package emailtracker
+
+import (
+	"cmp"
+	"errors"
+	"time"
+)
+
+// DefaultSuspiciousOpenWindow is the Config.SuspiciousOpenWindow used when
+// it is zero.
+const DefaultSuspiciousOpenWindow = 10 * time.Second
+
+// Confidence grades how likely an open is a person reading the message.
+type Confidence string
+
+const (
+	ConfidenceHuman     Confidence = "human"
+	ConfidenceLikelyBot Confidence = "likely_bot"
+	ConfidenceBot       Confidence = "bot"
+)
+
+// ConfidenceWeights grades opens for Config.Confidence. An open scores the
+// sum of the weights of the signals it shows; scores from LikelyBotAt up
+// are ConfidenceLikelyBot, from BotAt up ConfidenceBot, and the rest,
+// including every score of 0, ConfidenceHuman. Zero thresholds default to
+// those of DefaultConfidenceWeights.
+type ConfidenceWeights struct {
+	Bot        float64 // OpenEvent.IsBot
+	Suspicious float64 // OpenEvent.Suspicious
+	Prefetch   float64 // OpenEvent.PrivacyPrefetch or Prefetch
+	Proxied    float64 // OpenEvent.Proxied
+
+	LikelyBotAt float64
+	BotAt       float64
+}
+
+// DefaultConfidenceWeights grade known bots as bots, suspiciously fast opens
+// and prefetches as likely bots, and both together as bots. Proxied opens
+// are mostly people behind Gmail and the like, so the proxy alone stays
+// human.
+var DefaultConfidenceWeights = ConfidenceWeights{
+	Bot:         1,
+	Suspicious:  0.6,
+	Prefetch:    0.5,
+	Proxied:     0.1,
+	LikelyBotAt: 0.5,
+	BotAt:       1,
+}
+
+func (w ConfidenceWeights) withDefaults() ConfidenceWeights {
+	w.LikelyBotAt = cmp.Or(w.LikelyBotAt, DefaultConfidenceWeights.LikelyBotAt)
+	w.BotAt = cmp.Or(w.BotAt, DefaultConfidenceWeights.BotAt)
+	return w
+}
+
+func (w ConfidenceWeights) validate() error {
+	for _, v := range []float64{w.Bot, w.Suspicious, w.Prefetch, w.Proxied, w.LikelyBotAt, w.BotAt} {
+		if v < 0 {
+			return errors.New("emailtracker: Confidence weights and thresholds must not be negative")
+		}
+	}
+	if d := w.withDefaults(); d.LikelyBotAt > d.BotAt {
+		return errors.New("emailtracker: Confidence.LikelyBotAt must not exceed BotAt")
+	}
+	return nil
+}
+
+// grade returns the Confidence of e.
+func (w *ConfidenceWeights) grade(e *OpenEvent) Confidence {
+	var score float64
+	if e.IsBot {
+		score += w.Bot
+	}
+	if e.Suspicious {
+		score += w.Suspicious
+	}
+	if e.PrivacyPrefetch || e.Prefetch {
+		score += w.Prefetch
+	}
+	if e.Proxied {
+		score += w.Proxied
+	}
+	switch {
+	case score > 0 && score >= w.BotAt:
+		return ConfidenceBot
+	case score > 0 && score >= w.LikelyBotAt:
+		return ConfidenceLikelyBot
+	}
+	return ConfidenceHuman
+}
+
+// classifyConfidence sets e.Suspicious and, with Config.Confidence,
+// e.Confidence. sent is the link's WithSentAt time, if any; otherwise the
+// send time registered by RegisterSend is used.
+func (t *Tracker) classifyConfidence(e *OpenEvent, sent string) {
+	if t.suspicious > 0 {
+		if sent != "" {
+			// TimeToOpen is 0 for unparsable times and clamped for future ones.
+			e.Suspicious = e.TimeToOpen > 0 && e.TimeToOpen < t.suspicious
+		} else if s, ok, err := t.LookupSend(e.ID); err != nil {
+			t.reportError("store", err, e)
+		} else if ok {
+			d := e.Time.Sub(s.SentAt)
+			e.Suspicious = d >= 0 && d < t.suspicious
+		}
+	}
+	if t.confidence != nil {
+		e.Confidence = t.confidence.grade(e)
+	}
+}
@@ -0,0 +1,127 @@
+package emailtracker
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// corsMaxAge is how long, in seconds, browsers may cache a preflight answer.
+const corsMaxAge = "86400"
+
+// originRule is one parsed AllowedOrigins entry.
+type originRule struct {
+	scheme string // empty to accept any
+	host   string // host, or host:port
+	suffix bool   // host is a domain whose subdomains match, e.g. ".example.com"
+}
+
+// corsPolicy decides which browser origins may read pixel responses.
+type corsPolicy struct {
+	any   bool
+	rules []originRule
+}
+
+// compileOrigins parses AllowedOrigins entries: "*", an origin such as
+// "https://app.example.com", a host such as "app.example.com" with any
+// scheme, or "*.example.com" for every subdomain of example.com.
+func compileOrigins(origins []string) (*corsPolicy, error) {
+	if len(origins) == 0 {
+		return nil, nil
+	}
+	p := &corsPolicy{}
+	for _, o := range origins {
+		if o == "*" {
+			p.any = true
+			continue
+		}
+		var rule originRule
+		host := o
+		if scheme, rest, ok := strings.Cut(o, "://"); ok {
+			if scheme != "http" && scheme != "https" {
+				return nil, fmt.Errorf("emailtracker: AllowedOrigins: %q: scheme must be http or https", o)
+			}
+			rule.scheme, host = scheme, rest
+		}
+		if rest, ok := strings.CutPrefix(host, "*."); ok {
+			rule.suffix, host = true, rest
+		}
+		if !validHost(host) || strings.Contains(host, "*") {
+			return nil, fmt.Errorf("emailtracker: AllowedOrigins: invalid origin %q", o)
+		}
+		rule.host = strings.ToLower(host)
+		p.rules = append(p.rules, rule)
+	}
+	return p, nil
+}
+
+// allows reports whether origin, an Origin header value, matches.
+func (p *corsPolicy) allows(origin string) bool {
+	if origin == "" {
+		return false
+	}
+	if p.any {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" || u.Path != "" {
+		return false // including the opaque "null" origin
+	}
+	host := strings.ToLower(u.Host)
+	for _, rule := range p.rules {
+		if rule.scheme != "" && rule.scheme != u.Scheme {
+			continue
+		}
+		match := host == rule.host
+		if rule.suffix {
+			match = strings.HasSuffix(host, "."+rule.host)
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// setCORSHeaders adds Access-Control-Allow-Origin to the response for an
+// allowed origin, reporting whether it was allowed.
+func (t *Tracker) setCORSHeaders(w http.ResponseWriter, r *http.Request) bool {
+	if t.cors == nil {
+		return false
+	}
+	h := w.Header()
+	h.Add("Vary", "Origin")
+	origin := r.Header.Get("Origin")
+	if !t.cors.allows(origin) {
+		return false
+	}
+	if t.cors.any {
+		h.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		h.Set("Access-Control-Allow-Origin", origin)
+	}
+	return true
+}
+
+// preflight answers a CORS preflight for the pixel, reporting whether r was
+// one. Preflights never produce events.
+func (t *Tracker) preflight(w http.ResponseWriter, r *http.Request) bool {
+	if t.cors == nil || r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+		return false
+	}
+	if !t.setCORSHeaders(w, r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return true
+	}
+	methods := "GET, HEAD"
+	if t.config.Beacon {
+		methods = "GET, HEAD, POST"
+	}
+	h := w.Header()
+	h.Set("Access-Control-Allow-Methods", methods)
+	h.Set("Access-Control-Allow-Headers", "Content-Type")
+	h.Set("Access-Control-Max-Age", corsMaxAge)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
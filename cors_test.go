@@ -0,0 +1,103 @@
+package emailtracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// preflight serves a CORS preflight for target from origin through h.
+func preflight(h http.Handler, target, origin string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodOptions, target, nil)
+	r.Header.Set("Origin", origin)
+	r.Header.Set("Access-Control-Request-Method", http.MethodGet)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestCORSOrigins(t *testing.T) {
+	tr, log := newTestTracker(t, Config{AllowedOrigins: []string{"https://app.example.com", "*.example.org", "mail.test:8443"}})
+	link := tr.GenerateLink("msg-1")
+	for _, c := range []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://app.example.com.evil.test", false},
+		{"https://a.example.org", true},
+		{"http://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"http://mail.test:8443", true},
+		{"https://mail.test", false},
+		{"null", false},
+		{"", false},
+	} {
+		before := len(log.all())
+		w := get(tr.Handler(), link, "Origin", c.origin)
+		if w.Code != http.StatusOK || len(log.all()) != before+1 {
+			t.Errorf("GET from %q: status %d; want the pixel and an event either way", c.origin, w.Code)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Origin"); c.allowed && got != c.origin || !c.allowed && got != "" {
+			t.Errorf("GET from %q: Access-Control-Allow-Origin %q, allowed %v", c.origin, got, c.allowed)
+		}
+		if w.Header().Get("Vary") != "Origin" {
+			t.Errorf("GET from %q: Vary %q, want Origin", c.origin, w.Header().Get("Vary"))
+		}
+
+		w = preflight(tr.Handler(), link, c.origin)
+		if c.allowed {
+			if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != c.origin ||
+				w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD" || w.Header().Get("Access-Control-Max-Age") != corsMaxAge {
+				t.Errorf("preflight from %q: %d %v; want 204 with CORS headers", c.origin, w.Code, w.Header())
+			}
+		} else if w.Code != http.StatusForbidden || w.Header().Get("Access-Control-Allow-Origin") != "" {
+			t.Errorf("preflight from %q: %d %v; want 403 without CORS headers", c.origin, w.Code, w.Header())
+		}
+		if n := len(log.all()); n != before+1 {
+			t.Errorf("preflight from %q produced %d events", c.origin, n-before-1)
+		}
+	}
+}
+
+func TestCORSWildcard(t *testing.T) {
+	tr, log := newTestTracker(t, Config{AllowedOrigins: []string{"*"}, Beacon: true})
+	link := tr.GenerateLink("msg-1")
+	if got := get(tr.Handler(), link, "Origin", "https://anywhere.test").Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin %q, want *", got)
+	}
+	w := preflight(tr.Handler(), link, "https://anywhere.test")
+	if w.Code != http.StatusNoContent || w.Header().Get("Access-Control-Allow-Methods") != "GET, HEAD, POST" {
+		t.Errorf("preflight with Beacon: %d, methods %q; want 204 allowing POST", w.Code, w.Header().Get("Access-Control-Allow-Methods"))
+	}
+	if n := len(log.all()); n != 1 {
+		t.Errorf("%d events, want 1 from the GET alone", n)
+	}
+}
+
+func TestCORSDisabled(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	link := tr.GenerateLink("msg-1")
+	w := get(tr.Handler(), link, "Origin", "https://app.example.com")
+	if h := w.Header(); h.Get("Access-Control-Allow-Origin") != "" || h.Get("Vary") == "Origin" {
+		t.Errorf("without AllowedOrigins: CORS headers %v", h)
+	}
+	if w := preflight(tr.Handler(), link, "https://app.example.com"); w.Code == http.StatusNoContent || w.Header().Get("Access-Control-Allow-Origin") != "" {
+		t.Errorf("without AllowedOrigins preflight answered %d %v", w.Code, w.Header())
+	}
+	if n := len(log.all()); n != 1 {
+		t.Errorf("%d events, want 1 from the GET alone", n)
+	}
+}
+
+func TestInvalidAllowedOrigins(t *testing.T) {
+	for _, origin := range []string{"ftp://files.example.com", "a*b.example.com", "*.*.example.com", "https://", "https://exa mple.com"} {
+		if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", AllowedOrigins: []string{origin}}); err == nil {
+			t.Errorf("New accepted AllowedOrigins %q", origin)
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package emailtracker
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// DropDebounced is the drop reason for requests merged into a held event by
+// DebounceInterval.
+const DropDebounced DropReason = "debounced"
+
+// debouncer holds each event for the debounce interval, counting identical
+// requests that arrive meanwhile into its Repeats. Once max events are held,
+// new ones go out at once.
+type debouncer struct {
+	t        *Tracker
+	interval time.Duration
+	max      int
+
+	mu      sync.Mutex
+	held    map[string]*heldEvent
+	stopped bool
+	emits   sync.WaitGroup // releases emitting, for stop
+}
+
+type heldEvent struct {
+	key  string
+	ctx  context.Context
+	e    OpenEvent
+	stop func() // stops the timer; nil while the key is only claimed
+}
+
+func newDebouncer(t *Tracker, interval time.Duration, max int) *debouncer {
+	if max <= 0 {
+		max = defaultMaxKeys
+	}
+	return &debouncer{t: t, interval: interval, max: max, held: make(map[string]*heldEvent)}
+}
+
+// key identifies identical requests: same ID, IP and User-Agent. The IP is
+// anonymized first, so held keys never carry a raw address AnonymizeIP
+// would hide.
+func (d *debouncer) key(e *OpenEvent) string {
+	return e.ID + "\x00" + d.t.anonymizeIP(e.IP) + "\x00" + e.UserAgent
+}
+
+// claim counts e into the held event it repeats, reporting merged, or else
+// claims its key in the same critical section, so that of several
+// concurrent identical requests exactly one is held. The claim is nil when
+// the debouncer is full or stopped.
+func (d *debouncer) claim(e *OpenEvent) (h *heldEvent, merged bool) {
+	key := d.key(e)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if h, ok := d.held[key]; ok {
+		h.e.Repeats++
+		return nil, true
+	}
+	if d.stopped || len(d.held) >= d.max {
+		return nil, false
+	}
+	h = &heldEvent{key: key}
+	d.held[key] = h
+	return h, false
+}
+
+// hold keeps e back for the interval under its claim h, or emits it now
+// when there is no claim or stop has flushed it.
+func (d *debouncer) hold(ctx context.Context, h *heldEvent, e OpenEvent) {
+	ctx = context.WithoutCancel(ctx)
+	if h == nil {
+		d.t.emit(ctx, e)
+		return
+	}
+	d.mu.Lock()
+	e.Repeats += h.e.Repeats
+	if d.held[h.key] != h {
+		d.mu.Unlock()
+		d.t.emit(ctx, e)
+		return
+	}
+	h.ctx, h.e = ctx, e
+	h.stop = d.t.afterFunc(d.interval, func() { d.release(h) })
+	d.mu.Unlock()
+}
+
+// cancel gives up claim h if its event was dropped rather than held.
+func (d *debouncer) cancel(h *heldEvent) {
+	if d == nil || h == nil {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.held[h.key] == h && h.stop == nil {
+		delete(d.held, h.key)
+	}
+}
+
+// release emits h once its interval is over.
+func (d *debouncer) release(h *heldEvent) {
+	d.mu.Lock()
+	if d.held[h.key] != h {
+		d.mu.Unlock()
+		return // flushed by stop
+	}
+	delete(d.held, h.key)
+	e := h.e
+	d.emits.Add(1)
+	d.mu.Unlock()
+	defer d.emits.Done()
+	d.t.emit(h.ctx, e)
+}
+
+// stop emits every held event now and waits for releases already under way;
+// later events bypass the debouncer.
+func (d *debouncer) stop() {
+	d.mu.Lock()
+	d.stopped = true
+	held := d.held
+	d.held = make(map[string]*heldEvent)
+	d.mu.Unlock()
+	for _, h := range held {
+		if h.stop == nil {
+			continue // claimed only; its request emits the event itself
+		}
+		h.stop()
+		d.t.emit(h.ctx, h.e)
+	}
+	d.emits.Wait()
+}
+
+// debounced reports whether e repeats an event being held, counting it in.
+// Otherwise it returns the claim to pass to emitDebounced, or to cancel if
+// e is dropped.
+func (t *Tracker) debounced(e *OpenEvent) (claim *heldEvent, merged bool) {
+	if t.debounce == nil {
+		return nil, false
+	}
+	return t.debounce.claim(e)
+}
+
+// emitDebounced emits e, after the debounce interval if one is set.
+func (t *Tracker) emitDebounced(ctx context.Context, claim *heldEvent, e OpenEvent) {
+	if t.debounce == nil {
+		t.emit(ctx, e)
+		return
+	}
+	t.debounce.hold(ctx, claim, e)
+}
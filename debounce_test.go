@@ -0,0 +1,94 @@
+package emailtracker
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// tickClock is a TickerClock whose tickers fire only on fire.
+type tickClock struct {
+	*testClock
+	mu    sync.Mutex
+	ticks []chan time.Time
+}
+
+func (c *tickClock) NewTicker(time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	c.ticks = append(c.ticks, ch)
+	return ch, func() {}
+}
+
+// fire ticks every ticker started so far, reporting how many there were.
+func (c *tickClock) fire() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, ch := range c.ticks {
+		select {
+		case ch <- c.Now():
+		default:
+		}
+	}
+	return len(c.ticks)
+}
+
+func TestDebounceOnClock(t *testing.T) {
+	clock := &tickClock{testClock: newTestClock()}
+	tr, log := newTestTracker(t, Config{Clock: clock, DebounceInterval: time.Hour})
+	for range 3 {
+		get(tr.Handler(), tr.GenerateLink("msg-1"))
+	}
+	if events := log.all(); len(events) != 0 {
+		t.Fatalf("got %d events before the interval passed", len(events))
+	}
+	if n := clock.fire(); n != 1 {
+		t.Fatalf("%d timers started, want 1", n)
+	}
+	if e := log.wait(t, 1)[0]; e.Repeats != 2 {
+		t.Errorf("Repeats = %d, want 2", e.Repeats)
+	}
+	if got := tr.Metrics().EventsDropped[DropDebounced]; got != 2 {
+		t.Errorf("%d requests dropped as debounced, want 2", got)
+	}
+}
+
+func TestDebounceConcurrent(t *testing.T) {
+	const n = 50
+	clock := &tickClock{testClock: newTestClock()}
+	tr, log := newTestTracker(t, Config{Clock: clock, DebounceInterval: time.Hour})
+	link := tr.GenerateLink("msg-1")
+	var wg sync.WaitGroup
+	for range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(tr.Handler(), link)
+		}()
+	}
+	wg.Wait()
+	clock.fire()
+	if e := log.wait(t, 1)[0]; e.Repeats != n-1 {
+		t.Errorf("Repeats = %d, want %d", e.Repeats, n-1)
+	}
+	shutdown(t, tr)
+	if events := log.all(); len(events) != 1 {
+		t.Errorf("got %d events, want the requests merged into one", len(events))
+	}
+}
+
+func TestDebounceDroppedClaim(t *testing.T) {
+	tr, log := newTestTracker(t, Config{DebounceInterval: time.Hour, DropBots: true})
+	get(tr.Handler(), tr.GenerateLink("msg-1"), "User-Agent", "curl/8.0")
+	tr.debounce.mu.Lock()
+	held := len(tr.debounce.held)
+	tr.debounce.mu.Unlock()
+	if held != 0 {
+		t.Error("a dropped request's claim is still held")
+	}
+	shutdown(t, tr)
+	if events := log.all(); len(events) != 0 {
+		t.Errorf("got %d events, want the bot dropped", len(events))
+	}
+}
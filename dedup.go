@@ -0,0 +1,55 @@
+package emailtracker
+
+import "fmt"
+
+// dedupKey identifies events that count as repeats of each other.
+func (t *Tracker) dedupKey(e *OpenEvent) string {
+	if t.config.DedupByClient {
+		return e.ID + "\x00" + t.anonymizeIP(e.IP) + "\x00" + e.UserAgent
+	}
+	return e.ID
+}
+
+// duplicate reports whether e repeats an event seen within DedupWindow,
+// counting it if so.
+func (t *Tracker) duplicate(e *OpenEvent) bool {
+	if t.dedup == nil {
+		return false
+	}
+	if t.seenBefore(t.dedup, seenDedup, t.dedupKey(e), e) {
+		t.deduplicated.Add(1)
+		return true
+	}
+	return false
+}
+
+// Deduplicated reports how many events DedupWindow has suppressed.
+func (t *Tracker) Deduplicated() uint64 {
+	return t.deduplicated.Load()
+}
+
+// firstOpen reports whether e is the first open observed for its ID. The
+// in-memory set, or the SeenSet, settles concurrent requests so exactly one
+// wins; the Store, if any, catches opens recorded before a restart or
+// eviction, and is asked only for IDs the sets haven't seen, for at most
+// one open. A Store serving as the SeenSet keeps its keys as long as its
+// events, so when it answers, it isn't searched as well: an open saved by
+// a concurrent loser could otherwise deny the winner too.
+func (t *Tracker) firstOpen(e *OpenEvent) bool {
+	seen, shared := t.seenShared(t.seen, seenFirst, e.ID, e)
+	if seen {
+		return false
+	}
+	if shared && t.storeSeen {
+		return true
+	}
+	if t.config.Store != nil {
+		n, err := t.storedOpens(e.ID, 1)
+		if err != nil {
+			t.reportError("store", fmt.Errorf("%w: %w", ErrStoreRead, err), e)
+			return false
+		}
+		return n == 0
+	}
+	return true
+}
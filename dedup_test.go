@@ -0,0 +1,135 @@
+package emailtracker
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// mapSeenSet is a SeenSet in a map, ignoring TTLs.
+type mapSeenSet struct {
+	mu   sync.Mutex
+	keys map[string]bool
+}
+
+func (s *mapSeenSet) Add(key string, _ time.Duration) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.keys == nil {
+		s.keys = make(map[string]bool)
+	}
+	seen := s.keys[key]
+	s.keys[key] = true
+	return seen, nil
+}
+
+func firstOpens(events []OpenEvent) int {
+	n := 0
+	for _, e := range events {
+		if e.FirstOpen {
+			n++
+		}
+	}
+	return n
+}
+
+func TestFirstOpen(t *testing.T) {
+	tr, log := newTestTracker(t, Config{TrackFirstOpen: true})
+	for _, id := range []string{"msg-1", "msg-1", "msg-2", "msg-1"} {
+		get(tr.Handler(), tr.GenerateLink(id))
+	}
+	events := log.all()
+	if len(events) != 4 {
+		t.Fatalf("got %d events, want 4", len(events))
+	}
+	for i, want := range []bool{true, false, true, false} {
+		if events[i].FirstOpen != want {
+			t.Errorf("event %d (%s): FirstOpen = %v, want %v", i, events[i].ID, events[i].FirstOpen, want)
+		}
+	}
+}
+
+func TestFirstOpenSurvivesRestart(t *testing.T) {
+	plain := &byIDStore{mem: NewMemoryStore(0)}
+	for name, store := range map[string]Store{"ByID": plain, "OpenCountStore": NewMemoryStore(0)} {
+		t.Run(name, func(t *testing.T) {
+			tr, log := newTestTracker(t, Config{TrackFirstOpen: true, Store: store})
+			for range 3 {
+				get(tr.Handler(), tr.GenerateLink("msg-1"))
+			}
+			if got := firstOpens(log.all()); got != 1 {
+				t.Errorf("%d first opens, want 1", got)
+			}
+
+			tr2, log2 := newTestTracker(t, Config{TrackFirstOpen: true, Store: store})
+			get(tr2.Handler(), tr2.GenerateLink("msg-1"))
+			get(tr2.Handler(), tr2.GenerateLink("msg-2"))
+			if events := log2.wait(t, 2); events[0].FirstOpen || !events[1].FirstOpen {
+				t.Errorf("after restart FirstOpen = %v, %v; want false, true", events[0].FirstOpen, events[1].FirstOpen)
+			}
+		})
+	}
+	if plain.reads != 3 {
+		t.Errorf("ByID called %d times, want 3, once per unseen ID", plain.reads)
+	}
+}
+
+func TestFirstOpenAsksSeenSetBeforeStore(t *testing.T) {
+	seen := &mapSeenSet{}
+	store := &byIDStore{mem: NewMemoryStore(0)}
+	a, logA := newTestTracker(t, Config{TrackFirstOpen: true, Store: store, SeenSet: seen})
+	b, logB := newTestTracker(t, Config{TrackFirstOpen: true, Store: store, SeenSet: seen})
+	get(a.Handler(), a.GenerateLink("msg-1"))
+	reads := store.reads
+	get(b.Handler(), b.GenerateLink("msg-1"))
+
+	if !logA.all()[0].FirstOpen || logB.all()[0].FirstOpen {
+		t.Error("want only the first replica's open marked first")
+	}
+	if store.reads != reads {
+		t.Error("store searched for an ID the SeenSet had seen")
+	}
+}
+
+func TestSeenSetKeysAnonymized(t *testing.T) {
+	for name, mode := range map[string]IPAnonymization{"Truncate": AnonymizeTruncate, "Hash": AnonymizeHash} {
+		t.Run(name, func(t *testing.T) {
+			seen := &mapSeenSet{}
+			tr, log := newTestTracker(t, Config{
+				DedupWindow:   time.Hour,
+				DedupByClient: true,
+				ReplayWindow:  time.Hour,
+				SeenSet:       seen,
+				AnonymizeIP:   mode,
+				AnonymizeSalt: []byte("salt"),
+			})
+			link := tr.GenerateLink("msg-1", WithNonce())
+			get(tr.Handler(), link)
+			get(tr.Handler(), link)
+			if events := log.all(); len(events) != 1 {
+				t.Fatalf("got %d events, want the repeat dropped", len(events))
+			}
+
+			anon := tr.anonymizeIP("192.0.2.1")
+			var keys []string
+			for k := range seen.keys {
+				keys = append(keys, k)
+			}
+			for k := range tr.dedup.keys {
+				keys = append(keys, k)
+			}
+			for k := range tr.nonces.keys {
+				keys = append(keys, k)
+			}
+			if len(keys) != 4 {
+				t.Fatalf("stored keys %q, want a dedup and a nonce key in each set", keys)
+			}
+			for _, k := range keys {
+				if strings.Contains(k, "192.0.2.1") || !strings.Contains(k, anon) {
+					t.Errorf("key %q holds the raw IP, want %q instead", k, anon)
+				}
+			}
+		})
+	}
+}
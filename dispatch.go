@@ -0,0 +1,330 @@
+package emailtracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// QueuePolicy decides what happens to an event when the async queue is full.
+type QueuePolicy int
+
+const (
+	// QueueBlock makes the handler wait until a worker frees up room.
+	QueueBlock QueuePolicy = iota
+	// QueueDrop discards the event and counts it in QueueStats.Dropped.
+	QueueDrop
+)
+
+const defaultQueueSize = 1024
+
+// QueueStats describes the async dispatch queue.
+type QueueStats struct {
+	Depth    int    // events waiting for a worker
+	Capacity int    // maximum queue length
+	Dropped  uint64 // events discarded because the queue was full or closed
+}
+
+// dispatcher feeds events to a fixed pool of worker goroutines.
+type dispatcher struct {
+	queue   chan job
+	policy  QueuePolicy
+	dropped atomic.Uint64
+	onDrop  func()
+
+	mu     sync.RWMutex
+	closed bool
+	wg     sync.WaitGroup
+}
+
+// job is a queued event with the values, if any, of the context it was
+// queued from; see queueContext.
+type job struct {
+	ctx context.Context
+	e   OpenEvent
+}
+
+func newDispatcher(workers, size int, policy QueuePolicy, handle func(job), onDrop func()) *dispatcher {
+	if size <= 0 {
+		size = defaultQueueSize
+	}
+	d := &dispatcher{
+		queue:  make(chan job, size),
+		policy: policy,
+		onDrop: onDrop,
+	}
+	d.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer d.wg.Done()
+			for j := range d.queue {
+				handle(j)
+			}
+		}()
+	}
+	return d
+}
+
+// enqueue hands j to the worker pool, honoring the queue policy.
+func (d *dispatcher) enqueue(j job) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		d.drop()
+		return
+	}
+	if d.policy == QueueDrop {
+		select {
+		case d.queue <- j:
+		default:
+			d.drop()
+		}
+		return
+	}
+	d.queue <- j
+}
+
+func (d *dispatcher) drop() {
+	d.dropped.Add(1)
+	d.onDrop()
+}
+
+// close stops accepting events and waits for the workers to drain the queue.
+func (d *dispatcher) close(ctx context.Context) error {
+	d.mu.Lock()
+	if !d.closed {
+		d.closed = true
+		close(d.queue)
+	}
+	d.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (d *dispatcher) stats() QueueStats {
+	return QueueStats{
+		Depth:    len(d.queue),
+		Capacity: cap(d.queue),
+		Dropped:  d.dropped.Load(),
+	}
+}
+
+// QueueStats reports the async queue's depth and drop count. It returns the
+// zero value when the tracker dispatches callbacks synchronously.
+func (t *Tracker) QueueStats() QueueStats {
+	if t.dispatcher == nil {
+		return QueueStats{}
+	}
+	return t.dispatcher.stats()
+}
+
+// Subscriber receives events along with a context. In synchronous mode ctx
+// is the pixel request's; with Workers > 0 it is a background context. Either
+// way it is cancelled if Shutdown runs out of time while the subscriber is
+// still running, or once Config.CallbackTimeout passes. A returned error is
+// passed to the OnError hook.
+type Subscriber func(ctx context.Context, e OpenEvent) error
+
+// Subscribe registers fn to receive every OpenEvent. It is safe to call at
+// any time, including while the server is running; the callback passed to
+// NewTracker is simply the first subscriber.
+func (t *Tracker) Subscribe(fn func(OpenEvent)) {
+	if fn == nil {
+		return
+	}
+	t.SubscribeContext(func(_ context.Context, e OpenEvent) error {
+		fn(e)
+		return nil
+	})
+}
+
+// SubscribeContext is like Subscribe for callbacks that take a context and
+// can fail.
+func (t *Tracker) SubscribeContext(fn Subscriber) {
+	if fn == nil {
+		return
+	}
+	t.subMu.Lock()
+	defer t.subMu.Unlock()
+	subs := make([]Subscriber, len(t.subscribers), len(t.subscribers)+1)
+	copy(subs, t.subscribers)
+	t.subscribers = append(subs, fn)
+}
+
+func (t *Tracker) subscriberList() []Subscriber {
+	t.subMu.RLock()
+	defer t.subMu.RUnlock()
+	return t.subscribers
+}
+
+// emit delivers an event to the store, webhook and subscribers, either
+// inline or via the pool. ctx is the request context, used for inline
+// delivery only.
+func (t *Tracker) emit(ctx context.Context, e OpenEvent) {
+	if t.log.Enabled(ctx, slog.LevelDebug) {
+		t.log.Debug("event tracked", slog.String(LogKeyID, e.ID), slog.String(LogKeyIP, e.IP),
+			slog.String(LogKeyKind, string(e.Kind)))
+	}
+	if len(t.subscriberList()) == 0 && len(t.sinkList()) == 0 && t.config.Store == nil && t.webhook == nil && t.stream == nil && t.events.n.Load() == 0 {
+		t.metrics.IncEvent(e.Kind)
+		return
+	}
+	if t.dispatcher != nil {
+		t.dispatcher.enqueue(job{t.queueContext(ctx), e})
+		return
+	}
+	t.callbacks.add()
+	defer t.callbacks.done()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(t.ctx, cancel)
+	defer stop()
+	t.process(ctx, e)
+}
+
+// process enriches and stores e, then hands it to the subscribers. It runs
+// on a worker when async dispatch is enabled, so slow lookups never delay
+// the pixel.
+func (t *Tracker) process(ctx context.Context, e OpenEvent) {
+	failures := t.enrich(ctx, &e)
+	t.anonymize(&e)
+	for _, f := range failures {
+		t.reportError(f.source, f.err, &e)
+	}
+	t.metrics.IncEvent(e.Kind)
+	if e.PrivacyPrefetch {
+		t.counters.incPrivacyPrefetch()
+	}
+	t.save(ctx, &e)
+	if t.webhook != nil {
+		t.webhook.enqueue(e)
+	}
+	if t.stream != nil {
+		t.stream.publish(e)
+	}
+	t.publish(ctx, e)
+	t.events.send(e)
+	t.deliver(ctx, e)
+}
+
+// deliver runs every subscriber for e. With more than one subscriber each
+// runs in its own goroutine, so a slow or panicking subscriber can't keep the
+// others from seeing the event.
+func (t *Tracker) deliver(ctx context.Context, e OpenEvent) {
+	subs := t.subscriberList()
+	if len(subs) == 0 {
+		return
+	}
+	if len(subs) == 1 {
+		t.invoke(ctx, 0, subs[0], e)
+		return
+	}
+	var wg sync.WaitGroup
+	wg.Add(len(subs))
+	for i, fn := range subs {
+		go func(e OpenEvent) { // e passed, not captured, so it stays off the heap
+			defer wg.Done()
+			t.invoke(ctx, i, fn, e)
+		}(e)
+	}
+	wg.Wait()
+}
+
+// PanicError is reported to the OnError hook when a subscriber, filter or
+// enricher panics. The pixel is still served and other subscribers still run.
+type PanicError struct {
+	Value any    // the value passed to panic
+	Stack []byte // the subscriber goroutine's stack at the time of the panic
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("emailtracker: callback panicked: %v", p.Value)
+}
+
+// CallbackTimeoutError is reported to the OnError hook when a subscriber
+// runs past Config.CallbackTimeout.
+type CallbackTimeoutError struct {
+	Subscriber int           // position of the subscriber in registration order, from 0
+	Timeout    time.Duration // the CallbackTimeout it exceeded
+}
+
+func (e *CallbackTimeoutError) Error() string {
+	return fmt.Sprintf("emailtracker: subscriber %d exceeded CallbackTimeout %s", e.Subscriber, e.Timeout)
+}
+
+// retryInvoke runs fn again for e, turning a panic into an error report
+// without another retry.
+func (t *Tracker) retryInvoke(ctx context.Context, fn Subscriber, e OpenEvent) (err error) {
+	if d := t.config.CallbackTimeout; d > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d)
+		defer cancel()
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			t.reportError("callback", &PanicError{Value: p, Stack: debug.Stack()}, &e)
+			err = nil
+		}
+	}()
+	return fn(ctx, e)
+}
+
+// invoke runs subscriber i for e. With CallbackTimeout set, it runs on its
+// own goroutine which invoke abandons once the timeout passes.
+func (t *Tracker) invoke(ctx context.Context, i int, fn Subscriber, e OpenEvent) {
+	d := t.config.CallbackTimeout
+	if d <= 0 {
+		t.call(ctx, fn, e)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, d)
+	defer cancel()
+	done := make(chan struct{})
+	go func(e OpenEvent) {
+		defer close(done)
+		t.call(ctx, fn, e)
+	}(e)
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		e := e
+		t.reportError("callback", &CallbackTimeoutError{Subscriber: i, Timeout: d}, &e)
+		return
+	}
+	<-done // cancelled by Shutdown, which decides how long to wait
+}
+
+func (t *Tracker) call(ctx context.Context, fn Subscriber, e OpenEvent) {
+	defer func() {
+		if p := recover(); p != nil {
+			e := e
+			t.reportError("callback", &PanicError{Value: p, Stack: debug.Stack()}, &e)
+		}
+	}()
+	err := fn(ctx, e)
+	if err != nil && ctx.Err() == context.DeadlineExceeded && t.config.CallbackTimeout > 0 {
+		return // already reported as a CallbackTimeoutError
+	}
+	if err != nil {
+		e := e // taking the address of e itself would heap-allocate every call
+		t.reportError("callback", fmt.Errorf("emailtracker: subscriber: %w", err), &e)
+		t.retry(&e, func(ctx context.Context) error { return t.retryInvoke(ctx, fn, e) })
+	}
+}
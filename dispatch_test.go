@@ -0,0 +1,117 @@
+package emailtracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPanickingCallback(t *testing.T) {
+	for _, workers := range []int{0, 2} {
+		tr, log := newTestTracker(t, Config{Workers: workers})
+		panics := make(chan *PanicError, 4)
+		tr.OnError(func(err error, e *OpenEvent) {
+			var pe *PanicError
+			if errors.As(err, &pe) && e != nil {
+				panics <- pe
+			}
+		})
+		tr.Subscribe(func(e OpenEvent) {
+			var m map[string]int
+			m[e.ID]++ // nil map write
+		})
+
+		for i := range 3 {
+			w := get(tr.Handler(), tr.GenerateLink("msg-1"))
+			if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), gifData) {
+				t.Fatalf("workers %d, request %d: status %d, %d bytes; want the pixel", workers, i, w.Code, w.Body.Len())
+			}
+		}
+		if got := len(log.wait(t, 3)); got != 3 {
+			t.Errorf("workers %d: other subscriber got %d events, want 3", workers, got)
+		}
+		for range 3 {
+			var pe *PanicError
+			select {
+			case pe = <-panics:
+			case <-time.After(5 * time.Second):
+				t.Fatalf("workers %d: panic not reported", workers)
+			}
+			if !strings.Contains(pe.Error(), "nil map") || !bytes.Contains(pe.Stack, []byte("dispatch_test.go")) {
+				t.Errorf("workers %d: %v with stack\n%s", workers, pe, pe.Stack)
+			}
+		}
+	}
+}
+
+func TestCallbackTimeout(t *testing.T) {
+	const timeout = 50 * time.Millisecond
+	for _, workers := range []int{0, 1} {
+		tr, log := newTestTracker(t, Config{Workers: workers, CallbackTimeout: timeout})
+		release := make(chan struct{})
+		t.Cleanup(func() { close(release) }) // runs before Shutdown
+		errs := make(chan error, 16)
+		tr.OnError(func(err error, e *OpenEvent) {
+			if e == nil || e.ID != "msg-1" {
+				err = fmt.Errorf("%w, reported for event %v", err, e)
+			}
+			errs <- err
+		})
+		tr.Subscribe(func(OpenEvent) { <-release }) // ignores its context
+		cancelled := make(chan error, 4)
+		tr.SubscribeContext(func(ctx context.Context, _ OpenEvent) error {
+			<-ctx.Done()
+			cancelled <- ctx.Err()
+			return ctx.Err()
+		})
+
+		for i := range 3 {
+			start := time.Now()
+			w := get(tr.Handler(), tr.GenerateLink("msg-1"))
+			if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), gifData) {
+				t.Fatalf("workers %d, request %d: status %d, %d bytes; want the pixel", workers, i, w.Code, w.Body.Len())
+			}
+			if elapsed := time.Since(start); elapsed > timeout+time.Second {
+				t.Errorf("workers %d, request %d: pixel took %v with a %v CallbackTimeout", workers, i, elapsed, timeout)
+			}
+		}
+		// One worker gets through all three events only if each stuck job
+		// frees it.
+		if got := len(log.wait(t, 3)); got != 3 {
+			t.Errorf("workers %d: other subscriber got %d events, want 3", workers, got)
+		}
+
+		timedOut := map[int]int{}
+		for range 6 {
+			select {
+			case err := <-errs:
+				var te *CallbackTimeoutError
+				if !errors.As(err, &te) || te.Timeout != timeout {
+					t.Errorf("workers %d: reported %v, want a CallbackTimeoutError for %v", workers, err, timeout)
+					continue
+				}
+				timedOut[te.Subscriber]++
+			case <-time.After(5 * time.Second):
+				t.Fatalf("workers %d: timeouts reported only for subscribers %v", workers, timedOut)
+			}
+		}
+		if timedOut[1] != 3 || timedOut[2] != 3 {
+			t.Errorf("workers %d: timeouts per subscriber %v, want 3 each for 1 and 2", workers, timedOut)
+		}
+		for range 3 {
+			if err := <-cancelled; err != context.DeadlineExceeded {
+				t.Errorf("workers %d: subscriber context ended with %v, want DeadlineExceeded", workers, err)
+			}
+		}
+		select {
+		case err := <-errs:
+			t.Errorf("workers %d: extra error %v", workers, err)
+		case <-time.After(2 * timeout):
+		}
+	}
+}
@@ -0,0 +1,176 @@
+package emailtracker
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+	"time"
+)
+
+// Enricher adds fields to an event before it is stored or delivered, for
+// example by looking up the recipient behind its tracking ID. Enrich may
+// modify anything but Kind and ID. Implementations must be safe for
+// concurrent use; one that also has a Name() string method is labelled by
+// it in metrics, traces and errors, and by its type otherwise.
+type Enricher interface {
+	Enrich(ctx context.Context, e *OpenEvent) error
+}
+
+// EnricherFunc adapts a function to an Enricher.
+type EnricherFunc func(ctx context.Context, e *OpenEvent) error
+
+func (f EnricherFunc) Enrich(ctx context.Context, e *OpenEvent) error { return f(ctx, e) }
+
+// EnrichMetrics is an optional extension of Metrics. If the configured
+// Metrics implements it, the tracker reports how long each enricher took.
+type EnrichMetrics interface {
+	ObserveEnrich(name string, d time.Duration)
+}
+
+// Names of the built-in enrichers.
+const (
+	EnricherReverseDNS = "reverse_dns"
+	EnricherGeo        = "geo"
+)
+
+// EnrichError is reported to the OnError hook when an enricher fails. The
+// event is still delivered, with whatever the other enrichers added.
+type EnrichError struct {
+	Enricher string
+	Err      error
+}
+
+func (e *EnrichError) Error() string {
+	return fmt.Sprintf("emailtracker: enricher %s: %v", e.Enricher, e.Err)
+}
+
+func (e *EnrichError) Unwrap() error { return e.Err }
+
+// builtinEnricher is one of the lookups the tracker configures itself.
+type builtinEnricher struct {
+	name    string
+	enabled bool
+	fn      func(ctx context.Context, e *OpenEvent) error
+}
+
+func (b *builtinEnricher) Enrich(ctx context.Context, e *OpenEvent) error { return b.fn(ctx, e) }
+
+func (b *builtinEnricher) Name() string { return b.name }
+
+// enrichFailure is an enricher error held back until the event has been
+// anonymized.
+type enrichFailure struct {
+	source string
+	err    error
+}
+
+// ReverseDNSEnricher returns the built-in enricher filling OpenEvent.Hostname
+// when Config.ReverseDNS is set. Pass it to Use to change where it runs.
+func (t *Tracker) ReverseDNSEnricher() Enricher { return t.builtins[0] }
+
+// GeoEnricher returns the built-in enricher filling OpenEvent.Geo when
+// Config.GeoResolver is set. Pass it to Use to change where it runs.
+func (t *Tracker) GeoEnricher() Enricher { return t.builtins[1] }
+
+// Use appends e to the enrichment pipeline. Enrichers run in order on every
+// event, on a worker when Workers is set, after filters and dedup but before
+// the store, sinks, webhook and subscribers; they see the client IP before
+// Anonymize or PrivacyMode strips it. The built-in reverse DNS and geo
+// lookups run first, in that order, unless passed to Use themselves, which
+// moves them to that point in the pipeline. An enricher that fails or
+// panics is reported to the OnError hook and the rest still run.
+func (t *Tracker) Use(e Enricher) {
+	if e == nil {
+		return
+	}
+	t.subMu.Lock()
+	defer t.subMu.Unlock()
+	used := make([]Enricher, 0, len(t.used)+1)
+	for _, u := range t.used {
+		if b, ok := u.(*builtinEnricher); ok && Enricher(b) == e {
+			continue
+		}
+		used = append(used, u)
+	}
+	t.used = append(used, e)
+	t.enrichers = t.pipeline()
+}
+
+// pipeline returns the enabled built-ins not placed by Use, then t.used.
+// t.subMu must be held.
+func (t *Tracker) pipeline() []Enricher {
+	var out []Enricher
+	for _, b := range t.builtins {
+		if b.enabled && !t.placed(b) {
+			out = append(out, b)
+		}
+	}
+	for _, u := range t.used {
+		if b, ok := u.(*builtinEnricher); ok && !b.enabled {
+			continue
+		}
+		out = append(out, u)
+	}
+	return out
+}
+
+func (t *Tracker) placed(b *builtinEnricher) bool {
+	for _, u := range t.used {
+		if u == Enricher(b) {
+			return true
+		}
+	}
+	return false
+}
+
+// enrich runs the pipeline on e, returning the failures to report once e
+// is anonymized.
+func (t *Tracker) enrich(ctx context.Context, e *OpenEvent) []enrichFailure {
+	t.subMu.RLock()
+	enrichers := t.enrichers
+	t.subMu.RUnlock()
+	if len(enrichers) == 0 {
+		return nil
+	}
+	m, _ := t.config.Metrics.(EnrichMetrics)
+	var failures []enrichFailure
+	for _, en := range enrichers {
+		name := enricherName(en)
+		start := time.Now()
+		if err := t.runEnricher(ctx, name, en, e); err != nil {
+			source := "enrich"
+			if b, ok := en.(*builtinEnricher); ok {
+				source = b.name
+			} else if _, ok := err.(*PanicError); !ok {
+				err = &EnrichError{Enricher: name, Err: err}
+			}
+			failures = append(failures, enrichFailure{source, err})
+		}
+		if m != nil {
+			m.ObserveEnrich(name, time.Since(start))
+		}
+	}
+	return failures
+}
+
+func (t *Tracker) runEnricher(ctx context.Context, name string, en Enricher, e *OpenEvent) (err error) {
+	ctx, end := t.startSpan(ctx, SpanEnrich, slog.String(TraceKeyEnricher, name))
+	defer func() {
+		if p := recover(); p != nil {
+			err = &PanicError{Value: p, Stack: debug.Stack()}
+		}
+		end(err)
+	}()
+	kind, id := e.Kind, e.ID
+	err = en.Enrich(ctx, e)
+	e.Kind, e.ID = kind, id
+	return err
+}
+
+func enricherName(e Enricher) string {
+	if n, ok := e.(interface{ Name() string }); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", e)
+}
@@ -0,0 +1,606 @@
+package emailtracker
+
+import (
+	"cmp"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RouteESP is the Metrics route label of the ESP webhook endpoint.
+const RouteESP = "esp"
+
+// OpenEvent.Source values of events ingested from ESP webhooks.
+const (
+	SourceSendGrid = "sendgrid"
+	SourceSES      = "ses"
+)
+
+// maxESPBody caps an ESP webhook request body. SendGrid batches events, so
+// this is well above the size of a single notification.
+const maxESPBody = 8 << 20
+
+// maxSNSCerts bounds the cache of SNS signing certificates.
+const maxSNSCerts = 16
+
+// espMaxSkew bounds how far the signed timestamp of an ESP webhook post may
+// be from the tracker's clock, so a captured post can't be replayed later.
+// Posts seen within that window are remembered and not ingested twice.
+const espMaxSkew = 5 * time.Minute
+
+// DropESPReplay is the drop reason for ESP webhook posts already ingested.
+const DropESPReplay DropReason = "esp_replay"
+
+// errESPReplay marks a verified ESP webhook post that was already ingested.
+var errESPReplay = errors.New("emailtracker: ESP webhook post already ingested")
+
+// ESPConfig configures the endpoint at Config.ESPWebhookPath.
+type ESPConfig struct {
+	// SendGridPublicKey is the verification key of SendGrid's signed event
+	// webhook, base64 as shown in its settings. SendGrid signs with ECDSA
+	// P-256; an Ed25519 key is accepted too. Without it SendGrid posts are
+	// refused, since they couldn't be verified.
+	SendGridPublicKey string
+
+	// SNSTopicARNs lists the SNS topics messages are accepted from. Without
+	// it SNS posts are refused: anyone can create a topic, subscribe this
+	// endpoint and sign messages with AWS's keys. Every SNS message must
+	// carry a valid signature as well.
+	SNSTopicARNs []string
+
+	// ConfirmSubscriptions visits the SubscribeURL of verified SNS
+	// subscription confirmations, completing the subscription.
+	ConfirmSubscriptions bool
+
+	// TrackingID maps a message to the tracking ID its events carry; those
+	// mapped to "" are skipped. By default the ESP's message ID is used.
+	TrackingID func(m ESPMessage) string
+
+	// HTTPClient fetches SNS signing certificates and confirms
+	// subscriptions, default a client with a 5s timeout.
+	HTTPClient *http.Client
+}
+
+// ESPMessage describes the message an ESP webhook event is about, for
+// ESPConfig.TrackingID.
+type ESPMessage struct {
+	Provider   string    // SourceSendGrid or SourceSES
+	Kind       EventKind // EventDelivered, EventBounce, EventComplaint or EventOpen
+	MessageID  string    // the ESP's ID: sg_message_id, or the SES messageId
+	Recipients []string
+
+	// Headers holds the message headers SES includes when the configuration
+	// set is told to; Args holds SendGrid custom args and, for SES, the
+	// first value of each message tag.
+	Headers map[string]string
+	Args    map[string]string
+}
+
+// espRecord is one webhook event before it becomes an OpenEvent.
+type espRecord struct {
+	msg       ESPMessage
+	at        time.Time
+	detail    string
+	ip, agent string // for opens
+}
+
+// espIngest verifies and parses ESP webhooks.
+type espIngest struct {
+	cfg      ESPConfig
+	sendgrid crypto.PublicKey // *ecdsa.PublicKey or ed25519.PublicKey; nil refuses SendGrid
+	client   *http.Client
+	now      func() time.Time
+	seen     *ttlSet // signatures and SNS message IDs of recent posts
+
+	mu    sync.Mutex
+	certs map[string]*x509.Certificate // by SigningCertURL
+}
+
+func newESPIngest(cfg ESPConfig, now func() time.Time) (*espIngest, error) {
+	g := &espIngest{
+		cfg:    cfg,
+		client: cfg.HTTPClient,
+		now:    now,
+		seen:   newTTLSet(2*espMaxSkew, 0),
+		certs:  make(map[string]*x509.Certificate),
+	}
+	if g.client == nil {
+		g.client = &http.Client{Timeout: 5 * time.Second}
+	}
+	if cfg.SendGridPublicKey != "" {
+		key, err := parseSendGridKey(cfg.SendGridPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		g.sendgrid = key
+	}
+	return g, nil
+}
+
+func parseSendGridKey(s string) (crypto.PublicKey, error) {
+	der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("emailtracker: ESP.SendGridPublicKey: %w", err)
+	}
+	key, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("emailtracker: ESP.SendGridPublicKey: %w", err)
+	}
+	switch key.(type) {
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("emailtracker: ESP.SendGridPublicKey: unsupported key type %T", key)
+}
+
+// ESPWebhookHandler ingests delivery webhooks from SendGrid's event webhook
+// and from Amazon SES notifications delivered by SNS, told apart by SNS's
+// x-amz-sns-message-type header. Deliveries, bounces, complaints and opens
+// become events of the matching kind, with Source naming the ESP, and go
+// to the store, sinks and subscribers like pixel events; other ESP event
+// types are ignored. Requests other than POST get 405, and requests whose
+// signature doesn't verify, or whose signed timestamp is more than five
+// minutes off, get 403. A post already ingested gets 200 and is dropped
+// with DropESPReplay.
+func (t *Tracker) ESPWebhookHandler() http.HandlerFunc {
+	return t.instrument(RouteESP, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxESPBody))
+		if err != nil {
+			http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+			return
+		}
+		var records []espRecord
+		if r.Header.Get("X-Amz-Sns-Message-Type") != "" {
+			records, err = t.esp.sns(r.Context(), body)
+		} else {
+			records, err = t.esp.sendGrid(r.Header, body)
+		}
+		if errors.Is(err, errESPReplay) {
+			t.drop(r, DropESPReplay)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		if err != nil {
+			status := http.StatusBadRequest
+			if errors.Is(err, ErrBadSignature) {
+				status = http.StatusForbidden
+				t.drop(r, DropInvalidSignature)
+			}
+			t.warnRequest(r, "rejected ESP webhook", "", err)
+			t.reportError("esp", err, nil)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+		for _, rec := range records {
+			if e, ok := t.espEvent(rec); ok {
+				t.emit(r.Context(), e)
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+// espEvent builds the event for rec, or reports false if its message maps
+// to no tracking ID.
+func (t *Tracker) espEvent(rec espRecord) (OpenEvent, bool) {
+	id := rec.msg.MessageID
+	if t.esp.cfg.TrackingID != nil {
+		id = t.esp.cfg.TrackingID(rec.msg)
+	}
+	if id == "" {
+		return OpenEvent{}, false
+	}
+	if rec.at.IsZero() {
+		rec.at = t.now()
+	}
+	campaign, recipient, _ := ParseCampaignID(id)
+	e := OpenEvent{
+		Kind:        rec.msg.Kind,
+		ID:          id,
+		EventID:     newEventID(rec.at),
+		Source:      rec.msg.Provider,
+		MessageID:   rec.msg.MessageID,
+		Detail:      rec.detail,
+		CampaignID:  campaign,
+		RecipientID: recipient,
+		Time:        rec.at,
+	}
+	if !t.config.PrivacyMode {
+		e.IP, e.UserAgent = rec.ip, rec.agent
+	}
+	return e, true
+}
+
+// sendGridKinds maps SendGrid event types to event kinds.
+var sendGridKinds = map[string]EventKind{
+	"delivered":  EventDelivered,
+	"bounce":     EventBounce,
+	"spamreport": EventComplaint,
+	"open":       EventOpen,
+}
+
+// sendGridFields are the event fields that aren't custom args.
+var sendGridFields = []string{
+	"email", "event", "sg_message_id", "sg_event_id", "smtp-id", "timestamp", "ip", "useragent",
+	"reason", "type", "status", "response", "attempt", "url", "category", "tls", "cert_err",
+	"sg_machine_open", "bounce_classification",
+}
+
+// sendGrid verifies and parses a SendGrid event webhook post: a JSON array
+// of events, signed over the timestamp header and the body.
+func (g *espIngest) sendGrid(h http.Header, body []byte) ([]espRecord, error) {
+	if g.sendgrid == nil {
+		return nil, fmt.Errorf("%w: SendGrid webhook without ESP.SendGridPublicKey", ErrBadSignature)
+	}
+	sig, err := base64.StdEncoding.DecodeString(h.Get("X-Twilio-Email-Event-Webhook-Signature"))
+	if err != nil || len(sig) == 0 {
+		return nil, fmt.Errorf("%w: SendGrid webhook without a signature", ErrBadSignature)
+	}
+	ts := h.Get("X-Twilio-Email-Event-Webhook-Timestamp")
+	signed := append([]byte(ts), body...)
+	var ok bool
+	switch key := g.sendgrid.(type) {
+	case *ecdsa.PublicKey:
+		sum := sha256.Sum256(signed)
+		ok = ecdsa.VerifyASN1(key, sum[:], sig)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, signed, sig)
+	}
+	if !ok {
+		return nil, fmt.Errorf("%w: SendGrid webhook", ErrBadSignature)
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%w: SendGrid webhook timestamp %q", ErrBadSignature, ts)
+	}
+	if err := g.fresh("SendGrid webhook", time.Unix(sec, 0), "sendgrid:"+string(sig)); err != nil {
+		return nil, err
+	}
+	var events []map[string]any
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, fmt.Errorf("emailtracker: SendGrid webhook: %w", err)
+	}
+	var records []espRecord
+	for _, ev := range events {
+		kind, ok := sendGridKinds[jsonString(ev["event"])]
+		if !ok {
+			continue
+		}
+		rec := espRecord{msg: ESPMessage{Provider: SourceSendGrid, Kind: kind, MessageID: jsonString(ev["sg_message_id"])}}
+		if email := jsonString(ev["email"]); email != "" {
+			rec.msg.Recipients = []string{email}
+		}
+		if ts, ok := ev["timestamp"].(float64); ok {
+			rec.at = time.Unix(int64(ts), 0)
+		}
+		switch kind {
+		case EventBounce:
+			rec.detail = cmp.Or(jsonString(ev["type"]), "bounce")
+		case EventOpen:
+			rec.ip, rec.agent = jsonString(ev["ip"]), jsonString(ev["useragent"])
+		}
+		for k, v := range ev {
+			if s, ok := v.(string); ok && !slices.Contains(sendGridFields, k) {
+				if rec.msg.Args == nil {
+					rec.msg.Args = make(map[string]string)
+				}
+				rec.msg.Args[k] = s
+			}
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+func jsonString(v any) string {
+	s, _ := v.(string)
+	return s
+}
+
+// snsMessage is an SNS HTTP(S) delivery.
+type snsMessage struct {
+	Type             string
+	MessageID        string `json:"MessageId"`
+	Token            string
+	TopicArn         string
+	Subject          string
+	Message          string
+	Timestamp        string
+	SignatureVersion string
+	Signature        string
+	SigningCertURL   string
+	SubscribeURL     string
+}
+
+// snsCertHost matches the hosts SNS serves its signing certificates from.
+var snsCertHost = regexp.MustCompile(`^sns\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`)
+
+// sns verifies an SNS delivery and parses the SES notification it carries.
+// Verified subscription confirmations are confirmed when configured to.
+func (g *espIngest) sns(ctx context.Context, body []byte) ([]espRecord, error) {
+	var m snsMessage
+	if err := json.Unmarshal(body, &m); err != nil {
+		return nil, fmt.Errorf("emailtracker: SNS message: %w", err)
+	}
+	if len(g.cfg.SNSTopicARNs) == 0 {
+		return nil, fmt.Errorf("%w: SNS message without ESP.SNSTopicARNs", ErrBadSignature)
+	}
+	if !slices.Contains(g.cfg.SNSTopicARNs, m.TopicArn) {
+		return nil, fmt.Errorf("%w: SNS topic %q not allowed", ErrBadSignature, m.TopicArn)
+	}
+	if err := g.verifySNS(ctx, &m); err != nil {
+		return nil, err
+	}
+	ts, err := time.Parse(time.RFC3339Nano, m.Timestamp)
+	if err != nil {
+		return nil, fmt.Errorf("%w: SNS timestamp %q", ErrBadSignature, m.Timestamp)
+	}
+	if err := g.fresh("SNS message", ts, "sns:"+m.MessageID); err != nil {
+		return nil, err
+	}
+	switch m.Type {
+	case "Notification":
+		return parseSES(m.Message)
+	case "SubscriptionConfirmation":
+		if g.cfg.ConfirmSubscriptions {
+			return nil, g.confirm(ctx, m.SubscribeURL)
+		}
+	}
+	return nil, nil
+}
+
+// fresh checks the signed timestamp ts of a verified post against the
+// clock, and that the post, identified by key, wasn't ingested before.
+func (g *espIngest) fresh(what string, ts time.Time, key string) error {
+	now := g.now()
+	if d := now.Sub(ts); d > espMaxSkew || d < -espMaxSkew {
+		return fmt.Errorf("%w: %s timestamp %s is more than %v from now", ErrBadSignature, what, ts.UTC().Format(time.RFC3339), espMaxSkew)
+	}
+	if g.seen.add(key, now) {
+		return errESPReplay
+	}
+	return nil
+}
+
+func (g *espIngest) verifySNS(ctx context.Context, m *snsMessage) error {
+	var hash crypto.Hash
+	switch m.SignatureVersion {
+	case "1":
+		hash = crypto.SHA1
+	case "2":
+		hash = crypto.SHA256
+	default:
+		return fmt.Errorf("%w: SNS signature version %q", ErrBadSignature, m.SignatureVersion)
+	}
+	sig, err := base64.StdEncoding.DecodeString(m.Signature)
+	if err != nil {
+		return fmt.Errorf("%w: SNS signature: %v", ErrBadSignature, err)
+	}
+	cert, err := g.cert(ctx, m.SigningCertURL)
+	if err != nil {
+		return err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: SNS certificate key is %T", ErrBadSignature, cert.PublicKey)
+	}
+	var digest []byte
+	if hash == crypto.SHA1 {
+		sum := sha1.Sum(snsStringToSign(m))
+		digest = sum[:]
+	} else {
+		sum := sha256.Sum256(snsStringToSign(m))
+		digest = sum[:]
+	}
+	if err := rsa.VerifyPKCS1v15(key, hash, digest, sig); err != nil {
+		return fmt.Errorf("%w: SNS message %s", ErrBadSignature, m.MessageID)
+	}
+	return nil
+}
+
+// snsStringToSign is the canonical form SNS signs: name and value lines
+// of the fields its message type signs, in order.
+func snsStringToSign(m *snsMessage) []byte {
+	var b strings.Builder
+	add := func(name, value string) {
+		b.WriteString(name + "\n" + value + "\n")
+	}
+	add("Message", m.Message)
+	add("MessageId", m.MessageID)
+	if m.Type == "Notification" {
+		if m.Subject != "" {
+			add("Subject", m.Subject)
+		}
+	} else {
+		add("SubscribeURL", m.SubscribeURL)
+	}
+	add("Timestamp", m.Timestamp)
+	if m.Type != "Notification" {
+		add("Token", m.Token)
+	}
+	add("TopicArn", m.TopicArn)
+	add("Type", m.Type)
+	return []byte(b.String())
+}
+
+// validSNSURL reports whether raw is an https URL on an SNS host.
+func validSNSURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && u.Scheme == "https" && snsCertHost.MatchString(u.Host)
+}
+
+// cert returns the SNS signing certificate at raw, fetching it once.
+func (g *espIngest) cert(ctx context.Context, raw string) (*x509.Certificate, error) {
+	if !validSNSURL(raw) || !strings.HasSuffix(raw, ".pem") {
+		return nil, fmt.Errorf("%w: SNS signing certificate URL %q", ErrBadSignature, raw)
+	}
+	g.mu.Lock()
+	cert := g.certs[raw]
+	g.mu.Unlock()
+	if cert != nil {
+		return cert, nil
+	}
+	data, err := g.get(ctx, raw)
+	if err != nil {
+		return nil, fmt.Errorf("emailtracker: SNS signing certificate: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("emailtracker: SNS signing certificate: no PEM data")
+	}
+	if cert, err = x509.ParseCertificate(block.Bytes); err != nil {
+		return nil, fmt.Errorf("emailtracker: SNS signing certificate: %w", err)
+	}
+	g.mu.Lock()
+	if len(g.certs) >= maxSNSCerts {
+		clear(g.certs)
+	}
+	g.certs[raw] = cert
+	g.mu.Unlock()
+	return cert, nil
+}
+
+func (g *espIngest) confirm(ctx context.Context, raw string) error {
+	if !validSNSURL(raw) {
+		return fmt.Errorf("emailtracker: SNS SubscribeURL %q", raw)
+	}
+	if _, err := g.get(ctx, raw); err != nil {
+		return fmt.Errorf("emailtracker: SNS subscription confirmation: %w", err)
+	}
+	return nil
+}
+
+func (g *espIngest) get(ctx context.Context, raw string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, raw, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+}
+
+// sesNotification covers both SES notifications (notificationType) and
+// event publishing records (eventType).
+type sesNotification struct {
+	NotificationType string `json:"notificationType"`
+	EventType        string `json:"eventType"`
+	Mail             struct {
+		MessageID   string              `json:"messageId"`
+		Timestamp   string              `json:"timestamp"`
+		Destination []string            `json:"destination"`
+		Headers     []sesHeader         `json:"headers"`
+		Tags        map[string][]string `json:"tags"`
+	} `json:"mail"`
+	Bounce *struct {
+		BounceType    string `json:"bounceType"`
+		BounceSubType string `json:"bounceSubType"`
+		Timestamp     string `json:"timestamp"`
+	} `json:"bounce"`
+	Complaint *struct {
+		FeedbackType string `json:"complaintFeedbackType"`
+		Timestamp    string `json:"timestamp"`
+	} `json:"complaint"`
+	Delivery *struct {
+		Timestamp string `json:"timestamp"`
+	} `json:"delivery"`
+	Open *struct {
+		Timestamp string `json:"timestamp"`
+		IPAddress string `json:"ipAddress"`
+		UserAgent string `json:"userAgent"`
+	} `json:"open"`
+}
+
+type sesHeader struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+var sesKinds = map[string]EventKind{
+	"Delivery":  EventDelivered,
+	"Bounce":    EventBounce,
+	"Complaint": EventComplaint,
+	"Open":      EventOpen,
+}
+
+func parseSES(message string) ([]espRecord, error) {
+	var n sesNotification
+	if err := json.Unmarshal([]byte(message), &n); err != nil {
+		return nil, fmt.Errorf("emailtracker: SES notification: %w", err)
+	}
+	kind, ok := sesKinds[cmp.Or(n.NotificationType, n.EventType)]
+	if !ok {
+		return nil, nil
+	}
+	rec := espRecord{msg: ESPMessage{
+		Provider:   SourceSES,
+		Kind:       kind,
+		MessageID:  n.Mail.MessageID,
+		Recipients: n.Mail.Destination,
+	}}
+	for _, h := range n.Mail.Headers {
+		if rec.msg.Headers == nil {
+			rec.msg.Headers = make(map[string]string, len(n.Mail.Headers))
+		}
+		rec.msg.Headers[h.Name] = h.Value
+	}
+	for k, v := range n.Mail.Tags {
+		if len(v) > 0 {
+			if rec.msg.Args == nil {
+				rec.msg.Args = make(map[string]string, len(n.Mail.Tags))
+			}
+			rec.msg.Args[k] = v[0]
+		}
+	}
+	at := n.Mail.Timestamp
+	switch {
+	case n.Bounce != nil && kind == EventBounce:
+		at = cmp.Or(n.Bounce.Timestamp, at)
+		rec.detail = n.Bounce.BounceType
+		if n.Bounce.BounceSubType != "" {
+			rec.detail += "/" + n.Bounce.BounceSubType
+		}
+	case n.Complaint != nil && kind == EventComplaint:
+		at = cmp.Or(n.Complaint.Timestamp, at)
+		rec.detail = n.Complaint.FeedbackType
+	case n.Delivery != nil && kind == EventDelivered:
+		at = cmp.Or(n.Delivery.Timestamp, at)
+	case n.Open != nil && kind == EventOpen:
+		at = cmp.Or(n.Open.Timestamp, at)
+		rec.ip, rec.agent = n.Open.IPAddress, n.Open.UserAgent
+	}
+	if ts, err := time.Parse(time.RFC3339Nano, at); err == nil {
+		rec.at = ts
+	}
+	return []espRecord{rec}, nil
+}
@@ -0,0 +1,247 @@
+package emailtracker
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	testTopic   = "arn:aws:sns:us-east-1:123456789012:ses-events"
+	testCertURL = "https://sns.us-east-1.amazonaws.com/SimpleNotificationService-test.pem"
+)
+
+// snsSigner signs SNS messages with a certificate served at testCertURL by
+// its client.
+type snsSigner struct {
+	key    *rsa.PrivateKey
+	client *http.Client
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func newSNSSigner(t *testing.T) *snsSigner {
+	t.Helper()
+	key := must(rsa.GenerateKey(rand.Reader, 2048))
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "sns.amazonaws.com"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der := must(x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key))
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		if r.URL.String() != testCertURL {
+			return &http.Response{StatusCode: http.StatusNotFound, Body: io.NopCloser(strings.NewReader("")), Request: r}, nil
+		}
+		return &http.Response{StatusCode: http.StatusOK, Body: io.NopCloser(bytes.NewReader(certPEM)), Request: r}, nil
+	})}
+	return &snsSigner{key: key, client: client}
+}
+
+// notification returns a signed SNS body for a bounce of SES message
+// messageID, published on topic at ts.
+func (s *snsSigner) notification(t *testing.T, id, topic, messageID string, ts time.Time) []byte {
+	t.Helper()
+	ses := must(json.Marshal(map[string]any{
+		"notificationType": "Bounce",
+		"mail":             map[string]any{"messageId": messageID},
+		"bounce":           map[string]any{"bounceType": "Permanent"},
+	}))
+	m := snsMessage{
+		Type:             "Notification",
+		MessageID:        id,
+		TopicArn:         topic,
+		Message:          string(ses),
+		Timestamp:        ts.UTC().Format("2006-01-02T15:04:05.000Z"),
+		SignatureVersion: "2",
+		SigningCertURL:   testCertURL,
+	}
+	sum := sha256.Sum256(snsStringToSign(&m))
+	m.Signature = base64.StdEncoding.EncodeToString(must(rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, sum[:])))
+	return must(json.Marshal(m))
+}
+
+// postSNS posts body to h as an SNS notification delivery.
+func postSNS(h http.Handler, body []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, "/esp", bytes.NewReader(body))
+	r.Header.Set("X-Amz-Sns-Message-Type", "Notification")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestSNSTopics(t *testing.T) {
+	signer := newSNSSigner(t)
+	for _, c := range []struct {
+		name   string
+		topics []string
+		topic  string
+		status int
+	}{
+		{"allowed topic", []string{testTopic}, testTopic, http.StatusOK},
+		{"other topic", []string{testTopic}, "arn:aws:sns:us-east-1:999999999999:forged", http.StatusForbidden},
+		{"no topics configured", nil, testTopic, http.StatusForbidden},
+	} {
+		tr, log := newTestTracker(t, Config{ESPWebhookPath: "/esp", ESP: ESPConfig{SNSTopicARNs: c.topics, HTTPClient: signer.client}})
+		errs := recordErrors(tr)
+		w := postSNS(tr.ESPWebhookHandler(), signer.notification(t, "sns-1", c.topic, "ses-1", time.Now()))
+		if w.Code != c.status {
+			t.Errorf("%s: status %d, want %d (errors %v)", c.name, w.Code, c.status, errs.all())
+		}
+		events := log.all()
+		if ok := c.status == http.StatusOK; ok && (len(events) != 1 || events[0].Kind != EventBounce || events[0].ID != "ses-1") || !ok && len(events) != 0 {
+			t.Errorf("%s: events %+v", c.name, events)
+		}
+	}
+}
+
+// sendGridSigner signs SendGrid event webhook posts.
+type sendGridSigner struct {
+	key    *ecdsa.PrivateKey
+	public string // for ESPConfig.SendGridPublicKey
+}
+
+func newSendGridSigner(t *testing.T) *sendGridSigner {
+	t.Helper()
+	key := must(ecdsa.GenerateKey(elliptic.P256(), rand.Reader))
+	der := must(x509.MarshalPKIXPublicKey(&key.PublicKey))
+	return &sendGridSigner{key: key, public: base64.StdEncoding.EncodeToString(der)}
+}
+
+// post posts a signed SendGrid bounce of messageID, stamped ts, to h.
+func (s *sendGridSigner) post(t *testing.T, h http.Handler, messageID string, ts time.Time) *httptest.ResponseRecorder {
+	t.Helper()
+	body := must(json.Marshal([]map[string]any{{"event": "bounce", "sg_message_id": messageID, "type": "bounce"}}))
+	return s.serve(h, s.sign(t, body, ts))
+}
+
+// sign returns the request of a signed post of body, stamped ts.
+func (s *sendGridSigner) sign(t *testing.T, body []byte, ts time.Time) *http.Request {
+	t.Helper()
+	stamp := strconv.FormatInt(ts.Unix(), 10)
+	sum := sha256.Sum256(append([]byte(stamp), body...))
+	sig := must(ecdsa.SignASN1(rand.Reader, s.key, sum[:]))
+	r := httptest.NewRequest(http.MethodPost, "/esp", bytes.NewReader(body))
+	r.Header.Set("X-Twilio-Email-Event-Webhook-Timestamp", stamp)
+	r.Header.Set("X-Twilio-Email-Event-Webhook-Signature", base64.StdEncoding.EncodeToString(sig))
+	return r
+}
+
+// serve serves a copy of r through h, so a captured post can be sent again.
+func (s *sendGridSigner) serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	body := must(io.ReadAll(r.Body))
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	again := r.Clone(r.Context())
+	again.Body = io.NopCloser(bytes.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, again)
+	return w
+}
+
+func espTracker(t *testing.T, sg *sendGridSigner, sns *snsSigner) (*Tracker, *eventLog, *testClock) {
+	t.Helper()
+	clock := newTestClock()
+	tr, log := newTestTracker(t, Config{
+		Clock:          clock,
+		ESPWebhookPath: "/esp",
+		ESP:            ESPConfig{SendGridPublicKey: sg.public, SNSTopicARNs: []string{testTopic}, HTTPClient: sns.client},
+	})
+	return tr, log, clock
+}
+
+func TestESPTimestamps(t *testing.T) {
+	sg, sns := newSendGridSigner(t), newSNSSigner(t)
+	for _, c := range []struct {
+		name   string
+		offset time.Duration
+		status int
+	}{
+		{"current", 0, http.StatusOK},
+		{"four minutes old", -4 * time.Minute, http.StatusOK},
+		{"four minutes ahead", 4 * time.Minute, http.StatusOK},
+		{"six minutes old", -6 * time.Minute, http.StatusForbidden},
+		{"six minutes ahead", 6 * time.Minute, http.StatusForbidden},
+		{"a day old", -24 * time.Hour, http.StatusForbidden},
+	} {
+		tr, log, clock := espTracker(t, sg, sns)
+		ts := clock.Now().Add(c.offset)
+		if w := sg.post(t, tr.ESPWebhookHandler(), "sg-1", ts); w.Code != c.status {
+			t.Errorf("SendGrid %s: status %d, want %d", c.name, w.Code, c.status)
+		}
+		if w := postSNS(tr.ESPWebhookHandler(), sns.notification(t, "sns-1", testTopic, "ses-1", ts)); w.Code != c.status {
+			t.Errorf("SNS %s: status %d, want %d", c.name, w.Code, c.status)
+		}
+		want := 0
+		if c.status == http.StatusOK {
+			want = 2
+		}
+		if n := len(log.all()); n != want {
+			t.Errorf("%s: %d events, want %d", c.name, n, want)
+		}
+	}
+}
+
+func TestESPReplay(t *testing.T) {
+	sg, sns := newSendGridSigner(t), newSNSSigner(t)
+	tr, log, clock := espTracker(t, sg, sns)
+	h := tr.ESPWebhookHandler()
+	captured := sg.sign(t, must(json.Marshal([]map[string]any{{"event": "bounce", "sg_message_id": "sg-1"}})), clock.Now())
+	snsBody := sns.notification(t, "sns-1", testTopic, "ses-1", clock.Now())
+
+	if w := sg.serve(h, captured); w.Code != http.StatusOK {
+		t.Fatalf("SendGrid post: %d", w.Code)
+	}
+	if w := postSNS(h, snsBody); w.Code != http.StatusOK {
+		t.Fatalf("SNS post: %d", w.Code)
+	}
+
+	// Replays within the window are accepted but not ingested again.
+	clock.advance(time.Minute)
+	for range 3 {
+		if w := sg.serve(h, captured); w.Code != http.StatusOK {
+			t.Errorf("SendGrid replay: %d, want 200", w.Code)
+		}
+		if w := postSNS(h, snsBody); w.Code != http.StatusOK {
+			t.Errorf("SNS replay: %d, want 200", w.Code)
+		}
+	}
+	if n := len(log.all()); n != 2 {
+		t.Errorf("%d events after replays, want the 2 originals", n)
+	}
+	if n := tr.Metrics().EventsDropped[DropESPReplay]; n != 6 {
+		t.Errorf("%d replays dropped, want 6", n)
+	}
+
+	// Later they are stale.
+	clock.advance(time.Hour)
+	if w := sg.serve(h, captured); w.Code != http.StatusForbidden {
+		t.Errorf("late SendGrid replay: %d, want 403", w.Code)
+	}
+	if w := postSNS(h, snsBody); w.Code != http.StatusForbidden {
+		t.Errorf("late SNS replay: %d, want 403", w.Code)
+	}
+	if n := len(log.all()); n != 2 {
+		t.Errorf("%d events after late replays, want 2", n)
+	}
+}
@@ -0,0 +1,58 @@
+package emailtracker
+
+import (
+	"math/rand/v2"
+	"net/http"
+	"time"
+)
+
+// maxCorrelationIDLen caps the X-Request-ID value kept as CorrelationID.
+const maxCorrelationIDLen = 128
+
+// newEventID returns a UUIDv7 for an event created at t: 48 bits of Unix
+// milliseconds and 12 of sub-millisecond fraction, so IDs sort by time,
+// then 62 random bits. The runtime's per-thread generator makes this
+// cheap under contention and its output unpredictable enough for an
+// idempotency key; the one allocation is the string.
+func newEventID(t time.Time) string {
+	ns := t.UnixNano()
+	ms := uint64(ns / 1e6)
+	frac := uint64(ns%1e6) * 4096 / 1e6
+	rnd := rand.Uint64()
+
+	var b [16]byte
+	b[0], b[1], b[2], b[3], b[4], b[5] = byte(ms>>40), byte(ms>>32), byte(ms>>24), byte(ms>>16), byte(ms>>8), byte(ms)
+	b[6], b[7] = 0x70|byte(frac>>8), byte(frac)
+	for i := 8; i < 16; i++ {
+		b[i] = byte(rnd >> (8 * (15 - i)))
+	}
+	b[8] = 0x80 | b[8]&0x3f
+
+	const hexDigits = "0123456789abcdef"
+	var s [36]byte
+	j := 0
+	for i, c := range b {
+		if i == 4 || i == 6 || i == 8 || i == 10 {
+			s[j] = '-'
+			j++
+		}
+		s[j], s[j+1] = hexDigits[c>>4], hexDigits[c&0x0f]
+		j += 2
+	}
+	return string(s[:])
+}
+
+// correlationID returns r's X-Request-ID, or "" when it is missing, too
+// long or not printable ASCII.
+func correlationID(r *http.Request) string {
+	v := r.Header.Get("X-Request-Id") // canonical, so looked up without allocating
+	if len(v) > maxCorrelationIDLen {
+		return ""
+	}
+	for i := 0; i < len(v); i++ {
+		if v[i] <= ' ' || v[i] >= 0x7f {
+			return ""
+		}
+	}
+	return v
+}
@@ -0,0 +1,82 @@
+package emailtracker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// eventsBuffer is the capacity of each Events channel.
+const eventsBuffer = 256
+
+// eventChans fans events out to the channels returned by Events.
+type eventChans struct {
+	mu      sync.RWMutex
+	chans   map[chan OpenEvent]struct{}
+	closed  bool
+	n       atomic.Int32 // len(chans), read without mu on the hot path
+	dropped atomic.Uint64
+}
+
+// Events returns a channel receiving every event subscribers receive, until
+// ctx is done or Shutdown has delivered the last event, when it is closed.
+// Each call returns its own channel, buffered for 256 events. Delivery never
+// waits for a consumer: an event that doesn't fit in a full buffer is
+// dropped for that channel and counted by EventsDropped.
+func (t *Tracker) Events(ctx context.Context) <-chan OpenEvent {
+	ch := make(chan OpenEvent, eventsBuffer)
+	c := &t.events
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		close(ch)
+		return ch
+	}
+	if c.chans == nil {
+		c.chans = make(map[chan OpenEvent]struct{})
+	}
+	c.chans[ch] = struct{}{}
+	c.n.Add(1)
+	c.mu.Unlock()
+	context.AfterFunc(ctx, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if _, ok := c.chans[ch]; ok {
+			delete(c.chans, ch)
+			c.n.Add(-1)
+			close(ch)
+		}
+	})
+	return ch
+}
+
+// EventsDropped reports how many events Events channels missed because
+// their buffer was full, summed over all channels.
+func (t *Tracker) EventsDropped() uint64 {
+	return t.events.dropped.Load()
+}
+
+// send offers e to every channel without blocking.
+func (c *eventChans) send(e OpenEvent) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for ch := range c.chans {
+		select {
+		case ch <- e:
+		default:
+			c.dropped.Add(1)
+		}
+	}
+}
+
+// close closes every channel; later Events calls get a closed channel.
+func (c *eventChans) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for ch := range c.chans {
+		close(ch)
+	}
+	c.chans = nil
+	c.n.Store(0)
+}
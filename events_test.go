@@ -0,0 +1,127 @@
+package emailtracker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain receives from ch until it is closed, failing the test after five
+// seconds.
+func drain(t *testing.T, ch <-chan OpenEvent) []OpenEvent {
+	t.Helper()
+	var events []OpenEvent
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return events
+			}
+			events = append(events, e)
+		case <-deadline:
+			t.Fatalf("channel still open after %d events", len(events))
+		}
+	}
+}
+
+func TestEventsConcurrentSubscribers(t *testing.T) {
+	const consumers, senders, n = 8, 4, 50
+	tr, _ := newTestTracker(t, Config{Workers: 4})
+	results := make([]map[string]int, consumers)
+	var wg sync.WaitGroup
+	for i := range consumers {
+		ch := tr.Events(context.Background())
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = make(map[string]int)
+			for e := range ch {
+				results[i][e.ID]++
+			}
+		}()
+	}
+
+	var sent sync.WaitGroup
+	for s := range senders {
+		sent.Add(1)
+		go func() {
+			defer sent.Done()
+			for i := range n {
+				get(tr.Handler(), tr.GenerateLink(fmt.Sprintf("msg-%d-%d", s, i)))
+			}
+		}()
+	}
+	sent.Wait()
+	shutdown(t, tr) // drains the workers, then closes every channel
+	wg.Wait()
+
+	for i, got := range results {
+		if len(got) != senders*n {
+			t.Errorf("consumer %d got %d distinct events, want %d", i, len(got), senders*n)
+		}
+		for id, c := range got {
+			if c != 1 {
+				t.Errorf("consumer %d got %s %d times", i, id, c)
+			}
+		}
+	}
+	if d := tr.EventsDropped(); d != 0 {
+		t.Errorf("EventsDropped = %d, want 0", d)
+	}
+}
+
+func TestEventsCancel(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := tr.Events(ctx)
+	kept := tr.Events(context.Background())
+
+	get(tr.Handler(), tr.GenerateLink("before"))
+	cancel()
+	if events := drain(t, cancelled); len(events) != 1 || events[0].ID != "before" {
+		t.Errorf("cancelled channel got %v, want only the event before cancel", events)
+	}
+	get(tr.Handler(), tr.GenerateLink("after"))
+	for _, want := range []string{"before", "after"} {
+		if e := <-kept; e.ID != want {
+			t.Errorf("other channel got %q, want %q", e.ID, want)
+		}
+	}
+	if n := tr.events.n.Load(); n != 1 {
+		t.Errorf("%d subscriptions left, want 1", n)
+	}
+}
+
+func TestEventsSlowConsumer(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	slow := tr.Events(context.Background())
+	for i := range eventsBuffer + 10 {
+		get(tr.Handler(), tr.GenerateLink(fmt.Sprint("msg-", i)))
+	}
+	if d := tr.EventsDropped(); d != 10 {
+		t.Errorf("EventsDropped = %d, want 10", d)
+	}
+	shutdown(t, tr)
+	events := drain(t, slow)
+	if len(events) != eventsBuffer || events[0].ID != "msg-0" || events[len(events)-1].ID != fmt.Sprint("msg-", eventsBuffer-1) {
+		t.Errorf("slow consumer got %d events, want the first %d", len(events), eventsBuffer)
+	}
+}
+
+func TestEventsShutdown(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{Workers: 2})
+	ch := tr.Events(context.Background())
+	for i := range 20 {
+		get(tr.Handler(), tr.GenerateLink(fmt.Sprint("msg-", i)))
+	}
+	shutdown(t, tr)
+	if events := drain(t, ch); len(events) != 20 {
+		t.Errorf("got %d events before the channel closed, want all 20", len(events))
+	}
+	if _, ok := <-tr.Events(context.Background()); ok {
+		t.Error("Events after Shutdown returned an open channel")
+	}
+}
@@ -0,0 +1,108 @@
+package emailtracker
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ExportFormat selects the encoding written by Export.
+type ExportFormat string
+
+const (
+	ExportCSV    ExportFormat = "csv"    // RFC 4180 with a header row
+	ExportNDJSON ExportFormat = "ndjson" // one JSON event per line
+)
+
+// ExportFilter restricts the events written by Export. Zero fields match
+// everything.
+type ExportFilter struct {
+	IDPrefix string
+	Campaign string    // OpenEvent.CampaignID, see GenerateLinkFor
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+}
+
+func (f ExportFilter) match(e *OpenEvent) bool {
+	return strings.HasPrefix(e.ID, f.IDPrefix) &&
+		(f.Campaign == "" || e.CampaignID == f.Campaign) &&
+		(f.From.IsZero() || !e.Time.Before(f.From)) &&
+		(f.To.IsZero() || e.Time.Before(f.To))
+}
+
+// csvHeader names the columns of a CSV export.
+var csvHeader = []string{
+	"time", "kind", "id", "campaign_id", "recipient_id", "ip", "user_agent", "referer",
+	"accept_lang", "url", "first_open", "is_bot", "bot_name", "device_type", "os",
+	"os_version", "client", "client_version", "country", "region", "city", "event_id",
+}
+
+func csvRecord(e *OpenEvent) []string {
+	record := []string{
+		e.Time.Format(jsonTimeFormat), string(e.Kind), e.ID, e.CampaignID, e.RecipientID, e.IP,
+		e.UserAgent, e.Referer, e.AcceptLang, e.URL, strconv.FormatBool(e.FirstOpen),
+		strconv.FormatBool(e.IsBot), e.BotName, string(e.DeviceType), e.OS, e.OSVersion,
+		e.Client, e.ClientVersion, e.Geo.Country, e.Geo.Region, e.Geo.City, e.EventID,
+	}
+	for i, f := range record {
+		record[i] = csvSafe(f)
+	}
+	return record
+}
+
+// csvSafe defuses a field a spreadsheet would run as a formula, such as a
+// User-Agent of "=HYPERLINK(...)", by prefixing it with a quote.
+func csvSafe(f string) string {
+	if f != "" && strings.ContainsRune("=+-@\t\r", rune(f[0])) {
+		return "'" + f
+	}
+	return f
+}
+
+// Export writes the stored events matching filter to w, oldest first. Events
+// are streamed from the Store one at a time, never loaded all at once.
+func (t *Tracker) Export(w io.Writer, format ExportFormat, filter ExportFilter) error {
+	if t.config.Store == nil {
+		return ErrNoStore
+	}
+	var (
+		write func(*OpenEvent) error
+		flush = func() error { return nil }
+	)
+	switch format {
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return err
+		}
+		write = func(e *OpenEvent) error { return cw.Write(csvRecord(e)) }
+		flush = func() error {
+			cw.Flush()
+			return cw.Error()
+		}
+	case ExportNDJSON:
+		enc := json.NewEncoder(w)
+		write = func(e *OpenEvent) error { return enc.Encode(e) }
+	default:
+		return fmt.Errorf("emailtracker: unknown ExportFormat %q", format)
+	}
+	var werr error
+	err := t.config.Store.Each(func(e OpenEvent) bool {
+		if !filter.match(&e) {
+			return true
+		}
+		werr = write(&e)
+		return werr == nil
+	})
+	if werr != nil {
+		return werr
+	}
+	if err != nil {
+		return err
+	}
+	return flush()
+}
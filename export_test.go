@@ -0,0 +1,254 @@
+package emailtracker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+var exportStart = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// exportTracker returns a tracker whose store holds opens msg-0 to msg-9,
+// a minute apart, the even ones in campaign "spring", and one open whose
+// User-Agent needs quoting.
+func exportTracker(t *testing.T) *Tracker {
+	t.Helper()
+	store := NewMemoryStore(0)
+	for i := range 10 {
+		e := OpenEvent{Kind: EventOpen, ID: fmt.Sprintf("msg-%d", i), Time: exportStart.Add(time.Duration(i) * time.Minute)}
+		if i%2 == 0 {
+			e.CampaignID = "spring"
+		}
+		store.Save(e)
+	}
+	store.Save(OpenEvent{
+		Kind:      EventOpen,
+		ID:        "awkward",
+		Time:      exportStart.Add(time.Hour),
+		UserAgent: "Mozilla/5.0 (X11, Linux) \"quoted\"\nsecond line",
+		Referer:   "https://example.com/?a=1,b=2",
+	})
+	tr, _ := newTestTracker(t, Config{Store: store})
+	return tr
+}
+
+func TestExportCSV(t *testing.T) {
+	tr := exportTracker(t)
+	var buf bytes.Buffer
+	if err := tr.Export(&buf, ExportCSV, ExportFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("output isn't valid CSV: %v", err)
+	}
+	if len(records) != 12 {
+		t.Fatalf("%d records, want a header and 11 rows", len(records))
+	}
+	if strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+		t.Errorf("header %q", records[0])
+	}
+	col := make(map[string]int)
+	for i, name := range records[0] {
+		col[name] = i
+	}
+	for i, rec := range records[1:11] {
+		if rec[col["id"]] != fmt.Sprintf("msg-%d", i) {
+			t.Errorf("row %d has id %q", i+1, rec[col["id"]])
+		}
+	}
+	last := records[11]
+	if last[col["user_agent"]] != "Mozilla/5.0 (X11, Linux) \"quoted\"\nsecond line" || last[col["referer"]] != "https://example.com/?a=1,b=2" {
+		t.Errorf("quoted fields came back as %q, %q", last[col["user_agent"]], last[col["referer"]])
+	}
+	if got, _ := time.Parse(time.RFC3339Nano, last[col["time"]]); !got.Equal(exportStart.Add(time.Hour)) {
+		t.Errorf("time %q", last[col["time"]])
+	}
+}
+
+func TestExportNDJSON(t *testing.T) {
+	tr := exportTracker(t)
+	var buf bytes.Buffer
+	if err := tr.Export(&buf, ExportNDJSON, ExportFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	sc := bufio.NewScanner(&buf)
+	for sc.Scan() {
+		var e OpenEvent
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		ids = append(ids, e.ID)
+		if e.ID == "awkward" && !strings.Contains(e.UserAgent, "\nsecond line") {
+			t.Errorf("User-Agent %q", e.UserAgent)
+		}
+	}
+	if want := "msg-0 msg-1 msg-2 msg-3 msg-4 msg-5 msg-6 msg-7 msg-8 msg-9 awkward"; strings.Join(ids, " ") != want {
+		t.Errorf("ids %v, want %s", ids, want)
+	}
+}
+
+func TestExportFilter(t *testing.T) {
+	tr := exportTracker(t)
+	for _, c := range []struct {
+		name   string
+		filter ExportFilter
+		want   string
+	}{
+		{name: "prefix", filter: ExportFilter{IDPrefix: "aw"}, want: "awkward"},
+		{name: "campaign", filter: ExportFilter{Campaign: "spring"}, want: "msg-0 msg-2 msg-4 msg-6 msg-8"},
+		{name: "from inclusive", filter: ExportFilter{From: exportStart.Add(8 * time.Minute)}, want: "msg-8 msg-9 awkward"},
+		{name: "to exclusive", filter: ExportFilter{To: exportStart.Add(2 * time.Minute)}, want: "msg-0 msg-1"},
+		{name: "combined", filter: ExportFilter{IDPrefix: "msg-", Campaign: "spring", From: exportStart.Add(3 * time.Minute), To: exportStart.Add(7 * time.Minute)}, want: "msg-4 msg-6"},
+		{name: "nothing", filter: ExportFilter{IDPrefix: "none"}, want: ""},
+	} {
+		var buf bytes.Buffer
+		if err := tr.Export(&buf, ExportNDJSON, c.filter); err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var ids []string
+		for dec := json.NewDecoder(&buf); ; {
+			var e OpenEvent
+			if err := dec.Decode(&e); err == io.EOF {
+				break
+			} else if err != nil {
+				t.Fatalf("%s: %v", c.name, err)
+			}
+			ids = append(ids, e.ID)
+		}
+		if got := strings.Join(ids, " "); got != c.want {
+			t.Errorf("%s: got %q, want %q", c.name, got, c.want)
+		}
+	}
+}
+
+// countingWriter counts the lines written to it.
+type countingWriter struct{ lines int }
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.lines += bytes.Count(p, []byte("\n"))
+	return len(p), nil
+}
+
+// eachStore yields n generated events, calling check before each one.
+type eachStore struct {
+	n     int
+	check func(i int)
+}
+
+func (s *eachStore) Save(OpenEvent) error                { return nil }
+func (s *eachStore) ByID(string) ([]OpenEvent, error)    { return nil, nil }
+func (s *eachStore) CountOpens(string, int) (int, error) { return 0, nil }
+func (s *eachStore) Each(fn func(OpenEvent) bool) error {
+	for i := range s.n {
+		s.check(i)
+		if !fn(OpenEvent{Kind: EventOpen, ID: fmt.Sprintf("msg-%d", i), Time: exportStart}) {
+			break
+		}
+	}
+	return nil
+}
+
+func TestExportStreams(t *testing.T) {
+	var w countingWriter
+	store := &eachStore{n: 1000}
+	store.check = func(i int) {
+		if w.lines != i {
+			t.Fatalf("before event %d, %d lines were written", i, w.lines)
+		}
+	}
+	tr, _ := newTestTracker(t, Config{Store: store})
+	if err := tr.Export(&w, ExportNDJSON, ExportFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	if w.lines != store.n {
+		t.Errorf("%d lines, want %d", w.lines, store.n)
+	}
+}
+
+// failingWriter fails every write after the first n.
+type failingWriter struct{ n int }
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if w.n == 0 {
+		return 0, errBroken
+	}
+	w.n--
+	return len(p), nil
+}
+
+func TestExportErrors(t *testing.T) {
+	var yielded int
+	store := &eachStore{n: 100, check: func(int) { yielded++ }}
+	tr, _ := newTestTracker(t, Config{Store: store})
+	if err := tr.Export(&failingWriter{n: 3}, ExportNDJSON, ExportFilter{}); !errors.Is(err, errBroken) {
+		t.Errorf("Export to a failing writer = %v", err)
+	}
+	if yielded != 4 {
+		t.Errorf("store yielded %d events after the writer failed, want iteration to stop at 4", yielded)
+	}
+	if err := tr.Export(io.Discard, "xml", ExportFilter{}); err == nil {
+		t.Error("unknown format accepted")
+	}
+
+	plain, _ := newTestTracker(t, Config{})
+	if err := plain.Export(io.Discard, ExportCSV, ExportFilter{}); !errors.Is(err, ErrNoStore) {
+		t.Errorf("Export without a Store = %v, want ErrNoStore", err)
+	}
+
+	broken, _ := newTestTracker(t, Config{Store: failingStore{}})
+	if err := broken.Export(io.Discard, ExportCSV, ExportFilter{}); !errors.Is(err, errBroken) {
+		t.Errorf("Export from a failing Store = %v", err)
+	}
+}
+
+func TestExportCSVDefusesFormulas(t *testing.T) {
+	store := NewMemoryStore(0)
+	e := OpenEvent{
+		Kind:      EventOpen,
+		ID:        "=1+1",
+		Time:      exportStart,
+		UserAgent: "+cmd|' /C calc'!A0",
+		Referer:   "-2+3",
+		URL:       "@SUM(A1:A9)",
+		BotName:   "\tx",
+	}
+	e.OS, e.Client = "\rx", "Outlook =1"
+	store.Save(e)
+	tr, _ := newTestTracker(t, Config{Store: store})
+	var buf bytes.Buffer
+	if err := tr.Export(&buf, ExportCSV, ExportFilter{}); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	col := make(map[string]int)
+	for i, name := range records[0] {
+		col[name] = i
+	}
+	row := records[1]
+	for name, want := range map[string]string{
+		"id":         "'=1+1",
+		"user_agent": "'+cmd|' /C calc'!A0",
+		"referer":    "'-2+3",
+		"url":        "'@SUM(A1:A9)",
+		"bot_name":   "'\tx",
+		"os":         "'\rx",
+		"client":     "Outlook =1",
+		"kind":       "open",
+	} {
+		if got := row[col[name]]; got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package emailtracker
+
+import "runtime/debug"
+
+// DropFiltered is the drop reason for events rejected by a Filter.
+const DropFiltered DropReason = "filtered"
+
+// Filter registers fn to vet every open and click event once it is built,
+// before dedup, the store, the webhook or any subscriber sees it; returning
+// false drops the event. All registered filters must pass. Filters run on
+// the request path, after bot detection and UA parsing but before the
+// enrichers (see Use), so they don't see geo or reverse DNS results. A
+// filter that panics is reported to the OnError hook as a *PanicError and
+// lets the event through. The pixel or redirect is served either way.
+func (t *Tracker) Filter(fn func(e OpenEvent) bool) {
+	if fn == nil {
+		return
+	}
+	t.subMu.Lock()
+	defer t.subMu.Unlock()
+	filters := make([]func(OpenEvent) bool, len(t.filters), len(t.filters)+1)
+	copy(filters, t.filters)
+	t.filters = append(filters, fn)
+}
+
+// filtered reports whether a filter drops e, counting it if so.
+func (t *Tracker) filtered(e *OpenEvent) bool {
+	t.subMu.RLock()
+	filters := t.filters
+	t.subMu.RUnlock()
+	for _, fn := range filters {
+		if !t.pass(fn, e) {
+			t.metrics.IncDropped(DropFiltered)
+			return true
+		}
+	}
+	return false
+}
+
+func (t *Tracker) pass(fn func(OpenEvent) bool, e *OpenEvent) (ok bool) {
+	defer func() {
+		if p := recover(); p != nil {
+			t.reportError("filter", &PanicError{Value: p, Stack: debug.Stack()}, e)
+			ok = true
+		}
+	}()
+	return fn(*e)
+}
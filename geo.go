@@ -0,0 +1,49 @@
+package emailtracker
+
+import (
+	"context"
+	"fmt"
+)
+
+// Geo is the location resolved for an event's IP address.
+type Geo struct {
+	Country string  `json:"country,omitempty"` // ISO 3166-1 alpha-2 code, e.g. "DE"
+	Region  string  `json:"region,omitempty"`
+	City    string  `json:"city,omitempty"`
+	Lat     float64 `json:"lat,omitempty"`
+	Lon     float64 `json:"lon,omitempty"`
+}
+
+// GeoResolver looks up the location of an IP address. Implementations must
+// be safe for concurrent use.
+type GeoResolver interface {
+	Resolve(ip string) (Geo, error)
+}
+
+// GeoResolverFunc adapts a function to the GeoResolver interface.
+type GeoResolverFunc func(ip string) (Geo, error)
+
+func (f GeoResolverFunc) Resolve(ip string) (Geo, error) { return f(ip) }
+
+// NoopGeoResolver resolves every address to the zero Geo.
+type NoopGeoResolver struct{}
+
+func (NoopGeoResolver) Resolve(string) (Geo, error) { return Geo{}, nil }
+
+// resolveGeo fills e.Geo, except for proxied opens, whose IP is the proxy's.
+// The error deliberately omits the IP, which may be anonymized before the
+// error is reported.
+func (t *Tracker) resolveGeo(_ context.Context, e *OpenEvent) error {
+	if t.config.GeoResolver == nil || e.IP == "" || e.Proxied {
+		return nil
+	}
+	geo, err := t.config.GeoResolver.Resolve(e.IP)
+	if err != nil {
+		return fmt.Errorf("emailtracker: geo lookup: %w", err)
+	}
+	if geo.Country == "" {
+		geo.Country = e.Geo.Country // from CDNCountry, if any
+	}
+	e.Geo = geo
+	return nil
+}
@@ -0,0 +1,184 @@
+package emailtracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// Group serves several trackers from one listener, e.g. one per brand on
+// "/a/pixel" and "/b/pixel". Each tracker keeps its own Config, signing key
+// and subscribers; their Port settings are ignored.
+type Group struct {
+	port     int
+	trackers []*Tracker
+
+	mu     sync.Mutex
+	server *http.Server
+	addr   net.Addr
+	closed bool
+}
+
+// NewGroup returns a Group that will serve trackers on port. Port 0 picks a
+// free port, see Addr. The server uses the default limits described at
+// Config.ReadTimeout.
+func NewGroup(port int, trackers ...*Tracker) *Group {
+	return &Group{port: port, trackers: trackers}
+}
+
+// Add registers another tracker. It has no effect once the group has
+// started.
+func (g *Group) Add(t *Tracker) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.server == nil {
+		g.trackers = append(g.trackers, t)
+	}
+}
+
+// Start serves every tracker's routes. Like Tracker.Start it blocks until the
+// server fails or Shutdown is called, in which case it returns nil. It
+// reports an error if two trackers claim the same path or a tracker is
+// already running on its own.
+func (g *Group) Start() error {
+	return g.serve(false, "", "")
+}
+
+// StartTLS is like Start but serves HTTPS using the given certificate and key
+// files.
+func (g *Group) StartTLS(certFile, keyFile string) error {
+	return g.serve(true, certFile, keyFile)
+}
+
+func (g *Group) serve(useTLS bool, certFile, keyFile string) error {
+	g.mu.Lock()
+	if g.closed {
+		g.mu.Unlock()
+		return ErrTrackerClosed
+	}
+	if g.server != nil {
+		g.mu.Unlock()
+		return ErrAlreadyStarted
+	}
+	mux := http.NewServeMux()
+	owner := make(map[string]*Tracker)
+	for _, t := range g.trackers {
+		for _, r := range t.routes() {
+			if _, dup := owner[r.pattern]; dup {
+				g.mu.Unlock()
+				return fmt.Errorf("emailtracker: group: path %q is served by two trackers", r.pattern)
+			}
+			owner[r.pattern] = t
+			mux.Handle(r.pattern, r.handler)
+		}
+	}
+	if err := g.join(useTLS); err != nil {
+		g.mu.Unlock()
+		return err
+	}
+	srv := newServer(fmt.Sprintf(":%d", g.port), mux, Config{})
+	g.server = srv
+	g.mu.Unlock()
+
+	ln, err := net.Listen("tcp", srv.Addr)
+	if err == nil {
+		g.mu.Lock()
+		g.addr = ln.Addr()
+		g.mu.Unlock()
+		for _, t := range g.trackers {
+			t.bound(ln.Addr(), g.port == 0)
+			t.ready.Store(true)
+		}
+		if useTLS {
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		for _, t := range g.trackers {
+			t.ready.Store(false)
+		}
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
+	}
+	// The listener never came up; allow another attempt.
+	g.mu.Lock()
+	if g.server == srv {
+		g.server = nil
+		g.leave()
+	}
+	g.mu.Unlock()
+	return err
+}
+
+// join marks every tracker as served by the group, failing if any of them
+// is running or shut down. It is all or nothing.
+func (g *Group) join(useTLS bool) error {
+	for _, t := range g.trackers {
+		t.mu.Lock()
+		closed, running := t.closed, t.server != nil || t.grouped
+		t.mu.Unlock()
+		if closed {
+			return ErrTrackerClosed
+		}
+		if running {
+			return ErrAlreadyStarted
+		}
+	}
+	for _, t := range g.trackers {
+		t.mu.Lock()
+		t.grouped = true
+		t.tls = useTLS
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+func (g *Group) leave() {
+	for _, t := range g.trackers {
+		t.mu.Lock()
+		t.grouped = false
+		t.mu.Unlock()
+	}
+}
+
+// Addr returns the address the group listens on, or nil before it has
+// started.
+func (g *Group) Addr() net.Addr {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.addr
+}
+
+// Shutdown stops the shared server, then shuts down every tracker as
+// Tracker.Shutdown does, draining their queues and callbacks. A stopped
+// group cannot be restarted.
+func (g *Group) Shutdown(ctx context.Context) error {
+	g.mu.Lock()
+	srv := g.server
+	trackers := g.trackers
+	g.closed = true
+	g.mu.Unlock()
+
+	for _, t := range trackers {
+		t.ready.Store(false)
+		if t.stream != nil {
+			t.stream.close()
+		}
+	}
+	if srv != nil {
+		if err := srv.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	for _, t := range trackers {
+		if err := t.Shutdown(ctx); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,44 @@
+package emailtracker
+
+import (
+	"net/http"
+	"strings"
+)
+
+// sensitiveHeaders are never copied into OpenEvent.Headers.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+	"X-Auth-Token":        true,
+	"X-Csrf-Token":        true,
+}
+
+// captureHeaders copies the configured request headers, keyed by canonical
+// name with multiple values joined by ", ". It returns nil when nothing is
+// configured or present.
+func (t *Tracker) captureHeaders(h http.Header) map[string]string {
+	var out map[string]string
+	set := func(name string, values []string) {
+		if len(values) == 0 || sensitiveHeaders[name] {
+			return
+		}
+		if out == nil {
+			out = make(map[string]string)
+		}
+		out[name] = strings.Join(values, ", ")
+	}
+	if t.config.CaptureAllHeaders {
+		for name, values := range h {
+			set(http.CanonicalHeaderKey(name), values)
+		}
+		return out
+	}
+	for _, name := range t.config.CaptureHeaders {
+		name = http.CanonicalHeaderKey(name)
+		set(name, h.Values(name))
+	}
+	return out
+}
@@ -0,0 +1,50 @@
+package emailtracker
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// Route labels for the probe endpoints.
+const (
+	RouteHealth = "health"
+	RouteReady  = "ready"
+)
+
+type probeStatus struct {
+	Status  string  `json:"status"`
+	Uptime  float64 `json:"uptime_seconds"`
+	Version string  `json:"version,omitempty"`
+}
+
+// HealthHandler always answers 200 with a small JSON status body.
+func (t *Tracker) HealthHandler() http.HandlerFunc {
+	return t.instrument(RouteHealth, func(w http.ResponseWriter, r *http.Request) {
+		t.writeProbe(w, http.StatusOK, "ok")
+	})
+}
+
+// ReadyHandler answers 200 while the server is bound and accepting
+// requests, and 503 before Start has bound the listener or once Shutdown has
+// begun.
+func (t *Tracker) ReadyHandler() http.HandlerFunc {
+	return t.instrument(RouteReady, func(w http.ResponseWriter, r *http.Request) {
+		if !t.ready.Load() {
+			t.writeProbe(w, http.StatusServiceUnavailable, "unavailable")
+			return
+		}
+		t.writeProbe(w, http.StatusOK, "ready")
+	})
+}
+
+func (t *Tracker) writeProbe(w http.ResponseWriter, code int, status string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(probeStatus{
+		Status:  status,
+		Uptime:  time.Since(t.created).Seconds(),
+		Version: t.config.Version,
+	})
+}
@@ -0,0 +1,85 @@
+package emailtracker
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// cipherSuiteNames maps the cipher suites crypto/tls knows to their names,
+// built once since tls.CipherSuiteName rebuilds its tables on every call.
+var cipherSuiteNames = func() map[uint16]string {
+	names := make(map[uint16]string)
+	for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		names[s.ID] = s.Name
+	}
+	return names
+}()
+
+// cipherSuiteName is tls.CipherSuiteName, without the allocations.
+func cipherSuiteName(id uint16) string {
+	if name, ok := cipherSuiteNames[id]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%04X", id)
+}
+
+// maxHostLen bounds a forwarded host, the longest DNS name plus a port.
+const maxHostLen = 255 + len(":65535")
+
+// requestHost returns the client-facing host of r: X-Forwarded-Host, or the
+// host= of a Forwarded header, when the peer may set forwarding headers;
+// otherwise the Host header, falling back to the TLS server name.
+func (t *Tracker) requestHost(r *http.Request) string {
+	if t.trustedPeer(r) {
+		if h, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Host"), ","); validHost(h) {
+			return strings.TrimSpace(h)
+		}
+		if hosts := forwardedParam(r.Header.Values("Forwarded"), "host"); len(hosts) > 0 && validHost(hosts[0]) {
+			return strings.TrimSpace(hosts[0])
+		}
+	}
+	if r.Host != "" {
+		return r.Host
+	}
+	if r.TLS != nil {
+		return r.TLS.ServerName
+	}
+	return ""
+}
+
+// requestTLS reports whether r arrived over TLS, with the negotiated
+// version and cipher suite when the tracker terminated it. Otherwise, when
+// the peer is in TrustedProxies, X-Forwarded-Proto or the proto= of a
+// Forwarded header saying https sets only the boolean. Without
+// TrustedProxies the headers are ignored, since any client could send them.
+func (t *Tracker) requestTLS(r *http.Request) (ok bool, version, cipher string) {
+	if r.TLS != nil {
+		return true, tls.VersionName(r.TLS.Version), cipherSuiteName(r.TLS.CipherSuite)
+	}
+	if len(t.trusted) == 0 || !t.trustedPeer(r) {
+		return false, "", ""
+	}
+	proto, _, _ := strings.Cut(r.Header.Get("X-Forwarded-Proto"), ",")
+	if proto = strings.TrimSpace(proto); proto == "" {
+		if protos := forwardedParam(r.Header.Values("Forwarded"), "proto"); len(protos) > 0 {
+			proto = strings.TrimSpace(protos[0])
+		}
+	}
+	return strings.EqualFold(proto, "https"), "", ""
+}
+
+// validHost reports whether a forwarded host is plausible enough to record.
+func validHost(h string) bool {
+	h = strings.TrimSpace(h)
+	if h == "" || len(h) > maxHostLen {
+		return false
+	}
+	for i := 0; i < len(h); i++ {
+		if c := h[i]; c <= ' ' || c >= 0x7f || strings.IndexByte(`/\?#@"`, c) >= 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,81 @@
+package emailtracker
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestEventTLS(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	link := must(url.Parse(tr.GenerateLink("msg-1")))
+	tlsServer := httptest.NewTLSServer(tr.Handler())
+	defer tlsServer.Close()
+	plain := httptest.NewServer(tr.Handler())
+	defer plain.Close()
+
+	transport := tlsServer.Client().Transport.(*http.Transport).Clone()
+	transport.TLSClientConfig.MaxVersion = tls.VersionTLS12
+	transport.TLSClientConfig.CipherSuites = []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
+	tls12 := &http.Client{Transport: transport}
+	defer transport.CloseIdleConnections()
+	for _, c := range []struct {
+		name    string
+		server  *httptest.Server
+		client  *http.Client
+		tls     bool
+		version string
+		cipher  string
+	}{
+		{"TLS 1.3", tlsServer, tlsServer.Client(), true, "TLS 1.3", ""},
+		{"TLS 1.2", tlsServer, tls12, true, "TLS 1.2", "TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+		{"plain HTTP", plain, plain.Client(), false, "", ""},
+	} {
+		before := len(log.all())
+		resp := fetch(t, c.client, c.server.URL+link.RequestURI())
+		events := log.all()[before:]
+		if len(events) != 1 {
+			t.Fatalf("%s: %d events, want 1", c.name, len(events))
+		}
+		e := events[0]
+		cipher := c.cipher
+		if c.tls && cipher == "" {
+			cipher = tls.CipherSuiteName(resp.TLS.CipherSuite)
+		}
+		if e.TLS != c.tls || e.TLSVersion != c.version || e.CipherSuite != cipher {
+			t.Errorf("%s: TLS %v, version %q, cipher %q; want %v, %q, %q", c.name, e.TLS, e.TLSVersion, e.CipherSuite, c.tls, c.version, cipher)
+		}
+	}
+}
+
+func TestForwardedProto(t *testing.T) {
+	// get's requests come from 192.0.2.1, over plain HTTP for a target
+	// without a scheme.
+	for _, c := range []struct {
+		name    string
+		trusted []string
+		header  []string
+		tls     bool
+	}{
+		{"trusted https", []string{"192.0.2.1"}, []string{"X-Forwarded-Proto", "https"}, true},
+		{"trusted HTTPS list", []string{"192.0.2.0/24"}, []string{"X-Forwarded-Proto", "HTTPS, http"}, true},
+		{"trusted http", []string{"192.0.2.1"}, []string{"X-Forwarded-Proto", "http"}, false},
+		{"trusted Forwarded", []string{"192.0.2.1"}, []string{"Forwarded", "for=198.51.100.7;proto=https"}, true},
+		{"trusted, no header", []string{"192.0.2.1"}, nil, false},
+		{"untrusted https", []string{"10.0.0.0/8"}, []string{"X-Forwarded-Proto", "https"}, false},
+		{"untrusted Forwarded", []string{"10.0.0.0/8"}, []string{"Forwarded", "proto=https"}, false},
+		{"no TrustedProxies", nil, []string{"X-Forwarded-Proto", "https"}, false},
+	} {
+		tr, log := newTestTracker(t, Config{TrustedProxies: c.trusted})
+		get(tr.Handler(), must(url.Parse(tr.GenerateLink("msg-1"))).RequestURI(), c.header...)
+		events := log.all()
+		if len(events) != 1 {
+			t.Fatalf("%s: %d events, want 1", c.name, len(events))
+		}
+		if e := events[0]; e.TLS != c.tls || e.TLSVersion != "" || e.CipherSuite != "" {
+			t.Errorf("%s: TLS %v, version %q, cipher %q; want %v without version or cipher", c.name, e.TLS, e.TLSVersion, e.CipherSuite, c.tls)
+		}
+	}
+}
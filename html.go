@@ -0,0 +1,65 @@
+package emailtracker
+
+import (
+	"html"
+	"strings"
+)
+
+// htmlAttr is an extra attribute for GeneratePixelHTML.
+type htmlAttr struct{ name, value string }
+
+// WithHTMLAttr adds the attribute name="value" to the tag built by
+// GeneratePixelHTML, replacing a default attribute of the same name. Other
+// link builders ignore it. Names other than letters, digits, '-' and '_'
+// are left out.
+func WithHTMLAttr(name, value string) LinkOption {
+	return func(o *linkOptions) { o.attrs = append(o.attrs, htmlAttr{strings.ToLower(name), value}) }
+}
+
+// GeneratePixelHTML returns an <img> tag for the tracking link of id, sized
+// 1x1 with an empty alt and hidden, so clients that block images show no
+// broken-image icon. The link and attribute values are HTML-escaped.
+func (t *Tracker) GeneratePixelHTML(id string, opts ...LinkOption) string {
+	o := applyLinkOptions(opts)
+	attrs := []htmlAttr{
+		{"src", t.GenerateLink(id, opts...)},
+		{"width", "1"},
+		{"height", "1"},
+		{"alt", ""},
+		{"style", "display:none"},
+	}
+	for _, a := range o.attrs {
+		if !validAttrName(a.name) || a.name == "src" {
+			continue
+		}
+		replaced := false
+		for i := range attrs {
+			if attrs[i].name == a.name {
+				attrs[i].value, replaced = a.value, true
+				break
+			}
+		}
+		if !replaced {
+			attrs = append(attrs, a)
+		}
+	}
+	var b strings.Builder
+	b.WriteString("<img")
+	for _, a := range attrs {
+		b.WriteString(" " + a.name + `="` + html.EscapeString(a.value) + `"`)
+	}
+	b.WriteString(">")
+	return b.String()
+}
+
+func validAttrName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
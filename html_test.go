@@ -0,0 +1,122 @@
+package emailtracker
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+// parseImg parses tag as the single <img> element GeneratePixelHTML builds
+// and returns its attributes in order. The tag is wrapped in a <p> for the
+// decoder to close the void <img> against.
+func parseImg(t *testing.T, tag string) []xml.Attr {
+	t.Helper()
+	d := xml.NewDecoder(strings.NewReader("<p>" + tag + "</p>"))
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	var attrs []xml.Attr
+	elements := 0
+	for {
+		tok, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("%s doesn't parse: %v", tag, err)
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if tok.Name.Local == "p" {
+				continue
+			}
+			elements++
+			if tok.Name.Local != "img" {
+				t.Errorf("%s: element <%s>, want <img>", tag, tok.Name.Local)
+			}
+			attrs = tok.Attr
+		case xml.CharData:
+			t.Errorf("%s: text %q outside the tag", tag, tok)
+		}
+	}
+	if elements != 1 {
+		t.Fatalf("%s: %d elements, want 1", tag, elements)
+	}
+	return attrs
+}
+
+func attrValue(attrs []xml.Attr, name string) (string, bool) {
+	for _, a := range attrs {
+		if a.Name.Local == name {
+			return a.Value, true
+		}
+	}
+	return "", false
+}
+
+func TestGeneratePixelHTML(t *testing.T) {
+	ids := []string{"msg-1", `"><script>alert(1)</script>`, `it's "quoted"`, "a<b>c&d", "' onerror='x"}
+	for name, cfg := range map[string]Config{
+		"query":    {},
+		"path IDs": {PathIDs: true},
+		"signed":   {SigningKey: []byte("secret")},
+	} {
+		tr, log := newTestTracker(t, cfg)
+		for _, id := range ids {
+			tag := tr.GeneratePixelHTML(id)
+			attrs := parseImg(t, tag)
+			var names []string
+			for _, a := range attrs {
+				names = append(names, a.Name.Local)
+			}
+			if got := strings.Join(names, " "); got != "src width height alt style" {
+				t.Errorf("%s: %s has attributes %q", name, tag, got)
+			}
+			for attr, want := range map[string]string{"width": "1", "height": "1", "alt": "", "style": "display:none"} {
+				if v, _ := attrValue(attrs, attr); v != want {
+					t.Errorf("%s: %s: %s=%q, want %q", name, tag, attr, v, want)
+				}
+			}
+			src, _ := attrValue(attrs, "src")
+			if src != tr.GenerateLink(id) {
+				t.Errorf("%s: src %q, want the link %q", name, src, tr.GenerateLink(id))
+			}
+			before := len(log.all())
+			get(tr.Handler(), src)
+			if events := log.all()[before:]; len(events) != 1 || events[0].ID != id {
+				t.Errorf("%s: src of %s yields %d events, want one for %q", name, tag, len(events), id)
+			}
+		}
+	}
+}
+
+func TestGeneratePixelHTMLAttrs(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	tag := tr.GeneratePixelHTML("msg-1",
+		WithHTMLAttr("alt", `say "hi" <b>`),
+		WithHTMLAttr("Class", "pixel"),
+		WithHTMLAttr("data-x", "a&b"),
+		WithHTMLAttr("src", "https://evil.example/"),
+		WithHTMLAttr(`onload="x"`, "y"),
+		WithHTMLAttr("", "empty"),
+	)
+	attrs := parseImg(t, tag)
+	var names []string
+	for _, a := range attrs {
+		names = append(names, a.Name.Local)
+	}
+	if got := strings.Join(names, " "); got != "src width height alt style class data-x" {
+		t.Errorf("%s has attributes %q", tag, got)
+	}
+	for attr, want := range map[string]string{
+		"src":    tr.GenerateLink("msg-1"),
+		"alt":    `say "hi" <b>`,
+		"class":  "pixel",
+		"data-x": "a&b",
+	} {
+		if v, _ := attrValue(attrs, attr); v != want {
+			t.Errorf("%s: %s=%q, want %q", tag, attr, v, want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package emailtracker
+
+import (
+	"errors"
+	"html"
+	"strings"
+)
+
+// rawTextElements hold text in which tags aren't recognized, so a "</body>"
+// inside them is just text.
+var rawTextElements = []string{"script", "style", "textarea", "title", "xmp"}
+
+// InjectPixel inserts the tag built by GeneratePixelHTML(id) into the email
+// document doc, just before its closing body tag, or at the end when there
+// is none. The closing tag is found by scanning the markup, so one inside a
+// comment, an attribute value or a script doesn't count. The rest of doc is
+// left byte for byte as it was. If doc already contains the pixel link for
+// id, it is returned unchanged.
+func (t *Tracker) InjectPixel(doc, id string) (string, error) {
+	if id == "" {
+		return "", errors.New("emailtracker: InjectPixel requires an id")
+	}
+	if strings.Contains(doc, html.EscapeString(t.GenerateLink(id))) {
+		return doc, nil
+	}
+	pixel := t.GeneratePixelHTML(id)
+	at := closingBody(doc)
+	if at < 0 {
+		return doc + pixel, nil
+	}
+	return doc[:at] + pixel + doc[at:], nil
+}
+
+// closingBody returns the offset of the last </body> tag in doc that is
+// markup rather than text, or -1.
+func closingBody(doc string) int {
+	last := -1
+	walkTags(doc, func(name string, start, end int, closing bool) {
+		if closing && name == "body" {
+			last = start
+		}
+	})
+	return last
+}
+
+// walkTags calls fn with the lowercased name and the extent of every start
+// and end tag in doc, skipping comments, CDATA sections and the content of
+// raw text elements.
+func walkTags(doc string, fn func(name string, start, end int, closing bool)) {
+	for i := 0; i < len(doc); {
+		j := strings.IndexByte(doc[i:], '<')
+		if j < 0 {
+			return
+		}
+		i += j
+		rest := doc[i:]
+		switch {
+		case strings.HasPrefix(rest, "<!--"):
+			i = skipPast(doc, i+4, "-->")
+		case strings.HasPrefix(rest, "<![CDATA["):
+			i = skipPast(doc, i+9, "]]>")
+		case strings.HasPrefix(rest, "</"):
+			start, name := i, strings.ToLower(tagName(rest[2:]))
+			i = tagEnd(doc, i+2)
+			fn(name, start, i, true)
+		case len(rest) > 1 && isASCIILetter(rest[1]):
+			start, name := i, strings.ToLower(tagName(rest[1:]))
+			i = tagEnd(doc, i+1)
+			fn(name, start, i, false)
+			for _, raw := range rawTextElements {
+				if name == raw {
+					i = rawTextEnd(doc, i, name)
+					break
+				}
+			}
+		default:
+			i++
+		}
+	}
+}
+
+// tagName returns the element name at the start of s.
+func tagName(s string) string {
+	n := 0
+	for n < len(s) && (isASCIILetter(s[n]) || '0' <= s[n] && s[n] <= '9' || s[n] == '-') {
+		n++
+	}
+	return s[:n]
+}
+
+// tagEnd returns the offset just past the '>' closing the tag that continues
+// at i, skipping quoted attribute values.
+func tagEnd(doc string, i int) int {
+	var quote byte
+	for ; i < len(doc); i++ {
+		switch c := doc[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '>':
+			return i + 1
+		}
+	}
+	return len(doc)
+}
+
+// rawTextEnd returns the offset just past the end tag of the raw text
+// element name whose content starts at i.
+func rawTextEnd(doc string, i int, name string) int {
+	for i < len(doc) {
+		j := strings.Index(doc[i:], "</")
+		if j < 0 {
+			return len(doc)
+		}
+		i += j
+		if strings.EqualFold(tagName(doc[i+2:]), name) {
+			return tagEnd(doc, i+2)
+		}
+		i += 2
+	}
+	return len(doc)
+}
+
+// skipPast returns the offset just past the next end at or after i.
+func skipPast(doc string, i int, end string) int {
+	if j := strings.Index(doc[i:], end); j >= 0 {
+		return i + j + len(end)
+	}
+	return len(doc)
+}
+
+func isASCIILetter(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,24 @@
+package emailtracker
+
+import "net/netip"
+
+// DropDeniedIP is the drop reason for requests from an IP in DenyCIDRs, or
+// outside AllowCIDRs when that is set.
+const DropDeniedIP DropReason = "denied_ip"
+
+// deniedIP reports whether events from the resolved client ip are
+// suppressed. DenyCIDRs wins over AllowCIDRs; an IP that doesn't parse is
+// denied only when an allow list is set.
+func (t *Tracker) deniedIP(ip string) bool {
+	if len(t.allowIPs) == 0 && len(t.denyIPs) == 0 {
+		return false
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return len(t.allowIPs) > 0
+	}
+	if containsAddr(t.denyIPs, addr) {
+		return true
+	}
+	return len(t.allowIPs) > 0 && !containsAddr(t.allowIPs, addr)
+}
@@ -0,0 +1,117 @@
+package emailtracker
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+	"unicode"
+)
+
+// jsonTimeFormat is RFC 3339 with millisecond precision.
+const jsonTimeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// eventFields has OpenEvent's fields and tags but not its methods.
+type eventFields OpenEvent
+
+type eventJSON struct {
+	eventFields
+	Time string `json:"time,omitempty"`
+}
+
+// MarshalJSON encodes e with snake_case keys, omitting empty optional
+// fields, and Time in RFC 3339 with millisecond precision.
+func (e OpenEvent) MarshalJSON() ([]byte, error) {
+	v := eventJSON{eventFields: eventFields(e)}
+	if !e.Time.IsZero() {
+		v.Time = e.Time.Format(jsonTimeFormat)
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJSON decodes the format written by MarshalJSON, as well as events
+// encoded before OpenEvent had JSON tags, which used the Go field names.
+func (e *OpenEvent) UnmarshalJSON(data []byte) error {
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return err
+	}
+	for k := range keys {
+		if k != "" && unicode.IsUpper(rune(k[0])) {
+			return e.unmarshalLegacy(data)
+		}
+	}
+	var v eventJSON
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = OpenEvent(v.eventFields)
+	e.Time = time.Time{}
+	if v.Time != "" {
+		t, err := time.Parse(time.RFC3339Nano, v.Time)
+		if err != nil {
+			return fmt.Errorf("emailtracker: event time: %w", err)
+		}
+		e.Time = t
+	}
+	return nil
+}
+
+// legacyEvent is OpenEvent as encoded by encoding/json before it had tags.
+type legacyEvent struct {
+	Kind          EventKind
+	ID            string
+	IP            string
+	IPSource      string
+	XForwardedFor string
+	UserAgent     string
+	Referer       string
+	AcceptLang    string
+	Time          time.Time
+	Metadata      map[string]string
+	Params        map[string]string
+	URL           string
+	Revalidated   bool
+	FirstOpen     bool
+	IsBot         bool
+	BotName       string
+	DeviceType    DeviceType
+	OS            string
+	OSVersion     string
+	Client        string
+	ClientVersion string
+	Geo           Geo
+}
+
+func (e *OpenEvent) unmarshalLegacy(data []byte) error {
+	var v legacyEvent
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*e = OpenEvent{
+		Kind:          v.Kind,
+		ID:            v.ID,
+		IP:            v.IP,
+		IPSource:      v.IPSource,
+		XForwardedFor: v.XForwardedFor,
+		UserAgent:     v.UserAgent,
+		Referer:       v.Referer,
+		AcceptLang:    v.AcceptLang,
+		Time:          v.Time,
+		Metadata:      v.Metadata,
+		Params:        v.Params,
+		URL:           v.URL,
+		Revalidated:   v.Revalidated,
+		FirstOpen:     v.FirstOpen,
+		IsBot:         v.IsBot,
+		BotName:       v.BotName,
+		UserAgentInfo: UserAgentInfo{
+			DeviceType:    v.DeviceType,
+			OS:            v.OS,
+			OSVersion:     v.OSVersion,
+			Client:        v.Client,
+			ClientVersion: v.ClientVersion,
+		},
+		Geo: v.Geo,
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package emailtracker
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+var goldenEvents = map[string]OpenEvent{
+	"event_minimal.json": {
+		Kind: EventOpen,
+		ID:   "msg-1",
+		Time: time.Date(2026, 3, 1, 12, 30, 45, 123000000, time.UTC),
+	},
+	"event_full.json": {
+		Kind:            EventOpen,
+		ID:              "spring~ana",
+		EventID:         "019571a2-3b4c-7d5e-8f60-718293a4b5c6",
+		CorrelationID:   "req-42",
+		Method:          "GET",
+		Host:            "tracker.example.com",
+		Path:            "/pixel",
+		TLS:             true,
+		TLSVersion:      "TLS 1.3",
+		CipherSuite:     "TLS_AES_128_GCM_SHA256",
+		CampaignID:      "spring",
+		RecipientID:     "ana",
+		IP:              "203.0.113.7",
+		IPSource:        "X-Forwarded-For",
+		XForwardedFor:   "203.0.113.7, 10.0.0.1",
+		UserAgent:       "Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X)",
+		Referer:         "https://mail.example.com/",
+		AcceptLang:      "de-DE,en;q=0.8",
+		Headers:         map[string]string{"Dnt": "1"},
+		Languages:       []Locale{{"de", "DE", 1}, {"en", "", 0.8}},
+		PrimaryLanguage: "de",
+		Time:            time.Date(2026, 3, 1, 13, 30, 45, 123000000, time.FixedZone("CET", 3600)),
+		Metadata:        map[string]string{"recipient": "ana@example.com"},
+		Params:          map[string]string{"variant": "b"},
+		FirstOpen:       true,
+		Repeats:         2,
+		Proxied:         true,
+		ProxiedBy:       "gmail",
+		EmailClient:     "Gmail",
+		TimeToOpen:      90 * time.Second,
+		Confidence:      ConfidenceHuman,
+		SampleRate:      0.5,
+		UserAgentInfo:   UserAgentInfo{DeviceMobile, "iOS", "17.4", "", ""},
+		Geo:             Geo{Country: "DE", City: "Berlin", Lat: 52.52, Lon: 13.405},
+	},
+}
+
+func TestEventJSONGolden(t *testing.T) {
+	for name, e := range goldenEvents {
+		t.Run(name, func(t *testing.T) {
+			data, err := json.MarshalIndent(e, "", "\t")
+			if err != nil {
+				t.Fatal(err)
+			}
+			data = append(data, '\n')
+			path := filepath.Join("testdata", name)
+			if *update {
+				if err := os.WriteFile(path, data, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("%v; run go test -update to create it", err)
+			}
+			if !bytes.Equal(data, want) {
+				t.Errorf("wire format changed\n got %s\nwant %s", data, want)
+			}
+
+			var back OpenEvent
+			if err := json.Unmarshal(want, &back); err != nil {
+				t.Fatal(err)
+			}
+			if !back.Time.Equal(e.Time) {
+				t.Errorf("Time %v, want %v", back.Time, e.Time)
+			}
+			back.Time, e.Time = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(back, e) {
+				t.Errorf("round trip\n got %+v\nwant %+v", back, e)
+			}
+		})
+	}
+}
+
+func TestEventJSONTime(t *testing.T) {
+	e := OpenEvent{ID: "msg-1", Time: time.Date(2026, 3, 1, 12, 0, 0, 987654321, time.UTC)}
+	data, _ := json.Marshal(e)
+	var v struct{ Time string }
+	json.Unmarshal(data, &v)
+	if v.Time != "2026-03-01T12:00:00.987Z" {
+		t.Errorf("time %q, want RFC 3339 with milliseconds", v.Time)
+	}
+	if data, _ := json.Marshal(OpenEvent{ID: "msg-1"}); string(data) != `{"kind":"","id":"msg-1"}` {
+		t.Errorf("zero event encodes as %s", data)
+	}
+}
+
+func TestEventJSONLegacy(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "event_legacy.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var e OpenEvent
+	if err := json.Unmarshal(data, &e); err != nil {
+		t.Fatal(err)
+	}
+	want := OpenEvent{
+		Kind:          EventOpen,
+		ID:            "msg-1",
+		IP:            "203.0.113.7",
+		UserAgent:     "Mozilla/5.0",
+		Time:          time.Date(2024, 5, 6, 7, 8, 9, 123456789, time.UTC),
+		Metadata:      map[string]string{"campaign": "spring"},
+		FirstOpen:     true,
+		IsBot:         true,
+		BotName:       "curl",
+		UserAgentInfo: UserAgentInfo{DeviceType: DeviceDesktop, OS: "Windows"},
+		Geo:           Geo{Country: "DE"},
+	}
+	if !e.Time.Equal(want.Time) {
+		t.Errorf("Time %v, want %v", e.Time, want.Time)
+	}
+	e.Time, want.Time = time.Time{}, time.Time{}
+	if !reflect.DeepEqual(e, want) {
+		t.Errorf("legacy event\n got %+v\nwant %+v", e, want)
+	}
+	if err := json.Unmarshal([]byte(`{"id":"msg-1","time":"yesterday"}`), &e); err == nil {
+		t.Error("bad time accepted")
+	}
+}
@@ -0,0 +1,140 @@
+package emailtracker
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+)
+
+// keyParam carries the ID of the key a link was signed with. Links signed
+// with a key whose ID is empty, including every link made before key IDs
+// existed, leave it out.
+const keyParam = "k"
+
+// maxKeyIDLen bounds signing key IDs, which appear in every link.
+const maxKeyIDLen = 32
+
+// keyring holds the signing keys by ID: the current one, used for new links,
+// and older ones that are still accepted.
+type keyring struct {
+	mu      sync.RWMutex
+	current string
+	keys    map[string][]byte
+}
+
+func newKeyring(cfg Config) (*keyring, error) {
+	if len(cfg.SigningKey) == 0 {
+		if len(cfg.OldSigningKeys) > 0 {
+			return nil, errors.New("emailtracker: OldSigningKeys requires SigningKey")
+		}
+		return nil, nil
+	}
+	k := &keyring{current: cfg.SigningKeyID, keys: make(map[string][]byte)}
+	if err := k.add(cfg.SigningKeyID, cfg.SigningKey); err != nil {
+		return nil, err
+	}
+	for id, key := range cfg.OldSigningKeys {
+		if err := k.add(id, key); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// add stores a copy of key under id, which must be new.
+func (k *keyring) add(id string, key []byte) error {
+	if err := validKeyID(id); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return fmt.Errorf("emailtracker: signing key %q is empty", id)
+	}
+	if _, ok := k.keys[id]; ok {
+		return fmt.Errorf("emailtracker: signing key %q already exists", id)
+	}
+	k.keys[id] = append([]byte(nil), key...)
+	return nil
+}
+
+func validKeyID(id string) error {
+	if len(id) > maxKeyIDLen {
+		return fmt.Errorf("emailtracker: signing key ID %q is longer than %d bytes", id, maxKeyIDLen)
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			return fmt.Errorf("emailtracker: signing key ID %q may only contain letters, digits, - and _", id)
+		}
+	}
+	return nil
+}
+
+// sign returns the current key's ID and its signature of parts.
+func (k *keyring) sign(parts ...string) (kid, sig string) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current, signParts(k.keys[k.current], parts...)
+}
+
+// verify reports whether sig is the signature of parts under the key kid,
+// failing for unknown or retired keys.
+func (k *keyring) verify(kid, sig string, parts ...string) bool {
+	if k == nil {
+		return false
+	}
+	k.mu.RLock()
+	key, ok := k.keys[kid]
+	k.mu.RUnlock()
+	return ok && verifyParts(key, sig, parts...)
+}
+
+// AddSigningKey makes key, under the ID id, the key new links are signed
+// with; their "k" parameter names it. Links signed with earlier keys keep
+// verifying until those keys are retired. It fails without a
+// Config.SigningKey, for an empty key, or for an ID already in use. Keys
+// added at runtime don't outlive the process: move them into
+// Config.SigningKeyID and Config.OldSigningKeys before restarting.
+func (t *Tracker) AddSigningKey(id string, key []byte) error {
+	if t.keys == nil {
+		return errors.New("emailtracker: AddSigningKey requires Config.SigningKey")
+	}
+	t.keys.mu.Lock()
+	defer t.keys.mu.Unlock()
+	if err := t.keys.add(id, key); err != nil {
+		return err
+	}
+	t.keys.current = id
+	return nil
+}
+
+// RetireSigningKey stops accepting links signed with the key id, e.g. after
+// it leaked. The current key can't be retired; add its replacement first.
+func (t *Tracker) RetireSigningKey(id string) error {
+	if t.keys == nil {
+		return errors.New("emailtracker: RetireSigningKey requires Config.SigningKey")
+	}
+	t.keys.mu.Lock()
+	defer t.keys.mu.Unlock()
+	if _, ok := t.keys.keys[id]; !ok {
+		return fmt.Errorf("emailtracker: unknown signing key %q", id)
+	}
+	if id == t.keys.current {
+		return fmt.Errorf("emailtracker: signing key %q is current", id)
+	}
+	delete(t.keys.keys, id)
+	return nil
+}
+
+// signQuery sets the signature of parts, and the key ID if any, on q.
+func (t *Tracker) signQuery(q url.Values, parts ...string) {
+	kid, sig := t.keys.sign(parts...)
+	q.Set(sigParam, sig)
+	if kid != "" {
+		q.Set(keyParam, kid)
+	}
+}
+
+// verifyQuery checks the signature and key ID in q against parts.
+func (t *Tracker) verifyQuery(q url.Values, parts ...string) bool {
+	return t.keys.verify(q.Get(keyParam), q.Get(sigParam), parts...)
+}
@@ -0,0 +1,132 @@
+package emailtracker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// opens serves link and reports whether it produced an event.
+func opens(tr *Tracker, log *eventLog, link string) bool {
+	before := len(log.all())
+	get(tr.Handler(), link)
+	return len(log.all()) > before
+}
+
+func TestKeyRotation(t *testing.T) {
+	tr, log := newTestTracker(t, Config{SigningKey: []byte("first-key")})
+	legacy := tr.GenerateLink("msg-1")
+	if strings.Contains(legacy, "k=") {
+		t.Errorf("link %q names a key without a SigningKeyID", legacy)
+	}
+
+	if err := tr.AddSigningKey("2", []byte("second-key")); err != nil {
+		t.Fatal(err)
+	}
+	rotated := tr.GenerateLink("msg-1")
+	u, _ := url.Parse(rotated)
+	if u.Query().Get(keyParam) != "2" {
+		t.Errorf("link %q after rotation, want k=2", rotated)
+	}
+	if u.Query().Get(sigParam) == must(url.Parse(legacy)).Query().Get(sigParam) {
+		t.Error("the new key signs like the old one")
+	}
+	for name, link := range map[string]string{"old key": legacy, "new key": rotated} {
+		if !opens(tr, log, link) {
+			t.Errorf("link signed with the %s rejected after rotation", name)
+		}
+	}
+
+	if err := tr.RetireSigningKey(""); err != nil {
+		t.Fatal(err)
+	}
+	if opens(tr, log, legacy) {
+		t.Error("link signed with a retired key accepted")
+	}
+	if !opens(tr, log, rotated) {
+		t.Error("link signed with the current key rejected after retiring the old one")
+	}
+
+	// A link can't borrow another key's ID.
+	q := u.Query()
+	q.Set(keyParam, "")
+	u.RawQuery = q.Encode()
+	if opens(tr, log, u.String()) {
+		t.Error("link with a swapped key ID accepted")
+	}
+}
+
+func TestKeyRotationErrors(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{SigningKey: []byte("first-key"), SigningKeyID: "1"})
+	for name, err := range map[string]error{
+		"duplicate ID":   tr.AddSigningKey("1", []byte("other")),
+		"empty key":      tr.AddSigningKey("2", nil),
+		"bad ID":         tr.AddSigningKey("a/b", []byte("key")),
+		"long ID":        tr.AddSigningKey(strings.Repeat("k", maxKeyIDLen+1), []byte("key")),
+		"retire current": tr.RetireSigningKey("1"),
+		"retire unknown": tr.RetireSigningKey("9"),
+	} {
+		if err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+	if link := tr.GenerateLink("msg-1"); !strings.Contains(link, "k=1") {
+		t.Errorf("failed calls changed the current key: %q", link)
+	}
+
+	plain, _ := newTestTracker(t, Config{})
+	if plain.AddSigningKey("1", []byte("key")) == nil || plain.RetireSigningKey("1") == nil {
+		t.Error("key rotation accepted without a SigningKey")
+	}
+	if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", OldSigningKeys: map[string][]byte{"1": []byte("key")}}); err == nil {
+		t.Error("OldSigningKeys accepted without a SigningKey")
+	}
+}
+
+func TestOldSigningKeys(t *testing.T) {
+	before, _ := newTestTracker(t, Config{SigningKey: []byte("first-key"), SigningKeyID: "1"})
+	sent := before.GenerateLink("msg-1")
+
+	// After a restart with the key moved to OldSigningKeys.
+	after, log := newTestTracker(t, Config{
+		SigningKey:     []byte("second-key"),
+		SigningKeyID:   "2",
+		OldSigningKeys: map[string][]byte{"1": []byte("first-key")},
+	})
+	if !opens(after, log, sent) {
+		t.Error("link signed with an old configured key rejected")
+	}
+	if !strings.Contains(after.GenerateLink("msg-1"), "k=2") {
+		t.Error("new links aren't signed with SigningKey")
+	}
+	forged := strings.Replace(sent, "k=1", "k=2", 1)
+	if opens(after, log, forged) {
+		t.Error("old signature accepted under the new key's ID")
+	}
+}
+
+func TestKeyRotationConcurrent(t *testing.T) {
+	tr, log := newTestTracker(t, Config{SigningKey: []byte("key-0"), SigningKeyID: "k0"})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range 100 {
+				get(tr.Handler(), tr.GenerateLink("msg-1"))
+			}
+		}()
+	}
+	for i := 1; i <= 20; i++ {
+		if err := tr.AddSigningKey(fmt.Sprintf("k%d", i), []byte(fmt.Sprintf("key-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+	// No key was retired, so every link verified.
+	if n := len(log.all()); n != 400 {
+		t.Errorf("%d of 400 links verified during rotation", n)
+	}
+}
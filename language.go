@@ -0,0 +1,124 @@
+package emailtracker
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Locale is one entry of an Accept-Language header.
+type Locale struct {
+	Language string  `json:"language"`         // lowercase primary subtag, e.g. "en", or "*"
+	Region   string  `json:"region,omitempty"` // uppercase region subtag, e.g. "GB"
+	Quality  float64 `json:"quality"`          // q-value, 1 when absent
+}
+
+// Limits on the Accept-Language input considered by ParseAcceptLanguage.
+const (
+	maxAcceptLanguageLen     = 4096
+	maxAcceptLanguageEntries = 32
+)
+
+// ParseAcceptLanguage parses an Accept-Language header into locales sorted
+// by descending quality, keeping header order among equal qualities.
+// Malformed entries and entries with q=0 are skipped, so a malformed header
+// yields nil. Only the first 4 KiB and 32 entries are read.
+func ParseAcceptLanguage(header string) []Locale {
+	if len(header) > maxAcceptLanguageLen {
+		header = header[:maxAcceptLanguageLen]
+	}
+	var locales []Locale
+	rest, more := header, true
+	for i := 0; more && i < maxAcceptLanguageEntries; i++ {
+		var entry string
+		entry, rest, more = strings.Cut(rest, ",")
+		l, ok := parseLocale(strings.TrimSpace(entry))
+		if ok && l.Quality > 0 {
+			locales = append(locales, l)
+		}
+	}
+	slices.SortStableFunc(locales, func(a, b Locale) int {
+		switch {
+		case a.Quality > b.Quality:
+			return -1
+		case a.Quality < b.Quality:
+			return 1
+		}
+		return 0
+	})
+	return locales
+}
+
+// parseLocale parses a single "tag;q=value" entry.
+func parseLocale(entry string) (Locale, bool) {
+	tag, params, _ := strings.Cut(entry, ";")
+	tag = strings.TrimSpace(tag)
+	l := Locale{Quality: 1}
+	if p := strings.TrimSpace(params); p != "" {
+		v, ok := strings.CutPrefix(p, "q=")
+		if !ok {
+			return Locale{}, false
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		if err != nil || q < 0 || q > 1 {
+			return Locale{}, false
+		}
+		l.Quality = q
+	}
+	if tag == "*" {
+		l.Language = "*"
+		return l, true
+	}
+	primary, rest, more := strings.Cut(tag, "-")
+	if !isAlpha(primary, 1, 8) {
+		return Locale{}, false
+	}
+	l.Language = strings.ToLower(primary)
+	for more {
+		var s string
+		s, rest, more = strings.Cut(rest, "-")
+		if s == "" || len(s) > 8 {
+			return Locale{}, false
+		}
+		if l.Region == "" && (isAlpha(s, 2, 2) || isDigits(s, 3)) {
+			l.Region = strings.ToUpper(s)
+		}
+	}
+	return l, true
+}
+
+func isAlpha(s string, min, max int) bool {
+	if len(s) < min || len(s) > max {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i] | 0x20
+		if c < 'a' || c > 'z' {
+			return false
+		}
+	}
+	return true
+}
+
+func isDigits(s string, n int) bool {
+	if len(s) != n {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// primaryLanguage is the language of the best-ranked locale that isn't a
+// wildcard.
+func primaryLanguage(locales []Locale) string {
+	for _, l := range locales {
+		if l.Language != "*" {
+			return l.Language
+		}
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package emailtracker
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	for _, c := range []struct {
+		header string
+		want   []Locale
+	}{
+		{"", nil},
+		{"en", []Locale{{Language: "en", Quality: 1}}},
+		{"en-GB,en;q=0.9,fr;q=0.8", []Locale{{"en", "GB", 1}, {"en", "", 0.9}, {"fr", "", 0.8}}},
+		{"fr;q=0.5, de-DE;q=0.7, pt-br", []Locale{{"pt", "BR", 1}, {"de", "DE", 0.7}, {"fr", "", 0.5}}},
+		// Missing q-values are 1 and keep header order among themselves.
+		{"da, en-gb;q=0.8, en", []Locale{{"da", "", 1}, {"en", "", 1}, {"en", "GB", 0.8}}},
+		{"*", []Locale{{Language: "*", Quality: 1}}},
+		{"de;q=0.6,*;q=0.1", []Locale{{"de", "", 0.6}, {"*", "", 0.1}}},
+		{"zh-Hant-TW", []Locale{{"zh", "TW", 1}}},
+		{"es-419", []Locale{{"es", "419", 1}}},
+		{"EN-us", []Locale{{"en", "US", 1}}},
+		{"en;q=0", nil},
+		{"en ; q = 0.5", nil},
+		{" en ;q= 0.5 ", []Locale{{"en", "", 0.5}}},
+		{"en;q=2,fr;q=-1,de;q=abc,it;level=1", nil},
+		{"1234,--,en-,-en,@@,,", nil},
+		{"toolonglanguage,en", []Locale{{"en", "", 1}}},
+		{"français", nil},
+	} {
+		if got := ParseAcceptLanguage(c.header); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("ParseAcceptLanguage(%q) = %v, want %v", c.header, got, c.want)
+		}
+	}
+}
+
+func TestParseAcceptLanguageLong(t *testing.T) {
+	many := strings.Repeat("en,", 10000) + "fr"
+	if got := ParseAcceptLanguage(many); len(got) != maxAcceptLanguageEntries {
+		t.Errorf("%d locales from a huge header, want %d", len(got), maxAcceptLanguageEntries)
+	}
+	// The last entry lies past the byte limit.
+	long := strings.Repeat(" ", maxAcceptLanguageLen) + ",fr"
+	if got := ParseAcceptLanguage(long); got != nil {
+		t.Errorf("read past the length limit: %v", got)
+	}
+	if got := ParseAcceptLanguage(strings.Repeat("x", 1<<20)); got != nil {
+		t.Errorf("one giant tag parsed as %v", got)
+	}
+}
+
+func TestEventLanguages(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	for _, c := range []struct {
+		header  string
+		primary string
+		n       int
+	}{
+		{"*;q=1, en-GB;q=0.9", "en", 2},
+		{"*", "", 1},
+		{"garbage;;;", "", 0},
+	} {
+		get(tr.Handler(), tr.GenerateLink("msg-1"), "Accept-Language", c.header)
+		events := log.all()
+		e := events[len(events)-1]
+		if e.AcceptLang != c.header || e.PrimaryLanguage != c.primary || len(e.Languages) != c.n {
+			t.Errorf("%q: AcceptLang %q, PrimaryLanguage %q, Languages %v", c.header, e.AcceptLang, e.PrimaryLanguage, e.Languages)
+		}
+	}
+}
@@ -0,0 +1,346 @@
+package emailtracker
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// expParam carries a link's expiry in Unix seconds.
+const expParam = "exp"
+
+// expirySkew is how long after its expiry a link is still honored, to absorb
+// clock differences between the sending and tracking hosts.
+const expirySkew = 2 * time.Minute
+
+// LinkOption customizes a link built by GenerateLink and its variants.
+type LinkOption func(*linkOptions)
+
+type linkOptions struct {
+	expires time.Time
+	nonce   string
+	sentAt  time.Time
+	attrs   []htmlAttr // GeneratePixelHTML only
+	domain  string
+	scheme  string
+	params  map[string]string
+	err     error // from rejected options
+}
+
+// WithExpiry makes the link stop producing events after at. The pixel is
+// still served. With a SigningKey the expiry is covered by the signature, so
+// recipients can't extend it.
+func WithExpiry(at time.Time) LinkOption {
+	return func(o *linkOptions) { o.expires = at }
+}
+
+// WithDomain builds the link on domain instead of Config.Domain, for example
+// a regional hostname served by the same tracker. The path and signature
+// are unchanged, so the link verifies whichever domain serves it. A domain
+// with a scheme or path is rejected: the link keeps Config.Domain and the
+// error goes to the OnError hook.
+func WithDomain(domain string) LinkOption {
+	return func(o *linkOptions) {
+		if err := checkDomain("WithDomain", domain); err != nil {
+			o.err = errors.Join(o.err, err)
+			return
+		}
+		o.domain = domain
+	}
+}
+
+// WithScheme builds the link with scheme, "http" or "https", instead of the
+// tracker's. Any other value is rejected like an invalid WithDomain.
+func WithScheme(scheme string) LinkOption {
+	return func(o *linkOptions) {
+		if err := checkScheme("WithScheme", scheme); err != nil {
+			o.err = errors.Join(o.err, err)
+			return
+		}
+		o.scheme = scheme
+	}
+}
+
+// WithParams adds params to the link's query string, sorted by name, after
+// the tracking parameters. Like those of GenerateLinkWithParams they aren't
+// covered by the signature. Reserved names, such as the ID parameter, are an
+// error for BuildLink and left out by GenerateLink.
+func WithParams(params map[string]string) LinkOption {
+	return func(o *linkOptions) {
+		if o.params == nil {
+			o.params = make(map[string]string, len(params))
+		}
+		maps.Copy(o.params, params)
+	}
+}
+
+func applyLinkOptions(opts []LinkOption) linkOptions {
+	var o linkOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return o
+}
+
+// exp formats the expiry for a link, or returns "" for none.
+func (o linkOptions) exp() string {
+	if o.expires.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(o.expires.Unix(), 10)
+}
+
+// linkQuery builds the query of a pixel link, encoded as url.Values would
+// encode it but without the map and slices.
+type linkQuery struct {
+	n      int
+	params [6]struct{ name, value string }
+}
+
+// set adds a parameter unless value is empty.
+func (q *linkQuery) set(name, value string) {
+	if value != "" {
+		q.params[q.n].name, q.params[q.n].value = name, value
+		q.n++
+	}
+}
+
+func (q *linkQuery) encode() string {
+	params := q.params[:q.n]
+	slices.SortFunc(params, func(a, b struct{ name, value string }) int { return strings.Compare(a.name, b.name) })
+	n := 0
+	for _, p := range params {
+		n += len(p.name) + len(p.value) + 2
+	}
+	var b strings.Builder
+	b.Grow(n)
+	for i, p := range params {
+		if i > 0 {
+			b.WriteByte('&')
+		}
+		b.WriteString(url.QueryEscape(p.name))
+		b.WriteByte('=')
+		b.WriteString(url.QueryEscape(p.value))
+	}
+	return b.String()
+}
+
+// link holds the tracking values carried by a pixel request.
+type link struct {
+	id, sig, exp, nonce, sent, kid string
+	token                          string // from a path-style token link
+}
+
+// link returns the unsigned tracking values for id.
+func (o linkOptions) link(id string) link {
+	return link{id: id, exp: o.exp(), nonce: o.nonce, sent: o.sent()}
+}
+
+// sigParts returns the link values covered by its signature. Links without
+// options sign the ID alone, as they always have; the nonce and send time
+// are labelled so they can't be mistaken for an expiry.
+func (l link) sigParts() []string {
+	parts := []string{l.id}
+	if l.exp != "" {
+		parts = append(parts, l.exp)
+	}
+	if l.nonce != "" {
+		parts = append(parts, nonceParam, l.nonce)
+	}
+	if l.sent != "" {
+		parts = append(parts, sentParam, l.sent)
+	}
+	return parts
+}
+
+// expired reports whether a link with expiry exp is past it at now. A
+// malformed expiry counts as expired.
+func expired(exp string, now time.Time) bool {
+	secs, err := strconv.ParseInt(exp, 10, 64)
+	return err != nil || now.After(time.Unix(secs, 0).Add(expirySkew))
+}
+
+// requestLink reads the tracking values from a query-style or, failing that,
+// a path-style link. known is false for a path under the path-style prefix
+// that is no link at all.
+func (t *Tracker) requestLink(r *http.Request, query url.Values) (l link, known bool) {
+	l = link{
+		id:    t.queryID(query),
+		sig:   query.Get(sigParam),
+		exp:   query.Get(expParam),
+		nonce: query.Get(nonceParam),
+		sent:  query.Get(sentParam),
+		kid:   query.Get(keyParam),
+	}
+	if l.id != "" || query.Get(tokenParam) != "" {
+		return l, true
+	}
+	rest, ok := strings.CutPrefix(r.URL.EscapedPath(), t.pathPrefix())
+	if !ok || rest == "" {
+		return l, true
+	}
+	pl, ok := parsePathLink(rest, t.pixel.ext)
+	if !ok {
+		return l, false
+	}
+	return pl, true
+}
+
+// Errors returned by BuildLink.
+var (
+	// ErrInvalidLink marks a problem with the call: its ID or options.
+	ErrInvalidLink = errors.New("emailtracker: invalid link")
+	// ErrLinkConfig marks a tracker that can't build links whatever the
+	// call, such as a Tracker not made by New.
+	ErrLinkConfig = errors.New("emailtracker: tracker can't build links")
+)
+
+// BuildLink is GenerateLink for callers that want problems reported rather
+// than worked around, and a URL they can adjust further. Errors wrapping
+// ErrInvalidLink concern the call: an empty ID, one that isn't valid UTF-8
+// or holds control characters, a rejected option or a reserved WithParams
+// name. Errors wrapping ErrLinkConfig concern the tracker. The ID is only
+// registered with the IDRegistry when the link is built.
+func (t *Tracker) BuildLink(id string, opts ...LinkOption) (*url.URL, error) {
+	if err := errors.Join(checkDomain("Domain", t.config.Domain), t.checkPath()); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrLinkConfig, err)
+	}
+	o := applyLinkOptions(opts)
+	if err := errors.Join(checkLinkID(id), o.err, t.checkParams(o.params)); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidLink, err)
+	}
+	t.register(id)
+	u := t.linkURL(id, o)
+	return &u, nil
+}
+
+// checkPath fails for a Path New would reject, which only trackers made by
+// NewTracker can have.
+func (t *Tracker) checkPath() error {
+	if !strings.HasPrefix(t.config.Path, "/") {
+		return fmt.Errorf("emailtracker: Path %q must start with \"/\"", t.config.Path)
+	}
+	return nil
+}
+
+// checkLinkID rejects IDs that would not come back from the handler as
+// given: empty, not UTF-8, which JSON would mangle, or with control
+// characters.
+func checkLinkID(id string) error {
+	switch {
+	case id == "":
+		return errors.New("emailtracker: empty ID")
+	case !utf8.ValidString(id):
+		return fmt.Errorf("emailtracker: ID %q is not valid UTF-8", id)
+	case strings.ContainsFunc(id, unicode.IsControl):
+		return fmt.Errorf("emailtracker: ID %q contains control characters", id)
+	}
+	return nil
+}
+
+// checkParams rejects reserved WithParams names.
+func (t *Tracker) checkParams(params map[string]string) error {
+	var errs []error
+	for name := range params {
+		if t.reservedParam(name) {
+			errs = append(errs, fmt.Errorf("emailtracker: WithParams: parameter %q is reserved", name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// linkURL builds the pixel link for id, query-style or, with PathIDs,
+// path-style: {Path}/{id}{ext}. The signature and options of a path-style
+// link, when present, go in an extra "{sig}.{exp}.{nonce}.{sent}.{kid}"
+// segment before the ID, with empty trailing fields left out. WithParams
+// names that are reserved are left out.
+func (t *Tracker) linkURL(id string, o linkOptions) url.URL {
+	scheme, host := t.origin(o)
+	u := url.URL{Scheme: scheme, Host: host}
+	l := o.link(id)
+	if t.config.PathIDs {
+		var seg string
+		if t.signed() {
+			l.kid, seg = t.keys.sign(l.sigParts()...)
+		}
+		opts := [...]string{l.exp, l.nonce, l.sent, l.kid}
+		n := len(opts)
+		for n > 0 && opts[n-1] == "" {
+			n--
+		}
+		if n > 0 {
+			seg += "." + strings.Join(opts[:n], ".")
+		}
+		if seg != "" {
+			seg += "/"
+		}
+		dir := t.pathPrefix() + seg
+		u.Path = dir + id + t.pixel.ext
+		u.RawPath = dir + url.PathEscape(id) + t.pixel.ext
+	} else {
+		var q linkQuery
+		q.set(t.idParam, id)
+		q.set(expParam, l.exp)
+		q.set(nonceParam, l.nonce)
+		q.set(sentParam, l.sent)
+		if t.signed() {
+			kid, sig := t.keys.sign(l.sigParts()...)
+			q.set(sigParam, sig)
+			q.set(keyParam, kid)
+		}
+		u.Path, u.RawQuery = t.pixelPath(), q.encode()
+	}
+	if len(o.params) > 0 {
+		extra := url.Values{}
+		for k, v := range o.params {
+			if !t.reservedParam(k) {
+				extra.Set(k, v)
+			}
+		}
+		if u.RawQuery != "" && len(extra) > 0 {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += extra.Encode()
+	}
+	return u
+}
+
+// parsePathLink extracts the tracking values from the part of a path-style
+// link after the prefix, still escaped: "{id}", "{sig-and-options}/{id}" or
+// "t/{token}". The image extension is optional; tokens, which never contain
+// a dot, may carry any.
+func parsePathLink(rest, ext string) (link, bool) {
+	var l link
+	seg, rest, found := strings.Cut(rest, "/")
+	if !found {
+		seg, rest = "", seg
+	}
+	if strings.Contains(rest, "/") {
+		return link{}, false
+	}
+	if seg == tokenParam {
+		l.token, _, _ = strings.Cut(rest, ".")
+		return l, l.token != ""
+	}
+	var opts string
+	l.sig, opts, _ = strings.Cut(seg, ".")
+	l.exp, opts, _ = strings.Cut(opts, ".")
+	l.nonce, opts, _ = strings.Cut(opts, ".")
+	l.sent, l.kid, _ = strings.Cut(opts, ".")
+	id, err := url.PathUnescape(strings.TrimSuffix(rest, ext))
+	if err != nil || id == "" {
+		return link{}, false
+	}
+	l.id = id
+	return l, true
+}
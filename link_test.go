@@ -0,0 +1,301 @@
+package emailtracker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestGenerateLinkRoundTrip(t *testing.T) {
+	ids := []string{"a&b=c d/é+%", "msg-1", "100%", "?x=1#frag", "a+b", "日本語", "semi;colon", "tab\tand\nnewline", " padded "}
+	for name, cfg := range map[string]Config{
+		"query":    {},
+		"path IDs": {PathIDs: true},
+		"signed":   {SigningKey: []byte("secret")},
+		"IDParam":  {IDParam: "u"},
+	} {
+		tr, log := newTestTracker(t, cfg)
+		for _, id := range ids {
+			link := tr.GenerateLink(id)
+			if _, err := url.Parse(link); err != nil {
+				t.Errorf("%s: link %q for %q doesn't parse: %v", name, link, id, err)
+				continue
+			}
+			before := len(log.all())
+			get(tr.Handler(), link)
+			if events := log.all()[before:]; len(events) != 1 || events[0].ID != id {
+				t.Errorf("%s: link %q yields %d events, want one with ID %q", name, link, len(events), id)
+			}
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	for _, signed := range []bool{false, true} {
+		for _, emit := range []bool{false, true} {
+			cfg := Config{EmitExpired: emit}
+			if signed {
+				cfg.SigningKey = []byte("secret")
+			}
+			clock := newTestClock()
+			cfg.Clock = clock
+			tr, log := newTestTracker(t, cfg)
+			expiry := clock.Now().Add(time.Hour)
+			expiring := tr.GenerateLink("expiring", WithExpiry(expiry))
+			forever := tr.GenerateLink("forever")
+
+			for _, step := range []struct {
+				at      time.Time
+				expired bool
+			}{
+				{at: expiry.Add(-time.Minute)},
+				{at: expiry.Add(expirySkew)}, // within the skew
+				{at: expiry.Add(expirySkew + time.Second), expired: true},
+				{at: expiry.Add(90 * 24 * time.Hour), expired: true},
+			} {
+				clock.advance(step.at.Sub(clock.Now()))
+				before := len(log.all())
+				if w := get(tr.Handler(), expiring); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" {
+					t.Errorf("signed %v, emit %v, at expiry%+v: status %d, Content-Type %q", signed, emit, step.at.Sub(expiry), w.Code, w.Header().Get("Content-Type"))
+				}
+				get(tr.Handler(), forever)
+				events := log.all()[before:]
+				want := 2
+				if step.expired && !emit {
+					want = 1
+				}
+				if len(events) != want {
+					t.Errorf("signed %v, emit %v, at expiry%+v: %d events, want %d", signed, emit, step.at.Sub(expiry), len(events), want)
+					continue
+				}
+				for _, e := range events {
+					if e.Expired != (step.expired && e.ID == "expiring") {
+						t.Errorf("signed %v, emit %v, at expiry%+v: %s has Expired %v", signed, emit, step.at.Sub(expiry), e.ID, e.Expired)
+					}
+				}
+			}
+			if n := tr.Metrics().EventsDropped[DropExpired]; emit && n != 0 || !emit && n != 2 {
+				t.Errorf("signed %v, emit %v: %d expired drops", signed, emit, n)
+			}
+		}
+	}
+}
+
+func TestExpiryTamperedSigned(t *testing.T) {
+	clock := newTestClock()
+	tr, log := newTestTracker(t, Config{SigningKey: []byte("secret"), Clock: clock})
+	link, _ := url.Parse(tr.GenerateLink("msg-1", WithExpiry(clock.Now().Add(time.Hour))))
+	q := link.Query()
+	q.Set(expParam, "99999999999")
+	link.RawQuery = q.Encode()
+	clock.advance(2 * time.Hour)
+	get(tr.Handler(), link.String())
+	if events := log.all(); len(events) != 0 {
+		t.Errorf("extended expiry yielded %d events", len(events))
+	}
+	if n := tr.Metrics().EventsDropped[DropInvalidSignature]; n != 1 {
+		t.Errorf("%d invalid signature drops, want 1", n)
+	}
+}
+
+func TestWithDomain(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"signed":   {SigningKey: []byte("secret")},
+		"path IDs": {SigningKey: []byte("secret"), PathIDs: true},
+	} {
+		tr, log := newTestTracker(t, cfg)
+		errs := recordErrors(tr)
+		def := must(url.Parse(tr.GenerateLink("msg-1")))
+		eu := must(url.Parse(tr.GenerateLink("msg-1", WithDomain("track.eu.example.com"))))
+		us := must(url.Parse(tr.GenerateLink("msg-1", WithDomain("track.us.example.com:8443"), WithScheme("http"))))
+		if eu.Scheme != "https" || eu.Host != "track.eu.example.com" || us.Scheme != "http" || us.Host != "track.us.example.com:8443" {
+			t.Errorf("%s: links %s and %s, want the EU host over https and the US one over http", name, eu, us)
+		}
+		for _, u := range []*url.URL{eu, us} {
+			if u.Path != def.Path || u.RawQuery != def.RawQuery {
+				t.Errorf("%s: %s differs from %s beyond scheme and host", name, u, def)
+			}
+		}
+
+		// Every link verifies on every domain serving it.
+		for _, host := range []string{"tracker.test", "track.eu.example.com", "track.us.example.com:8443"} {
+			for _, u := range []*url.URL{def, eu, us} {
+				served := *u
+				served.Host = host
+				before := len(log.all())
+				get(tr.Handler(), served.String())
+				if events := log.all()[before:]; len(events) != 1 || events[0].ID != "msg-1" || events[0].Host != host {
+					t.Errorf("%s: %s served on %s yields %+v, want one event on that host", name, u, host, events)
+				}
+			}
+		}
+		if errs := errs.all(); len(errs) != 0 {
+			t.Errorf("%s: reported %v", name, errs)
+		}
+	}
+}
+
+func TestWithDomainRejects(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	def := tr.GenerateLink("msg-1")
+	for name, opt := range map[string]LinkOption{
+		"scheme":         WithDomain("https://track.eu.example.com"),
+		"path":           WithDomain("track.eu.example.com/pixel"),
+		"empty":          WithDomain(""),
+		"space":          WithDomain(" track.eu.example.com"),
+		"WithScheme ftp": WithScheme("ftp"),
+	} {
+		errs := recordErrors(tr)
+		if link := tr.GenerateLink("msg-1", opt); link != def {
+			t.Errorf("%s: GenerateLink = %q, want the default link %q", name, link, def)
+		}
+		if got := errs.all(); len(got) != 1 {
+			t.Errorf("%s: reported %v, want one error", name, got)
+		}
+		if _, err := tr.BuildLink("msg-1", opt); !errors.Is(err, ErrInvalidLink) {
+			t.Errorf("%s: BuildLink: %v, want ErrInvalidLink", name, err)
+		}
+	}
+}
+
+func TestBuildLink(t *testing.T) {
+	clock := newTestClock()
+	for name, cfg := range map[string]Config{
+		"query":    {Clock: clock},
+		"path IDs": {Clock: clock, PathIDs: true},
+		"signed":   {Clock: clock, SigningKey: []byte("secret")},
+	} {
+		tr, log := newTestTracker(t, cfg)
+		for _, opts := range [][]LinkOption{
+			nil,
+			{WithExpiry(clock.Now().Add(time.Hour))},
+			{WithParams(map[string]string{"v": "b", "utm_source": "news letter"})},
+			{WithDomain("track.eu.example.com"), WithScheme("http")},
+		} {
+			u, err := tr.BuildLink("msg 1/é", opts...)
+			if err != nil {
+				t.Fatalf("%s: BuildLink: %v", name, err)
+			}
+			if link := tr.GenerateLink("msg 1/é", opts...); u.String() != link {
+				t.Errorf("%s: BuildLink = %s, GenerateLink = %s", name, u, link)
+			}
+			before := len(log.all())
+			get(tr.Handler(), u.String())
+			if events := log.all()[before:]; len(events) != 1 || events[0].ID != "msg 1/é" {
+				t.Errorf("%s: %s yields %+v, want one event", name, u, events)
+			}
+		}
+	}
+}
+
+func TestBuildLinkErrors(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	errs := recordErrors(tr)
+	for name, c := range map[string]struct {
+		id   string
+		opts []LinkOption
+	}{
+		"empty ID":     {id: ""},
+		"invalid UTF8": {id: "msg-\xff"},
+		"control char": {id: "msg\n1"},
+		"bad domain":   {id: "msg-1", opts: []LinkOption{WithDomain("https://x.example.com")}},
+		"bad scheme":   {id: "msg-1", opts: []LinkOption{WithScheme("ftp")}},
+		"reserved":     {id: "msg-1", opts: []LinkOption{WithParams(map[string]string{"id": "other"})}},
+	} {
+		u, err := tr.BuildLink(c.id, c.opts...)
+		if u != nil || !errors.Is(err, ErrInvalidLink) || errors.Is(err, ErrLinkConfig) {
+			t.Errorf("%s: BuildLink = %v, %v; want only ErrInvalidLink", name, u, err)
+		}
+	}
+	if got := errs.all(); len(got) != 0 {
+		t.Errorf("BuildLink reported %v to the hook, want its errors returned only", got)
+	}
+
+	for name, cfg := range map[string]Config{
+		"no domain":       {Path: "/pixel"},
+		"relative path":   {Domain: "tracker.test", Path: "pixel"},
+		"domain and path": {Domain: "https://tracker.test"},
+	} {
+		nt := NewTracker(cfg, nil)
+		u, err := nt.BuildLink("msg-1")
+		if u != nil || !errors.Is(err, ErrLinkConfig) || errors.Is(err, ErrInvalidLink) {
+			t.Errorf("%s: BuildLink = %v, %v; want only ErrLinkConfig", name, u, err)
+		}
+		nt.Shutdown(context.Background())
+	}
+}
+
+func FuzzBuildLink(f *testing.F) {
+	for _, seed := range [][3]string{
+		{"msg-1", "v", "a"},
+		{"a&b=c d/é+%", "utm_source", "news letter"},
+		{"?x=1#frag", "q", "a#b?c"},
+		{"日本語", "名前", "値"},
+		{" padded ", "", ""},
+		{"../..", "id", "reserved"},
+		{"100%", "%zz", "%"},
+	} {
+		f.Add(seed[0], seed[1], seed[2])
+	}
+	trackers := map[string]*Tracker{}
+	logs := map[string]*eventLog{}
+	for name, cfg := range map[string]Config{
+		"query":    {},
+		"path IDs": {PathIDs: true},
+		"signed":   {SigningKey: []byte("secret"), PathIDs: true},
+	} {
+		trackers[name], logs[name] = newTestTracker(f, cfg)
+	}
+	f.Fuzz(func(t *testing.T, id, key, value string) {
+		var opts []LinkOption
+		if key != "" {
+			opts = append(opts, WithParams(map[string]string{key: value}))
+		}
+		for name, tr := range trackers {
+			u, err := tr.BuildLink(id, opts...)
+			if err != nil {
+				if !errors.Is(err, ErrInvalidLink) {
+					t.Fatalf("%s: BuildLink(%q): %v, want ErrInvalidLink", name, id, err)
+				}
+				continue
+			}
+			again, err := url.Parse(u.String())
+			if err != nil || again.String() != u.String() {
+				t.Fatalf("%s: %s doesn't re-parse to itself: %v, %v", name, u, again, err)
+			}
+			log := logs[name]
+			before := len(log.all())
+			get(tr.Handler(), u.String())
+			if events := log.all()[before:]; len(events) != 1 || events[0].ID != id {
+				t.Fatalf("%s: %s yields %+v, want one event for %q", name, u, events, id)
+			}
+		}
+	})
+}
+
+func BenchmarkGenerateLink(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "default", cfg: Config{}},
+		{name: "signed", cfg: Config{SigningKey: []byte("benchmark-key")}},
+		{name: "path-ids", cfg: Config{PathIDs: true}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			bb.cfg.Domain, bb.cfg.Path = "tracker.test", "/pixel"
+			tr, err := New(bb.cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer tr.Shutdown(context.Background())
+			b.ReportAllocs()
+			for b.Loop() {
+				tr.GenerateLink("msg-1")
+			}
+		})
+	}
+}
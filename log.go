@@ -0,0 +1,43 @@
+package emailtracker
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+// Attribute keys used in log records. They are part of the package's API so
+// dashboards built on them keep working.
+const (
+	LogKeyID     = "tracking_id"
+	LogKeyIP     = "client_ip"
+	LogKeyKind   = "event_kind"
+	LogKeySource = "source"
+	LogKeyError  = "error"
+	LogKeyAddr   = "addr"
+	LogKeyPanic  = "panic"
+	LogKeyStack  = "stack"
+)
+
+func newLogger(l *slog.Logger) *slog.Logger {
+	if l == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return l
+}
+
+// warnRequest logs a rejected or malformed request.
+func (t *Tracker) warnRequest(r *http.Request, msg, id string, err error) {
+	ip, _ := t.clientIP(r)
+	attrs := []any{slog.String(LogKeyID, id), slog.String(LogKeyIP, ip)}
+	if err != nil {
+		attrs = append(attrs, slog.String(LogKeyError, err.Error()))
+	}
+	t.log.Warn(msg, attrs...)
+}
+
+func eventAttrs(e *OpenEvent) []any {
+	if e == nil {
+		return nil
+	}
+	return []any{slog.String(LogKeyID, e.ID), slog.String(LogKeyIP, e.IP)}
+}
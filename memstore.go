@@ -0,0 +1,108 @@
+package emailtracker
+
+import "sync"
+
+// DefaultMemoryStoreLimit is the capacity of a MemoryStore created with a
+// non-positive limit.
+const DefaultMemoryStoreLimit = 100_000
+
+// MemoryStore is a thread-safe in-memory Store holding a bounded number of
+// events. Once full, each new event evicts the oldest one. Events are copied
+// on the way in and out, so callers can't mutate its contents.
+type MemoryStore struct {
+	mu   sync.RWMutex
+	ring []OpenEvent
+	next uint64              // sequence number of the next event
+	byID map[string][]uint64 // sequence numbers per ID, oldest first
+}
+
+// NewMemoryStore returns a MemoryStore holding at most limit events.
+func NewMemoryStore(limit int) *MemoryStore {
+	if limit <= 0 {
+		limit = DefaultMemoryStoreLimit
+	}
+	return &MemoryStore{
+		ring: make([]OpenEvent, limit),
+		byID: make(map[string][]uint64),
+	}
+}
+
+func (s *MemoryStore) Save(e OpenEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	size := uint64(len(s.ring))
+	if s.next >= size {
+		s.evict(s.next - size)
+	}
+	s.ring[s.next%size] = cloneEvent(e)
+	s.byID[e.ID] = append(s.byID[e.ID], s.next)
+	s.next++
+	return nil
+}
+
+// evict forgets the event with sequence number seq, which is always the
+// oldest one stored.
+func (s *MemoryStore) evict(seq uint64) {
+	id := s.ring[seq%uint64(len(s.ring))].ID
+	seqs := s.byID[id]
+	if len(seqs) <= 1 {
+		delete(s.byID, id)
+		return
+	}
+	s.byID[id] = seqs[1:]
+}
+
+func (s *MemoryStore) ByID(id string) ([]OpenEvent, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seqs := s.byID[id]
+	out := make([]OpenEvent, 0, len(seqs))
+	for _, seq := range seqs {
+		out = append(out, cloneEvent(s.ring[seq%uint64(len(s.ring))]))
+	}
+	return out, nil
+}
+
+// CountOpens implements OpenCountStore without copying events.
+func (s *MemoryStore) CountOpens(id string, limit int) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	n := 0
+	for _, seq := range s.byID[id] {
+		if n >= limit {
+			break
+		}
+		if k := s.ring[seq%uint64(len(s.ring))].Kind; k == EventOpen || k == "" {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// Each iterates over a snapshot, so fn may call back into the store.
+func (s *MemoryStore) Each(fn func(OpenEvent) bool) error {
+	s.mu.RLock()
+	size := uint64(len(s.ring))
+	first := uint64(0)
+	if s.next > size {
+		first = s.next - size
+	}
+	snapshot := make([]OpenEvent, 0, s.next-first)
+	for seq := first; seq < s.next; seq++ {
+		snapshot = append(snapshot, s.ring[seq%size])
+	}
+	s.mu.RUnlock()
+	for _, e := range snapshot {
+		if !fn(cloneEvent(e)) {
+			break
+		}
+	}
+	return nil
+}
+
+// Len reports the number of events held.
+func (s *MemoryStore) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return int(min(s.next, uint64(len(s.ring))))
+}
@@ -0,0 +1,130 @@
+package emailtracker
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Metrics receives instrumentation from the tracker; see the prommetrics
+// sub-package for a Prometheus implementation. Labels are drawn from small
+// fixed sets, never from tracking IDs. Implementations must be safe for
+// concurrent use.
+type Metrics interface {
+	// ObserveRequest records a request to one of the tracker's routes.
+	ObserveRequest(route string, status int, d time.Duration)
+	// IncEvent counts an event handed to the store and subscribers.
+	IncEvent(kind EventKind)
+	// IncDropped counts an event suppressed before delivery.
+	IncDropped(reason DropReason)
+	// IncError counts an internal failure, by source such as "store".
+	IncError(source string)
+}
+
+// BatchMetrics is an optional extension of Metrics. If the configured Metrics
+// implements it, the tracker reports how many events are waiting in
+// SubscribeBatch buffers.
+type BatchMetrics interface {
+	SetBatchBuffered(n int)
+}
+
+// DropReason says why an event was suppressed.
+type DropReason string
+
+const (
+	DropDedup            DropReason = "dedup"
+	DropBot              DropReason = "bot"
+	DropInvalidSignature DropReason = "invalid_signature"
+	DropInvalidToken     DropReason = "invalid_token"
+	DropQueueFull        DropReason = "queue_full"
+	DropExpired          DropReason = "expired"
+	DropDoNotTrack       DropReason = "do_not_track"
+	DropHead             DropReason = "head"
+	DropUnknownPath      DropReason = "unknown_path"
+)
+
+// Route labels passed to Metrics.ObserveRequest.
+const (
+	RoutePixel = "pixel"
+	RouteClick = "click"
+)
+
+type noopMetrics struct{}
+
+func (noopMetrics) ObserveRequest(string, int, time.Duration) {}
+func (noopMetrics) IncEvent(EventKind)                        {}
+func (noopMetrics) IncDropped(DropReason)                     {}
+func (noopMetrics) IncError(string)                           {}
+
+// statusRecorder captures the status code and body size written by a
+// handler. Flushing and hijacking pass through to the wrapped writer.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+func (r *statusRecorder) Flush() { r.FlushError() }
+
+// FlushError is what http.ResponseController.Flush calls.
+func (r *statusRecorder) FlushError() error {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return http.NewResponseController(r.ResponseWriter).Flush()
+}
+
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(r.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the wrapped writer.
+func (r *statusRecorder) Unwrap() http.ResponseWriter { return r.ResponseWriter }
+
+// code returns the status sent, 200 if the handler wrote nothing.
+func (r *statusRecorder) code() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
+
+// instrument reports each request to h under route, and traces it.
+func (t *Tracker) instrument(route string, h http.HandlerFunc) http.HandlerFunc {
+	h = t.logAccess(t.traceRequest(route, h))
+	if t.config.Metrics == nil {
+		if !recipientRoute(route) {
+			return h
+		}
+		return func(w http.ResponseWriter, r *http.Request) {
+			t.counters.requests.Add(1)
+			h(w, r)
+		}
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		t.metrics.ObserveRequest(route, rec.status, time.Since(start))
+	}
+}
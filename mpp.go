@@ -0,0 +1,98 @@
+package emailtracker
+
+import (
+	"net/netip"
+	"regexp"
+	"time"
+)
+
+// PrefetchClassifier decides whether an open is a mail client's privacy
+// prefetch, such as Apple Mail Privacy Protection loading every image on
+// delivery, rather than a person opening the message. It sees the event
+// before filters and subscribers, with IP, User-Agent and TimeToOpen set.
+// Implementations must be safe for concurrent use.
+type PrefetchClassifier interface {
+	PrivacyPrefetch(e *OpenEvent) bool
+}
+
+// PrefetchMetrics is an optional extension of Metrics. If the configured
+// Metrics implements it, the tracker counts emitted opens classified as
+// privacy prefetches.
+type PrefetchMetrics interface {
+	IncPrivacyPrefetch()
+}
+
+// NoopPrefetchClassifier classifies nothing, turning off the default
+// Apple Mail Privacy Protection detection.
+type NoopPrefetchClassifier struct{}
+
+func (NoopPrefetchClassifier) PrivacyPrefetch(*OpenEvent) bool { return false }
+
+// DefaultAppleRelayCIDRs are the ranges Apple Mail Privacy Protection fetches
+// from by default: Apple's own network. Apple publishes the iCloud Private
+// Relay egress ranges, some of which MPP also uses, at
+// https://mask-api.icloud.com/egress-ip-ranges.csv.
+var DefaultAppleRelayCIDRs = []string{"17.0.0.0/8"}
+
+// appleMailUA matches the User-Agents of Apple Mail's image loader: WebKit
+// without Safari's Version and Safari tokens, or a bare "Mozilla/5.0".
+var appleMailUA = regexp.MustCompile(`^Mozilla/5\.0(?: \((?:Macintosh|iPhone|iPad)[^)]*\) AppleWebKit/[\d.]+ \(KHTML, like Gecko\)(?: Mobile/\w+)?)?$`)
+
+// AppleMPPOptions configures an AppleMPPClassifier.
+type AppleMPPOptions struct {
+	// RelayCIDRs are the ranges prefetches come from, DefaultAppleRelayCIDRs
+	// when nil.
+	RelayCIDRs []string
+
+	// PrefetchWindow, when set, also classifies Apple Mail opens within
+	// that long of a link's WithSentAt time, from any IP. Prefetches
+	// usually land within seconds of delivery.
+	PrefetchWindow time.Duration
+}
+
+// AppleMPPClassifier is the default PrefetchClassifier. It flags opens with
+// an Apple Mail User-Agent coming from an Apple relay range, or, with a
+// PrefetchWindow, arriving right after the message was sent.
+type AppleMPPClassifier struct {
+	relays []netip.Prefix
+	window time.Duration
+}
+
+// NewAppleMPPClassifier returns a classifier for opts, failing on an
+// invalid CIDR.
+func NewAppleMPPClassifier(opts AppleMPPOptions) (*AppleMPPClassifier, error) {
+	cidrs := opts.RelayCIDRs
+	if cidrs == nil {
+		cidrs = DefaultAppleRelayCIDRs
+	}
+	relays, err := parsePrefixes("AppleMPPOptions.RelayCIDRs", cidrs)
+	if err != nil {
+		return nil, err
+	}
+	return &AppleMPPClassifier{relays: relays, window: opts.PrefetchWindow}, nil
+}
+
+func (c *AppleMPPClassifier) PrivacyPrefetch(e *OpenEvent) bool {
+	if !appleMailUA.MatchString(e.UserAgent) {
+		return false
+	}
+	if addr, err := netip.ParseAddr(e.IP); err == nil && containsAddr(c.relays, addr) {
+		return true
+	}
+	return c.window > 0 && e.TimeToOpen > 0 && e.TimeToOpen < c.window
+}
+
+// classifyPrefetch sets e.PrivacyPrefetch.
+func (t *Tracker) classifyPrefetch(e *OpenEvent) {
+	if e.UserAgent == "" && e.IP == "" {
+		return // privacy mode or DNT-minimal: nothing to go on
+	}
+	e.PrivacyPrefetch = t.prefetch.PrivacyPrefetch(e)
+}
+
+func (c *counters) incPrivacyPrefetch() {
+	c.prefetches.Add(1)
+	if m, ok := c.next.(PrefetchMetrics); ok {
+		m.IncPrivacyPrefetch()
+	}
+}
@@ -0,0 +1,97 @@
+package emailtracker
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+)
+
+// DropOpenCap is the drop reason for opens past Config.MaxOpensPerID.
+const DropOpenCap DropReason = "open_cap"
+
+// openCounter counts emitted opens per ID, forgetting the least recently
+// opened IDs beyond size.
+type openCounter struct {
+	size int
+
+	mu     sync.Mutex
+	order  *list.List               // most recently opened first
+	counts map[string]*list.Element // id -> element holding an openCount
+}
+
+type openCount struct {
+	id string
+	n  int
+}
+
+func newOpenCounter(size int) *openCounter {
+	if size <= 0 {
+		size = defaultMaxKeys
+	}
+	return &openCounter{size: size, order: list.New(), counts: make(map[string]*list.Element)}
+}
+
+// has reports whether id is counted.
+func (c *openCounter) has(id string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	_, ok := c.counts[id]
+	return ok
+}
+
+// incr counts an open for id unless it already has limit, reporting
+// whether it was counted. floor, when larger than the remembered count,
+// replaces it.
+func (c *openCounter) incr(id string, floor, limit int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.counts[id]
+	if !ok {
+		el = c.order.PushFront(&openCount{id: id})
+		c.counts[id] = el
+		if c.order.Len() > c.size {
+			last := c.order.Back()
+			c.order.Remove(last)
+			delete(c.counts, last.Value.(*openCount).id)
+		}
+	}
+	c.order.MoveToFront(el)
+	oc := el.Value.(*openCount)
+	oc.n = max(oc.n, floor)
+	if oc.n >= limit {
+		return false
+	}
+	oc.n++
+	return true
+}
+
+// overCap reports whether e's ID has already produced MaxOpensPerID opens,
+// counting e if not. With a Store, the stored opens set a floor for the
+// count, so restarts and evictions don't reset it. An OpenCountStore is
+// asked on every open, so replicas sharing it agree too; other stores are
+// read only when an ID isn't counted yet, keeping ByID off the hot path.
+func (t *Tracker) overCap(e *OpenEvent) bool {
+	if t.opens == nil {
+		return false
+	}
+	var stored int
+	if t.config.Store != nil {
+		if _, ok := t.config.Store.(OpenCountStore); ok || !t.opens.has(e.ID) {
+			n, err := t.storedOpens(e.ID, t.config.MaxOpensPerID)
+			if err != nil {
+				t.reportError("store", fmt.Errorf("%w: %w", ErrStoreRead, err), e)
+			}
+			stored = n
+		}
+	}
+	if t.opens.incr(e.ID, stored, t.config.MaxOpensPerID) {
+		return false
+	}
+	t.openCapped.Add(1)
+	return true
+}
+
+// OpenCapped reports how many opens MaxOpensPerID has suppressed.
+func (t *Tracker) OpenCapped() uint64 {
+	return t.openCapped.Load()
+}
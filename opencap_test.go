@@ -0,0 +1,86 @@
+package emailtracker
+
+import "testing"
+
+// byIDStore is a Store without the OpenCountStore extension, counting
+// ByID calls.
+type byIDStore struct {
+	mem   *MemoryStore
+	reads int
+}
+
+func (s *byIDStore) Save(e OpenEvent) error { return s.mem.Save(e) }
+
+func (s *byIDStore) ByID(id string) ([]OpenEvent, error) {
+	s.reads++
+	return s.mem.ByID(id)
+}
+
+func (s *byIDStore) Each(fn func(OpenEvent) bool) error { return s.mem.Each(fn) }
+
+func TestOpenCap(t *testing.T) {
+	tr, log := newTestTracker(t, Config{MaxOpensPerID: 2})
+	link := tr.GenerateLink("msg-1")
+	for range 4 {
+		get(tr.Handler(), link)
+	}
+	get(tr.Handler(), tr.GenerateLink("msg-2"))
+
+	if got := len(log.all()); got != 3 {
+		t.Errorf("delivered %d events, want 3", got)
+	}
+	if got := tr.OpenCapped(); got != 2 {
+		t.Errorf("OpenCapped = %d, want 2", got)
+	}
+}
+
+func TestOpenCapReadsPlainStoreOncePerID(t *testing.T) {
+	store := &byIDStore{mem: NewMemoryStore(0)}
+	tr, log := newTestTracker(t, Config{MaxOpensPerID: 3, Store: store})
+	link := tr.GenerateLink("msg-1")
+	for range 5 {
+		get(tr.Handler(), link)
+	}
+	if got := len(log.all()); got != 3 {
+		t.Errorf("delivered %d events, want 3", got)
+	}
+	if store.reads != 1 {
+		t.Errorf("ByID called %d times, want 1", store.reads)
+	}
+
+	// A restarted tracker starts from the stored opens.
+	tr2, log2 := newTestTracker(t, Config{MaxOpensPerID: 3, Store: store})
+	get(tr2.Handler(), tr2.GenerateLink("msg-1"))
+	if got := len(log2.all()); got != 0 {
+		t.Errorf("restarted tracker delivered %d events, want 0", got)
+	}
+}
+
+func TestOpenCapSharedOpenCountStore(t *testing.T) {
+	store := NewMemoryStore(0)
+	a, logA := newTestTracker(t, Config{MaxOpensPerID: 2, Store: store})
+	b, logB := newTestTracker(t, Config{MaxOpensPerID: 2, Store: store})
+	get(a.Handler(), a.GenerateLink("msg-1"))
+	get(b.Handler(), b.GenerateLink("msg-1"))
+	get(a.Handler(), a.GenerateLink("msg-1"))
+	get(b.Handler(), b.GenerateLink("msg-1"))
+
+	if got := len(logA.all()) + len(logB.all()); got != 2 {
+		t.Errorf("replicas delivered %d events, want 2", got)
+	}
+	if got := a.OpenCapped() + b.OpenCapped(); got != 2 {
+		t.Errorf("OpenCapped = %d, want 2", got)
+	}
+}
+
+func TestMemoryStoreCountOpens(t *testing.T) {
+	s := NewMemoryStore(0)
+	for _, kind := range []EventKind{EventOpen, EventClick, "", EventOpen} {
+		s.Save(OpenEvent{ID: "msg-1", Kind: kind})
+	}
+	for _, tt := range []struct{ limit, want int }{{10, 3}, {2, 2}, {0, 0}} {
+		if n, err := s.CountOpens("msg-1", tt.limit); err != nil || n != tt.want {
+			t.Errorf("CountOpens(limit %d) = %d, %v; want %d", tt.limit, n, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package emailtracker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// Option adjusts the Config passed to New. Options are applied in order, so
+// a later option overrides an earlier one or the Config field it sets.
+// Options whose value can't be silently replaced, such as a Store or a
+// signing key, instead make New fail if it was already set.
+type Option func(cfg *Config, o *options) error
+
+// options collects what an Option sets beyond Config.
+type options struct {
+	subscribers []Subscriber
+}
+
+// WithCallback subscribes fn, as Subscribe does. It may be given several
+// times; the callbacks are subscribed in order.
+func WithCallback(fn func(OpenEvent)) Option {
+	if fn == nil {
+		return WithSubscriber(nil)
+	}
+	return WithSubscriber(func(_ context.Context, e OpenEvent) error {
+		fn(e)
+		return nil
+	})
+}
+
+// WithSubscriber subscribes fn, as SubscribeContext does.
+func WithSubscriber(fn Subscriber) Option {
+	return func(_ *Config, o *options) error {
+		if fn != nil {
+			o.subscribers = append(o.subscribers, fn)
+		}
+		return nil
+	}
+}
+
+// WithStore sets Config.Store. It fails if a store is already set.
+func WithStore(s Store) Option {
+	return func(cfg *Config, _ *options) error {
+		if cfg.Store != nil {
+			return errors.New("emailtracker: WithStore: a Store is already set")
+		}
+		cfg.Store = s
+		return nil
+	}
+}
+
+// WithSigningKey sets Config.SigningKey and SigningKeyID. It fails if a
+// signing key is already set; use OldSigningKeys to accept earlier keys.
+func WithSigningKey(id string, key []byte) Option {
+	return func(cfg *Config, _ *options) error {
+		if len(cfg.SigningKey) > 0 {
+			return errors.New("emailtracker: WithSigningKey: a SigningKey is already set")
+		}
+		cfg.SigningKey, cfg.SigningKeyID = key, id
+		return nil
+	}
+}
+
+// WithWebhook sets Config.Webhook. It fails if a webhook is already set.
+func WithWebhook(w WebhookConfig) Option {
+	return func(cfg *Config, _ *options) error {
+		if cfg.Webhook != nil {
+			return errors.New("emailtracker: WithWebhook: a Webhook is already set")
+		}
+		cfg.Webhook = &w
+		return nil
+	}
+}
+
+// WithPixelFormat sets Config.PixelFormat. It fails if Config.PixelData is
+// set, since that takes precedence.
+func WithPixelFormat(f PixelFormat) Option {
+	return func(cfg *Config, _ *options) error {
+		if cfg.PixelData != nil {
+			return errors.New("emailtracker: WithPixelFormat: PixelData is set")
+		}
+		cfg.PixelFormat = f
+		return nil
+	}
+}
+
+// WithLogger sets Config.Logger.
+func WithLogger(l *slog.Logger) Option {
+	return func(cfg *Config, _ *options) error {
+		cfg.Logger = l
+		return nil
+	}
+}
+
+// WithMetrics sets Config.Metrics.
+func WithMetrics(m Metrics) Option {
+	return func(cfg *Config, _ *options) error {
+		cfg.Metrics = m
+		return nil
+	}
+}
+
+// WithTracer sets Config.Tracer.
+func WithTracer(tr Tracer) Option {
+	return func(cfg *Config, _ *options) error {
+		cfg.Tracer = tr
+		return nil
+	}
+}
@@ -0,0 +1,134 @@
+package emailtracker
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOptionsKeepDefaults(t *testing.T) {
+	cfg := Config{Domain: "tracker.test", Path: "/pixel"}
+	plain := must(New(cfg))
+	noops := must(New(cfg,
+		WithCallback(nil), WithSubscriber(nil),
+		WithLogger(nil), WithMetrics(nil), WithTracer(nil),
+	))
+	legacy := NewTracker(cfg, nil)
+	for name, tr := range map[string]*Tracker{"no-op options": noops, "NewTracker": legacy} {
+		if !reflect.DeepEqual(tr.config, plain.config) {
+			t.Errorf("%s: config %+v, want %+v", name, tr.config, plain.config)
+		}
+		if tr.pixel.contentType != plain.pixel.contentType || !bytes.Equal(tr.pixel.data, plain.pixel.data) ||
+			tr.idParam != plain.idParam || tr.prefix != plain.prefix || tr.suspicious != plain.suspicious {
+			t.Errorf("%s: derived settings differ from New without options", name)
+		}
+		if n := len(tr.subscriberList()); n != 0 {
+			t.Errorf("%s: %d subscribers, want none", name, n)
+		}
+	}
+	if plain.pixel.contentType != "image/gif" || plain.idParam != defaultIDParam || plain.suspicious != DefaultSuspiciousOpenWindow {
+		t.Errorf("New: pixel %q, IDParam %q, SuspiciousOpenWindow %v; want the defaults",
+			plain.pixel.contentType, plain.idParam, plain.suspicious)
+	}
+}
+
+func TestOptionsSetConfig(t *testing.T) {
+	store := NewMemoryStore(0)
+	logger := slog.New(slog.NewTextHandler(&bytes.Buffer{}, nil))
+	metrics := noopMetrics{}
+	hook := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	t.Cleanup(hook.Close) // after Shutdown flushes the webhook
+	tr, _ := newTestTracker(t, Config{},
+		WithStore(store),
+		WithSigningKey("k1", []byte("secret")),
+		WithWebhook(WebhookConfig{URL: hook.URL}),
+		WithPixelFormat(PixelPNG),
+		WithLogger(logger),
+		WithMetrics(metrics),
+	)
+	c := tr.config
+	if c.Store != store || string(c.SigningKey) != "secret" || c.SigningKeyID != "k1" ||
+		c.Webhook == nil || c.Webhook.URL != hook.URL || c.PixelFormat != PixelPNG ||
+		c.Logger != logger || c.Metrics != Metrics(metrics) {
+		t.Errorf("config after options = %+v", c)
+	}
+	if tr.pixel.contentType != "image/png" {
+		t.Errorf("pixel %q, want image/png", tr.pixel.contentType)
+	}
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	if store.Len() != 1 {
+		t.Errorf("store has %d events, want 1", store.Len())
+	}
+}
+
+func TestOptionsApplyInOrder(t *testing.T) {
+	var first, second bytes.Buffer
+	tr, _ := newTestTracker(t, Config{PixelFormat: PixelGIF, SigningKey: []byte("secret")},
+		WithPixelFormat(PixelPNG),
+		WithPixelFormat(PixelSVG),
+		WithLogger(slog.New(slog.NewTextHandler(&first, nil))),
+		WithLogger(slog.New(slog.NewTextHandler(&second, nil))),
+	)
+	if tr.pixel.contentType != "image/svg+xml" {
+		t.Errorf("pixel %q, want the last format, SVG", tr.pixel.contentType)
+	}
+	get(tr.Handler(), "/pixel?id=msg-1&sig=forged")
+	if first.Len() != 0 || !strings.Contains(second.String(), ErrBadSignature.Error()) {
+		t.Errorf("first logger got %q, second %q; want only the second", first.String(), second.String())
+	}
+}
+
+func TestOptionConflicts(t *testing.T) {
+	cfg := Config{Domain: "tracker.test", Path: "/pixel"}
+	webhook := WebhookConfig{URL: "https://hooks.test/"}
+	for _, c := range []struct {
+		name string
+		cfg  Config
+		opts []Option
+		want string
+	}{
+		{"two stores", cfg, []Option{WithStore(NewMemoryStore(0)), WithStore(NewMemoryStore(0))}, "WithStore"},
+		{"store in Config", Config{Domain: "tracker.test", Path: "/pixel", Store: NewMemoryStore(0)}, []Option{WithStore(NewMemoryStore(0))}, "WithStore"},
+		{"two signing keys", cfg, []Option{WithSigningKey("a", []byte("a")), WithSigningKey("b", []byte("b"))}, "WithSigningKey"},
+		{"signing key in Config", Config{Domain: "tracker.test", Path: "/pixel", SigningKey: []byte("a")}, []Option{WithSigningKey("b", []byte("b"))}, "WithSigningKey"},
+		{"two webhooks", cfg, []Option{WithWebhook(webhook), WithWebhook(webhook)}, "WithWebhook"},
+		{"format with PixelData", Config{Domain: "tracker.test", Path: "/pixel", PixelData: []byte("x"), PixelContentType: "image/x"}, []Option{WithPixelFormat(PixelPNG)}, "WithPixelFormat"},
+	} {
+		tr, err := New(c.cfg, c.opts...)
+		if err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: New = %v, %v; want an error from %s", c.name, tr, err, c.want)
+		}
+	}
+}
+
+func TestOptionSubscribers(t *testing.T) {
+	a, b := newEventLog(), newEventLog()
+	tr, _ := newTestTracker(t, Config{},
+		WithCallback(a.add),
+		WithCallback(nil),
+		WithSubscriber(func(_ context.Context, e OpenEvent) error {
+			b.add(e)
+			return nil
+		}),
+	)
+	// newTestTracker subscribes its own log after the options' subscribers.
+	if n := len(tr.subscriberList()); n != 3 {
+		t.Fatalf("%d subscribers, want 3", n)
+	}
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	if len(a.all()) != 1 || len(b.all()) != 1 {
+		t.Errorf("WithCallback got %d events, WithSubscriber %d; want 1 each", len(a.all()), len(b.all()))
+	}
+
+	legacy := newEventLog()
+	nt := NewTracker(Config{Domain: "tracker.test", Path: "/pixel"}, legacy.add)
+	get(nt.Handler(), nt.GenerateLink("msg-1"))
+	if events := legacy.all(); len(events) != 1 || events[0].ID != "msg-1" {
+		t.Errorf("NewTracker callback got %v, want one event for msg-1", events)
+	}
+}
@@ -0,0 +1,123 @@
+package emailtracker
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// defaultIDParam is the query parameter carrying the tracking ID unless
+// Config.IDParam renames it.
+const defaultIDParam = "id"
+
+// validIDParam checks a custom Config.IDParam: it must be URL-safe and must
+// not clash with the tracker's other parameters.
+func validIDParam(name string) error {
+	switch name {
+	case sigParam, tokenParam, urlParam, expParam, nonceParam, sentParam, keyParam,
+		ampParam, ampClientIDParam, ampRandomParam, ampSourceOriginParam:
+		return fmt.Errorf("emailtracker: IDParam %q is reserved", name)
+	}
+	for _, c := range name {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || strings.ContainsRune("-._~", c)) {
+			return fmt.Errorf("emailtracker: IDParam %q may only contain letters, digits and -._~", name)
+		}
+	}
+	return nil
+}
+
+// queryID returns the tracking ID carried in query.
+func (t *Tracker) queryID(query url.Values) string {
+	id := query.Get(t.idParam)
+	if id == "" && t.config.AcceptDefaultIDParam {
+		id = query.Get(defaultIDParam)
+	}
+	return id
+}
+
+// reservedParam reports whether name is used by the tracker itself and so
+// can't be set through link params or appear in OpenEvent.Params.
+func (t *Tracker) reservedParam(name string) bool {
+	switch name {
+	case t.idParam, sigParam, tokenParam, expParam, nonceParam, sentParam, keyParam,
+		ampParam, ampClientIDParam, ampRandomParam, ampSourceOriginParam:
+		return true
+	case defaultIDParam:
+		return t.config.AcceptDefaultIDParam
+	case modeParam:
+		return t.config.Beacon
+	}
+	return false
+}
+
+// GenerateLinkWithParams is like GenerateLink but appends params, URL-encoded
+// and sorted by name so links are stable. Reserved names such as the ID
+// parameter are ignored. Params are not covered by the link signature.
+func (t *Tracker) GenerateLinkWithParams(id string, params map[string]string, opts ...LinkOption) string {
+	link := t.GenerateLink(id, opts...)
+	extra := url.Values{}
+	for k, v := range params {
+		if !t.reservedParam(k) {
+			extra.Set(k, v)
+		}
+	}
+	if len(extra) > 0 {
+		sep := "&"
+		if !strings.Contains(link, "?") {
+			sep = "?"
+		}
+		link += sep + extra.Encode()
+	}
+	return link
+}
+
+// defaultMaxParamLen caps tracked parameter values.
+const defaultMaxParamLen = 256
+
+// extraParams returns the non-reserved query parameters, or nil if none.
+// With TrackedParams only those are kept, without empty values, and values
+// are truncated to MaxParamLen bytes. Repeated parameters keep their first
+// value.
+func (t *Tracker) extraParams(query url.Values) map[string]string {
+	if len(t.config.TrackedParams) > 0 {
+		return t.trackedParams(query)
+	}
+	var params map[string]string
+	for k, v := range query {
+		if t.reservedParam(k) || len(v) == 0 {
+			continue
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[k] = v[0]
+	}
+	return params
+}
+
+func (t *Tracker) trackedParams(query url.Values) map[string]string {
+	var params map[string]string
+	for _, k := range t.config.TrackedParams {
+		v := query.Get(k)
+		if v == "" || t.reservedParam(k) {
+			continue
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[k] = t.truncateParam(v)
+	}
+	return params
+}
+
+// truncateParam cuts v to MaxParamLen bytes.
+func (t *Tracker) truncateParam(v string) string {
+	limit := t.config.MaxParamLen
+	if limit <= 0 {
+		limit = defaultMaxParamLen
+	}
+	if len(v) > limit {
+		v = strings.ToValidUTF8(v[:limit], "")
+	}
+	return v
+}
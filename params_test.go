@@ -0,0 +1,136 @@
+package emailtracker
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestValidIDParam(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		ok   bool
+	}{
+		{"u", true},
+		{"msg_id", true},
+		{"m-1.x~", true},
+		{"sig", false},
+		{"amp", false},
+		{"amp_cid", false},
+		{"amp_rnd", false},
+		{"__amp_source_origin", false},
+		{"a b", false},
+		{"é", false},
+	} {
+		if err := validIDParam(tt.name); (err == nil) != tt.ok {
+			t.Errorf("validIDParam(%q) = %v, want ok %v", tt.name, err, tt.ok)
+		}
+	}
+}
+
+func TestGenerateLinkWithParams(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	params := map[string]string{"variant": "b&c", "campaign": "spring sale", "id": "forged", "sig": "x"}
+	link := tr.GenerateLinkWithParams("msg-1", params)
+	const want = "https://tracker.test/pixel?id=msg-1&campaign=spring+sale&variant=b%26c"
+	if link != want {
+		t.Errorf("link %q, want %q", link, want)
+	}
+	for range 5 {
+		if again := tr.GenerateLinkWithParams("msg-1", params); again != link {
+			t.Fatalf("link changed to %q", again)
+		}
+	}
+
+	get(tr.Handler(), link+"&sig=y")
+	e := log.wait(t, 1)[0]
+	if e.ID != "msg-1" {
+		t.Errorf("ID %q, want msg-1", e.ID)
+	}
+	if want := map[string]string{"campaign": "spring sale", "variant": "b&c"}; !maps.Equal(e.Params, want) {
+		t.Errorf("Params %v, want %v", e.Params, want)
+	}
+}
+
+func TestParamsWithoutExtras(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	if link := tr.GenerateLinkWithParams("msg-1", nil); link != tr.GenerateLink("msg-1") {
+		t.Errorf("link %q differs from GenerateLink", link)
+	}
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	if e := log.wait(t, 1)[0]; e.Params != nil {
+		t.Errorf("Params %v, want nil", e.Params)
+	}
+}
+
+func TestIDParam(t *testing.T) {
+	tr, log := newTestTracker(t, Config{IDParam: "u"})
+	if link := tr.GenerateLink("msg-1"); link != "https://tracker.test/pixel?u=msg-1" {
+		t.Errorf("link %q, want the ID in u", link)
+	}
+	get(tr.Handler(), "/pixel?u=msg-1&id=msg-2")
+	get(tr.Handler(), "/pixel?id=msg-3")
+	events := log.wait(t, 2)
+	if events[0].ID != "msg-1" || events[1].ID != "" {
+		t.Errorf("IDs %q and %q, want msg-1 and none from the old parameter", events[0].ID, events[1].ID)
+	}
+	if events[0].Params["id"] != "msg-2" {
+		t.Errorf("Params %v, want the old id kept as a plain parameter", events[0].Params)
+	}
+
+	migrating, log := newTestTracker(t, Config{IDParam: "u", AcceptDefaultIDParam: true})
+	get(migrating.Handler(), "/pixel?id=msg-3")
+	get(migrating.Handler(), "/pixel?u=msg-1&id=msg-2")
+	events = log.wait(t, 2)
+	if events[0].ID != "msg-3" || events[1].ID != "msg-1" {
+		t.Errorf("IDs %q and %q, want msg-3 and msg-1", events[0].ID, events[1].ID)
+	}
+	if events[1].Params != nil {
+		t.Errorf("Params %v, want id reserved while accepted", events[1].Params)
+	}
+}
+
+func TestInvalidIDParam(t *testing.T) {
+	for _, name := range []string{"sig", "t", "a b"} {
+		if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", IDParam: name}); err == nil {
+			t.Errorf("New accepted IDParam %q", name)
+		}
+	}
+}
+
+func TestTrackedParams(t *testing.T) {
+	tr, log := newTestTracker(t, Config{TrackedParams: []string{"v", "src", "sig", "long"}, MaxParamLen: 8})
+	base := tr.GenerateLink("msg-1")
+	for _, c := range []struct {
+		name  string
+		query string
+		want  map[string]string
+	}{
+		{name: "allowed", query: "&v=subjectA&src=news", want: map[string]string{"v": "subjectA", "src": "news"}},
+		{name: "others ignored", query: "&v=a&utm=x&evil=" + strings.Repeat("x", 1000), want: map[string]string{"v": "a"}},
+		{name: "duplicated", query: "&v=first&v=second", want: map[string]string{"v": "first"}},
+		{name: "missing value", query: "&v=&src", want: nil},
+		{name: "absent", query: "", want: nil},
+		{name: "decoded", query: "&v=a%20b%26c", want: map[string]string{"v": "a b&c"}},
+		{name: "capped", query: "&long=" + strings.Repeat("y", 50), want: map[string]string{"long": "yyyyyyyy"}},
+		{name: "capped mid-rune", query: "&long=abcdefg%C3%A9", want: map[string]string{"long": "abcdefg"}},
+		{name: "reserved", query: "&v=a&sig=zzz", want: map[string]string{"v": "a"}},
+	} {
+		before := len(log.all())
+		get(tr.Handler(), base+c.query)
+		events := log.all()[before:]
+		if len(events) != 1 {
+			t.Fatalf("%s: %d events", c.name, len(events))
+		}
+		if got := events[0].Params; !maps.Equal(got, c.want) || (got == nil) != (c.want == nil) {
+			t.Errorf("%s: Params %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// GenerateLinkWithParams sets tracked params.
+	get(tr.Handler(), tr.GenerateLinkWithParams("msg-2", map[string]string{"v": "subjectB", "other": "x"}))
+	events := log.all()
+	if got := events[len(events)-1].Params; !maps.Equal(got, map[string]string{"v": "subjectB"}) {
+		t.Errorf("GenerateLinkWithParams: Params %v", got)
+	}
+}
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS events (
+	id          BIGSERIAL   PRIMARY KEY,
+	tracking_id TEXT        NOT NULL,
+	kind        TEXT        NOT NULL,
+	ip          TEXT        NOT NULL,
+	user_agent  TEXT        NOT NULL,
+	lang        TEXT        NOT NULL,
+	referer     TEXT        NOT NULL,
+	occurred_at TIMESTAMPTZ NOT NULL,
+	metadata    JSONB,               -- decrypted token payload, if any
+	data        JSONB       NOT NULL -- the full event
+);
+CREATE INDEX IF NOT EXISTS events_tracking_id ON events (tracking_id, occurred_at);
+CREATE INDEX IF NOT EXISTS events_occurred_at ON events (occurred_at);
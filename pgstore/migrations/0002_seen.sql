@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS seen (
+	key        TEXT        PRIMARY KEY,
+	expires_at TIMESTAMPTZ             -- NULL for never
+);
+CREATE INDEX IF NOT EXISTS seen_expires_at ON seen (expires_at);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS sends (
+	id          BIGSERIAL   PRIMARY KEY,
+	tracking_id TEXT        NOT NULL UNIQUE,
+	campaign    TEXT        NOT NULL,
+	recipient   TEXT        NOT NULL,
+	sent_at     TIMESTAMPTZ NOT NULL,
+	data        JSONB       NOT NULL -- the full send
+);
+CREATE INDEX IF NOT EXISTS sends_campaign ON sends (campaign, sent_at);
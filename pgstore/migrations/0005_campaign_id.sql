@@ -0,0 +1,3 @@
+ALTER TABLE events ADD COLUMN IF NOT EXISTS campaign_id TEXT NOT NULL DEFAULT '';
+UPDATE events SET campaign_id = data->>'campaign_id' WHERE data->>'campaign_id' IS NOT NULL;
+CREATE INDEX IF NOT EXISTS events_campaign_id ON events (campaign_id, occurred_at) WHERE campaign_id <> '';
@@ -0,0 +1,523 @@
+// Package pgstore provides an emailtracker.Store, with the SeenSet,
+// SendStore, CampaignStore, EventPager and OpenCountStore extensions,
+// backed by PostgreSQL.
+//
+// It works with any database/sql PostgreSQL driver; import one (for example
+// github.com/jackc/pgx/v5/stdlib or github.com/lib/pq) and pass its name to
+// Open, or hand an already opened *sql.DB to New. The schema is created and
+// upgraded by embedded migrations, recorded in the emailtracker_migrations
+// table.
+package pgstore
+
+import (
+	"database/sql"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// migrationLock is the advisory lock key held while migrating, so replicas
+// starting together don't race.
+const migrationLock = 0x656d61696c74726b // "emailtrk"
+
+const selectEvents = `SELECT data, occurred_at FROM events `
+
+const selectSends = `SELECT data, sent_at FROM sends `
+
+// columnsPerRow is the number of parameters one inserted event takes;
+// PostgreSQL allows at most 65535 per statement.
+const columnsPerRow = 11
+
+const maxBatchSize = 65535 / columnsPerRow
+
+// pruneEvery is how many Add calls pass between deletions of expired keys.
+const pruneEvery = 1000
+
+// Options configures a Store.
+type Options struct {
+	// BatchSize, when above 1, buffers saved events and writes them in one
+	// multi-row INSERT once BatchSize have accumulated or FlushInterval
+	// (default 1s) has passed. Buffered events are flushed before every
+	// read and on Close. Errors from interval flushes go to OnError, or are
+	// dropped when it is nil; errors from size-triggered flushes are returned
+	// by Save.
+	BatchSize     int
+	FlushInterval time.Duration
+	OnError       func(error)
+}
+
+// Store is an emailtracker.Store persisting events in a PostgreSQL database.
+// It also implements emailtracker.SeenSet, so replicas sharing the database
+// agree on dedup and first opens, as well as emailtracker.SendStore,
+// emailtracker.CampaignStore, emailtracker.EventPager and
+// emailtracker.OpenCountStore.
+type Store struct {
+	db   *sql.DB
+	opts Options
+	adds atomic.Int64 // Add calls
+
+	mu      sync.Mutex
+	pending []emailtracker.OpenEvent
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// Open opens the database with the named driver and runs the migrations.
+func Open(driverName, dsn string, opts Options) (*Store, error) {
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, err
+	}
+	s, err := New(db, opts)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New wraps an open database, running any migrations not yet applied.
+func New(db *sql.DB, opts Options) (*Store, error) {
+	if opts.BatchSize > maxBatchSize {
+		return nil, fmt.Errorf("pgstore: BatchSize %d exceeds %d", opts.BatchSize, maxBatchSize)
+	}
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+	s := &Store{db: db, opts: opts}
+	if opts.BatchSize > 1 {
+		if s.opts.FlushInterval <= 0 {
+			s.opts.FlushInterval = time.Second
+		}
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.flushLoop()
+	}
+	return s, nil
+}
+
+func migrate(db *sql.DB) error {
+	names, err := fs.Glob(migrations, "migrations/*.sql")
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, int64(migrationLock)); err != nil {
+		return fmt.Errorf("pgstore: lock migrations: %w", err)
+	}
+	// Created under the lock: concurrent CREATE TABLE IF NOT EXISTS can
+	// fail on a duplicate catalog entry.
+	if _, err := tx.Exec(`CREATE TABLE IF NOT EXISTS emailtracker_migrations (
+	version    INTEGER     PRIMARY KEY,
+	applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`); err != nil {
+		return fmt.Errorf("pgstore: create migrations table: %w", err)
+	}
+	for _, name := range names {
+		version, err := strconv.Atoi(strings.SplitN(path.Base(name), "_", 2)[0])
+		if err != nil {
+			return fmt.Errorf("pgstore: migration %s: bad version", name)
+		}
+		var applied bool
+		if err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM emailtracker_migrations WHERE version = $1)`, version).Scan(&applied); err != nil {
+			return err
+		}
+		if applied {
+			continue
+		}
+		body, err := migrations.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(string(body)); err != nil {
+			return fmt.Errorf("pgstore: migration %s: %w", name, err)
+		}
+		if _, err := tx.Exec(`INSERT INTO emailtracker_migrations (version) VALUES ($1)`, version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Save implements emailtracker.Store. An event whose EventID is already
+// stored is skipped, so saving it again, as a retry may, is harmless.
+func (s *Store) Save(e emailtracker.OpenEvent) error {
+	if s.opts.BatchSize <= 1 {
+		return s.insert([]emailtracker.OpenEvent{e})
+	}
+	s.mu.Lock()
+	s.pending = append(s.pending, e)
+	full := len(s.pending) >= s.opts.BatchSize
+	s.mu.Unlock()
+	if full {
+		return s.Flush()
+	}
+	return nil
+}
+
+// Flush writes any buffered events.
+func (s *Store) Flush() error {
+	s.mu.Lock()
+	batch := s.pending
+	s.pending = nil
+	s.mu.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+	return s.insert(batch)
+}
+
+func (s *Store) flushLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Flush(); err != nil && s.opts.OnError != nil {
+				s.opts.OnError(err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Store) insert(events []emailtracker.OpenEvent) error {
+	var q strings.Builder
+	q.WriteString(`INSERT INTO events (tracking_id, kind, ip, user_agent, lang, referer, occurred_at, metadata, data, event_id, campaign_id) VALUES `)
+	args := make([]any, 0, len(events)*columnsPerRow)
+	for i, e := range events {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		var metadata any
+		if e.Metadata != nil {
+			b, err := json.Marshal(e.Metadata)
+			if err != nil {
+				return err
+			}
+			metadata = string(b)
+		}
+		if i > 0 {
+			q.WriteString(", ")
+		}
+		n := len(args)
+		fmt.Fprintf(&q, "($%d, $%d, $%d, $%d, $%d, $%d, $%d, $%d::jsonb, $%d::jsonb, $%d, $%d)",
+			n+1, n+2, n+3, n+4, n+5, n+6, n+7, n+8, n+9, n+10, n+11)
+		args = append(args, e.ID, string(e.Kind), e.IP, e.UserAgent, e.AcceptLang, e.Referer, e.Time, metadata, string(data), e.EventID, e.CampaignID)
+	}
+	q.WriteString(` ON CONFLICT (event_id) WHERE event_id <> '' DO NOTHING`)
+	_, err := s.db.Exec(q.String(), args...)
+	return err
+}
+
+// Add implements emailtracker.SeenSet. The key is inserted unless present
+// and unexpired, in one statement, so exactly one replica sees it as new.
+func (s *Store) Add(key string, ttl time.Duration) (bool, error) {
+	var expires any
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	res, err := s.db.Exec(`INSERT INTO seen (key, expires_at) VALUES ($1, $2)
+ON CONFLICT (key) DO UPDATE SET expires_at = EXCLUDED.expires_at WHERE seen.expires_at <= now()`, key, expires)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if s.adds.Add(1)%pruneEvery == 0 {
+		if _, err := s.db.Exec(`DELETE FROM seen WHERE expires_at <= now()`); err != nil {
+			return false, err
+		}
+	}
+	return n == 0, nil
+}
+
+// ByID implements emailtracker.Store.
+func (s *Store) ByID(id string) ([]emailtracker.OpenEvent, error) {
+	return s.query(selectEvents+`WHERE tracking_id = $1 ORDER BY occurred_at, id`, id)
+}
+
+// CountOpens implements emailtracker.OpenCountStore over the tracking_id
+// index.
+func (s *Store) CountOpens(id string, limit int) (int, error) {
+	if err := s.Flush(); err != nil {
+		return 0, err
+	}
+	var n int
+	err := s.db.QueryRow(`SELECT count(*) FROM (SELECT 1 FROM events WHERE tracking_id = $1 AND kind IN ('open', '') LIMIT $2) AS opens`,
+		id, limit).Scan(&n)
+	return n, err
+}
+
+// Range returns the events that occurred in [from, to), oldest first.
+func (s *Store) Range(from, to time.Time) ([]emailtracker.OpenEvent, error) {
+	return s.query(selectEvents+`WHERE occurred_at >= $1 AND occurred_at < $2 ORDER BY occurred_at, id`, from, to)
+}
+
+// PageByID implements emailtracker.EventPager. Cursors are row IDs.
+func (s *Store) PageByID(id, cursor string, limit int) ([]emailtracker.OpenEvent, string, error) {
+	var after int64
+	if cursor != "" {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n < 0 {
+			return nil, "", emailtracker.ErrBadCursor
+		}
+		after = n
+	}
+	if err := s.Flush(); err != nil {
+		return nil, "", err
+	}
+	rows, err := s.db.Query(`SELECT data, occurred_at, id FROM events WHERE tracking_id = $1 AND id > $2 ORDER BY id LIMIT $3`,
+		id, after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	var out []emailtracker.OpenEvent
+	var seqs []int64
+	for rows.Next() {
+		var data []byte
+		var at time.Time
+		var seq int64
+		if err := rows.Scan(&data, &at, &seq); err != nil {
+			return nil, "", err
+		}
+		var e emailtracker.OpenEvent
+		if err := json.Unmarshal(data, &e); err != nil {
+			return nil, "", err
+		}
+		e.Time = at.In(e.Time.Location())
+		out, seqs = append(out, e), append(seqs, seq)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if len(out) <= limit {
+		return out, "", nil
+	}
+	return out[:limit], strconv.FormatInt(seqs[limit-1], 10), nil
+}
+
+// Each implements emailtracker.Store, streaming rows in insertion order.
+func (s *Store) Each(fn func(emailtracker.OpenEvent) bool) error {
+	if err := s.Flush(); err != nil {
+		return err
+	}
+	rows, err := s.db.Query(selectEvents + `ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+		if !fn(e) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// SaveSend implements emailtracker.SendStore. The upsert is one statement;
+// xmax is zero only for the row it inserted.
+func (s *Store) SaveSend(send emailtracker.Send) (bool, error) {
+	data, err := json.Marshal(send)
+	if err != nil {
+		return false, err
+	}
+	var created bool
+	err = s.db.QueryRow(`INSERT INTO sends (tracking_id, campaign, recipient, sent_at, data) VALUES ($1, $2, $3, $4, $5::jsonb)
+ON CONFLICT (tracking_id) DO UPDATE SET campaign = EXCLUDED.campaign, recipient = EXCLUDED.recipient,
+	sent_at = EXCLUDED.sent_at, data = EXCLUDED.data
+RETURNING xmax = 0`, send.ID, send.Campaign, send.Recipient, send.SentAt, string(data)).Scan(&created)
+	return created, err
+}
+
+// SendByID implements emailtracker.SendStore.
+func (s *Store) SendByID(id string) (emailtracker.Send, bool, error) {
+	rows, err := s.db.Query(selectSends+`WHERE tracking_id = $1`, id)
+	if err != nil {
+		return emailtracker.Send{}, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return emailtracker.Send{}, false, rows.Err()
+	}
+	send, err := scanSend(rows)
+	return send, err == nil, err
+}
+
+// EachSend implements emailtracker.SendStore.
+func (s *Store) EachSend(fn func(emailtracker.Send) bool) error {
+	rows, err := s.db.Query(selectSends + `ORDER BY id`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		send, err := scanSend(rows)
+		if err != nil {
+			return err
+		}
+		if !fn(send) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// CampaignReport implements emailtracker.CampaignStore, aggregating in
+// SQL over the campaign_id index.
+func (s *Store) CampaignReport(campaign string, window emailtracker.TimeRange) (emailtracker.CampaignReport, error) {
+	rep := emailtracker.CampaignReport{OpensByDay: make(map[string]int)}
+	if err := s.Flush(); err != nil {
+		return rep, err
+	}
+	var from, to any
+	if !window.From.IsZero() {
+		from = window.From
+	}
+	if !window.To.IsZero() {
+		to = window.To
+	}
+	if err := s.db.QueryRow(`SELECT count(*) FROM sends WHERE campaign = $1
+	AND sent_at >= coalesce($2, '-infinity'::timestamptz) AND sent_at < coalesce($3, 'infinity'::timestamptz)`,
+		campaign, from, to).Scan(&rep.Sends); err != nil {
+		return rep, err
+	}
+	const opens = `FROM events WHERE campaign_id = $1 AND kind IN ('open', '')
+	AND occurred_at >= coalesce($2, '-infinity'::timestamptz) AND occurred_at < coalesce($3, 'infinity'::timestamptz) `
+	if err := s.db.QueryRow(`SELECT count(*), count(DISTINCT tracking_id) `+opens, campaign, from, to).
+		Scan(&rep.TotalOpens, &rep.UniqueOpens); err != nil {
+		return rep, err
+	}
+	days, err := s.counts(`SELECT to_char(occurred_at AT TIME ZONE 'UTC', 'YYYY-MM-DD'), count(*) `+opens+
+		`GROUP BY 1`, campaign, from, to)
+	if err != nil {
+		return rep, err
+	}
+	for _, d := range days {
+		rep.OpensByDay[d.Name] = d.Opens
+	}
+	for _, top := range []struct {
+		field string
+		dst   *[]emailtracker.NameCount
+	}{
+		{`data->>'email_client'`, &rep.TopEmailClients},
+		{`data->'geo'->>'country'`, &rep.TopCountries},
+	} {
+		*top.dst, err = s.counts(`SELECT `+top.field+` AS name, count(*) AS n `+opens+
+			`AND coalesce(`+top.field+`, '') <> '' GROUP BY name ORDER BY n DESC, name LIMIT `+strconv.Itoa(emailtracker.CampaignTopN),
+			campaign, from, to)
+		if err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// counts runs a query of name and count pairs.
+func (s *Store) counts(q string, args ...any) ([]emailtracker.NameCount, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []emailtracker.NameCount
+	for rows.Next() {
+		var c emailtracker.NameCount
+		if err := rows.Scan(&c.Name, &c.Opens); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// Close flushes buffered events and closes the underlying database.
+func (s *Store) Close() error {
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	err := s.Flush()
+	if cerr := s.db.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (s *Store) query(q string, args ...any) ([]emailtracker.OpenEvent, error) {
+	if err := s.Flush(); err != nil {
+		return nil, err
+	}
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []emailtracker.OpenEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func scanEvent(rows *sql.Rows) (emailtracker.OpenEvent, error) {
+	var data []byte
+	var at time.Time
+	var e emailtracker.OpenEvent
+	if err := rows.Scan(&data, &at); err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal(data, &e); err != nil {
+		return e, err
+	}
+	// The JSON time has millisecond precision; occurred_at keeps
+	// microseconds.
+	e.Time = at.In(e.Time.Location())
+	return e, nil
+}
+
+func scanSend(rows *sql.Rows) (emailtracker.Send, error) {
+	var data []byte
+	var at time.Time
+	var send emailtracker.Send
+	if err := rows.Scan(&data, &at); err != nil {
+		return send, err
+	}
+	if err := json.Unmarshal(data, &send); err != nil {
+		return send, err
+	}
+	send.SentAt = at.In(send.SentAt.Location())
+	return send, nil
+}
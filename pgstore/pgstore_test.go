@@ -0,0 +1,365 @@
+package pgstore
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+// These tests need a PostgreSQL server, e.g.
+//
+//	docker run --rm -e POSTGRES_PASSWORD=pg -p 5432:5432 postgres
+//	PGSTORE_TEST_DSN=postgres://postgres:pg@localhost:5432/postgres go test ./pgstore
+//
+// Each test works in a schema of its own, dropped when it ends.
+const dsnEnv = "PGSTORE_TEST_DSN"
+
+var t0 = time.Date(2026, 3, 1, 12, 0, 0, 123456000, time.UTC)
+
+// schema creates a fresh schema, dropped when the test ends, and returns
+// the connection settings confined to it. It skips the test when dsnEnv is
+// unset.
+func schema(t *testing.T) *pgx.ConnConfig {
+	t.Helper()
+	dsn := os.Getenv(dsnEnv)
+	if dsn == "" {
+		t.Skipf("%s not set", dsnEnv)
+	}
+	cfg, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		t.Fatalf("%s: %v", dsnEnv, err)
+	}
+	b := make([]byte, 6)
+	rand.Read(b)
+	name := "pgstore_test_" + hex.EncodeToString(b)
+	admin := stdlib.OpenDB(*cfg)
+	t.Cleanup(func() {
+		if _, err := admin.Exec(`DROP SCHEMA IF EXISTS ` + name + ` CASCADE`); err != nil {
+			t.Errorf("drop schema: %v", err)
+		}
+		admin.Close()
+	})
+	if _, err := admin.Exec(`CREATE SCHEMA ` + name); err != nil {
+		t.Fatalf("create schema: %v", err)
+	}
+	cfg = cfg.Copy()
+	if cfg.RuntimeParams == nil {
+		cfg.RuntimeParams = make(map[string]string)
+	}
+	cfg.RuntimeParams["search_path"] = name
+	return cfg
+}
+
+// openDB opens a database confined to a fresh schema.
+func openDB(t *testing.T) *sql.DB {
+	t.Helper()
+	return stdlib.OpenDB(*schema(t))
+}
+
+// open returns a store on a fresh schema, closed when the test ends.
+func open(t *testing.T, opts Options) (*Store, *sql.DB) {
+	t.Helper()
+	db := openDB(t)
+	s, err := New(db, opts)
+	if err != nil {
+		db.Close()
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, db
+}
+
+func ids(events []emailtracker.OpenEvent) []string {
+	var out []string
+	for _, e := range events {
+		out = append(out, e.EventID)
+	}
+	return out
+}
+
+// rows counts the rows of the events table, bypassing the store's buffer.
+func rows(t *testing.T, db *sql.DB) int {
+	t.Helper()
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM events`).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSaveAndQuery(t *testing.T) {
+	s, _ := open(t, Options{})
+	for _, e := range []emailtracker.OpenEvent{
+		{EventID: "e1", ID: "msg-1", Kind: emailtracker.EventOpen, Time: t0.Add(2 * time.Minute), IP: "203.0.113.7", UserAgent: "Mail/1.0"},
+		{EventID: "e2", ID: "msg-2", Kind: emailtracker.EventOpen, Time: t0.Add(time.Minute)},
+		{EventID: "e3", ID: "msg-1", Kind: emailtracker.EventClick, Time: t0},
+		{EventID: "e4", ID: "msg-1", Time: t0.Add(3 * time.Minute), Metadata: map[string]string{"campaign": "spring"}},
+		{EventID: "e1", ID: "msg-1", Kind: emailtracker.EventOpen, Time: t0.Add(2 * time.Minute)}, // a retry
+	} {
+		if err := s.Save(e); err != nil {
+			t.Fatalf("Save %s: %v", e.EventID, err)
+		}
+	}
+
+	got, err := s.ByID("msg-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e3", "e1", "e4"}; !slices.Equal(ids(got), want) {
+		t.Fatalf("ByID = %v, want %v, oldest first", ids(got), want)
+	}
+	if !got[1].Time.Equal(t0.Add(2*time.Minute)) || got[1].IP != "203.0.113.7" || got[1].UserAgent != "Mail/1.0" {
+		t.Errorf("event e1 came back with Time %v, IP %q, UserAgent %q", got[1].Time, got[1].IP, got[1].UserAgent)
+	}
+	if got[2].Metadata["campaign"] != "spring" {
+		t.Errorf("Metadata %v lost", got[2].Metadata)
+	}
+
+	inRange, err := s.Range(t0.Add(time.Minute), t0.Add(3*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e2", "e1"}; !slices.Equal(ids(inRange), want) {
+		t.Errorf("Range = %v, want %v", ids(inRange), want)
+	}
+
+	var all []string
+	if err := s.Each(func(e emailtracker.OpenEvent) bool { all = append(all, e.EventID); return true }); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e1", "e2", "e3", "e4"}; !slices.Equal(all, want) {
+		t.Errorf("Each = %v, want %v in insertion order", all, want)
+	}
+
+	for limit, want := range map[int]int{10: 2, 1: 1} {
+		if n, err := s.CountOpens("msg-1", limit); err != nil || n != want {
+			t.Errorf("CountOpens(limit %d) = %d, %v; want %d", limit, n, err, want)
+		}
+	}
+
+	var paged []string
+	for cursor := ""; ; {
+		page, next, err := s.PageByID("msg-1", cursor, 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		paged = append(paged, ids(page)...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if want := []string{"e1", "e3", "e4"}; !slices.Equal(paged, want) {
+		t.Errorf("PageByID = %v, want %v in insertion order", paged, want)
+	}
+	if _, _, err := s.PageByID("msg-1", "bogus", 2); err != emailtracker.ErrBadCursor {
+		t.Errorf("PageByID with a bad cursor = %v", err)
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	s, db := open(t, Options{})
+	if _, err := New(db, Options{}); err != nil {
+		t.Fatalf("second New on a migrated database: %v", err)
+	}
+	var versions int
+	if err := db.QueryRow(`SELECT count(*) FROM emailtracker_migrations`).Scan(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if want, _ := migrations.ReadDir("migrations"); versions != len(want) {
+		t.Errorf("%d migrations recorded, want %d", versions, len(want))
+	}
+	for _, index := range []string{"events_tracking_id", "events_occurred_at", "events_event_id", "events_campaign_id"} {
+		var ok bool
+		if err := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM pg_indexes WHERE indexname = $1 AND schemaname = current_schema())`, index).Scan(&ok); err != nil || !ok {
+			t.Errorf("index %s missing: %v", index, err)
+		}
+	}
+	if err := s.Save(emailtracker.OpenEvent{ID: "msg-1", Time: t0}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConcurrentMigrations(t *testing.T) {
+	db := openDB(t)
+	defer db.Close()
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := New(db, Options{}); err != nil {
+				t.Errorf("New: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestBatching(t *testing.T) {
+	cfg := schema(t)
+	var flushErrs atomic.Int32
+	s, err := New(stdlib.OpenDB(*cfg), Options{BatchSize: 3, FlushInterval: time.Hour, OnError: func(error) { flushErrs.Add(1) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A second handle sees only what the store has written.
+	reader := stdlib.OpenDB(*cfg)
+	defer reader.Close()
+	save := func(id string) {
+		t.Helper()
+		if err := s.Save(emailtracker.OpenEvent{EventID: id, ID: "msg-1", Kind: emailtracker.EventOpen, Time: t0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save("e1")
+	save("e2")
+	if n := rows(t, reader); n != 0 {
+		t.Errorf("%d rows before the batch filled", n)
+	}
+	save("e3")
+	if n := rows(t, reader); n != 3 {
+		t.Errorf("%d rows after a full batch, want 3", n)
+	}
+	save("e4")
+	if got, err := s.ByID("msg-1"); err != nil || len(got) != 4 {
+		t.Errorf("ByID = %v, %v; want buffered events flushed first", ids(got), err)
+	}
+
+	save("e5")
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if n := rows(t, reader); n != 5 {
+		t.Errorf("after Close %d rows, want 5", n)
+	}
+	if flushErrs.Load() != 0 {
+		t.Errorf("%d flush errors", flushErrs.Load())
+	}
+}
+
+func TestBatchInterval(t *testing.T) {
+	s, db := open(t, Options{BatchSize: 100, FlushInterval: 20 * time.Millisecond})
+	if err := s.Save(emailtracker.OpenEvent{ID: "msg-1", Time: t0}); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for rows(t, db) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("buffered event never flushed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSeenSet(t *testing.T) {
+	s, _ := open(t, Options{})
+	for i, want := range []bool{false, true} {
+		if seen, err := s.Add("dedup:a", time.Hour); err != nil || seen != want {
+			t.Errorf("Add %d = %v, %v; want %v", i, seen, err, want)
+		}
+	}
+	if seen, _ := s.Add("dedup:b", time.Millisecond); seen {
+		t.Error("new key reported seen")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if seen, _ := s.Add("dedup:b", time.Hour); seen {
+		t.Error("expired key still reported seen")
+	}
+	if seen, _ := s.Add("first:msg-1", 0); seen {
+		t.Error("new key without TTL reported seen")
+	}
+	if seen, _ := s.Add("first:msg-1", 0); !seen {
+		t.Error("key without TTL forgotten")
+	}
+
+	var wg sync.WaitGroup
+	var firsts atomic.Int32
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if seen, err := s.Add("race", time.Hour); err != nil {
+				t.Errorf("Add: %v", err)
+			} else if !seen {
+				firsts.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := firsts.Load(); n != 1 {
+		t.Errorf("%d concurrent Adds saw the key as new, want 1", n)
+	}
+}
+
+func TestSendsAndCampaignReport(t *testing.T) {
+	s, _ := open(t, Options{})
+	for _, send := range []emailtracker.Send{
+		{ID: "s1", SendMeta: emailtracker.SendMeta{Campaign: "spring", Recipient: "ana", SentAt: t0}},
+		{ID: "s2", SendMeta: emailtracker.SendMeta{Campaign: "spring", Recipient: "bo", SentAt: t0}},
+	} {
+		if created, err := s.SaveSend(send); err != nil || !created {
+			t.Fatalf("SaveSend %s = %v, %v", send.ID, created, err)
+		}
+	}
+	if created, err := s.SaveSend(emailtracker.Send{ID: "s1", SendMeta: emailtracker.SendMeta{Campaign: "spring", Recipient: "ana", SentAt: t0.Add(time.Hour)}}); err != nil || created {
+		t.Errorf("SaveSend again = %v, %v; want an update", created, err)
+	}
+	if send, ok, err := s.SendByID("s1"); err != nil || !ok || !send.SentAt.Equal(t0.Add(time.Hour)) {
+		t.Errorf("SendByID = %+v, %v, %v", send, ok, err)
+	}
+	if _, ok, err := s.SendByID("none"); err != nil || ok {
+		t.Errorf("SendByID(none) = %v, %v", ok, err)
+	}
+
+	for i, e := range []emailtracker.OpenEvent{
+		{ID: "s1", CampaignID: "spring", EmailClient: "Gmail", Time: t0.Add(2 * time.Hour)},
+		{ID: "s1", CampaignID: "spring", EmailClient: "Gmail", Time: t0.Add(26 * time.Hour)},
+		{ID: "s2", CampaignID: "spring", EmailClient: "Outlook", Time: t0.Add(3 * time.Hour)},
+		{ID: "x", CampaignID: "autumn", Time: t0},
+	} {
+		e.Kind, e.EventID = emailtracker.EventOpen, string(rune('a'+i))
+		if err := s.Save(e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rep, err := s.CampaignReport("spring", emailtracker.TimeRange{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rep.Sends != 2 || rep.TotalOpens != 3 || rep.UniqueOpens != 2 || rep.OpensByDay["2026-03-01"] != 2 || rep.OpensByDay["2026-03-02"] != 1 {
+		t.Errorf("report %+v", rep)
+	}
+	if len(rep.TopEmailClients) != 2 || rep.TopEmailClients[0] != (emailtracker.NameCount{Name: "Gmail", Opens: 2}) {
+		t.Errorf("top email clients %v", rep.TopEmailClients)
+	}
+}
+
+func TestTrackerFirstOpen(t *testing.T) {
+	s, _ := open(t, Options{})
+	var firsts []bool
+	for range 2 {
+		tr, err := emailtracker.New(emailtracker.Config{Domain: "tracker.test", Path: "/pixel", Store: s, TrackFirstOpen: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr.Subscribe(func(e emailtracker.OpenEvent) { firsts = append(firsts, e.FirstOpen) })
+		tr.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tr.GenerateLink("msg-1"), nil))
+		tr.Shutdown(context.Background())
+	}
+	if !slices.Equal(firsts, []bool{true, false}) {
+		t.Errorf("FirstOpen across trackers = %v, want [true false]", firsts)
+	}
+}
@@ -0,0 +1,135 @@
+package emailtracker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// PixelFormat names the image format served as the tracking pixel.
+type PixelFormat string
+
+const (
+	PixelGIF PixelFormat = "gif"
+	PixelPNG PixelFormat = "png"
+	PixelSVG PixelFormat = "svg"
+)
+
+type pixel struct {
+	data        []byte
+	contentType string
+	ext         string
+	length      string // Content-Length header value
+}
+
+var pixels = map[PixelFormat]pixel{
+	"":       {gifData, "image/gif", ".gif", strconv.Itoa(len(gifData))},
+	PixelGIF: {gifData, "image/gif", ".gif", strconv.Itoa(len(gifData))},
+	PixelPNG: {pngData, "image/png", ".png", strconv.Itoa(len(pngData))},
+	PixelSVG: {svgData, "image/svg+xml", ".svg", strconv.Itoa(len(svgData))},
+}
+
+// pixel resolves the image to serve from PixelData or PixelFormat.
+func (c Config) pixel() (pixel, error) {
+	if c.PixelData != nil || c.PixelContentType != "" {
+		if len(c.PixelData) == 0 {
+			return pixel{}, errors.New("emailtracker: PixelContentType set but PixelData is empty")
+		}
+		if c.PixelContentType == "" {
+			return pixel{}, errors.New("emailtracker: PixelData set without PixelContentType")
+		}
+		px := pixel{
+			data:        append([]byte(nil), c.PixelData...),
+			contentType: c.PixelContentType,
+			length:      strconv.Itoa(len(c.PixelData)),
+		}
+		if c.PixelContentType == "image/jpeg" {
+			px.ext = ".jpg" // rather than the alphabetically first ".jfif"
+		} else if exts, _ := mime.ExtensionsByType(c.PixelContentType); len(exts) > 0 {
+			px.ext = exts[0]
+		}
+		return px, nil
+	}
+	px, ok := pixels[c.PixelFormat]
+	if !ok {
+		return pixel{}, fmt.Errorf("emailtracker: unknown PixelFormat %q", c.PixelFormat)
+	}
+	return px, nil
+}
+
+// pixelPath is the path GenerateLink points at.
+func (t *Tracker) pixelPath() string {
+	if t.config.LinkExtension {
+		return t.config.Path + t.pixel.ext
+	}
+	return t.config.Path
+}
+
+// pathPrefix is the path under which path-style links live.
+func (t *Tracker) pathPrefix() string {
+	return t.prefix
+}
+
+// etag derives a stable ETag from the tracking ID, so a client revalidating
+// its cached copy of one message's pixel sends the ID back to us.
+func (t *Tracker) etag(id string) string {
+	sum := sha256.Sum256([]byte(id))
+	var tag [18]byte
+	tag[0], tag[17] = '"', '"'
+	hex.Encode(tag[1:17], sum[:8])
+	return string(tag[:])
+}
+
+// notModified reports whether r is a conditional request that the cached
+// pixel with the given ETag still satisfies.
+func (t *Tracker) notModified(r *http.Request, etag string) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		since, err := http.ParseTime(ims)
+		if err != nil {
+			return false
+		}
+		modified, _ := http.ParseTime(t.lastModified)
+		return !modified.After(since)
+	}
+	return false
+}
+
+// 1x1 Transparent Gif
+var gifData = []byte{
+	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00,
+	0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0xFF, 0xFF, 0xFF, 0x21, 0xF9, 0x04, 0x01, 0x00,
+	0x00, 0x00, 0x00, 0x2C, 0x00, 0x00, 0x00, 0x00,
+	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44,
+	0x01, 0x00, 0x3B,
+}
+
+// 1x1 Transparent PNG
+var pngData = []byte{
+	0x89, 0x50, 0x4E, 0x47, 0x0D, 0x0A, 0x1A, 0x0A,
+	0x00, 0x00, 0x00, 0x0D, 0x49, 0x48, 0x44, 0x52,
+	0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01,
+	0x08, 0x06, 0x00, 0x00, 0x00, 0x1F, 0x15, 0xC4,
+	0x89, 0x00, 0x00, 0x00, 0x0E, 0x49, 0x44, 0x41,
+	0x54, 0x78, 0xDA, 0x62, 0x62, 0x60, 0x60, 0x60,
+	0x00, 0x0C, 0x00, 0x00, 0x0F, 0x00, 0x03, 0xB1,
+	0x88, 0xF4, 0x0F, 0x00, 0x00, 0x00, 0x00, 0x49,
+	0x45, 0x4E, 0x44, 0xAE, 0x42, 0x60, 0x82,
+}
+
+// 1x1 empty SVG
+var svgData = []byte(`<svg xmlns="http://www.w3.org/2000/svg" width="1" height="1"/>`)
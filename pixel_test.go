@@ -0,0 +1,116 @@
+package emailtracker
+
+import (
+	"bytes"
+	"encoding/xml"
+	"image"
+	"image/gif"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestPixelFormats(t *testing.T) {
+	for _, tt := range []struct {
+		format      PixelFormat
+		contentType string
+		decode      func([]byte) (image.Image, error)
+	}{
+		{"", "image/gif", func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }},
+		{PixelGIF, "image/gif", func(b []byte) (image.Image, error) { return gif.Decode(bytes.NewReader(b)) }},
+		{PixelPNG, "image/png", func(b []byte) (image.Image, error) { return png.Decode(bytes.NewReader(b)) }},
+		{PixelSVG, "image/svg+xml", nil},
+	} {
+		tr, log := newTestTracker(t, Config{PixelFormat: tt.format})
+		w := get(tr.Handler(), tr.GenerateLink("msg-1"))
+		body := w.Body.Bytes()
+		if got := w.Header().Get("Content-Type"); got != tt.contentType {
+			t.Errorf("%q: Content-Type %q, want %q", tt.format, got, tt.contentType)
+		}
+		if got := w.Header().Get("Content-Length"); got != strconv.Itoa(len(body)) {
+			t.Errorf("%q: Content-Length %s for %d bytes", tt.format, got, len(body))
+		}
+		if tt.decode == nil {
+			var svg struct {
+				XMLName xml.Name
+				Width   string `xml:"width,attr"`
+				Height  string `xml:"height,attr"`
+			}
+			if err := xml.Unmarshal(body, &svg); err != nil || svg.XMLName.Local != "svg" || svg.Width != "1" || svg.Height != "1" {
+				t.Errorf("%q: %s isn't a 1x1 SVG (%v)", tt.format, body, err)
+			}
+		} else if img, err := tt.decode(body); err != nil {
+			t.Errorf("%q: decode: %v", tt.format, err)
+		} else if b := img.Bounds(); b.Dx() != 1 || b.Dy() != 1 {
+			t.Errorf("%q: image is %dx%d, want 1x1", tt.format, b.Dx(), b.Dy())
+		}
+		if got := len(log.all()); got != 1 {
+			t.Errorf("%q: %d events, want 1", tt.format, got)
+		}
+	}
+}
+
+func TestLinkExtension(t *testing.T) {
+	tr, log := newTestTracker(t, Config{PixelFormat: PixelPNG, LinkExtension: true})
+	link := tr.GenerateLink("msg-1")
+	if !strings.HasPrefix(link, "https://tracker.test/pixel.png?") {
+		t.Fatalf("link %q, want the .png extension", link)
+	}
+	if w := get(tr.Handler(), link); w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	log.wait(t, 1)
+}
+
+func TestUnknownPixelFormat(t *testing.T) {
+	if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", PixelFormat: "bmp"}); err == nil {
+		t.Error("New accepted PixelFormat bmp")
+	}
+	if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", PixelData: []byte("x")}); err == nil {
+		t.Error("New accepted PixelData without PixelContentType")
+	}
+}
+
+func TestConditionalRequests(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	link := tr.GenerateLink("msg-1")
+	first := get(tr.Handler(), link)
+	etag, modified := first.Header().Get("Etag"), first.Header().Get("Last-Modified")
+	if first.Code != http.StatusOK || etag == "" || modified == "" {
+		t.Fatalf("first request: status %d, ETag %q, Last-Modified %q", first.Code, etag, modified)
+	}
+	otherTag := get(tr.Handler(), tr.GenerateLink("msg-2")).Header().Get("Etag")
+	if otherTag == etag {
+		t.Fatal("two IDs share an ETag")
+	}
+
+	for _, tt := range []struct {
+		name        string
+		header      []string
+		status      int
+		revalidated bool
+	}{
+		{"If-None-Match", []string{"If-None-Match", etag}, http.StatusNotModified, true},
+		{"weak and listed", []string{"If-None-Match", `"other", W/` + etag}, http.StatusNotModified, true},
+		{"wildcard", []string{"If-None-Match", "*"}, http.StatusNotModified, true},
+		{"other ID's ETag", []string{"If-None-Match", otherTag}, http.StatusOK, false},
+		{"If-Modified-Since", []string{"If-Modified-Since", modified}, http.StatusNotModified, true},
+		{"older If-Modified-Since", []string{"If-Modified-Since", "Mon, 02 Jan 2006 15:04:05 GMT"}, http.StatusOK, false},
+		{"ETag wins over date", []string{"If-None-Match", otherTag, "If-Modified-Since", modified}, http.StatusOK, false},
+	} {
+		before := len(log.all())
+		w := get(tr.Handler(), link, tt.header...)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status == http.StatusNotModified && w.Body.Len() != 0 {
+			t.Errorf("%s: 304 with a %d byte body", tt.name, w.Body.Len())
+		}
+		events := log.all()[before:]
+		if len(events) != 1 || events[0].Revalidated != tt.revalidated {
+			t.Errorf("%s: events %+v, want one with Revalidated %v", tt.name, events, tt.revalidated)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package emailtracker
+
+import (
+	"net/http"
+	"strings"
+)
+
+// DropPrefetch is the drop reason for prefetches suppressed by DropPrefetch.
+const DropPrefetch DropReason = "prefetch"
+
+// prefetchHeaders announce a speculative fetch: Sec-Purpose from current
+// browsers, Purpose from older ones and X-Moz from Firefox.
+var prefetchHeaders = []string{"Sec-Purpose", "Purpose", "X-Moz"}
+
+// isPrefetch reports whether r is a prefetch rather than a fetch for
+// display. Values are lists, such as "prefetch;prerender", matched
+// case-insensitively.
+func isPrefetch(r *http.Request) bool {
+	for _, name := range prefetchHeaders {
+		for _, v := range r.Header.Values(name) {
+			for _, item := range strings.FieldsFunc(v, func(c rune) bool { return c == ',' || c == ';' }) {
+				if strings.EqualFold(strings.TrimSpace(item), "prefetch") {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,84 @@
+package emailtracker
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPrefetchHeaders(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	for _, c := range []struct {
+		header   []string
+		prefetch bool
+	}{
+		{[]string{"Sec-Purpose", "prefetch"}, true},
+		{[]string{"sec-purpose", "Prefetch"}, true},
+		{[]string{"SEC-PURPOSE", "PREFETCH"}, true},
+		{[]string{"Sec-Purpose", "prefetch;prerender"}, true},
+		{[]string{"Sec-Purpose", "prerender, prefetch"}, true},
+		{[]string{"Purpose", "prefetch"}, true},
+		{[]string{"purpose", " Prefetch "}, true},
+		{[]string{"X-Moz", "prefetch"}, true},
+		{[]string{"x-moz", "PreFetch"}, true},
+		{[]string{"Sec-Purpose", "prerender"}, false},
+		{[]string{"Sec-Purpose", "prefetching"}, false},
+		{[]string{"Sec-Purpose", ""}, false},
+		{[]string{"X-Purpose", "prefetch"}, false},
+		{nil, false},
+	} {
+		before := len(log.all())
+		w := get(tr.Handler(), tr.GenerateLink("msg-1"), c.header...)
+		if w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), gifData) {
+			t.Errorf("%q: status %d; want the pixel", c.header, w.Code)
+		}
+		events := log.all()[before:]
+		if len(events) != 1 || events[0].Prefetch != c.prefetch {
+			t.Errorf("%q: events %+v, want one with Prefetch %v", c.header, events, c.prefetch)
+		}
+	}
+}
+
+func TestPrefetchThenOpen(t *testing.T) {
+	tr, log := newTestTracker(t, Config{DedupWindow: time.Hour, TrackFirstOpen: true})
+	link := tr.GenerateLink("msg-1")
+	get(tr.Handler(), link, "Sec-Purpose", "prefetch")
+	get(tr.Handler(), link)
+	get(tr.Handler(), link) // a repeat within DedupWindow
+
+	events := log.all()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want the prefetch and the open", len(events))
+	}
+	if e := events[0]; !e.Prefetch || e.FirstOpen {
+		t.Errorf("prefetch event: Prefetch %v, FirstOpen %v; want a prefetch that isn't the first open", e.Prefetch, e.FirstOpen)
+	}
+	if e := events[1]; e.Prefetch || !e.FirstOpen {
+		t.Errorf("open after the prefetch: Prefetch %v, FirstOpen %v; want the first open", e.Prefetch, e.FirstOpen)
+	}
+	if n := tr.Metrics().EventsDropped[DropDedup]; n != 1 {
+		t.Errorf("%d deduplicated, want only the repeat", n)
+	}
+}
+
+func TestDropPrefetch(t *testing.T) {
+	tr, log := newTestTracker(t, Config{DropPrefetch: true, TrackFirstOpen: true})
+	link := tr.GenerateLink("msg-1")
+	for _, header := range [][]string{{"Sec-Purpose", "prefetch"}, {"purpose", "PREFETCH"}, {"X-Moz", "prefetch"}} {
+		if w := get(tr.Handler(), link, header...); w.Code != http.StatusOK || !bytes.Equal(w.Body.Bytes(), gifData) {
+			t.Errorf("%q: status %d; want the pixel", header, w.Code)
+		}
+	}
+	if events := log.all(); len(events) != 0 {
+		t.Errorf("dropped prefetches yielded %d events", len(events))
+	}
+	if n := tr.Metrics().EventsDropped[DropPrefetch]; n != 3 {
+		t.Errorf("%d prefetches dropped, want 3", n)
+	}
+
+	get(tr.Handler(), link)
+	if events := log.all(); len(events) != 1 || events[0].Prefetch || !events[0].FirstOpen {
+		t.Errorf("open after the prefetches: %+v, want one first open", events)
+	}
+}
@@ -0,0 +1,191 @@
+// Package prommetrics implements emailtracker.Metrics and exposes the values
+// in the Prometheus text exposition format, without depending on the
+// Prometheus client library.
+//
+//	m := prommetrics.New()
+//	config.Metrics = m
+//	http.Handle("/metrics", m)
+package prommetrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+// DefaultBuckets are the latency histogram bounds, in seconds.
+var DefaultBuckets = []float64{0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Metrics collects tracker metrics. It implements emailtracker.Metrics, its
+// optional BatchMetrics, RetryMetrics, PrefetchMetrics and EnrichMetrics
+// extensions, and http.Handler; serve it on your /metrics route.
+type Metrics struct {
+	buckets []float64
+
+	mu        sync.Mutex
+	requests  map[[2]string]uint64  // route, code
+	events    map[string]uint64     // kind
+	dropped   map[string]uint64     // reason
+	errors    map[string]uint64     // source
+	latencies map[string]*histogram // route
+	enrich    map[string]*histogram // enricher
+	buffered  int
+	retrying  int
+	prefetch  uint64
+}
+
+type histogram struct {
+	counts []uint64 // per bucket, non-cumulative
+	sum    float64
+	count  uint64
+}
+
+// New returns an empty Metrics using DefaultBuckets.
+func New() *Metrics {
+	return &Metrics{
+		buckets:   DefaultBuckets,
+		requests:  make(map[[2]string]uint64),
+		events:    make(map[string]uint64),
+		dropped:   make(map[string]uint64),
+		errors:    make(map[string]uint64),
+		latencies: make(map[string]*histogram),
+		enrich:    make(map[string]*histogram),
+	}
+}
+
+func (m *Metrics) ObserveRequest(route string, status int, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.requests[[2]string{route, strconv.Itoa(status)}]++
+	m.observe(m.latencies, route, d)
+}
+
+func (m *Metrics) ObserveEnrich(name string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.observe(m.enrich, name, d)
+}
+
+// observe adds d to the histogram for label; m.mu must be held.
+func (m *Metrics) observe(hs map[string]*histogram, label string, d time.Duration) {
+	h := hs[label]
+	if h == nil {
+		h = &histogram{counts: make([]uint64, len(m.buckets))}
+		hs[label] = h
+	}
+	secs := d.Seconds()
+	if i, _ := slices.BinarySearch(m.buckets, secs); i < len(m.buckets) {
+		h.counts[i]++
+	}
+	h.sum += secs
+	h.count++
+}
+
+func (m *Metrics) IncEvent(kind emailtracker.EventKind) {
+	m.inc(m.events, string(kind))
+}
+
+func (m *Metrics) IncDropped(reason emailtracker.DropReason) {
+	m.inc(m.dropped, string(reason))
+}
+
+func (m *Metrics) IncError(source string) {
+	m.inc(m.errors, source)
+}
+
+func (m *Metrics) SetBatchBuffered(n int) {
+	m.mu.Lock()
+	m.buffered = n
+	m.mu.Unlock()
+}
+
+func (m *Metrics) SetRetryQueued(n int) {
+	m.mu.Lock()
+	m.retrying = n
+	m.mu.Unlock()
+}
+
+func (m *Metrics) IncPrivacyPrefetch() {
+	m.mu.Lock()
+	m.prefetch++
+	m.mu.Unlock()
+}
+
+func (m *Metrics) inc(c map[string]uint64, label string) {
+	m.mu.Lock()
+	c[label]++
+	m.mu.Unlock()
+}
+
+// ServeHTTP writes the metrics in the Prometheus text format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+// WriteTo writes the metrics in the Prometheus text format.
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var b strings.Builder
+
+	header(&b, "emailtracker_requests_total", "counter", "Requests to tracker routes.")
+	for _, k := range slices.SortedFunc(maps.Keys(m.requests), compareKeys) {
+		fmt.Fprintf(&b, "emailtracker_requests_total{route=%q,code=%q} %d\n", k[0], k[1], m.requests[k])
+	}
+	counter(&b, "emailtracker_events_total", "Events delivered, by kind.", "kind", m.events)
+	counter(&b, "emailtracker_events_dropped_total", "Events suppressed before delivery, by reason.", "reason", m.dropped)
+	counter(&b, "emailtracker_errors_total", "Internal failures, by source.", "source", m.errors)
+	header(&b, "emailtracker_privacy_prefetches_total", "counter", "Opens classified as mail client privacy prefetches.")
+	fmt.Fprintf(&b, "emailtracker_privacy_prefetches_total %d\n", m.prefetch)
+	header(&b, "emailtracker_batch_buffered_events", "gauge", "Events waiting in batch buffers.")
+	fmt.Fprintf(&b, "emailtracker_batch_buffered_events %d\n", m.buffered)
+	header(&b, "emailtracker_retry_queued_events", "gauge", "Deliveries waiting to be retried.")
+	fmt.Fprintf(&b, "emailtracker_retry_queued_events %d\n", m.retrying)
+
+	m.histograms(&b, "emailtracker_request_duration_seconds", "Handler latency.", "route", m.latencies)
+	m.histograms(&b, "emailtracker_enrich_duration_seconds", "Enricher latency.", "enricher", m.enrich)
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+func (m *Metrics) histograms(b *strings.Builder, name, help, label string, hs map[string]*histogram) {
+	header(b, name, "histogram", help)
+	for _, k := range slices.Sorted(maps.Keys(hs)) {
+		h := hs[k]
+		var cum uint64
+		for i, le := range m.buckets {
+			cum += h.counts[i]
+			fmt.Fprintf(b, "%s_bucket{%s=%q,le=%q} %d\n", name, label, k, strconv.FormatFloat(le, 'g', -1, 64), cum)
+		}
+		fmt.Fprintf(b, "%s_bucket{%s=%q,le=\"+Inf\"} %d\n", name, label, k, h.count)
+		fmt.Fprintf(b, "%s_sum{%s=%q} %g\n", name, label, k, h.sum)
+		fmt.Fprintf(b, "%s_count{%s=%q} %d\n", name, label, k, h.count)
+	}
+}
+
+func header(b *strings.Builder, name, typ, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+func counter(b *strings.Builder, name, help, label string, values map[string]uint64) {
+	header(b, name, "counter", help)
+	for _, k := range slices.Sorted(maps.Keys(values)) {
+		fmt.Fprintf(b, "%s{%s=%q} %d\n", name, label, k, values[k])
+	}
+}
+
+func compareKeys(a, b [2]string) int {
+	if c := strings.Compare(a[0], b[0]); c != 0 {
+		return c
+	}
+	return strings.Compare(a[1], b[1])
+}
@@ -0,0 +1,83 @@
+package prommetrics
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+func TestScrape(t *testing.T) {
+	m := New()
+	tr, err := emailtracker.New(emailtracker.Config{
+		Domain:      "tracker.test",
+		Path:        "/pixel",
+		Metrics:     m,
+		DropBots:    true,
+		DedupWindow: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Shutdown(context.Background())
+	tr.Subscribe(func(emailtracker.OpenEvent) { panic("callback failed") })
+	for _, req := range []struct{ id, ua string }{
+		{"msg-1", "Mozilla/5.0"},
+		{"msg-2", "Mozilla/5.0"},
+		{"msg-2", "Mozilla/5.0"}, // deduplicated
+		{"msg-3", "curl/8.4.0"},  // a bot
+	} {
+		r := httptest.NewRequest(http.MethodGet, tr.GenerateLink(req.id), nil)
+		r.Header.Set("User-Agent", req.ua)
+		tr.Handler().ServeHTTP(httptest.NewRecorder(), r)
+	}
+
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type %q", ct)
+	}
+	body := w.Body.String()
+	for _, line := range []string{
+		`emailtracker_requests_total{route="pixel",code="200"} 4`,
+		`emailtracker_events_total{kind="open"} 2`,
+		`emailtracker_events_dropped_total{reason="bot"} 1`,
+		`emailtracker_events_dropped_total{reason="dedup"} 1`,
+		`emailtracker_errors_total{source="callback"} 2`,
+		`emailtracker_request_duration_seconds_count{route="pixel"} 4`,
+		"# TYPE emailtracker_request_duration_seconds histogram",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("scrape is missing %s", line)
+		}
+	}
+	if strings.Contains(body, "msg-") {
+		t.Error("scrape is labeled by tracking ID")
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	m := New()
+	for _, d := range []time.Duration{200 * time.Microsecond, 3 * time.Millisecond, 2 * time.Second} {
+		m.ObserveRequest("pixel", http.StatusOK, d)
+	}
+	var b strings.Builder
+	m.WriteTo(&b)
+	for _, line := range []string{
+		`emailtracker_request_duration_seconds_bucket{route="pixel",le="0.0005"} 1`,
+		`emailtracker_request_duration_seconds_bucket{route="pixel",le="0.0025"} 1`,
+		`emailtracker_request_duration_seconds_bucket{route="pixel",le="0.005"} 2`,
+		`emailtracker_request_duration_seconds_bucket{route="pixel",le="1"} 2`,
+		`emailtracker_request_duration_seconds_bucket{route="pixel",le="+Inf"} 3`,
+		`emailtracker_request_duration_seconds_sum{route="pixel"} 2.0032`,
+		`emailtracker_request_duration_seconds_count{route="pixel"} 3`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("histogram is missing %s", line)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package emailtracker
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+)
+
+// ImageProxy identifies a mail provider's image proxy, which fetches the
+// pixel on a reader's behalf, by a User-Agent regular expression (matched
+// case-insensitively), by the CIDRs it fetches from, or both. Either match
+// is enough.
+type ImageProxy struct {
+	Name    string
+	Pattern string
+	CIDRs   []string
+}
+
+// DefaultImageProxies lists the image proxies of major mail providers.
+var DefaultImageProxies = []ImageProxy{
+	// e.g. "Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)"
+	{Name: "gmail", Pattern: `googleimageproxy`},
+}
+
+type proxyMatcher struct {
+	name     string
+	re       *regexp.Regexp
+	prefixes []netip.Prefix
+}
+
+// compileProxies compiles the configured proxies followed by the defaults.
+func compileProxies(extra []ImageProxy) ([]proxyMatcher, error) {
+	all := append(append([]ImageProxy(nil), extra...), DefaultImageProxies...)
+	out := make([]proxyMatcher, 0, len(all))
+	for _, p := range all {
+		m := proxyMatcher{name: p.Name}
+		if p.Pattern != "" {
+			re, err := regexp.Compile("(?i)" + p.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("emailtracker: ImageProxies %q: %w", p.Name, err)
+			}
+			m.re = re
+		}
+		prefixes, err := parsePrefixes(fmt.Sprintf("ImageProxies %q", p.Name), p.CIDRs)
+		if err != nil {
+			return nil, err
+		}
+		m.prefixes = prefixes
+		out = append(out, m)
+	}
+	return out, nil
+}
+
+// detectProxy returns the name of the first image proxy matching ua or ip,
+// or "".
+func (t *Tracker) detectProxy(ua, ip string) string {
+	addr, addrErr := netip.ParseAddr(ip)
+	for _, p := range t.proxies {
+		if p.re != nil && ua != "" && p.re.MatchString(ua) {
+			return p.name
+		}
+		if addrErr == nil && containsAddr(p.prefixes, addr) {
+			return p.name
+		}
+	}
+	return ""
+}
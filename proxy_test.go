@@ -0,0 +1,75 @@
+package emailtracker
+
+import "testing"
+
+// proxyUAs are User-Agents captured from image fetches, with the proxy the
+// default rules should name.
+var proxyUAs = []struct{ ua, proxy string }{
+	{"Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com GoogleImageProxy)", "gmail"},
+	{"Mozilla/5.0 (Windows NT 5.1; rv:11.0) Gecko Firefox/11.0 (via ggpht.com googleimageproxy)", "gmail"},
+	{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko)", ""},
+	{"Mozilla/4.0 (compatible; MSIE 7.0; Windows NT 10.0; WOW64; Trident/7.0; .NET4.0C; .NET4.0E; Microsoft Outlook 16.0.5244; ms-office; MSOffice 16)", ""},
+	{"Mozilla/5.0 (X11; Linux x86_64; rv:115.0) Gecko/20100101 Thunderbird/115.6.0", ""},
+	{"YahooMailProxy; https://help.yahoo.com/kb/yahoo-mail-proxy-SLN28749.html", ""},
+	{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Mobile Safari/537.36", ""},
+	{"", ""},
+}
+
+func TestDetectImageProxy(t *testing.T) {
+	geo := &ipRecorder{}
+	tr, log := newTestTracker(t, Config{GeoResolver: geo})
+	for _, c := range proxyUAs {
+		before := len(log.all())
+		get(tr.Handler(), tr.GenerateLink("msg-1"), "User-Agent", c.ua)
+		e := log.all()[before]
+		if e.ProxiedBy != c.proxy || e.Proxied != (c.proxy != "") {
+			t.Errorf("%q: Proxied %v, ProxiedBy %q; want %q", c.ua, e.Proxied, e.ProxiedBy, c.proxy)
+		}
+		// The proxy's address says nothing about the reader's location.
+		if located := e.Geo.Country != ""; located == e.Proxied {
+			t.Errorf("%q: Geo %+v for Proxied %v", c.ua, e.Geo, e.Proxied)
+		}
+	}
+	if want := len(proxyUAs) - 2; len(geo.ips) != want {
+		t.Errorf("%d geo lookups, want %d, none for proxied opens", len(geo.ips), want)
+	}
+}
+
+func TestImageProxiesConfig(t *testing.T) {
+	tr, log := newTestTracker(t, Config{ImageProxies: []ImageProxy{
+		{Name: "yahoo", Pattern: `YahooMailProxy`},
+		{Name: "relay", CIDRs: []string{"198.51.100.0/24", "2001:db8:aa::/48"}},
+		{Name: "google", Pattern: `GoogleImageProxy`}, // shadows the default
+	}})
+	for _, c := range []struct {
+		ua, ip, proxy string
+	}{
+		{ua: "YahooMailProxy; https://help.yahoo.com/kb/yahoo-mail-proxy-SLN28749.html", proxy: "yahoo"},
+		{ua: "Mozilla/5.0", ip: "198.51.100.42", proxy: "relay"},
+		{ua: "Mozilla/5.0", ip: "2001:db8:aa::1", proxy: "relay"},
+		{ua: "Mozilla/5.0", ip: "198.51.101.1"},
+		{ua: "Mozilla/5.0", ip: "not an IP"},
+		{ua: proxyUAs[0].ua, proxy: "google"},
+	} {
+		header := []string{"User-Agent", c.ua}
+		if c.ip != "" {
+			header = append(header, "X-Forwarded-For", c.ip)
+		}
+		before := len(log.all())
+		get(tr.Handler(), tr.GenerateLink("msg-1"), header...)
+		if e := log.all()[before]; e.ProxiedBy != c.proxy {
+			t.Errorf("%q from %q: ProxiedBy %q, want %q", c.ua, c.ip, e.ProxiedBy, c.proxy)
+		}
+	}
+}
+
+func TestInvalidImageProxies(t *testing.T) {
+	for name, p := range map[string]ImageProxy{
+		"pattern": {Name: "bad", Pattern: `(`},
+		"CIDR":    {Name: "bad", CIDRs: []string{"198.51.100.0/33"}},
+	} {
+		if _, err := New(Config{Domain: "tracker.test", Path: "/pixel", ImageProxies: []ImageProxy{p}}); err == nil {
+			t.Errorf("%s: New accepted %+v", name, p)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package emailtracker
+
+import (
+	"hash/maphash"
+	"sync"
+	"time"
+)
+
+// RateLimit configures a token-bucket limiter on the pixel endpoint. Requests
+// over the limit still get the pixel, but produce no event.
+type RateLimit struct {
+	Rate  float64 // sustained events per second per key
+	Burst int     // bucket size; defaults to 1
+
+	// Key derives the bucket key from the tracking ID and resolved client
+	// IP, anonymized when AnonymizeIP is set. The default limits by IP; use
+	// RateLimitByID to limit by message.
+	Key func(id, ip string) string
+
+	// MaxKeys bounds the number of tracked buckets (default 100000). Idle
+	// buckets are expired automatically.
+	MaxKeys int
+}
+
+// DropRateLimited is the drop reason for requests over the rate limit.
+const DropRateLimited DropReason = "rate_limited"
+
+// RateLimitByID is a RateLimit.Key that limits per tracking ID.
+func RateLimitByID(id, ip string) string { return id }
+
+// limiter holds one token bucket per key. Keys are hashed, so no raw IPs are
+// retained.
+type limiter struct {
+	rate  float64
+	burst float64
+	key   func(id, ip string) string
+	max   int
+	seed  maphash.Seed
+	idle  time.Duration // time for an empty bucket to refill
+
+	mu        sync.Mutex
+	buckets   map[uint64]*bucket
+	nextSweep time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(cfg RateLimit) *limiter {
+	l := &limiter{
+		rate:    cfg.Rate,
+		burst:   float64(max(cfg.Burst, 1)),
+		key:     cfg.Key,
+		max:     cfg.MaxKeys,
+		seed:    maphash.MakeSeed(),
+		buckets: make(map[uint64]*bucket),
+	}
+	if l.key == nil {
+		l.key = func(id, ip string) string { return ip }
+	}
+	if l.max <= 0 {
+		l.max = defaultMaxKeys
+	}
+	l.idle = time.Duration(l.burst / l.rate * float64(time.Second))
+	return l
+}
+
+// allow takes a token for the request's key, reporting whether one was free.
+func (l *limiter) allow(id, ip string, now time.Time) bool {
+	k := maphash.String(l.seed, l.key(id, ip))
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.buckets[k]
+	if b == nil {
+		l.sweep(now)
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[k] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep drops buckets that have refilled completely, since they behave like
+// new ones. It runs at most once per refill period unless the map is full.
+func (l *limiter) sweep(now time.Time) {
+	full := len(l.buckets) >= l.max
+	if !full && now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(l.idle)
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= l.idle {
+			delete(l.buckets, k)
+		}
+	}
+	for k := range l.buckets {
+		if len(l.buckets) < l.max {
+			break
+		}
+		delete(l.buckets, k)
+	}
+}
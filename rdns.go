@@ -0,0 +1,104 @@
+package emailtracker
+
+import (
+	"container/list"
+	"context"
+	"hash/maphash"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReverseDNSTimeout   = 500 * time.Millisecond
+	defaultReverseDNSCacheSize = 10_000
+)
+
+// reverseDNS resolves IPs to hostnames, remembering the most recently used
+// answers, failures included, so repeated opens don't re-resolve. Cached
+// IPs are hashed, so no raw addresses are retained.
+type reverseDNS struct {
+	resolver *net.Resolver
+	timeout  time.Duration
+	size     int
+	seed     maphash.Seed
+
+	mu    sync.Mutex
+	order *list.List               // most recently used first
+	cache map[uint64]*list.Element // ip hash -> element holding an rdnsEntry
+}
+
+type rdnsEntry struct {
+	key  uint64
+	host string
+}
+
+func newReverseDNS(resolver *net.Resolver, timeout time.Duration, size int) *reverseDNS {
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	if timeout <= 0 {
+		timeout = defaultReverseDNSTimeout
+	}
+	if size <= 0 {
+		size = defaultReverseDNSCacheSize
+	}
+	return &reverseDNS{
+		resolver: resolver,
+		timeout:  timeout,
+		size:     size,
+		seed:     maphash.MakeSeed(),
+		order:    list.New(),
+		cache:    make(map[uint64]*list.Element),
+	}
+}
+
+// lookup returns the first PTR name for ip without its trailing dot, or ""
+// if there is none or the lookup fails.
+func (d *reverseDNS) lookup(ctx context.Context, ip string) string {
+	key := maphash.String(d.seed, ip)
+	d.mu.Lock()
+	if el, ok := d.cache[key]; ok {
+		d.order.MoveToFront(el)
+		d.mu.Unlock()
+		return el.Value.(rdnsEntry).host
+	}
+	d.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeout)
+	defer cancel()
+	var host string
+	names, err := d.resolver.LookupAddr(ctx, ip)
+	if err == nil && len(names) > 0 {
+		host = strings.TrimSuffix(names[0], ".")
+	}
+	if ctx.Err() != nil && host == "" {
+		// A timeout or shutdown says nothing about the address, so don't
+		// remember it.
+		return ""
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if el, ok := d.cache[key]; ok {
+		d.order.MoveToFront(el)
+		return el.Value.(rdnsEntry).host
+	}
+	d.cache[key] = d.order.PushFront(rdnsEntry{key: key, host: host})
+	if d.order.Len() > d.size {
+		el := d.order.Back()
+		d.order.Remove(el)
+		delete(d.cache, el.Value.(rdnsEntry).key)
+	}
+	return host
+}
+
+// resolveHostname fills e.Hostname when ReverseDNS is enabled.
+func (t *Tracker) resolveHostname(ctx context.Context, e *OpenEvent) error {
+	if t.rdns == nil || e.IP == "" {
+		return nil
+	}
+	e.Hostname = t.rdns.lookup(ctx, e.IP)
+	return nil
+}
@@ -0,0 +1,154 @@
+// Package redisstore provides an emailtracker.Store and emailtracker.SeenSet
+// backed by Redis, for trackers running as several replicas.
+//
+// It speaks the Redis protocol directly, so it needs no client library.
+// Every key starts with Options.Prefix:
+//
+//	{prefix}events          list of every event, as JSON, oldest first
+//	{prefix}events:{id}     list of the events for one tracking ID
+//	{prefix}seen:{key}      SeenSet entries, expiring with their TTL
+package redisstore
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+// Options configures a Store.
+type Options struct {
+	Addr     string // host:port, default "localhost:6379"
+	Username string // for Redis 6 ACLs; leave empty for AUTH with Password only
+	Password string
+	DB       int
+
+	// Prefix namespaces every key, default "emailtracker:".
+	Prefix string
+
+	// DialTimeout and IOTimeout bound connecting and each round trip,
+	// default 2s and 1s. Keep them short: the tracker reports failures and
+	// carries on rather than wait for Redis.
+	DialTimeout time.Duration
+	IOTimeout   time.Duration
+
+	// PoolSize is how many idle connections are kept, default 8.
+	PoolSize int
+}
+
+// eachBatch is how many events Each fetches per round trip.
+const eachBatch = 500
+
+// Store implements emailtracker.Store and emailtracker.SeenSet on Redis.
+type Store struct {
+	pool   *pool
+	prefix string
+}
+
+// New returns a Store for the server in opts, checking that it answers.
+func New(opts Options) (*Store, error) {
+	if opts.Addr == "" {
+		opts.Addr = "localhost:6379"
+	}
+	if opts.Prefix == "" {
+		opts.Prefix = "emailtracker:"
+	}
+	if opts.DialTimeout <= 0 {
+		opts.DialTimeout = 2 * time.Second
+	}
+	if opts.IOTimeout <= 0 {
+		opts.IOTimeout = time.Second
+	}
+	if opts.PoolSize <= 0 {
+		opts.PoolSize = 8
+	}
+	s := &Store{pool: newPool(opts), prefix: opts.Prefix}
+	if _, err := s.pool.do([]string{"PING"}); err != nil {
+		s.pool.close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Save implements emailtracker.Store. The event is appended to the global
+// and per-ID lists in a single round trip.
+func (s *Store) Save(e emailtracker.OpenEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.pool.do(
+		[]string{"RPUSH", s.prefix + "events", string(data)},
+		[]string{"RPUSH", s.prefix + "events:" + e.ID, string(data)},
+	)
+	return err
+}
+
+// ByID implements emailtracker.Store.
+func (s *Store) ByID(id string) ([]emailtracker.OpenEvent, error) {
+	return s.lrange(s.prefix+"events:"+id, 0, -1)
+}
+
+// Each implements emailtracker.Store, fetching events in batches.
+func (s *Store) Each(fn func(emailtracker.OpenEvent) bool) error {
+	for start := 0; ; start += eachBatch {
+		events, err := s.lrange(s.prefix+"events", start, start+eachBatch-1)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if !fn(e) {
+				return nil
+			}
+		}
+		if len(events) < eachBatch {
+			return nil
+		}
+	}
+}
+
+// Add implements emailtracker.SeenSet with SET NX, so concurrent replicas
+// agree on which of them saw a key first.
+func (s *Store) Add(key string, ttl time.Duration) (bool, error) {
+	cmd := []string{"SET", s.prefix + "seen:" + key, "1", "NX"}
+	if ttl > 0 {
+		cmd = append(cmd, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	replies, err := s.pool.do(cmd)
+	if err != nil {
+		return false, err
+	}
+	return replies[0] == nil, nil // nil: the key already existed
+}
+
+// Close closes the idle connections.
+func (s *Store) Close() error {
+	s.pool.close()
+	return nil
+}
+
+func (s *Store) lrange(key string, start, stop int) ([]emailtracker.OpenEvent, error) {
+	replies, err := s.pool.do([]string{"LRANGE", key, strconv.Itoa(start), strconv.Itoa(stop)})
+	if err != nil {
+		return nil, err
+	}
+	items, ok := replies[0].([]any)
+	if !ok {
+		return nil, errors.New("redisstore: LRANGE: unexpected reply")
+	}
+	events := make([]emailtracker.OpenEvent, 0, len(items))
+	for _, item := range items {
+		data, ok := item.(string)
+		if !ok {
+			return nil, errors.New("redisstore: LRANGE: unexpected element")
+		}
+		var e emailtracker.OpenEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, nil
+}
@@ -0,0 +1,234 @@
+package redisstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+var t0 = time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC)
+
+// open starts a miniredis server and a store on it, closing both when the
+// test ends.
+func open(t *testing.T, opts Options) (*Store, *miniredis.Miniredis) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	opts.Addr = mr.Addr()
+	s, err := New(opts)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s, mr
+}
+
+func ids(events []emailtracker.OpenEvent) []string {
+	var out []string
+	for _, e := range events {
+		out = append(out, e.EventID)
+	}
+	return out
+}
+
+// newTracker returns a tracker on store, shut down when the test ends.
+func newTracker(t *testing.T, cfg emailtracker.Config) *emailtracker.Tracker {
+	t.Helper()
+	cfg.Domain, cfg.Path = "tracker.test", "/pixel"
+	tr, err := emailtracker.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tr.Shutdown(context.Background()) })
+	return tr
+}
+
+func hit(tr *emailtracker.Tracker, id string) int {
+	w := httptest.NewRecorder()
+	tr.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, tr.GenerateLink(id), nil))
+	return w.Code
+}
+
+func TestSaveAndQuery(t *testing.T) {
+	s, mr := open(t, Options{Prefix: "test:"})
+	for _, e := range []emailtracker.OpenEvent{
+		{EventID: "e1", ID: "msg-1", Kind: emailtracker.EventOpen, Time: t0, IP: "203.0.113.7"},
+		{EventID: "e2", ID: "msg-2", Kind: emailtracker.EventOpen, Time: t0.Add(time.Minute)},
+		{EventID: "e3", ID: "msg-1", Kind: emailtracker.EventClick, Time: t0.Add(2 * time.Minute), Metadata: map[string]string{"campaign": "spring"}},
+	} {
+		if err := s.Save(e); err != nil {
+			t.Fatalf("Save %s: %v", e.EventID, err)
+		}
+	}
+
+	got, err := s.ByID("msg-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e1", "e3"}; !slices.Equal(ids(got), want) {
+		t.Fatalf("ByID = %v, want %v", ids(got), want)
+	}
+	// Events are stored as JSON, which keeps milliseconds.
+	if !got[0].Time.Equal(t0.Truncate(time.Millisecond)) || got[0].IP != "203.0.113.7" {
+		t.Errorf("event e1 came back with Time %v, IP %q", got[0].Time, got[0].IP)
+	}
+	if got[1].Kind != emailtracker.EventClick || got[1].Metadata["campaign"] != "spring" {
+		t.Errorf("event e3 came back with Kind %q, Metadata %v", got[1].Kind, got[1].Metadata)
+	}
+	if got, err := s.ByID("unknown"); err != nil || len(got) != 0 {
+		t.Errorf("ByID(unknown) = %v, %v", got, err)
+	}
+
+	keys := mr.Keys()
+	if want := []string{"test:events", "test:events:msg-1", "test:events:msg-2"}; !slices.Equal(keys, want) {
+		t.Errorf("keys %v, want %v", keys, want)
+	}
+}
+
+func TestEachBatches(t *testing.T) {
+	s, _ := open(t, Options{})
+	const n = 2*eachBatch + 7
+	for i := range n {
+		if err := s.Save(emailtracker.OpenEvent{EventID: fmt.Sprint(i), ID: "msg-1", Time: t0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	if err := s.Each(func(e emailtracker.OpenEvent) bool { got = append(got, e.EventID); return true }); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != n || got[0] != "0" || got[n-1] != fmt.Sprint(n-1) {
+		t.Fatalf("Each yielded %d events, %v…%v", len(got), got[:1], got[len(got)-1:])
+	}
+	for i, id := range got {
+		if id != fmt.Sprint(i) {
+			t.Fatalf("event %d is %s, want insertion order", i, id)
+		}
+	}
+
+	var stopped int
+	s.Each(func(emailtracker.OpenEvent) bool { stopped++; return stopped < eachBatch+1 })
+	if stopped != eachBatch+1 {
+		t.Errorf("Each ran fn %d times after it returned false", stopped)
+	}
+}
+
+func TestSeenSetTTL(t *testing.T) {
+	s, mr := open(t, Options{Prefix: "test:"})
+	for i, want := range []bool{false, true} {
+		if seen, err := s.Add("dedup:a", time.Hour); err != nil || seen != want {
+			t.Errorf("Add %d = %v, %v; want %v", i, seen, err, want)
+		}
+	}
+	if ttl := mr.TTL("test:seen:dedup:a"); ttl != time.Hour {
+		t.Errorf("TTL %v, want 1h", ttl)
+	}
+	s.Add("dedup:b", time.Nanosecond) // rounded up to 1ms
+	s.Add("first:msg-1", 0)
+	if ttl := mr.TTL("test:seen:first:msg-1"); ttl != 0 {
+		t.Errorf("key without TTL expires in %v", ttl)
+	}
+	mr.FastForward(time.Hour)
+	for key, want := range map[string]bool{"dedup:a": false, "dedup:b": false, "first:msg-1": true} {
+		if seen, err := s.Add(key, time.Hour); err != nil || seen != want {
+			t.Errorf("after an hour Add(%s) = %v, %v; want %v", key, seen, err, want)
+		}
+	}
+}
+
+func TestAuthAndDB(t *testing.T) {
+	mr := miniredis.RunT(t)
+	mr.RequireUserAuth("tracker", "s3cret")
+	if _, err := New(Options{Addr: mr.Addr(), Username: "tracker", Password: "wrong"}); err == nil {
+		t.Error("New succeeded with a wrong password")
+	}
+	s, err := New(Options{Addr: mr.Addr(), Username: "tracker", Password: "s3cret", DB: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := s.Save(emailtracker.OpenEvent{ID: "msg-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if keys := mr.DB(3).Keys(); len(keys) != 2 {
+		t.Errorf("DB 3 holds %v", keys)
+	}
+	if keys := mr.DB(0).Keys(); len(keys) != 0 {
+		t.Errorf("DB 0 holds %v", keys)
+	}
+}
+
+func TestUnreachable(t *testing.T) {
+	if _, err := New(Options{Addr: "127.0.0.1:1", DialTimeout: 100 * time.Millisecond}); err == nil {
+		t.Error("New succeeded without a server")
+	}
+
+	s, mr := open(t, Options{DialTimeout: 100 * time.Millisecond, IOTimeout: 100 * time.Millisecond})
+	tr := newTracker(t, emailtracker.Config{Store: s, TrackFirstOpen: true})
+	var (
+		mu   sync.Mutex
+		errs []error
+	)
+	tr.OnError(func(err error, _ *emailtracker.OpenEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		errs = append(errs, err)
+	})
+	var events []emailtracker.OpenEvent
+	tr.Subscribe(func(e emailtracker.OpenEvent) { events = append(events, e) })
+
+	mr.Close()
+	if code := hit(tr, "msg-1"); code != http.StatusOK {
+		t.Errorf("status %d with Redis down", code)
+	}
+	mu.Lock()
+	if len(errs) == 0 || !slices.ContainsFunc(errs, func(err error) bool { return errors.Is(err, emailtracker.ErrStoreWrite) }) {
+		t.Errorf("errors reported %v, want ErrStoreWrite", errs)
+	}
+	mu.Unlock()
+	if len(events) != 1 {
+		t.Errorf("%d events with Redis down, want the open delivered anyway", len(events))
+	}
+
+	if err := mr.Restart(); err != nil {
+		t.Fatal(err)
+	}
+	hit(tr, "msg-2")
+	if got, err := s.ByID("msg-2"); err != nil || len(got) != 1 {
+		t.Errorf("after Redis came back ByID = %v, %v", got, err)
+	}
+}
+
+func TestReplicasShareState(t *testing.T) {
+	mr := miniredis.RunT(t)
+	var events [2][]emailtracker.OpenEvent
+	var replicas [2]*emailtracker.Tracker
+	for i := range replicas {
+		s, err := New(Options{Addr: mr.Addr()})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { s.Close() })
+		replicas[i] = newTracker(t, emailtracker.Config{Store: s, TrackFirstOpen: true, DedupWindow: time.Minute})
+		replicas[i].Subscribe(func(e emailtracker.OpenEvent) { events[i] = append(events[i], e) })
+	}
+
+	hit(replicas[0], "msg-1")
+	hit(replicas[1], "msg-1") // a duplicate within the window
+	if len(events[0]) != 1 || !events[0][0].FirstOpen || len(events[1]) != 0 {
+		t.Fatalf("replica events %+v, want one first open on the first", events)
+	}
+	mr.FastForward(2 * time.Minute)
+	hit(replicas[1], "msg-1")
+	if len(events[1]) != 1 || events[1][0].FirstOpen {
+		t.Errorf("second replica events %+v, want one repeat open", events[1])
+	}
+}
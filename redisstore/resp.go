@@ -0,0 +1,186 @@
+package redisstore
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+// redisError is an error reply from the server, e.g. "WRONGTYPE ...".
+type redisError string
+
+func (e redisError) Error() string { return "redisstore: " + string(e) }
+
+// conn is a single RESP connection.
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+// pool hands out connections to one server, dialing on demand and keeping
+// up to cap(idle) of them around. A connection that fails is closed rather
+// than returned, so the next command redials.
+type pool struct {
+	opts Options
+	idle chan *conn
+}
+
+func newPool(opts Options) *pool {
+	return &pool{opts: opts, idle: make(chan *conn, opts.PoolSize)}
+}
+
+func (p *pool) get() (*conn, error) {
+	select {
+	case c := <-p.idle:
+		return c, nil
+	default:
+	}
+	nc, err := net.DialTimeout("tcp", p.opts.Addr, p.opts.DialTimeout)
+	if err != nil {
+		return nil, fmt.Errorf("redisstore: dial: %w", err)
+	}
+	c := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	var setup [][]string
+	if p.opts.Password != "" {
+		if p.opts.Username != "" {
+			setup = append(setup, []string{"AUTH", p.opts.Username, p.opts.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", p.opts.Password})
+		}
+	}
+	if p.opts.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(p.opts.DB)})
+	}
+	if len(setup) > 0 {
+		if _, err := c.pipeline(p.opts.IOTimeout, setup); err != nil {
+			nc.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (p *pool) put(c *conn) {
+	select {
+	case p.idle <- c:
+	default:
+		c.nc.Close()
+	}
+}
+
+// do sends cmds in one round trip and returns their replies in order. An
+// error reply to any of them is returned as the error once all replies are
+// read.
+func (p *pool) do(cmds ...[]string) ([]any, error) {
+	c, err := p.get()
+	if err != nil {
+		return nil, err
+	}
+	replies, err := c.pipeline(p.opts.IOTimeout, cmds)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		c.nc.Close()
+		return nil, err
+	}
+	p.put(c)
+	return replies, err
+}
+
+func (p *pool) close() {
+	for {
+		select {
+		case c := <-p.idle:
+			c.nc.Close()
+		default:
+			return
+		}
+	}
+}
+
+func (c *conn) pipeline(timeout time.Duration, cmds [][]string) ([]any, error) {
+	if timeout > 0 {
+		c.nc.SetDeadline(time.Now().Add(timeout))
+	}
+	for _, args := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(args))
+		for _, a := range args {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(a), a)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, fmt.Errorf("redisstore: write: %w", err)
+	}
+	replies := make([]any, len(cmds))
+	var first error
+	for i := range cmds {
+		v, err := c.readReply()
+		if rerr, ok := err.(redisError); ok {
+			if first == nil {
+				first = rerr
+			}
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("redisstore: read: %w", err)
+		}
+		replies[i] = v
+	}
+	return replies, first
+}
+
+// readReply reads one RESP2 value: a string, an int64, nil, or a []any. An
+// error reply is returned as a redisError.
+func (c *conn) readReply() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("malformed reply")
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]any, n)
+		for i := range items {
+			// An error nested in an array belongs to that element, as
+			// with EXEC; keep reading so the connection stays in sync.
+			v, err := c.readReply()
+			if rerr, ok := err.(redisError); ok {
+				items[i] = rerr
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			items[i] = v
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("unexpected reply type %q", kind)
+}
@@ -0,0 +1,31 @@
+package emailtracker
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// nonceParam carries a link's per-send nonce.
+const nonceParam = "nonce"
+
+// WithNonce adds a random nonce to the link, so that with ReplayWindow set
+// the tracker can tell a replayed request apart from a genuine re-open.
+// Generate a fresh link per send.
+func WithNonce() LinkOption {
+	return func(o *linkOptions) { o.nonce = newNonce() }
+}
+
+func newNonce() string {
+	var b [9]byte
+	rand.Read(b[:])
+	return base64.RawURLEncoding.EncodeToString(b[:])
+}
+
+// replay reports whether e's nonce was already seen from the same IP within
+// ReplayWindow, recording it otherwise.
+func (t *Tracker) replay(e *OpenEvent) bool {
+	if t.nonces == nil || e.Nonce == "" {
+		return false
+	}
+	return t.seenBefore(t.nonces, seenNonce, e.Nonce+"\x00"+t.anonymizeIP(e.IP), e)
+}
@@ -0,0 +1,254 @@
+package emailtracker
+
+import (
+	"cmp"
+	"context"
+	"hash/maphash"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	defaultReportInterval = time.Hour
+
+	// reportShards spreads the aggregation over independently locked
+	// shards, keyed by tracking ID, so concurrent events rarely contend.
+	reportShards = 16
+
+	// reportTopUserAgents is the length of Report.TopUserAgents.
+	reportTopUserAgents = 10
+
+	// reportMaxUserAgents bounds the distinct User-Agents counted per shard
+	// and window; later newcomers are left out of TopUserAgents.
+	reportMaxUserAgents = 1000
+)
+
+// Report summarizes the opens of one OnReport window.
+type Report struct {
+	Start, End    time.Time
+	Opens         int
+	UniqueIDs     int
+	UniqueIPs     int
+	TopUserAgents []UserAgentCount // most frequent first, at most 10
+	Campaigns     map[string]int   // opens per CampaignID, for IDs built by CampaignID
+
+	// Sends counts the sends registered by RegisterSend in the window, and
+	// CampaignSends those per SendMeta.Campaign.
+	Sends         int
+	CampaignSends map[string]int
+}
+
+// OpenRate returns UniqueIDs as a fraction of Sends, or 0 without sends.
+// Both count the same window, so with opens lagging sends the rate is only
+// meaningful over windows much longer than the usual time to open.
+func (r Report) OpenRate() float64 {
+	if r.Sends == 0 {
+		return 0
+	}
+	return float64(r.UniqueIDs) / float64(r.Sends)
+}
+
+// UserAgentCount is the number of opens from one User-Agent.
+type UserAgentCount struct {
+	UserAgent string
+	Opens     int
+}
+
+// reporter aggregates opens for an OnReport callback and delivers a Report
+// every interval from its own goroutine.
+type reporter struct {
+	t        *Tracker
+	interval time.Duration
+	fn       func(Report)
+	seed     maphash.Seed
+
+	shards [reportShards]reportShard
+	start  time.Time // touched only by run
+
+	stopMu sync.Mutex
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+type reportShard struct {
+	mu        sync.Mutex
+	opens     int
+	ids       map[string]struct{}
+	ips       map[string]struct{}
+	uas       map[string]int
+	campaigns map[string]int
+	sends     int
+	csends    map[string]int // sends per campaign
+}
+
+// OnReport registers fn to receive a Report every interval (default one
+// hour) summarizing the opens of that window, instead of every event. fn
+// runs on a dedicated goroutine. Shutdown delivers the last, partial window.
+func (t *Tracker) OnReport(interval time.Duration, fn func(Report)) {
+	if fn == nil {
+		return
+	}
+	if interval <= 0 {
+		interval = defaultReportInterval
+	}
+	r := &reporter{
+		t:        t,
+		interval: interval,
+		fn:       fn,
+		seed:     maphash.MakeSeed(),
+		start:    t.now(),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	t.batchMu.Lock()
+	t.reporters = append(t.reporters, r)
+	t.batchMu.Unlock()
+	go r.run()
+	t.SubscribeContext(func(_ context.Context, e OpenEvent) error {
+		r.add(&e)
+		return nil
+	})
+}
+
+func (r *reporter) add(e *OpenEvent) {
+	if e.Kind != EventOpen && e.Kind != "" {
+		return
+	}
+	s := &r.shards[maphash.String(r.seed, e.ID)%reportShards]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.ids == nil {
+		s.ids = make(map[string]struct{})
+		s.ips = make(map[string]struct{})
+		s.uas = make(map[string]int)
+		s.campaigns = make(map[string]int)
+	}
+	s.opens++
+	s.ids[e.ID] = struct{}{}
+	if e.IP != "" {
+		s.ips[e.IP] = struct{}{}
+	}
+	if _, ok := s.uas[e.UserAgent]; ok || len(s.uas) < reportMaxUserAgents {
+		s.uas[e.UserAgent]++
+	}
+	if e.CampaignID != "" {
+		s.campaigns[e.CampaignID]++
+	}
+}
+
+// addSend counts a newly registered send.
+func (r *reporter) addSend(id, campaign string) {
+	s := &r.shards[maphash.String(r.seed, id)%reportShards]
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sends++
+	if campaign != "" {
+		if s.csends == nil {
+			s.csends = make(map[string]int)
+		}
+		s.csends[campaign]++
+	}
+}
+
+func (r *reporter) run() {
+	defer close(r.done)
+	tick, stop := r.t.newTicker(r.interval)
+	defer stop()
+	for {
+		select {
+		case <-tick:
+			r.report()
+		case <-r.stop:
+			r.report()
+			return
+		}
+	}
+}
+
+// report takes the window's counts, resetting every shard, and delivers
+// them.
+func (r *reporter) report() {
+	end := r.t.now()
+	rep := Report{Start: r.start, End: end, Campaigns: make(map[string]int), CampaignSends: make(map[string]int)}
+	r.start = end
+	ips := make(map[string]struct{})
+	uas := make(map[string]int)
+	for i := range r.shards {
+		s := &r.shards[i]
+		s.mu.Lock()
+		opens, ids, sips, suas, campaigns := s.opens, len(s.ids), s.ips, s.uas, s.campaigns
+		sends, csends := s.sends, s.csends
+		s.opens, s.ids, s.ips, s.uas, s.campaigns = 0, nil, nil, nil, nil
+		s.sends, s.csends = 0, nil
+		s.mu.Unlock()
+		rep.Opens += opens
+		rep.Sends += sends
+		for c, n := range csends {
+			rep.CampaignSends[c] += n
+		}
+		rep.UniqueIDs += ids // IDs never span shards
+		for ip := range sips {
+			ips[ip] = struct{}{}
+		}
+		for ua, n := range suas {
+			uas[ua] += n
+		}
+		for c, n := range campaigns {
+			rep.Campaigns[c] += n
+		}
+	}
+	rep.UniqueIPs = len(ips)
+	for ua, n := range uas {
+		rep.TopUserAgents = append(rep.TopUserAgents, UserAgentCount{ua, n})
+	}
+	slices.SortFunc(rep.TopUserAgents, func(a, b UserAgentCount) int {
+		if c := cmp.Compare(b.Opens, a.Opens); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.UserAgent, b.UserAgent)
+	})
+	if len(rep.TopUserAgents) > reportTopUserAgents {
+		rep.TopUserAgents = rep.TopUserAgents[:reportTopUserAgents]
+	}
+	r.deliver(rep)
+}
+
+func (r *reporter) deliver(rep Report) {
+	defer func() {
+		if p := recover(); p != nil {
+			r.t.reportError("callback", &PanicError{Value: p, Stack: debug.Stack()}, nil)
+		}
+	}()
+	r.fn(rep)
+}
+
+// close delivers the last window and stops the report goroutine.
+func (r *reporter) close(ctx context.Context) error {
+	r.stopMu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.stop)
+	}
+	r.stopMu.Unlock()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracker) closeReporters(ctx context.Context) error {
+	t.batchMu.Lock()
+	reporters := t.reporters
+	t.batchMu.Unlock()
+	for _, r := range reporters {
+		if err := r.close(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,222 @@
+package emailtracker
+
+import (
+	"container/heap"
+	"context"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// RetryConfig enables retrying failed deliveries. When a subscriber returns
+// an error, a sink fails to publish or the store fails to save, that one
+// delivery is retried on its own with exponential backoff and jitter; the
+// other targets are not repeated.
+type RetryConfig struct {
+	MaxAttempts    int           // attempts per delivery including the first, default 5
+	InitialBackoff time.Duration // wait before the first retry, default 1s
+	MaxBackoff     time.Duration // cap on the doubling backoff, default 1m
+	QueueSize      int           // deliveries waiting to be retried, default 1024
+
+	// DeadLetter receives the events whose deliveries ran out of attempts,
+	// didn't fit in the queue, or were still queued when Shutdown gave up.
+	// It is called from the retry goroutine, or from Shutdown.
+	DeadLetter func([]OpenEvent)
+}
+
+// RetryMetrics is an optional extension of Metrics. If the configured
+// Metrics implements it, the tracker reports how many deliveries are waiting
+// to be retried.
+type RetryMetrics interface {
+	SetRetryQueued(n int)
+}
+
+// retryItem is one failed delivery.
+type retryItem struct {
+	event   OpenEvent
+	op      func(ctx context.Context) error
+	attempt int // attempts made so far
+	due     time.Time
+}
+
+type retryHeap []*retryItem
+
+func (h retryHeap) Len() int           { return len(h) }
+func (h retryHeap) Less(i, j int) bool { return h[i].due.Before(h[j].due) }
+func (h retryHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+func (h *retryHeap) Push(x any)        { *h = append(*h, x.(*retryItem)) }
+func (h *retryHeap) Pop() any {
+	old := *h
+	it := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return it
+}
+
+// retrier runs failed deliveries again once their backoff has passed.
+type retrier struct {
+	cfg     RetryConfig
+	ctx     context.Context
+	setSize func(n int)
+
+	mu     sync.Mutex
+	items  retryHeap
+	closed bool
+	wake   chan struct{}
+	done   chan struct{}
+}
+
+func newRetrier(cfg RetryConfig, ctx context.Context, setSize func(int)) *retrier {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = time.Second
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = time.Minute
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	r := &retrier{
+		cfg:     cfg,
+		ctx:     ctx,
+		setSize: setSize,
+		wake:    make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// backoff returns the wait before attempt n+1, with up to 50% jitter.
+func (r *retrier) backoff(n int) time.Duration {
+	d := r.cfg.InitialBackoff
+	for i := 1; i < n && d < r.cfg.MaxBackoff; i++ {
+		d *= 2
+	}
+	d = min(d, r.cfg.MaxBackoff)
+	return d/2 + rand.N(d/2+1)
+}
+
+// retry schedules op, which has just failed for e for the first time.
+func (r *retrier) retry(e OpenEvent, op func(context.Context) error) {
+	r.schedule(&retryItem{event: cloneEvent(e), op: op, attempt: 1})
+}
+
+func (r *retrier) schedule(it *retryItem) {
+	if it.attempt >= r.cfg.MaxAttempts {
+		r.deadLetter([]OpenEvent{it.event})
+		return
+	}
+	it.due = time.Now().Add(r.backoff(it.attempt))
+	r.mu.Lock()
+	if r.closed || len(r.items) >= r.cfg.QueueSize {
+		r.mu.Unlock()
+		r.deadLetter([]OpenEvent{it.event})
+		return
+	}
+	heap.Push(&r.items, it)
+	n := len(r.items)
+	r.mu.Unlock()
+	r.setSize(n)
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (r *retrier) deadLetter(events []OpenEvent) {
+	if r.cfg.DeadLetter != nil && len(events) > 0 {
+		r.cfg.DeadLetter(events)
+	}
+}
+
+func (r *retrier) run() {
+	defer close(r.done)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		r.mu.Lock()
+		if r.closed {
+			r.mu.Unlock()
+			return
+		}
+		var it *retryItem
+		wait := time.Hour
+		if len(r.items) > 0 {
+			if wait = time.Until(r.items[0].due); wait <= 0 {
+				it = heap.Pop(&r.items).(*retryItem)
+			}
+		}
+		n := len(r.items)
+		r.mu.Unlock()
+		if it != nil {
+			r.setSize(n)
+			r.attempt(it)
+			continue
+		}
+		timer.Reset(wait)
+		select {
+		case <-timer.C:
+		case <-r.wake:
+		}
+	}
+}
+
+func (r *retrier) attempt(it *retryItem) {
+	it.attempt++
+	if err := it.op(r.ctx); err != nil {
+		r.schedule(it)
+	}
+}
+
+// close stops the retry loop and makes one last attempt at every queued
+// delivery, ignoring backoff. Deliveries still failing, or left when ctx
+// ends, go to DeadLetter in one call.
+func (r *retrier) close(ctx context.Context) error {
+	r.mu.Lock()
+	r.closed = true
+	r.mu.Unlock()
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+	select {
+	case <-r.done:
+	case <-ctx.Done():
+	}
+	r.mu.Lock()
+	items := r.items
+	r.items = nil
+	r.mu.Unlock()
+	r.setSize(0)
+	var dead []OpenEvent
+	for _, it := range items {
+		if ctx.Err() != nil || it.op(r.ctx) != nil {
+			dead = append(dead, it.event)
+		}
+	}
+	r.deadLetter(dead)
+	return ctx.Err()
+}
+
+func (r *retrier) queued() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.items)
+}
+
+func (t *Tracker) retryQueued() int {
+	if t.retrier == nil {
+		return 0
+	}
+	return t.retrier.queued()
+}
+
+// retry schedules op for another attempt if a retry queue is configured.
+func (t *Tracker) retry(e *OpenEvent, op func(context.Context) error) {
+	if t.retrier != nil {
+		t.retrier.retry(*e, op)
+	}
+}
@@ -0,0 +1,141 @@
+package emailtracker
+
+import (
+	"errors"
+	"html"
+	"net/url"
+	"strings"
+)
+
+// RewriteOption customizes RewriteLinks.
+type RewriteOption func(*rewriteOptions)
+
+type rewriteOptions struct {
+	exclude []string
+}
+
+// ExcludeDomains makes RewriteLinks leave links to these domains, and their
+// subdomains, as they are, e.g. the host serving unsubscribe pages.
+func ExcludeDomains(domains ...string) RewriteOption {
+	return func(o *rewriteOptions) {
+		for _, d := range domains {
+			o.exclude = append(o.exclude, strings.ToLower(strings.TrimPrefix(d, ".")))
+		}
+	}
+}
+
+func (o rewriteOptions) excluded(host string) bool {
+	host = strings.ToLower(host)
+	for _, d := range o.exclude {
+		if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// RewriteLinks points the href of every <a> tag in the email document doc
+// at the click endpoint, recording clicks for id, with the original
+// destination signed into the link as GenerateClickLink does. Only absolute
+// http and https links are rewritten: mailto:, tel:, fragment and relative
+// links are kept, as are links to the tracker's own host, which includes
+// links already rewritten, and links to excluded domains. Everything but
+// the rewritten href values is left byte for byte as it was. It requires
+// Config.ClickPath.
+func (t *Tracker) RewriteLinks(doc, id string, opts ...RewriteOption) (string, error) {
+	if t.config.ClickPath == "" {
+		return "", errors.New("emailtracker: RewriteLinks requires Config.ClickPath")
+	}
+	var o rewriteOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	self := strings.ToLower(t.host())
+	var b strings.Builder
+	copied := 0
+	var err error
+	walkTags(doc, func(name string, start, end int, closing bool) {
+		if closing || name != "a" || err != nil {
+			return
+		}
+		vs, ve, ok := hrefValue(doc[start:end])
+		if !ok {
+			return
+		}
+		target := strings.TrimSpace(html.UnescapeString(doc[start+vs : start+ve]))
+		u, perr := url.Parse(target)
+		if perr != nil || validTarget(target) != nil || strings.ToLower(u.Host) == self || o.excluded(u.Hostname()) {
+			return
+		}
+		var link string
+		if link, err = t.GenerateClickLink(id, target); err != nil {
+			return
+		}
+		b.WriteString(doc[copied : start+vs])
+		if q := doc[start+vs-1]; q == '"' || q == '\'' {
+			b.WriteString(html.EscapeString(link))
+		} else {
+			b.WriteString(`"` + html.EscapeString(link) + `"`) // quote a formerly unquoted value
+		}
+		copied = start + ve
+	})
+	if err != nil {
+		return "", err
+	}
+	b.WriteString(doc[copied:])
+	return b.String(), nil
+}
+
+// hrefValue returns the extent of the href attribute's value within tag, a
+// complete start tag, without its quotes.
+func hrefValue(tag string) (start, end int, ok bool) {
+	i := 1 + len(tagName(tag[1:]))
+	for i < len(tag) {
+		for i < len(tag) && (isHTMLSpace(tag[i]) || tag[i] == '/') {
+			i++
+		}
+		if i >= len(tag) || tag[i] == '>' {
+			return 0, 0, false
+		}
+		nameStart := i
+		for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '=' && tag[i] != '>' && tag[i] != '/' {
+			i++
+		}
+		name := tag[nameStart:i]
+		for i < len(tag) && isHTMLSpace(tag[i]) {
+			i++
+		}
+		if i >= len(tag) || tag[i] != '=' {
+			continue // attribute without a value
+		}
+		i++
+		for i < len(tag) && isHTMLSpace(tag[i]) {
+			i++
+		}
+		var vs, ve int
+		if i < len(tag) && (tag[i] == '"' || tag[i] == '\'') {
+			q := tag[i]
+			vs = i + 1
+			j := strings.IndexByte(tag[vs:], q)
+			if j < 0 {
+				return 0, 0, false
+			}
+			ve = vs + j
+			i = ve + 1
+		} else {
+			vs = i
+			for i < len(tag) && !isHTMLSpace(tag[i]) && tag[i] != '>' {
+				i++
+			}
+			ve = i
+		}
+		if strings.EqualFold(name, "href") {
+			return vs, ve, true
+		}
+	}
+	return 0, 0, false
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == '\f'
+}
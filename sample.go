@@ -0,0 +1,38 @@
+package emailtracker
+
+import "hash/fnv"
+
+// DropSampled is the drop reason for requests whose tracking ID falls outside
+// Config.SampleRate.
+const DropSampled DropReason = "sampled"
+
+// sampled reports whether events for id are kept. The decision hashes the ID
+// with FNV-1a, unseeded, so every open of one message, on every replica,
+// lands on the same side.
+func (t *Tracker) sampled(id string) bool {
+	rate := t.config.SampleRate
+	if rate <= 0 {
+		return !t.config.Sampling
+	}
+	if rate >= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	// FNV's high bits barely change between similar IDs such as "m1" and
+	// "m2"; the splitmix64 finalizer spreads them before the top 53 bits
+	// become a float in [0, 1).
+	x := h.Sum64()
+	x = (x ^ x>>30) * 0xbf58476d1ce4e5b9
+	x = (x ^ x>>27) * 0x94d049bb133111eb
+	x ^= x >> 31
+	return float64(x>>11)/(1<<53) < rate
+}
+
+// sampleRate is the rate recorded on events, 0 when not sampling.
+func (t *Tracker) sampleRate() float64 {
+	if t.config.SampleRate >= 1 {
+		return 0
+	}
+	return t.config.SampleRate
+}
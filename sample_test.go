@@ -0,0 +1,67 @@
+package emailtracker
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSampleRate(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		rate     float64
+		sampling bool
+		min, max int // events kept out of 200
+	}{
+		{"default", 0, false, 200, 200},
+		{"all", 1, false, 200, 200},
+		{"half", 0.5, false, 70, 130},
+		{"half, Sampling", 0.5, true, 70, 130},
+		{"none", 0, true, 0, 0},
+	} {
+		tr, log := newTestTracker(t, Config{SampleRate: c.rate, Sampling: c.sampling})
+		for i := range 200 {
+			get(tr.Handler(), tr.GenerateLink(fmt.Sprint("msg-", i)))
+		}
+		events := log.all()
+		if n := len(events); n < c.min || n > c.max {
+			t.Errorf("%s: kept %d of 200, want %d to %d", c.name, n, c.min, c.max)
+		}
+		if dropped := tr.Metrics().EventsDropped[DropSampled]; int(dropped) != 200-len(events) {
+			t.Errorf("%s: %d dropped as sampled, want %d", c.name, dropped, 200-len(events))
+		}
+		want := c.rate
+		if want >= 1 {
+			want = 0
+		}
+		for _, e := range events {
+			if e.SampleRate != want {
+				t.Errorf("%s: event SampleRate = %v, want %v", c.name, e.SampleRate, want)
+				break
+			}
+		}
+	}
+}
+
+func TestSampleRateDeterministic(t *testing.T) {
+	a, logA := newTestTracker(t, Config{SampleRate: 0.3})
+	b, logB := newTestTracker(t, Config{SampleRate: 0.3})
+	for i := range 100 {
+		id := fmt.Sprint("msg-", i)
+		get(a.Handler(), a.GenerateLink(id))
+		get(b.Handler(), b.GenerateLink(id))
+		get(b.Handler(), b.GenerateLink(id))
+	}
+	kept := make(map[string]int)
+	for _, e := range logB.all() {
+		kept[e.ID]++
+	}
+	for _, e := range logA.all() {
+		if kept[e.ID] != 2 {
+			t.Errorf("%s kept by one replica but not every open on the other", e.ID)
+		}
+		delete(kept, e.ID)
+	}
+	if len(kept) != 0 {
+		t.Errorf("replicas disagree on %d IDs", len(kept))
+	}
+}
@@ -0,0 +1,52 @@
+package emailtracker
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrSeenSet wraps errors returned by Config.SeenSet when they are reported
+// through the error hook.
+var ErrSeenSet = errors.New("emailtracker: seen set failed")
+
+// SeenSet is shared state for dedup, first-open and replay detection, so
+// that several tracker replicas behind a load balancer agree on what they've
+// seen; see the redisstore sub-package. Implementations must be safe for
+// concurrent use.
+type SeenSet interface {
+	// Add records key for ttl, or indefinitely if ttl is zero, and reports
+	// whether it was already present. A present key keeps its original
+	// expiry.
+	Add(key string, ttl time.Duration) (seen bool, err error)
+}
+
+// Key namespaces within a SeenSet.
+const (
+	seenDedup = "dedup:"
+	seenFirst = "first:"
+	seenNonce = "nonce:"
+)
+
+// seenBefore records key in the SeenSet, if any, and in local, reporting
+// whether it was seen before. When the SeenSet fails the error is reported
+// and the in-memory answer is used, so the tracker keeps working, with
+// per-replica accuracy, while the shared store is unreachable.
+func (t *Tracker) seenBefore(local *ttlSet, namespace, key string, e *OpenEvent) bool {
+	seen, _ := t.seenShared(local, namespace, key, e)
+	return seen
+}
+
+// seenShared is seenBefore, also reporting whether the SeenSet answered.
+func (t *Tracker) seenShared(local *ttlSet, namespace, key string, e *OpenEvent) (seen, shared bool) {
+	seen = local.add(key, e.Time)
+	if t.seenSet == nil {
+		return seen, false
+	}
+	sharedSeen, err := t.seenSet.Add(namespace+key, local.ttl)
+	if err != nil {
+		t.reportError("seen", fmt.Errorf("%w: %w", ErrSeenSet, err), e)
+		return seen, false
+	}
+	return sharedSeen, true
+}
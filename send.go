@@ -0,0 +1,154 @@
+package emailtracker
+
+import (
+	"cmp"
+	"container/list"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+	"time"
+)
+
+// DefaultSendRegistrySize is the number of sends kept in memory when the
+// Store doesn't implement SendStore.
+const DefaultSendRegistrySize = 100_000
+
+// SendMeta describes a sent email, for RegisterSend.
+type SendMeta struct {
+	Recipient string            `json:"recipient,omitempty"`
+	Campaign  string            `json:"campaign,omitempty"`
+	SentAt    time.Time         `json:"sent_at"`        // defaults to the time of RegisterSend
+	Tags      map[string]string `json:"tags,omitempty"` // arbitrary labels, e.g. the template name
+}
+
+// Send is a send registered by RegisterSend.
+type Send struct {
+	ID string `json:"id"` // tracking ID
+	SendMeta
+}
+
+// SendStore is an optional extension of Store. If the configured Store
+// implements it, RegisterSend persists sends there; otherwise they are kept
+// in memory. Implementations must be safe for concurrent use.
+type SendStore interface {
+	// SaveSend records s, replacing any send with the same ID, and reports
+	// whether there was none.
+	SaveSend(s Send) (created bool, err error)
+	// SendByID returns the send registered for a tracking ID.
+	SendByID(id string) (s Send, ok bool, err error)
+	// EachSend calls fn for every send, in the order their IDs were first
+	// registered, until fn returns false.
+	EachSend(fn func(Send) bool) error
+}
+
+// memorySends is the in-memory SendStore. Once full, registering a new ID
+// evicts the ID registered first.
+type memorySends struct {
+	size int
+
+	mu    sync.Mutex
+	order *list.List               // most recently registered ID first
+	byID  map[string]*list.Element // ID -> element holding a Send
+}
+
+func newMemorySends(size int) *memorySends {
+	if size <= 0 {
+		size = DefaultSendRegistrySize
+	}
+	return &memorySends{size: size, order: list.New(), byID: make(map[string]*list.Element)}
+}
+
+func (m *memorySends) SaveSend(s Send) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.byID[s.ID]; ok {
+		el.Value = s
+		return false, nil
+	}
+	m.byID[s.ID] = m.order.PushFront(s)
+	if m.order.Len() > m.size {
+		el := m.order.Back()
+		m.order.Remove(el)
+		delete(m.byID, el.Value.(Send).ID)
+	}
+	return true, nil
+}
+
+func (m *memorySends) SendByID(id string) (Send, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.byID[id]; ok {
+		return el.Value.(Send), true, nil
+	}
+	return Send{}, false, nil
+}
+
+func (m *memorySends) EachSend(fn func(Send) bool) error {
+	m.mu.Lock()
+	sends := make([]Send, 0, m.order.Len())
+	for el := m.order.Back(); el != nil; el = el.Prev() {
+		sends = append(sends, el.Value.(Send))
+	}
+	m.mu.Unlock()
+	for _, s := range sends {
+		if !fn(s) {
+			break
+		}
+	}
+	return nil
+}
+
+// RegisterSend records that the email tracked by id was sent, so Stats and
+// Report can relate opens to sends. Registering an ID again replaces its
+// send. Campaign and Recipient default to those packed into IDs built by
+// CampaignID. Sends are persisted when the Store implements SendStore, as
+// those in the sqlitestore and pgstore sub-packages do; otherwise the last
+// Config.SendRegistrySize IDs registered are kept in memory, the first
+// registered forgotten first, and all are lost on restart. With KnownSends,
+// registered IDs also count as known to StrictIDs.
+func (t *Tracker) RegisterSend(id string, meta SendMeta) error {
+	if id == "" {
+		return errors.New("emailtracker: RegisterSend: empty ID")
+	}
+	if meta.SentAt.IsZero() {
+		meta.SentAt = t.now()
+	}
+	if campaign, recipient, ok := ParseCampaignID(id); ok {
+		meta.Campaign = cmp.Or(meta.Campaign, campaign)
+		meta.Recipient = cmp.Or(meta.Recipient, recipient)
+	}
+	meta.Tags = maps.Clone(meta.Tags)
+	created, err := t.sends.SaveSend(Send{ID: id, SendMeta: meta})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStoreWrite, err)
+	}
+	if created {
+		t.batchMu.Lock()
+		reporters := t.reporters
+		t.batchMu.Unlock()
+		for _, r := range reporters {
+			r.addSend(id, meta.Campaign)
+		}
+	}
+	return nil
+}
+
+// LookupSend returns the send registered for id.
+func (t *Tracker) LookupSend(id string) (Send, bool, error) {
+	s, ok, err := t.sends.SendByID(id)
+	if err != nil {
+		return Send{}, false, fmt.Errorf("%w: %w", ErrStoreRead, err)
+	}
+	return s, ok, nil
+}
+
+// sendKnown reports whether id was registered by RegisterSend. Lookup
+// errors are reported and count as unknown.
+func (t *Tracker) sendKnown(id string) bool {
+	_, ok, err := t.LookupSend(id)
+	if err != nil {
+		t.reportError("store", err, nil)
+	}
+	return ok
+}
@@ -0,0 +1,42 @@
+package emailtracker
+
+import (
+	"strconv"
+	"time"
+)
+
+// sentParam carries a link's send time in Unix milliseconds.
+const sentParam = "sent"
+
+// WithSentAt records when the message carrying the link was sent, so opens
+// report OpenEvent.TimeToOpen. With a SigningKey the time is covered by the
+// signature, so recipients can't alter it.
+func WithSentAt(at time.Time) LinkOption {
+	return func(o *linkOptions) { o.sentAt = at }
+}
+
+// sent formats the send time for a link, or returns "" for none.
+func (o linkOptions) sent() string {
+	if o.sentAt.IsZero() {
+		return ""
+	}
+	return strconv.FormatInt(o.sentAt.UnixMilli(), 10)
+}
+
+// timeToOpen returns how long before now a link with send time sent was
+// sent. A send time in the future is clamped to zero and reported as skewed;
+// a missing or malformed one yields zero.
+func timeToOpen(sent string, now time.Time) (d time.Duration, skewed bool) {
+	if sent == "" {
+		return 0, false
+	}
+	ms, err := strconv.ParseInt(sent, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	d = now.Sub(time.UnixMilli(ms))
+	if d < 0 {
+		return 0, true
+	}
+	return d, false
+}
@@ -0,0 +1,444 @@
+package emailtracker
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrTrackerClosed is returned by Start after the tracker has been shut down.
+var ErrTrackerClosed = errors.New("emailtracker: tracker has been shut down")
+
+// ErrAlreadyStarted is returned by Start when the server is already running.
+var ErrAlreadyStarted = errors.New("emailtracker: tracker already started")
+
+// Start serves the tracking pixel on the configured port or socket, over TLS
+// when the Config carries certificates. It blocks until the server fails or
+// Shutdown is called, in which case it returns nil.
+func (t *Tracker) Start() error {
+	if t.config.TLSCertFile != "" || t.config.TLSKeyFile != "" || hasCertificates(t.config.TLSConfig) {
+		return t.StartTLS(t.config.TLSCertFile, t.config.TLSKeyFile)
+	}
+	if err := t.checkPath(); err != nil {
+		return err
+	}
+	return t.serve(t.newServer(), nil, false, "", "")
+}
+
+// StartWithListener is like Start but serves on l, for socket activation
+// or listeners wrapped to decode the PROXY protocol, instead of binding
+// Config.Port. Links use Config.Domain as it is, and Addr reports l.Addr().
+// The tracker owns l from then on: Shutdown closes it, as does a failed
+// start.
+func (t *Tracker) StartWithListener(l net.Listener) error {
+	if err := t.checkPath(); err != nil {
+		l.Close()
+		return err
+	}
+	srv := t.newServer()
+	srv.Addr = l.Addr().String()
+	useTLS := t.config.TLSCertFile != "" || hasCertificates(t.config.TLSConfig)
+	return t.serve(srv, l, useTLS, t.config.TLSCertFile, t.config.TLSKeyFile)
+}
+
+// StartTLS is like Start but serves HTTPS using the given certificate and key
+// files. Both may be empty if Config.TLSConfig already provides certificates.
+func (t *Tracker) StartTLS(certFile, keyFile string) error {
+	if err := t.checkPath(); err != nil {
+		return err
+	}
+	return t.serve(t.newServer(), nil, true, certFile, keyFile)
+}
+
+// Serve is like Start but runs srv, for settings such as ConnState, ErrorLog
+// or HTTP/2 options that Config doesn't cover. srv's own timeouts and limits
+// are used as they are. If srv.Handler is already set, the tracker's routes
+// take precedence and every other request falls through to it. An empty
+// srv.Addr listens on Config.Port, or Config.SocketPath. srv serves HTTPS
+// when srv.TLSConfig carries certificates, or Config.TLSCertFile and
+// TLSKeyFile are set.
+// Start, StartTLS, StartWithListener and Serve are mutually exclusive;
+// Shutdown stops srv.
+func (t *Tracker) Serve(srv *http.Server) error {
+	if err := t.checkPath(); err != nil {
+		return err
+	}
+	if srv.Addr == "" {
+		srv.Addr = t.listenAddr()
+	}
+	if srv.Handler == nil {
+		srv.Handler = t.mux()
+	} else {
+		srv.Handler = t.overlay(srv.Handler)
+	}
+	useTLS := hasCertificates(srv.TLSConfig) || t.config.TLSCertFile != ""
+	return t.serve(srv, nil, useTLS, t.config.TLSCertFile, t.config.TLSKeyFile)
+}
+
+// overlay serves the tracker's routes and passes every other request to next.
+func (t *Tracker) overlay(next http.Handler) http.Handler {
+	mux := t.mux()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, pattern := mux.Handler(r); pattern != "" {
+			mux.ServeHTTP(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Server limit defaults and bounds.
+const (
+	defaultReadTimeout    = 5 * time.Second
+	defaultWriteTimeout   = 10 * time.Second
+	defaultIdleTimeout    = 60 * time.Second
+	defaultMaxHeaderBytes = 1 << 20
+	maxServerTimeout      = time.Hour
+	maxMaxHeaderBytes     = 16 << 20
+)
+
+func validateServerLimits(cfg Config) error {
+	for _, d := range []struct {
+		name string
+		v    time.Duration
+	}{{"ReadTimeout", cfg.ReadTimeout}, {"WriteTimeout", cfg.WriteTimeout}, {"IdleTimeout", cfg.IdleTimeout}} {
+		if d.v < 0 || d.v > maxServerTimeout {
+			return fmt.Errorf("emailtracker: %s %v out of range [0, %v]", d.name, d.v, maxServerTimeout)
+		}
+	}
+	if cfg.MaxHeaderBytes < 0 || cfg.MaxHeaderBytes > maxMaxHeaderBytes {
+		return fmt.Errorf("emailtracker: MaxHeaderBytes %d out of range [0, %d]", cfg.MaxHeaderBytes, maxMaxHeaderBytes)
+	}
+	return nil
+}
+
+// allowGetHead answers requests other than GET and HEAD with 405 Method Not
+// Allowed, reporting whether r may proceed.
+func allowGetHead(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	w.Header().Set("Allow", "GET, HEAD")
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	return false
+}
+
+// newServer builds an http.Server with cfg's limits, or their defaults.
+func newServer(addr string, h http.Handler, cfg Config) *http.Server {
+	srv := &http.Server{
+		Addr:           addr,
+		Handler:        h,
+		ReadTimeout:    cmp.Or(cfg.ReadTimeout, defaultReadTimeout),
+		WriteTimeout:   cmp.Or(cfg.WriteTimeout, defaultWriteTimeout),
+		IdleTimeout:    cmp.Or(cfg.IdleTimeout, defaultIdleTimeout),
+		MaxHeaderBytes: cmp.Or(cfg.MaxHeaderBytes, defaultMaxHeaderBytes),
+	}
+	srv.ReadHeaderTimeout = srv.ReadTimeout
+	return srv
+}
+
+func (t *Tracker) newServer() *http.Server {
+	return newServer(t.listenAddr(), t.mux(), t.config)
+}
+
+// listenAddr is Config.SocketPath, or ":{Port}".
+func (t *Tracker) listenAddr() string {
+	if t.config.SocketPath != "" {
+		return t.config.SocketPath
+	}
+	return fmt.Sprintf(":%d", t.config.Port)
+}
+
+// listen binds addr: the unix socket at Config.SocketPath when that's what
+// addr names, and a TCP address otherwise.
+func (t *Tracker) listen(addr string) (net.Listener, error) {
+	if t.config.SocketPath == "" || addr != t.config.SocketPath {
+		return net.Listen("tcp", addr)
+	}
+	if err := removeStaleSocket(addr); err != nil {
+		return nil, err
+	}
+	ln, err := net.Listen("unix", addr)
+	if err != nil {
+		return nil, err
+	}
+	// The unix listener removes the socket file when closed.
+	if err := os.Chmod(addr, cmp.Or(t.config.SocketMode, 0o660)); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// removeStaleSocket removes the socket at path if nothing answers on it.
+// Other kinds of file are left alone, making the listen fail.
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return nil
+	}
+	if c, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		c.Close()
+		return fmt.Errorf("emailtracker: socket %s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// serve runs srv on ln, or on a listener bound to srv.Addr when ln is nil.
+func (t *Tracker) serve(srv *http.Server, ln net.Listener, useTLS bool, certFile, keyFile string) error {
+	t.mu.Lock()
+	var err error
+	if t.closed {
+		err = ErrTrackerClosed
+	} else if t.server != nil || t.grouped {
+		err = ErrAlreadyStarted
+	}
+	if err != nil {
+		t.mu.Unlock()
+		if ln != nil {
+			ln.Close()
+		}
+		return err
+	}
+	if useTLS {
+		if srv.TLSConfig == nil && t.config.TLSConfig != nil {
+			srv.TLSConfig = t.config.TLSConfig.Clone()
+		}
+		t.tls = true
+	}
+	t.server = srv
+	t.mu.Unlock()
+
+	ephemeral := false
+	if ln == nil {
+		ln, err = t.listen(srv.Addr)
+		_, port, _ := net.SplitHostPort(srv.Addr)
+		ephemeral = port == "0"
+	}
+	if err == nil {
+		t.bound(ln.Addr(), ephemeral)
+		t.log.Info("tracker started", slog.String(LogKeyAddr, ln.Addr().String()), slog.Bool("tls", useTLS))
+		t.ready.Store(true)
+		if useTLS {
+			err = srv.ServeTLS(ln, certFile, keyFile)
+		} else {
+			err = srv.Serve(ln)
+		}
+		t.ready.Store(false)
+	}
+	if errors.Is(err, http.ErrServerClosed) {
+		t.log.Info("tracker stopped", slog.String(LogKeyAddr, srv.Addr))
+		return nil
+	}
+	t.log.Error("tracker failed", slog.String(LogKeyAddr, srv.Addr), slog.String(LogKeyError, err.Error()))
+	// The listener never came up; allow another attempt.
+	t.mu.Lock()
+	if t.server == srv {
+		t.server = nil
+	}
+	t.mu.Unlock()
+	return err
+}
+
+// bound records the listener address and signals Started. ephemeral says
+// the port was picked by the OS, so links need to carry it.
+func (t *Tracker) bound(addr net.Addr, ephemeral bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.addr = addr
+	if tcp, ok := addr.(*net.TCPAddr); ok && ephemeral {
+		t.linkPort = tcp.Port
+	}
+	select {
+	case <-t.started: // bound before, by an attempt that failed later
+	default:
+		close(t.started)
+	}
+}
+
+// Started returns a channel that is closed once Start, StartTLS or
+// StartWithListener has bound the listener, after which Addr is valid.
+func (t *Tracker) Started() <-chan struct{} {
+	return t.started
+}
+
+// Addr returns the address the tracker listens on, or nil before it has
+// started. With Port 0 this reveals the port the OS picked.
+func (t *Tracker) Addr() net.Addr {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.addr
+}
+
+// tlsEnabled reports whether the tracker serves (or will serve) HTTPS.
+func (t *Tracker) tlsEnabled() bool {
+	if t.config.TLSCertFile != "" || hasCertificates(t.config.TLSConfig) {
+		return true
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.tls
+}
+
+func hasCertificates(c *tls.Config) bool {
+	return c != nil && (len(c.Certificates) > 0 || c.GetCertificate != nil || c.GetConfigForClient != nil)
+}
+
+// mux returns a fresh ServeMux holding only this tracker's routes, so several
+// trackers (or other code using http.DefaultServeMux) can share a process.
+func (t *Tracker) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	t.Mount(mux)
+	return mux
+}
+
+// Mount registers the tracker's routes (the pixel, click, health and ready
+// paths it is configured with) on mux, for serving them from your own
+// server. Like http.ServeMux.Handle, it panics if a path is already taken.
+func (t *Tracker) Mount(mux *http.ServeMux) {
+	for _, r := range t.routes() {
+		mux.Handle(r.pattern, r.handler)
+	}
+}
+
+// route is a pattern the tracker serves.
+type route struct {
+	pattern string
+	handler http.Handler
+}
+
+func (t *Tracker) routes() []route {
+	routes := []route{{t.config.Path, t.Handler()}}
+	if t.config.HealthPath != "" {
+		routes = append(routes, route{t.config.HealthPath, t.HealthHandler()})
+	}
+	if t.config.ReadyPath != "" {
+		routes = append(routes, route{t.config.ReadyPath, t.ReadyHandler()})
+	}
+	if t.config.LinkExtension {
+		routes = append(routes, route{t.pixelPath(), t.Handler()})
+	}
+	if t.config.PathIDs && t.pathPrefix() != t.config.Path {
+		routes = append(routes, route{t.pathPrefix(), t.Handler()})
+	}
+	if t.config.ClickPath != "" {
+		routes = append(routes, route{t.config.ClickPath, t.ClickHandler()})
+	}
+	if t.config.UnsubscribePath != "" {
+		routes = append(routes, route{t.config.UnsubscribePath, t.UnsubscribeHandler()})
+	}
+	if t.config.ESPWebhookPath != "" {
+		routes = append(routes, route{t.config.ESPWebhookPath, t.ESPWebhookHandler()})
+	}
+	if t.config.StreamPath != "" {
+		routes = append(routes, route{t.config.StreamPath, t.StreamHandler()})
+	}
+	if t.config.AdminPath != "" {
+		routes = append(routes, route{strings.TrimSuffix(t.config.AdminPath, "/") + "/", t.AdminHandler()})
+	}
+	return routes
+}
+
+// Shutdown gracefully stops the server: it closes the listener, waits for
+// in-flight pixel requests and running callbacks to finish (including events
+// still queued for async workers), and returns once everything has drained or
+// ctx is done; in the latter case subscribers still running have their
+// context cancelled. Sinks, the webhook and the retry queue are closed even
+// when an earlier step fails; the failures are joined. A stopped tracker
+// cannot be restarted.
+func (t *Tracker) Shutdown(ctx context.Context) error {
+	t.ready.Store(false)
+	t.mu.Lock()
+	srv := t.server
+	t.closed = true
+	t.mu.Unlock()
+	// Subscribers still running when Shutdown returns, whether drained
+	// or timed out, see their context cancelled.
+	defer t.cancel()
+	if t.stream != nil {
+		t.stream.close() // streams never go idle, so end them first
+	}
+
+	// Every step runs even if an earlier one failed or ctx is done, so the
+	// webhook, retry queue and sinks are always closed.
+	var errs []error
+	if srv != nil {
+		errs = append(errs, srv.Shutdown(ctx))
+	}
+	if t.debounce != nil {
+		t.debounce.stop()
+	}
+	if t.dispatcher != nil {
+		errs = append(errs, t.dispatcher.close(ctx))
+	}
+	errs = append(errs,
+		t.callbacks.wait(ctx),
+		t.closeBatchers(ctx),
+		t.closeReporters(ctx),
+		t.closeAlerters(ctx),
+	)
+	t.events.close()
+	if t.webhook != nil {
+		errs = append(errs, t.webhook.close(ctx))
+	}
+	// Sinks stay open until the retry queue, which may publish to them,
+	// has had its last attempt.
+	errs = append(errs, t.drainSinks(ctx))
+	if t.retrier != nil {
+		errs = append(errs, t.retrier.close(ctx))
+	}
+	errs = append(errs, t.closeSinks())
+	return errors.Join(errs...)
+}
+
+// inflight counts running callbacks so Shutdown can wait for them. Unlike
+// sync.WaitGroup it tolerates add being called while wait is in progress.
+type inflight struct {
+	mu   sync.Mutex
+	n    int
+	idle chan struct{}
+}
+
+func (f *inflight) add() {
+	f.mu.Lock()
+	f.n++
+	f.mu.Unlock()
+}
+
+func (f *inflight) done() {
+	f.mu.Lock()
+	f.n--
+	if f.n == 0 && f.idle != nil {
+		close(f.idle)
+		f.idle = nil
+	}
+	f.mu.Unlock()
+}
+
+func (f *inflight) wait(ctx context.Context) error {
+	f.mu.Lock()
+	if f.n == 0 {
+		f.mu.Unlock()
+		return nil
+	}
+	if f.idle == nil {
+		f.idle = make(chan struct{}) // made on demand, sparing add an allocation
+	}
+	idle := f.idle
+	f.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
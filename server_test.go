@@ -0,0 +1,441 @@
+package emailtracker
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startServer runs start, e.g. tr.Start, in the background until tr is
+// shut down at the end of the test, and returns once tr is listening.
+func startServer(t testing.TB, tr *Tracker, start func() error) {
+	t.Helper()
+	errc := make(chan error, 1)
+	go func() { errc <- start() }()
+	select {
+	case <-tr.Started():
+	case err := <-errc:
+		t.Fatalf("start: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("tracker didn't start")
+	}
+	t.Cleanup(func() {
+		// A connection the client dialed but never used counts as active
+		// for five seconds, stalling Shutdown.
+		http.DefaultClient.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tr.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		if err := <-errc; err != nil {
+			t.Errorf("start returned %v after Shutdown", err)
+		}
+	})
+}
+
+// fetch GETs url with client, failing the test unless it answers 200.
+func fetch(t testing.TB, client *http.Client, url string) *http.Response {
+	t.Helper()
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, resp.StatusCode)
+	}
+	return resp
+}
+
+func TestTrackersShareProcess(t *testing.T) {
+	a, logA := newTestTracker(t, Config{Domain: "127.0.0.1"})
+	b, logB := newTestTracker(t, Config{Domain: "127.0.0.1"})
+	startServer(t, a, a.Start)
+	startServer(t, b, b.Start)
+
+	fetch(t, http.DefaultClient, a.GenerateLink("msg-a"))
+	fetch(t, http.DefaultClient, b.GenerateLink("msg-b"))
+	if got := logA.wait(t, 1); len(got) != 1 || got[0].ID != "msg-a" {
+		t.Errorf("first tracker got %+v, want only msg-a", got)
+	}
+	if got := logB.wait(t, 1); len(got) != 1 || got[0].ID != "msg-b" {
+		t.Errorf("second tracker got %+v, want only msg-b", got)
+	}
+	r, _ := http.NewRequest(http.MethodGet, "/pixel", nil)
+	if _, pattern := http.DefaultServeMux.Handler(r); pattern != "" {
+		t.Errorf("DefaultServeMux serves /pixel with pattern %q", pattern)
+	}
+}
+
+func TestStartShutdownRepeatedly(t *testing.T) {
+	for i := range 3 {
+		tr, err := New(Config{Domain: "127.0.0.1", Path: "/pixel"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		errc := make(chan error, 1)
+		go func() { errc <- tr.Start() }()
+		<-tr.Started()
+		fetch(t, http.DefaultClient, tr.GenerateLink("msg-1"))
+		if err := tr.Shutdown(context.Background()); err != nil {
+			t.Fatalf("round %d: Shutdown: %v", i, err)
+		}
+		if err := <-errc; err != nil {
+			t.Fatalf("round %d: Start returned %v", i, err)
+		}
+		if err := tr.Start(); err != ErrTrackerClosed {
+			t.Errorf("round %d: Start after Shutdown = %v, want ErrTrackerClosed", i, err)
+		}
+	}
+}
+
+// selfSigned writes a certificate for 127.0.0.1 and its key to a temporary
+// directory, returning their paths and a client trusting the certificate.
+func selfSigned(t testing.TB) (certFile, keyFile string, client *http.Client) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		IPAddresses:  []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := os.WriteFile(certFile, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(certPEM)
+	return certFile, keyFile, &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+}
+
+func TestStartTLS(t *testing.T) {
+	certFile, keyFile, client := selfSigned(t)
+	tr, log := newTestTracker(t, Config{Domain: "127.0.0.1"})
+	startServer(t, tr, func() error { return tr.StartTLS(certFile, keyFile) })
+
+	link := tr.GenerateLink("msg-1")
+	if !strings.HasPrefix(link, "https://127.0.0.1:") {
+		t.Fatalf("link %q, want https with the bound port", link)
+	}
+	if resp := fetch(t, client, link); resp.TLS == nil {
+		t.Error("pixel not served over TLS")
+	}
+	if got := log.wait(t, 1); got[0].ID != "msg-1" {
+		t.Errorf("event for %q, want msg-1", got[0].ID)
+	}
+}
+
+func TestStartTLSFromConfig(t *testing.T) {
+	certFile, keyFile, client := selfSigned(t)
+	tr, log := newTestTracker(t, Config{Domain: "127.0.0.1", TLSCertFile: certFile, TLSKeyFile: keyFile})
+	if link := tr.GenerateLink("msg-1"); !strings.HasPrefix(link, "https://") {
+		t.Errorf("link before Start %q, want https", link)
+	}
+	startServer(t, tr, tr.Start)
+	fetch(t, client, tr.GenerateLink("msg-1"))
+	log.wait(t, 1)
+}
+
+func TestLinkSchemeWithoutTLS(t *testing.T) {
+	for domain, want := range map[string]string{
+		"localhost":    "http://localhost/",
+		"127.0.0.1":    "http://127.0.0.1/",
+		"[::1]:8080":   "http://[::1]:8080/",
+		"tracker.test": "https://tracker.test/",
+	} {
+		tr, _ := newTestTracker(t, Config{Domain: domain})
+		if link := tr.GenerateLink("msg-1"); !strings.HasPrefix(link, want) {
+			t.Errorf("Domain %s: link %q, want prefix %q", domain, link, want)
+		}
+	}
+}
+
+func TestEphemeralPort(t *testing.T) {
+	tr, log := newTestTracker(t, Config{Domain: "localhost"})
+	if tr.Addr() != nil {
+		t.Errorf("Addr before Start = %v, want nil", tr.Addr())
+	}
+	if link := tr.GenerateLink("msg-1"); !strings.HasPrefix(link, "http://localhost/pixel?") {
+		t.Errorf("link before Start %q", link)
+	}
+	startServer(t, tr, tr.Start)
+
+	addr, ok := tr.Addr().(*net.TCPAddr)
+	if !ok || addr.Port == 0 {
+		t.Fatalf("Addr = %v, want the bound TCP port", tr.Addr())
+	}
+	link := tr.GenerateLink("msg-1")
+	if want := "http://" + net.JoinHostPort("localhost", strconv.Itoa(addr.Port)) + "/pixel?"; !strings.HasPrefix(link, want) {
+		t.Fatalf("link %q, want prefix %q", link, want)
+	}
+	fetch(t, http.DefaultClient, link)
+	log.wait(t, 1)
+
+	explicit, _ := newTestTracker(t, Config{Domain: "localhost:9999"})
+	startServer(t, explicit, explicit.Start)
+	if link := explicit.GenerateLink("msg-1"); !strings.HasPrefix(link, "http://localhost:9999/") {
+		t.Errorf("link %q dropped the Domain's port", link)
+	}
+}
+
+func TestServerLimits(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		cfg  Config
+		want *http.Server
+	}{
+		{
+			name: "defaults",
+			want: &http.Server{ReadTimeout: 5 * time.Second, ReadHeaderTimeout: 5 * time.Second, WriteTimeout: 10 * time.Second, IdleTimeout: time.Minute, MaxHeaderBytes: 1 << 20},
+		},
+		{
+			name: "configured",
+			cfg:  Config{ReadTimeout: time.Second, WriteTimeout: 2 * time.Second, IdleTimeout: 3 * time.Second, MaxHeaderBytes: 4096},
+			want: &http.Server{ReadTimeout: time.Second, ReadHeaderTimeout: time.Second, WriteTimeout: 2 * time.Second, IdleTimeout: 3 * time.Second, MaxHeaderBytes: 4096},
+		},
+	} {
+		tr, _ := newTestTracker(t, c.cfg)
+		srv := tr.newServer()
+		if srv.ReadTimeout != c.want.ReadTimeout || srv.ReadHeaderTimeout != c.want.ReadHeaderTimeout ||
+			srv.WriteTimeout != c.want.WriteTimeout || srv.IdleTimeout != c.want.IdleTimeout ||
+			srv.MaxHeaderBytes != c.want.MaxHeaderBytes {
+			t.Errorf("%s: read %v, header %v, write %v, idle %v, max header %d", c.name,
+				srv.ReadTimeout, srv.ReadHeaderTimeout, srv.WriteTimeout, srv.IdleTimeout, srv.MaxHeaderBytes)
+		}
+	}
+}
+
+func TestInvalidServerLimits(t *testing.T) {
+	for name, cfg := range map[string]Config{
+		"negative read":    {ReadTimeout: -time.Second},
+		"huge write":       {WriteTimeout: 2 * time.Hour},
+		"huge idle":        {IdleTimeout: 25 * time.Hour},
+		"negative headers": {MaxHeaderBytes: -1},
+		"huge headers":     {MaxHeaderBytes: 1 << 30},
+	} {
+		cfg.Domain, cfg.Path = "tracker.test", "/pixel"
+		if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), "out of range") {
+			t.Errorf("%s: New = %v, want an out of range error", name, err)
+		}
+	}
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	link := tr.GenerateLink("msg-1")
+	for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodPatch, http.MethodOptions, "PROPFIND"} {
+		w := httptest.NewRecorder()
+		tr.Handler().ServeHTTP(w, httptest.NewRequest(method, link, nil))
+		if w.Code != http.StatusMethodNotAllowed || w.Header().Get("Allow") != "GET, HEAD" {
+			t.Errorf("%s: status %d, Allow %q", method, w.Code, w.Header().Get("Allow"))
+		}
+	}
+	if n := len(log.all()); n != 0 {
+		t.Errorf("%d events from rejected methods", n)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodHead} {
+		w := httptest.NewRecorder()
+		tr.Handler().ServeHTTP(w, httptest.NewRequest(method, link, nil))
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: status %d", method, w.Code)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	tr, log := newTestTracker(t, Config{Domain: "127.0.0.1"})
+	var conns atomic.Int32
+	srv := &http.Server{
+		Addr: "127.0.0.1:0",
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, "app "+r.URL.Path)
+		}),
+		ConnState: func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		},
+		ReadTimeout: 42 * time.Second,
+	}
+	startServer(t, tr, func() error { return tr.Serve(srv) })
+
+	fetch(t, http.DefaultClient, tr.GenerateLink("msg-1"))
+	if got := log.wait(t, 1); got[0].ID != "msg-1" {
+		t.Errorf("event for %q, want msg-1", got[0].ID)
+	}
+	base := "http://" + tr.Addr().String()
+	for _, path := range []string{"/", "/other", "/pixelated"} {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "app "+path {
+			t.Errorf("%s: body %q, want the caller's handler", path, body)
+		}
+	}
+	if len(log.all()) != 1 {
+		t.Errorf("fall-through requests produced events")
+	}
+	if conns.Load() == 0 {
+		t.Error("the caller's ConnState hook never ran")
+	}
+	if srv.ReadTimeout != 42*time.Second || srv.WriteTimeout != 0 {
+		t.Errorf("Serve changed srv's limits to read %v, write %v", srv.ReadTimeout, srv.WriteTimeout)
+	}
+	if err := tr.Start(); err != ErrAlreadyStarted {
+		t.Errorf("Start while serving = %v, want ErrAlreadyStarted", err)
+	}
+	if err := tr.Serve(&http.Server{Addr: "127.0.0.1:0"}); err != ErrAlreadyStarted {
+		t.Errorf("second Serve = %v, want ErrAlreadyStarted", err)
+	}
+}
+
+func TestServeWithoutHandler(t *testing.T) {
+	tr, log := newTestTracker(t, Config{Domain: "127.0.0.1"})
+	srv := &http.Server{Addr: "127.0.0.1:0"}
+	startServer(t, tr, func() error { return tr.Serve(srv) })
+
+	fetch(t, http.DefaultClient, tr.GenerateLink("msg-1"))
+	log.wait(t, 1)
+	resp, err := http.Get("http://" + tr.Addr().String() + "/other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown path: status %d, want 404", resp.StatusCode)
+	}
+}
+
+func TestServeAfterStart(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{Domain: "127.0.0.1"})
+	startServer(t, tr, tr.Start)
+	if err := tr.Serve(&http.Server{Addr: "127.0.0.1:0"}); err != ErrAlreadyStarted {
+		t.Errorf("Serve while started = %v, want ErrAlreadyStarted", err)
+	}
+}
+
+// proxyListener stands in for a listener decoding the PROXY protocol: its
+// connections report client as their remote address.
+type proxyListener struct {
+	net.Listener
+	client   net.Addr
+	accepted atomic.Int32
+}
+
+type proxyConn struct {
+	net.Conn
+	client net.Addr
+}
+
+func (c proxyConn) RemoteAddr() net.Addr { return c.client }
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	l.accepted.Add(1)
+	return proxyConn{c, l.client}, nil
+}
+
+func TestStartWithListener(t *testing.T) {
+	inner, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := &proxyListener{Listener: inner, client: &net.TCPAddr{IP: net.ParseIP("198.51.100.7"), Port: 4242}}
+	tr, log := newTestTracker(t, Config{Domain: "127.0.0.1", Port: 8080})
+	startServer(t, tr, func() error { return tr.StartWithListener(l) })
+
+	if tr.Addr() != inner.Addr() {
+		t.Errorf("Addr = %v, want the listener's %v", tr.Addr(), inner.Addr())
+	}
+	link := tr.GenerateLink("msg-1")
+	if !strings.HasPrefix(link, "http://127.0.0.1/pixel?") {
+		t.Errorf("link %q, want Config.Domain without Port or the listener's port", link)
+	}
+	u := must(url.Parse(link))
+	u.Host = inner.Addr().String()
+	fetch(t, http.DefaultClient, u.String())
+	if events := log.wait(t, 1); events[0].ID != "msg-1" || events[0].IP != "198.51.100.7" {
+		t.Errorf("event %+v, want msg-1 from the listener's client address", events[0])
+	}
+	if l.accepted.Load() == 0 {
+		t.Error("the listener accepted no connections")
+	}
+
+	shutdown(t, tr)
+	if c, err := net.Dial("tcp", inner.Addr().String()); err == nil {
+		c.Close()
+		t.Error("listener still accepts connections after Shutdown")
+	}
+}
+
+func TestStartWithListenerFails(t *testing.T) {
+	listen := func() net.Listener {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return l
+	}
+	tr, _ := newTestTracker(t, Config{Domain: "127.0.0.1"})
+	startServer(t, tr, tr.Start)
+	l := listen()
+	if err := tr.StartWithListener(l); err != ErrAlreadyStarted {
+		t.Errorf("StartWithListener after Start = %v, want ErrAlreadyStarted", err)
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Error("a failed StartWithListener left its listener open")
+	}
+
+	noPath := NewTracker(Config{Domain: "127.0.0.1"}, nil)
+	defer noPath.Shutdown(context.Background())
+	l = listen()
+	if err := noPath.StartWithListener(l); err == nil {
+		t.Error("StartWithListener without a Path succeeded")
+	}
+	if _, err := l.Accept(); err == nil {
+		t.Error("a failed StartWithListener left its listener open")
+	}
+}
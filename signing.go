@@ -0,0 +1,59 @@
+package emailtracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+)
+
+// ErrBadSignature is reported through the error hook when a pixel or click
+// link arrives with a missing or wrong signature.
+var ErrBadSignature = errors.New("emailtracker: invalid signature")
+
+// sigParam is the query parameter carrying a link's HMAC signature.
+const sigParam = "sig"
+
+// sigLen is the number of HMAC-SHA256 bytes kept in a link signature.
+const sigLen = 16
+
+// signParts returns the URL-safe signature of parts under key.
+func signParts(key []byte, parts ...string) string {
+	return base64.RawURLEncoding.EncodeToString(macParts(key, parts...))
+}
+
+// verifyParts reports whether sig is the signature of parts under key. The
+// comparison is constant-time.
+func verifyParts(key []byte, sig string, parts ...string) bool {
+	var got [sigLen]byte
+	if base64.RawURLEncoding.DecodedLen(len(sig)) != sigLen {
+		return false
+	}
+	if _, err := base64.RawURLEncoding.Decode(got[:], []byte(sig)); err != nil {
+		return false
+	}
+	return hmac.Equal(got[:], macParts(key, parts...))
+}
+
+// macParts returns the truncated HMAC-SHA256 of parts under key. Each part
+// is length-prefixed so that ("ab", "c") and ("a", "bc") sign differently.
+func macParts(key []byte, parts ...string) []byte {
+	n := 0
+	for _, p := range parts {
+		n += binary.MaxVarintLen64 + len(p)
+	}
+	msg := make([]byte, 0, n)
+	for _, p := range parts {
+		msg = binary.AppendUvarint(msg, uint64(len(p)))
+		msg = append(msg, p...)
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(msg)
+	return mac.Sum(nil)[:sigLen]
+}
+
+// signed reports whether links and requests carry signatures.
+func (t *Tracker) signed() bool {
+	return t.keys != nil
+}
@@ -0,0 +1,96 @@
+package emailtracker
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+// withQuery returns link with its query parameter name set to value, or
+// removed when value is empty.
+func withQuery(t testing.TB, link, name, value string) string {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if value == "" {
+		q.Del(name)
+	} else {
+		q.Set(name, value)
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func TestSignedLinks(t *testing.T) {
+	tr, log := newTestTracker(t, Config{SigningKey: []byte("secret")})
+	errs := recordErrors(tr)
+	link := tr.GenerateLink("msg-1")
+	sig := must(url.Parse(link)).Query().Get(sigParam)
+	if sig == "" {
+		t.Fatalf("link %q carries no %s", link, sigParam)
+	}
+	flipped := []byte(sig)
+	flipped[0] ^= 1
+
+	for _, tt := range []struct {
+		name string
+		link string
+		want int // events
+	}{
+		{"valid", link, 1},
+		{"tampered id", withQuery(t, link, "id", "msg-2"), 0},
+		{"missing sig", withQuery(t, link, sigParam, ""), 0},
+		{"flipped sig", withQuery(t, link, sigParam, string(flipped)), 0},
+		{"truncated sig", withQuery(t, link, sigParam, sig[:len(sig)-1]), 0},
+		{"other key", withQuery(t, link, sigParam, signParts([]byte("other"), "msg-1")), 0},
+	} {
+		before := len(log.all())
+		w := get(tr.Handler(), tt.link)
+		if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" {
+			t.Errorf("%s: status %d, Content-Type %q; want the pixel", tt.name, w.Code, w.Header().Get("Content-Type"))
+		}
+		if got := len(log.all()) - before; got != tt.want {
+			t.Errorf("%s: %d events, want %d", tt.name, got, tt.want)
+		}
+	}
+	got := errs.all()
+	if len(got) != 5 {
+		t.Fatalf("%d errors reported, want 5", len(got))
+	}
+	for _, err := range got {
+		if !errors.Is(err, ErrBadSignature) {
+			t.Errorf("reported %v, want ErrBadSignature", err)
+		}
+	}
+}
+
+func TestRejectInvalidSignatures(t *testing.T) {
+	tr, log := newTestTracker(t, Config{SigningKey: []byte("secret"), RejectInvalidSignatures: true})
+	link := tr.GenerateLink("msg-1")
+	if w := get(tr.Handler(), withQuery(t, link, "id", "msg-2")); w.Code != http.StatusForbidden {
+		t.Errorf("tampered link: status %d, want 403", w.Code)
+	}
+	if w := get(tr.Handler(), link); w.Code != http.StatusOK {
+		t.Errorf("valid link: status %d, want 200", w.Code)
+	}
+	if got := len(log.all()); got != 1 {
+		t.Errorf("%d events, want 1", got)
+	}
+}
+
+func TestVerifyParts(t *testing.T) {
+	key := []byte("secret")
+	sig := signParts(key, "msg-1", "campaign=a")
+	if !verifyParts(key, sig, "msg-1", "campaign=a") {
+		t.Error("signature doesn't verify")
+	}
+	for _, parts := range [][]string{{"msg-1"}, {"msg-1", "campaign=b"}, {"msg-1campaign=a"}, {"msg-1", "campaign=a", ""}} {
+		if verifyParts(key, sig, parts...) {
+			t.Errorf("signature verifies for %q", parts)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package emailtracker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"sync"
+)
+
+// EventSink publishes events to an external system such as a message queue.
+// Implementations must be safe for concurrent use with Close.
+type EventSink interface {
+	// Publish delivers e. ctx is cancelled if Shutdown runs out of time.
+	Publish(ctx context.Context, e OpenEvent) error
+	// Close releases the sink's resources; it is called once by Shutdown
+	// after the sink's queued events have been published.
+	Close() error
+}
+
+// ErrSinkPublish is reported through the error hook for events a sink failed
+// to publish, or dropped because its queue was full.
+var ErrSinkPublish = errors.New("emailtracker: sink publish failed")
+
+// sinkQueueSize is the number of events buffered for each sink.
+const sinkQueueSize = defaultQueueSize
+
+// sinkRunner feeds one sink from its own queue and goroutine, so a slow or
+// failing sink holds up neither the pixel nor the other sinks.
+type sinkRunner struct {
+	sink  EventSink
+	queue chan job
+	done  chan struct{}
+
+	mu     sync.RWMutex
+	closed bool
+}
+
+// AddSink registers s to receive every event that passes the filters,
+// asynchronously. Errors are reported to the OnError hook wrapped in
+// ErrSinkPublish. Shutdown drains the sink's queue and then closes it.
+func (t *Tracker) AddSink(s EventSink) {
+	if s == nil {
+		return
+	}
+	r := &sinkRunner{sink: s, queue: make(chan job, sinkQueueSize), done: make(chan struct{})}
+	name := fmt.Sprintf("%T", s)
+	go func() {
+		defer close(r.done)
+		for j := range r.queue {
+			e := j.e
+			ctx, end := t.startSpan(t.workContext(j.ctx), SpanSinkPublish, slog.String(TraceKeySink, name))
+			err := s.Publish(ctx, e)
+			end(err)
+			if err != nil {
+				t.reportError("sink", fmt.Errorf("%w: %T: %w", ErrSinkPublish, s, err), &e)
+				t.retry(&e, func(ctx context.Context) error { return s.Publish(ctx, e) })
+			}
+		}
+	}()
+	t.subMu.Lock()
+	defer t.subMu.Unlock()
+	sinks := make([]*sinkRunner, len(t.sinks), len(t.sinks)+1)
+	copy(sinks, t.sinks)
+	t.sinks = append(sinks, r)
+}
+
+func (t *Tracker) sinkList() []*sinkRunner {
+	t.subMu.RLock()
+	defer t.subMu.RUnlock()
+	return t.sinks
+}
+
+// publish queues e for every sink. ctx is the context of the delivery.
+func (t *Tracker) publish(ctx context.Context, e OpenEvent) {
+	ctx = t.queueContext(ctx)
+	for _, r := range t.sinkList() {
+		if !r.enqueue(job{ctx, e}) {
+			e := e // only this copy escapes
+			t.reportError("sink", fmt.Errorf("%w: %T: queue full", ErrSinkPublish, r.sink), &e)
+		}
+	}
+}
+
+func (r *sinkRunner) enqueue(j job) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if r.closed {
+		return false
+	}
+	select {
+	case r.queue <- j:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain stops accepting events and waits for the queue to empty.
+func (r *sinkRunner) drain(ctx context.Context) error {
+	r.mu.Lock()
+	if !r.closed {
+		r.closed = true
+		close(r.queue)
+	}
+	r.mu.Unlock()
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// drainSinks waits for every sink's queue to empty.
+func (t *Tracker) drainSinks(ctx context.Context) error {
+	var errs []error
+	for _, r := range t.sinkList() {
+		errs = append(errs, r.drain(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+// closeSinks closes every sink, drained or not.
+func (t *Tracker) closeSinks() error {
+	var errs []error
+	for _, r := range t.sinkList() {
+		errs = append(errs, r.sink.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// JSONSink is an EventSink writing each event as a line of JSON, e.g. to
+// os.Stdout for piping into another tool.
+type JSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	w   io.Writer
+}
+
+// NewJSONSink returns a JSONSink writing to w. Close closes w if it is an
+// io.Closer.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w), w: w}
+}
+
+func (s *JSONSink) Publish(_ context.Context, e OpenEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(e)
+}
+
+func (s *JSONSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
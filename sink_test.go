@@ -0,0 +1,220 @@
+package emailtracker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testSink records what it publishes. With block set, Publish waits until
+// block is closed; with fail set it returns errBroken.
+type testSink struct {
+	block chan struct{}
+	fail  bool
+
+	mu     sync.Mutex
+	closes int
+	log    *eventLog
+}
+
+func newTestSink() *testSink {
+	return &testSink{log: newEventLog()}
+}
+
+func (s *testSink) Publish(ctx context.Context, e OpenEvent) error {
+	if s.block != nil {
+		select {
+		case <-s.block:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	if s.fail {
+		return errBroken
+	}
+	s.log.add(e)
+	return nil
+}
+
+func (s *testSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closes++
+	return nil
+}
+
+func (s *testSink) closed() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closes
+}
+
+// shutdown shuts tr down, failing the test on error.
+func shutdown(t *testing.T, tr *Tracker) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
+
+func TestSinks(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{DropBots: true})
+	a, b := newTestSink(), newTestSink()
+	tr.AddSink(a)
+	tr.AddSink(b)
+	tr.AddSink(nil)
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	get(tr.Handler(), tr.GenerateLink("bot"), "User-Agent", "curl/8.0")
+	get(tr.Handler(), tr.GenerateLink("msg-2"))
+	for name, s := range map[string]*testSink{"first": a, "second": b} {
+		got := s.log.wait(t, 2)
+		if len(got) != 2 || got[0].ID != "msg-1" || got[1].ID != "msg-2" {
+			t.Errorf("%s sink got %v, want msg-1 and msg-2 in order", name, got)
+		}
+	}
+	shutdown(t, tr)
+	if a.closed() != 1 || b.closed() != 1 {
+		t.Errorf("sinks closed %d and %d times, want once", a.closed(), b.closed())
+	}
+}
+
+func TestSinkFailureIsolated(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	errs := recordErrors(tr)
+	stuck := &testSink{block: make(chan struct{}), log: newEventLog()}
+	failing := &testSink{fail: true, log: newEventLog()}
+	ok := newTestSink()
+	tr.AddSink(stuck)
+	tr.AddSink(failing)
+	tr.AddSink(ok)
+
+	const n = 10
+	start := time.Now()
+	for i := range n {
+		get(tr.Handler(), tr.GenerateLink(fmt.Sprintf("msg-%d", i)))
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("pixels took %v behind a stuck sink", d)
+	}
+	if got := ok.log.wait(t, n); len(got) != n {
+		t.Errorf("healthy sink got %d events, want %d", len(got), n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(errs.all()) < n && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	for _, err := range errs.all() {
+		if !errors.Is(err, ErrSinkPublish) || !errors.Is(err, errBroken) || !strings.Contains(err.Error(), "*emailtracker.testSink") {
+			t.Errorf("reported %v, want ErrSinkPublish naming the sink", err)
+		}
+	}
+	if len(errs.all()) != n {
+		t.Errorf("%d errors reported, want one per failed publish", len(errs.all()))
+	}
+
+	close(stuck.block)
+	shutdown(t, tr)
+	if got := stuck.log.all(); len(got) != n {
+		t.Errorf("Shutdown drained %d of the stuck sink's %d events", len(got), n)
+	}
+}
+
+func TestSinkQueueFull(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	errs := recordErrors(tr)
+	stuck := &testSink{block: make(chan struct{}), log: newEventLog()}
+	tr.AddSink(stuck)
+	defer close(stuck.block)
+
+	// The first event is taken off the queue and blocks in Publish.
+	for i := range sinkQueueSize + 3 {
+		get(tr.Handler(), tr.GenerateLink(fmt.Sprintf("msg-%d", i)))
+	}
+	var full int
+	for _, err := range errs.all() {
+		if errors.Is(err, ErrSinkPublish) && strings.Contains(err.Error(), "queue full") {
+			full++
+		}
+	}
+	if full < 2 || full > 3 {
+		t.Errorf("%d queue full errors, want 2 or 3", full)
+	}
+}
+
+func TestShutdownCancelsStuckSink(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	stuck := &testSink{block: make(chan struct{}), log: newEventLog()}
+	tr.AddSink(stuck)
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tr.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want its deadline exceeded", err)
+	}
+	if stuck.closed() != 1 {
+		t.Errorf("stuck sink closed %d times, want once", stuck.closed())
+	}
+}
+
+// closeBuffer is a bytes.Buffer that records being closed.
+type closeBuffer struct {
+	bytes.Buffer
+	closed bool
+}
+
+func (b *closeBuffer) Close() error {
+	b.closed = true
+	return nil
+}
+
+func TestJSONSink(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	var buf closeBuffer
+	tr.AddSink(NewJSONSink(&buf))
+	for _, id := range []string{"msg-1", "msg-2"} {
+		get(tr.Handler(), tr.GenerateLink(id), "User-Agent", "Mail/1.0\nforged: line")
+	}
+	shutdown(t, tr)
+	if !buf.closed {
+		t.Error("Close didn't close the writer")
+	}
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("%d lines, want one per event: %q", len(lines), buf.String())
+	}
+	for i, line := range lines {
+		var e OpenEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("line %d: %v", i, err)
+		}
+		if want := fmt.Sprintf("msg-%d", i+1); e.ID != want || e.UserAgent != "Mail/1.0\nforged: line" {
+			t.Errorf("line %d: ID %q, UserAgent %q", i, e.ID, e.UserAgent)
+		}
+	}
+}
+
+func TestShutdownClosesSinksAfterTimeout(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{Workers: 1})
+	release := make(chan struct{})
+	defer close(release)
+	tr.Subscribe(func(OpenEvent) { <-release }) // ignores its context
+	sink := newTestSink()
+	tr.AddSink(sink)
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := tr.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown = %v, want its deadline exceeded", err)
+	}
+	if sink.closed() != 1 {
+		t.Errorf("sink closed %d times after a stuck subscriber, want once", sink.closed())
+	}
+}
@@ -0,0 +1,136 @@
+package emailtracker
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MetricsSnapshot is a point-in-time copy of the tracker's built-in
+// counters, available without configuring Config.Metrics.
+type MetricsSnapshot struct {
+	TotalRequests     uint64                // pixel, click and unsubscribe requests; not probes, admin or ESP
+	EventsEmitted     uint64                // events handed to the store and subscribers
+	EventsDropped     map[DropReason]uint64 // suppressed events by reason
+	Errors            map[string]uint64     // internal failures by source, as passed to OnError
+	CallbackErrors    uint64                // subscriber errors and panics; Errors["callback"]
+	RetryQueued       int                   // deliveries waiting in the Config.Retry queue
+	PrivacyPrefetches uint64                // emitted opens with PrivacyPrefetch set
+	Since             time.Time             // when counting started: New, or the last ResetMetrics
+	Uptime            time.Duration         // since New
+}
+
+// counters is the Metrics implementation behind Tracker.Metrics. It tees
+// every call to the configured Metrics.
+type counters struct {
+	next       Metrics
+	requests   atomic.Uint64
+	events     atomic.Uint64
+	prefetches atomic.Uint64
+	dropped    sync.Map // DropReason -> *atomic.Uint64
+	errors     sync.Map // string -> *atomic.Uint64
+	since      atomic.Int64
+}
+
+func newCounters(next Metrics) *counters {
+	c := &counters{next: next}
+	c.since.Store(time.Now().UnixNano())
+	return c
+}
+
+func (c *counters) ObserveRequest(route string, status int, d time.Duration) {
+	if recipientRoute(route) {
+		c.requests.Add(1)
+	}
+	c.next.ObserveRequest(route, status, d)
+}
+
+// recipientRoute reports whether route serves recipients' mail clients, and
+// so counts in TotalRequests. Probe, admin and ESP requests would otherwise
+// swamp it.
+func recipientRoute(route string) bool {
+	switch route {
+	case RoutePixel, RouteClick, RouteUnsubscribe:
+		return true
+	}
+	return false
+}
+
+func (c *counters) IncEvent(kind EventKind) {
+	c.events.Add(1)
+	c.next.IncEvent(kind)
+}
+
+func (c *counters) IncDropped(reason DropReason) {
+	counter(&c.dropped, reason).Add(1)
+	c.next.IncDropped(reason)
+}
+
+func (c *counters) IncError(source string) {
+	counter(&c.errors, source).Add(1)
+	c.next.IncError(source)
+}
+
+func counter[K comparable](m *sync.Map, key K) *atomic.Uint64 {
+	if v, ok := m.Load(key); ok {
+		return v.(*atomic.Uint64)
+	}
+	v, _ := m.LoadOrStore(key, new(atomic.Uint64))
+	return v.(*atomic.Uint64)
+}
+
+// snapshot reads every counter, or swaps it with zero when reset is set, so
+// that no increment is lost between consecutive resets.
+func (c *counters) snapshot(reset bool) MetricsSnapshot {
+	read := func(v *atomic.Uint64) uint64 {
+		if reset {
+			return v.Swap(0)
+		}
+		return v.Load()
+	}
+	since := c.since.Load()
+	if reset {
+		since = c.since.Swap(time.Now().UnixNano())
+	}
+	s := MetricsSnapshot{
+		TotalRequests:     read(&c.requests),
+		EventsEmitted:     read(&c.events),
+		PrivacyPrefetches: read(&c.prefetches),
+		EventsDropped:     make(map[DropReason]uint64),
+		Errors:            make(map[string]uint64),
+		Since:             time.Unix(0, since),
+	}
+	c.dropped.Range(func(k, v any) bool {
+		if n := read(v.(*atomic.Uint64)); n > 0 {
+			s.EventsDropped[k.(DropReason)] = n
+		}
+		return true
+	})
+	c.errors.Range(func(k, v any) bool {
+		if n := read(v.(*atomic.Uint64)); n > 0 {
+			s.Errors[k.(string)] = n
+		}
+		return true
+	})
+	s.CallbackErrors = s.Errors["callback"]
+	return s
+}
+
+// Metrics returns a snapshot of the tracker's request, event, drop and error
+// counts. Each counter is read atomically; counters read one after another
+// may reflect slightly different moments under load.
+func (t *Tracker) Metrics() MetricsSnapshot {
+	s := t.counters.snapshot(false)
+	s.Uptime = time.Since(t.created)
+	s.RetryQueued = t.retryQueued()
+	return s
+}
+
+// ResetMetrics returns the same snapshot as Metrics and zeroes the counters,
+// for periodic reporting. Every increment is reported by exactly one call.
+func (t *Tracker) ResetMetrics() MetricsSnapshot {
+	s := t.counters.snapshot(true)
+	s.Uptime = time.Since(t.created)
+	s.RetryQueued = t.retryQueued()
+	return s
+}
@@ -0,0 +1,173 @@
+package emailtracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{DropBots: true, DedupWindow: time.Hour})
+	tr.SubscribeContext(func(_ context.Context, e OpenEvent) error {
+		if e.ID == "fails" {
+			return errors.New("subscriber failed")
+		}
+		return nil
+	})
+	before := time.Now()
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	get(tr.Handler(), tr.GenerateLink("msg-1")) // dedup
+	get(tr.Handler(), tr.GenerateLink("msg-2"), "User-Agent", "curl/8.0")
+	get(tr.Handler(), tr.GenerateLink("fails"))
+
+	m := tr.Metrics()
+	if m.TotalRequests != 4 || m.EventsEmitted != 2 || m.CallbackErrors != 1 || m.Errors["callback"] != 1 {
+		t.Errorf("requests %d, emitted %d, callback errors %d, errors %v", m.TotalRequests, m.EventsEmitted, m.CallbackErrors, m.Errors)
+	}
+	if m.EventsDropped[DropDedup] != 1 || m.EventsDropped[DropBot] != 1 || len(m.EventsDropped) != 2 {
+		t.Errorf("dropped %v, want one dedup and one bot", m.EventsDropped)
+	}
+	if m.Uptime <= 0 || m.Since.After(before) {
+		t.Errorf("Uptime %v, Since %v", m.Uptime, m.Since)
+	}
+
+	// Snapshots are copies.
+	m.EventsDropped[DropBot] = 100
+	if n := tr.Metrics().EventsDropped[DropBot]; n != 1 {
+		t.Errorf("editing a snapshot changed the counter to %d", n)
+	}
+
+	r := tr.ResetMetrics()
+	if r.TotalRequests != 4 || r.EventsDropped[DropDedup] != 1 {
+		t.Errorf("ResetMetrics returned requests %d, dropped %v", r.TotalRequests, r.EventsDropped)
+	}
+	after := tr.Metrics()
+	if after.TotalRequests != 0 || after.EventsEmitted != 0 || len(after.EventsDropped) != 0 || len(after.Errors) != 0 {
+		t.Errorf("after ResetMetrics: %+v", after)
+	}
+	if !after.Since.After(m.Since) || after.Uptime < m.Uptime {
+		t.Errorf("after ResetMetrics Since %v, Uptime %v; before %v, %v", after.Since, after.Uptime, m.Since, m.Uptime)
+	}
+}
+
+// hammer serves n requests from each of workers goroutines, every third
+// one from a dropped bot.
+func hammer(tr *Tracker, workers, n int) {
+	var wg sync.WaitGroup
+	for w := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range n {
+				link := tr.GenerateLink(fmt.Sprintf("msg-%d-%d", w, i))
+				if i%3 == 0 {
+					get(tr.Handler(), link, "User-Agent", "curl/8.0")
+				} else {
+					get(tr.Handler(), link)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestMetricsMonotonic(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{DropBots: true})
+	const workers, n = 8, 200
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var last MetricsSnapshot
+			for {
+				m := tr.Metrics()
+				if m.TotalRequests < last.TotalRequests || m.EventsEmitted < last.EventsEmitted ||
+					m.EventsDropped[DropBot] < last.EventsDropped[DropBot] {
+					t.Errorf("counters went backwards: %+v after %+v", m, last)
+					return
+				}
+				last = m
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+	}
+	hammer(tr, workers, n)
+	close(done)
+	wg.Wait()
+
+	m := tr.Metrics()
+	bots := uint64(workers * ((n + 2) / 3))
+	if m.TotalRequests != workers*n || m.EventsDropped[DropBot] != bots || m.EventsEmitted != workers*n-bots {
+		t.Errorf("requests %d, emitted %d, dropped %v", m.TotalRequests, m.EventsEmitted, m.EventsDropped)
+	}
+}
+
+func TestResetMetricsLosesNothing(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{DropBots: true})
+	const workers, n = 8, 200
+	var (
+		mu           sync.Mutex
+		requests     uint64
+		emitted, bot uint64
+	)
+	add := func(m MetricsSnapshot) {
+		mu.Lock()
+		defer mu.Unlock()
+		requests += m.TotalRequests
+		emitted += m.EventsEmitted
+		bot += m.EventsDropped[DropBot]
+	}
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 3 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				add(tr.ResetMetrics())
+				select {
+				case <-done:
+					return
+				default:
+				}
+			}
+		}()
+	}
+	hammer(tr, workers, n)
+	close(done)
+	wg.Wait()
+	add(tr.ResetMetrics())
+
+	bots := uint64(workers * ((n + 2) / 3))
+	if requests != workers*n || bot != bots || emitted != workers*n-bots {
+		t.Errorf("resets added up to requests %d, emitted %d, bots %d", requests, emitted, bot)
+	}
+}
+
+func TestMetricsSkipProbes(t *testing.T) {
+	for name, metrics := range map[string]Metrics{"builtin": nil, "Metrics": noopMetrics{}} {
+		t.Run(name, func(t *testing.T) {
+			tr, _ := newTestTracker(t, Config{Metrics: metrics, AdminToken: "secret"})
+			for _, h := range []http.Handler{tr.HealthHandler(), tr.ReadyHandler(), tr.AdminHandler(), tr.ESPWebhookHandler()} {
+				get(h, "/probe")
+			}
+			if n := tr.Metrics().TotalRequests; n != 0 {
+				t.Errorf("TotalRequests = %d after probe, admin and ESP requests, want 0", n)
+			}
+			get(tr.Handler(), tr.GenerateLink("msg-1"))
+			if n := tr.Metrics().TotalRequests; n != 1 {
+				t.Errorf("TotalRequests = %d after an open, want 1", n)
+			}
+		})
+	}
+}
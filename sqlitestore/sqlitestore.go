@@ -0,0 +1,421 @@
+// Package sqlitestore provides an emailtracker.Store, with the SeenSet,
+// SendStore, CampaignStore, EventPager and OpenCountStore extensions,
+// backed by SQLite.
+//
+// It works with any database/sql SQLite driver; import one (for example
+// modernc.org/sqlite or github.com/mattn/go-sqlite3) and pass its name to
+// Open, or hand an already opened *sql.DB to New.
+package sqlitestore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS events (
+	seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+	tracking_id TEXT    NOT NULL,
+	kind        TEXT    NOT NULL,
+	ip          TEXT    NOT NULL,
+	user_agent  TEXT    NOT NULL,
+	occurred_at INTEGER NOT NULL, -- Unix nanoseconds
+	data        TEXT    NOT NULL, -- the full event as JSON
+	event_id    TEXT    NOT NULL DEFAULT '',
+	campaign_id TEXT    NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS events_tracking_id ON events (tracking_id, occurred_at);
+CREATE INDEX IF NOT EXISTS events_occurred_at ON events (occurred_at);
+CREATE TABLE IF NOT EXISTS seen (
+	key        TEXT PRIMARY KEY,
+	expires_at INTEGER -- Unix nanoseconds, NULL for never
+) WITHOUT ROWID;
+CREATE TABLE IF NOT EXISTS sends (
+	seq         INTEGER PRIMARY KEY AUTOINCREMENT,
+	tracking_id TEXT    NOT NULL UNIQUE,
+	campaign    TEXT    NOT NULL,
+	recipient   TEXT    NOT NULL,
+	sent_at     INTEGER NOT NULL, -- Unix nanoseconds
+	data        TEXT    NOT NULL  -- the full send as JSON
+);
+CREATE INDEX IF NOT EXISTS sends_campaign ON sends (campaign, sent_at);
+`
+
+// indexes are created once older databases have gained their columns.
+const indexes = `
+CREATE UNIQUE INDEX IF NOT EXISTS events_event_id ON events (event_id) WHERE event_id != '';
+CREATE INDEX IF NOT EXISTS events_campaign_id ON events (campaign_id, occurred_at) WHERE campaign_id != '';
+`
+
+const selectEvents = `SELECT data, occurred_at FROM events `
+
+const selectSends = `SELECT data, sent_at FROM sends `
+
+// pruneEvery is how many Add calls pass between deletions of expired keys.
+const pruneEvery = 1000
+
+// Store is an emailtracker.Store persisting events in an SQLite database.
+// It also implements emailtracker.SeenSet, so trackers in several processes
+// sharing the database file agree on dedup and first opens, as well as
+// emailtracker.SendStore, emailtracker.CampaignStore,
+// emailtracker.EventPager and emailtracker.OpenCountStore.
+type Store struct {
+	db   *sql.DB
+	mu   sync.Mutex // SQLite allows one writer at a time
+	adds int        // Add calls, guarded by mu
+}
+
+// Open opens the database file at path with the named driver, enables WAL
+// journaling and creates the schema if needed.
+func Open(driverName, path string) (*Store, error) {
+	db, err := sql.Open(driverName, path)
+	if err != nil {
+		return nil, err
+	}
+	for _, pragma := range []string{"PRAGMA journal_mode=WAL", "PRAGMA busy_timeout=5000"} {
+		if _, err := db.Exec(pragma); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("sqlitestore: %s: %w", pragma, err)
+		}
+	}
+	s, err := New(db)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// New wraps an open database, creating the schema on first use and adding
+// columns missing from databases created by older versions.
+func New(db *sql.DB) (*Store, error) {
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("sqlitestore: create schema: %w", err)
+	}
+	if err := addColumn(db, "events", "event_id", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return nil, err
+	}
+	if err := addColumn(db, "events", "campaign_id", `TEXT NOT NULL DEFAULT ''`); err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(indexes); err != nil {
+		return nil, fmt.Errorf("sqlitestore: create indexes: %w", err)
+	}
+	return &Store{db: db}, nil
+}
+
+// addColumn adds column to table unless it's already there, filling it in
+// from the JSON data of existing rows.
+func addColumn(db *sql.DB, table, column, decl string) error {
+	var n int
+	if err := db.QueryRow(`SELECT count(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n); err != nil {
+		return fmt.Errorf("sqlitestore: inspect %s: %w", table, err)
+	}
+	if n > 0 {
+		return nil
+	}
+	if _, err := db.Exec(`ALTER TABLE ` + table + ` ADD COLUMN ` + column + ` ` + decl); err != nil {
+		return fmt.Errorf("sqlitestore: add %s.%s: %w", table, column, err)
+	}
+	if _, err := db.Exec(`UPDATE ` + table + ` SET ` + column + ` = coalesce(json_extract(data, '$.` + column + `'), '')`); err != nil {
+		return fmt.Errorf("sqlitestore: fill %s.%s: %w", table, column, err)
+	}
+	return nil
+}
+
+// Save implements emailtracker.Store. An event whose EventID is already
+// stored is skipped, so saving it again, as a retry may, is harmless.
+func (s *Store) Save(e emailtracker.OpenEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err = s.db.Exec(
+		`INSERT INTO events (tracking_id, kind, ip, user_agent, occurred_at, data, event_id, campaign_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+ON CONFLICT (event_id) WHERE event_id != '' DO NOTHING`,
+		e.ID, string(e.Kind), e.IP, e.UserAgent, e.Time.UnixNano(), string(data), e.EventID, e.CampaignID,
+	)
+	return err
+}
+
+// Add implements emailtracker.SeenSet. The key is inserted unless present
+// and unexpired, in one statement, so exactly one caller sees it as new.
+func (s *Store) Add(key string, ttl time.Duration) (bool, error) {
+	now := time.Now().UnixNano()
+	var expires any
+	if ttl > 0 {
+		expires = now + int64(ttl)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res, err := s.db.Exec(`INSERT INTO seen (key, expires_at) VALUES (?, ?)
+ON CONFLICT (key) DO UPDATE SET expires_at = excluded.expires_at WHERE seen.expires_at <= ?`, key, expires, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	if s.adds++; s.adds%pruneEvery == 0 {
+		if _, err := s.db.Exec(`DELETE FROM seen WHERE expires_at <= ?`, now); err != nil {
+			return false, err
+		}
+	}
+	return n == 0, nil
+}
+
+// ByID implements emailtracker.Store.
+func (s *Store) ByID(id string) ([]emailtracker.OpenEvent, error) {
+	return s.query(selectEvents+`WHERE tracking_id = ? ORDER BY occurred_at, seq`, id)
+}
+
+// CountOpens implements emailtracker.OpenCountStore over the tracking_id
+// index.
+func (s *Store) CountOpens(id string, limit int) (int, error) {
+	var n int
+	err := s.db.QueryRow(`SELECT count(*) FROM (SELECT 1 FROM events WHERE tracking_id = ? AND kind IN ('open', '') LIMIT ?)`,
+		id, limit).Scan(&n)
+	return n, err
+}
+
+// Range returns the events that occurred in [from, to), oldest first.
+func (s *Store) Range(from, to time.Time) ([]emailtracker.OpenEvent, error) {
+	return s.query(selectEvents+`WHERE occurred_at >= ? AND occurred_at < ? ORDER BY occurred_at, seq`,
+		from.UnixNano(), to.UnixNano())
+}
+
+// PageByID implements emailtracker.EventPager. Cursors are row sequence
+// numbers.
+func (s *Store) PageByID(id, cursor string, limit int) ([]emailtracker.OpenEvent, string, error) {
+	var after int64
+	if cursor != "" {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n < 0 {
+			return nil, "", emailtracker.ErrBadCursor
+		}
+		after = n
+	}
+	rows, err := s.db.Query(`SELECT data, occurred_at, seq FROM events WHERE tracking_id = ? AND seq > ? ORDER BY seq LIMIT ?`,
+		id, after, limit+1)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+	var out []emailtracker.OpenEvent
+	var seqs []int64
+	for rows.Next() {
+		var data string
+		var ns, seq int64
+		if err := rows.Scan(&data, &ns, &seq); err != nil {
+			return nil, "", err
+		}
+		var e emailtracker.OpenEvent
+		if err := json.Unmarshal([]byte(data), &e); err != nil {
+			return nil, "", err
+		}
+		e.Time = time.Unix(0, ns).In(e.Time.Location())
+		out, seqs = append(out, e), append(seqs, seq)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if len(out) <= limit {
+		return out, "", nil
+	}
+	return out[:limit], strconv.FormatInt(seqs[limit-1], 10), nil
+}
+
+// Each implements emailtracker.Store, streaming rows in insertion order.
+func (s *Store) Each(fn func(emailtracker.OpenEvent) bool) error {
+	rows, err := s.db.Query(selectEvents + `ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return err
+		}
+		if !fn(e) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// SaveSend implements emailtracker.SendStore.
+func (s *Store) SaveSend(send emailtracker.Send) (bool, error) {
+	data, err := json.Marshal(send)
+	if err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var n int
+	if err := s.db.QueryRow(`SELECT count(*) FROM sends WHERE tracking_id = ?`, send.ID).Scan(&n); err != nil {
+		return false, err
+	}
+	_, err = s.db.Exec(`INSERT INTO sends (tracking_id, campaign, recipient, sent_at, data) VALUES (?, ?, ?, ?, ?)
+ON CONFLICT (tracking_id) DO UPDATE SET campaign = excluded.campaign, recipient = excluded.recipient,
+	sent_at = excluded.sent_at, data = excluded.data`,
+		send.ID, send.Campaign, send.Recipient, send.SentAt.UnixNano(), string(data))
+	return n == 0, err
+}
+
+// SendByID implements emailtracker.SendStore.
+func (s *Store) SendByID(id string) (emailtracker.Send, bool, error) {
+	rows, err := s.db.Query(selectSends+`WHERE tracking_id = ?`, id)
+	if err != nil {
+		return emailtracker.Send{}, false, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return emailtracker.Send{}, false, rows.Err()
+	}
+	send, err := scanSend(rows)
+	return send, err == nil, err
+}
+
+// EachSend implements emailtracker.SendStore.
+func (s *Store) EachSend(fn func(emailtracker.Send) bool) error {
+	rows, err := s.db.Query(selectSends + `ORDER BY seq`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		send, err := scanSend(rows)
+		if err != nil {
+			return err
+		}
+		if !fn(send) {
+			return nil
+		}
+	}
+	return rows.Err()
+}
+
+// CampaignReport implements emailtracker.CampaignStore, aggregating in
+// SQL over the campaign_id index.
+func (s *Store) CampaignReport(campaign string, window emailtracker.TimeRange) (emailtracker.CampaignReport, error) {
+	rep := emailtracker.CampaignReport{OpensByDay: make(map[string]int)}
+	from, to := int64(math.MinInt64), int64(math.MaxInt64)
+	if !window.From.IsZero() {
+		from = window.From.UnixNano()
+	}
+	if !window.To.IsZero() {
+		to = window.To.UnixNano()
+	}
+	if err := s.db.QueryRow(`SELECT count(*) FROM sends WHERE campaign = ? AND sent_at >= ? AND sent_at < ?`,
+		campaign, from, to).Scan(&rep.Sends); err != nil {
+		return rep, err
+	}
+	const opens = `FROM events WHERE campaign_id = ? AND kind IN ('open', '') AND occurred_at >= ? AND occurred_at < ? `
+	if err := s.db.QueryRow(`SELECT count(*), count(DISTINCT tracking_id) `+opens, campaign, from, to).
+		Scan(&rep.TotalOpens, &rep.UniqueOpens); err != nil {
+		return rep, err
+	}
+	days, err := counts(s.db, `SELECT strftime('%Y-%m-%d', occurred_at / 1000000000, 'unixepoch'), count(*) `+opens+
+		`GROUP BY 1`, campaign, from, to)
+	if err != nil {
+		return rep, err
+	}
+	for _, d := range days {
+		rep.OpensByDay[d.Name] = d.Opens
+	}
+	for _, top := range []struct {
+		field string
+		dst   *[]emailtracker.NameCount
+	}{
+		{"$.email_client", &rep.TopEmailClients},
+		{"$.geo.country", &rep.TopCountries},
+	} {
+		*top.dst, err = counts(s.db, `SELECT json_extract(data, '`+top.field+`') AS name, count(*) AS n `+opens+
+			`AND coalesce(name, '') != '' GROUP BY name ORDER BY n DESC, name LIMIT `+strconv.Itoa(emailtracker.CampaignTopN),
+			campaign, from, to)
+		if err != nil {
+			return rep, err
+		}
+	}
+	return rep, nil
+}
+
+// counts runs a query of name and count pairs.
+func counts(db *sql.DB, q string, args ...any) ([]emailtracker.NameCount, error) {
+	rows, err := db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []emailtracker.NameCount
+	for rows.Next() {
+		var c emailtracker.NameCount
+		if err := rows.Scan(&c.Name, &c.Opens); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// Close closes the underlying database.
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+func (s *Store) query(q string, args ...any) ([]emailtracker.OpenEvent, error) {
+	rows, err := s.db.Query(q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []emailtracker.OpenEvent
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+func scanEvent(rows *sql.Rows) (emailtracker.OpenEvent, error) {
+	var data string
+	var ns int64
+	var e emailtracker.OpenEvent
+	if err := rows.Scan(&data, &ns); err != nil {
+		return e, err
+	}
+	if err := json.Unmarshal([]byte(data), &e); err != nil {
+		return e, err
+	}
+	// The JSON time has millisecond precision; occurred_at is exact.
+	e.Time = time.Unix(0, ns).In(e.Time.Location())
+	return e, nil
+}
+
+func scanSend(rows *sql.Rows) (emailtracker.Send, error) {
+	var data string
+	var ns int64
+	var send emailtracker.Send
+	if err := rows.Scan(&data, &ns); err != nil {
+		return send, err
+	}
+	if err := json.Unmarshal([]byte(data), &send); err != nil {
+		return send, err
+	}
+	send.SentAt = time.Unix(0, ns).In(send.SentAt.Location())
+	return send, nil
+}
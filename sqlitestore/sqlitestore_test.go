@@ -0,0 +1,169 @@
+package sqlitestore
+
+import (
+	"context"
+	"net/http/httptest"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+	_ "modernc.org/sqlite"
+)
+
+var t0 = time.Date(2026, 3, 1, 12, 0, 0, 123456789, time.UTC)
+
+// open opens a store on path, closing it when the test ends.
+func open(t *testing.T, path string) *Store {
+	t.Helper()
+	s, err := Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func ids(events []emailtracker.OpenEvent) []string {
+	var out []string
+	for _, e := range events {
+		out = append(out, e.EventID)
+	}
+	return out
+}
+
+func TestSaveAndQuery(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "events.db"))
+	for _, e := range []emailtracker.OpenEvent{
+		{EventID: "e1", ID: "msg-1", Kind: emailtracker.EventOpen, Time: t0.Add(2 * time.Minute), IP: "203.0.113.7"},
+		{EventID: "e2", ID: "msg-2", Kind: emailtracker.EventOpen, Time: t0.Add(time.Minute)},
+		{EventID: "e3", ID: "msg-1", Kind: emailtracker.EventClick, Time: t0},
+		{EventID: "e4", ID: "msg-1", Time: t0.Add(3 * time.Minute), Metadata: map[string]string{"campaign": "spring"}},
+		{EventID: "e1", ID: "msg-1", Kind: emailtracker.EventOpen, Time: t0.Add(2 * time.Minute)}, // a retry
+	} {
+		if err := s.Save(e); err != nil {
+			t.Fatalf("Save %s: %v", e.EventID, err)
+		}
+	}
+
+	got, err := s.ByID("msg-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e3", "e1", "e4"}; !slices.Equal(ids(got), want) {
+		t.Fatalf("ByID = %v, want %v, oldest first", ids(got), want)
+	}
+	if !got[1].Time.Equal(t0.Add(2*time.Minute)) || got[1].IP != "203.0.113.7" {
+		t.Errorf("event e1 came back as %+v", got[1])
+	}
+	if got[2].Metadata["campaign"] != "spring" {
+		t.Errorf("Metadata %v lost", got[2].Metadata)
+	}
+
+	inRange, err := s.Range(t0.Add(time.Minute), t0.Add(3*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e2", "e1"}; !slices.Equal(ids(inRange), want) {
+		t.Errorf("Range = %v, want %v", ids(inRange), want)
+	}
+
+	var all []string
+	if err := s.Each(func(e emailtracker.OpenEvent) bool { all = append(all, e.EventID); return true }); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"e1", "e2", "e3", "e4"}; !slices.Equal(all, want) {
+		t.Errorf("Each = %v, want %v in insertion order", all, want)
+	}
+
+	for limit, want := range map[int]int{10: 2, 1: 1} {
+		if n, err := s.CountOpens("msg-1", limit); err != nil || n != want {
+			t.Errorf("CountOpens(limit %d) = %d, %v; want %d", limit, n, err, want)
+		}
+	}
+}
+
+func TestRestartRecovery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.db")
+	s, err := Open("sqlite", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr, err := emailtracker.New(emailtracker.Config{Domain: "tracker.test", Path: "/pixel", Store: s, TrackFirstOpen: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tr.GenerateLink("msg-1"), nil))
+	tr.Shutdown(context.Background())
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	s = open(t, path)
+	got, err := s.ByID("msg-1")
+	if err != nil || len(got) != 1 || !got[0].FirstOpen {
+		t.Fatalf("after reopening ByID = %+v, %v; want the first open", got, err)
+	}
+	tr, err = emailtracker.New(emailtracker.Config{Domain: "tracker.test", Path: "/pixel", Store: s, TrackFirstOpen: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Shutdown(context.Background())
+	var first []bool
+	tr.Subscribe(func(e emailtracker.OpenEvent) { first = append(first, e.FirstOpen) })
+	tr.Handler().ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tr.GenerateLink("msg-1"), nil))
+	if len(first) != 1 || first[0] {
+		t.Errorf("FirstOpen after restart = %v, want false", first)
+	}
+}
+
+func TestConcurrentWrites(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "events.db"))
+	const writers, each = 8, 25
+	var wg sync.WaitGroup
+	var firsts atomic.Int32
+	for w := range writers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range each {
+				if err := s.Save(emailtracker.OpenEvent{ID: "msg-1", Kind: emailtracker.EventOpen, Time: t0.Add(time.Duration(w*each + i))}); err != nil {
+					t.Errorf("Save: %v", err)
+					return
+				}
+			}
+			seen, err := s.Add("first:msg-1", 0)
+			if err != nil {
+				t.Errorf("Add: %v", err)
+			} else if !seen {
+				firsts.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if got, _ := s.ByID("msg-1"); len(got) != writers*each {
+		t.Errorf("%d events stored, want %d", len(got), writers*each)
+	}
+	if n := firsts.Load(); n != 1 {
+		t.Errorf("%d writers saw the key as new, want 1", n)
+	}
+}
+
+func TestSeenSetTTL(t *testing.T) {
+	s := open(t, filepath.Join(t.TempDir(), "events.db"))
+	for i, want := range []bool{false, true} {
+		if seen, err := s.Add("dedup:a", time.Hour); err != nil || seen != want {
+			t.Errorf("Add %d = %v, %v; want %v", i, seen, err, want)
+		}
+	}
+	if seen, _ := s.Add("dedup:b", time.Nanosecond); seen {
+		t.Error("new key reported seen")
+	}
+	time.Sleep(time.Millisecond)
+	if seen, _ := s.Add("dedup:b", time.Hour); seen {
+		t.Error("expired key still reported seen")
+	}
+}
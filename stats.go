@@ -0,0 +1,78 @@
+package emailtracker
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrNoStore is returned by methods that need Config.Store when none is set.
+var ErrNoStore = errors.New("emailtracker: no Store configured")
+
+// OpenStats summarizes the opens recorded for one tracking ID.
+type OpenStats struct {
+	TotalOpens  int            `json:"total_opens"`
+	UniqueIPs   int            `json:"unique_ips"`
+	FirstOpenAt time.Time      `json:"first_open_at,omitzero"`
+	LastOpenAt  time.Time      `json:"last_open_at,omitzero"`
+	PerDay      map[string]int `json:"per_day,omitempty"` // opens per UTC day, keyed "2006-01-02"
+
+	// Sends is 1 when the ID was registered by RegisterSend, with its
+	// SentAt in SentAt.
+	Sends  int       `json:"sends"`
+	SentAt time.Time `json:"sent_at,omitzero"`
+}
+
+// OpenRate returns the fraction of the ID's sends that were opened: 1 for
+// a registered ID with opens, otherwise 0.
+func (s OpenStats) OpenRate() float64 {
+	if s.Sends == 0 || s.TotalOpens == 0 {
+		return 0
+	}
+	return 1
+}
+
+// Stats aggregates the stored opens for id. Unknown IDs yield zero stats.
+func (t *Tracker) Stats(id string) (OpenStats, error) {
+	if t.config.Store == nil {
+		return OpenStats{}, ErrNoStore
+	}
+	events, err := t.config.Store.ByID(id)
+	if err != nil {
+		return OpenStats{}, err
+	}
+	st := aggregateOpens(events)
+	send, ok, err := t.LookupSend(id)
+	if err != nil {
+		return OpenStats{}, err
+	}
+	if ok {
+		st.Sends, st.SentAt = 1, send.SentAt
+	}
+	return st, nil
+}
+
+func aggregateOpens(events []OpenEvent) OpenStats {
+	var st OpenStats
+	ips := make(map[string]struct{})
+	for _, e := range events {
+		if e.Kind != EventOpen && e.Kind != "" {
+			continue
+		}
+		st.TotalOpens++
+		if e.IP != "" {
+			ips[e.IP] = struct{}{}
+		}
+		if st.FirstOpenAt.IsZero() || e.Time.Before(st.FirstOpenAt) {
+			st.FirstOpenAt = e.Time
+		}
+		if e.Time.After(st.LastOpenAt) {
+			st.LastOpenAt = e.Time
+		}
+		if st.PerDay == nil {
+			st.PerDay = make(map[string]int)
+		}
+		st.PerDay[e.Time.UTC().Format(time.DateOnly)]++
+	}
+	st.UniqueIPs = len(ips)
+	return st
+}
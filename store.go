@@ -0,0 +1,78 @@
+package emailtracker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+)
+
+// ErrStoreWrite and ErrStoreRead wrap errors returned by Config.Store when
+// they are reported through the error hook.
+var (
+	ErrStoreWrite = errors.New("emailtracker: store write failed")
+	ErrStoreRead  = errors.New("emailtracker: store read failed")
+)
+
+// Store persists events. When Config.Store is set, the tracker saves every
+// event before handing it to subscribers. Implementations must be safe for
+// concurrent use.
+type Store interface {
+	// Save records an event.
+	Save(e OpenEvent) error
+	// ByID returns the events recorded for a tracking ID, oldest first.
+	ByID(id string) ([]OpenEvent, error)
+	// Each calls fn for every stored event, oldest first, until fn returns
+	// false.
+	Each(fn func(OpenEvent) bool) error
+}
+
+// OpenCountStore is an optional extension of Store. If the configured Store
+// implements it, MaxOpensPerID and TrackFirstOpen count an ID's stored
+// opens without loading its events, as MemoryStore and the sqlitestore and
+// pgstore stores do; otherwise they read ByID.
+type OpenCountStore interface {
+	// CountOpens returns how many events of kind EventOpen, or of no kind,
+	// are recorded for id, counting at most limit of them.
+	CountOpens(id string, limit int) (int, error)
+}
+
+// storedOpens counts, up to limit, the opens stored for id.
+func (t *Tracker) storedOpens(id string, limit int) (int, error) {
+	if s, ok := t.config.Store.(OpenCountStore); ok {
+		return s.CountOpens(id, limit)
+	}
+	prior, err := t.config.Store.ByID(id)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, p := range prior {
+		if n < limit && (p.Kind == EventOpen || p.Kind == "") {
+			n++
+		}
+	}
+	return n, nil
+}
+
+// save persists e, reporting failures through the error hook.
+func (t *Tracker) save(ctx context.Context, e *OpenEvent) {
+	if t.config.Store == nil {
+		return
+	}
+	_, end := t.startSpan(ctx, SpanStoreSave)
+	err := t.config.Store.Save(*e)
+	end(err)
+	if err != nil {
+		t.reportError("store", fmt.Errorf("%w: %w", ErrStoreWrite, err), e)
+		ev := *e
+		t.retry(e, func(context.Context) error { return t.config.Store.Save(ev) })
+	}
+}
+
+// cloneEvent returns a copy of e that shares no maps with it.
+func cloneEvent(e OpenEvent) OpenEvent {
+	e.Metadata = maps.Clone(e.Metadata)
+	e.Params = maps.Clone(e.Params)
+	return e
+}
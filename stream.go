@@ -0,0 +1,132 @@
+package emailtracker
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamHeartbeat = 15 * time.Second
+	streamBuffer    = 64 // events queued per client before it starts missing some
+)
+
+// stream fans events out to connected StreamHandler clients.
+type stream struct {
+	mu      sync.Mutex
+	clients map[chan OpenEvent]struct{}
+	closed  bool
+	done    chan struct{} // closed on shutdown, ending every stream
+}
+
+func newStream() *stream {
+	return &stream{clients: make(map[chan OpenEvent]struct{}), done: make(chan struct{})}
+}
+
+func (s *stream) subscribe() (chan OpenEvent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return nil, false
+	}
+	ch := make(chan OpenEvent, streamBuffer)
+	s.clients[ch] = struct{}{}
+	return ch, true
+}
+
+func (s *stream) unsubscribe(ch chan OpenEvent) {
+	s.mu.Lock()
+	delete(s.clients, ch)
+	s.mu.Unlock()
+}
+
+// publish offers e to every client without waiting: a client whose buffer
+// is full misses the event rather than holding up the tracker.
+func (s *stream) publish(e OpenEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ch := range s.clients {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (s *stream) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.done)
+	}
+}
+
+// StreamHandler serves live events as Server-Sent Events: each event is sent
+// as JSON under its kind ("open" or "click"), and a comment line goes out
+// every 15 seconds to keep proxies from timing the connection out. Clients
+// authenticate with Config.StreamToken, as a bearer token or a "token" query
+// parameter. Streams end when the tracker shuts down.
+func (t *Tracker) StreamHandler() http.HandlerFunc {
+	return t.logAccess(func(w http.ResponseWriter, r *http.Request) {
+		if t.stream == nil {
+			http.NotFound(w, r)
+			return
+		}
+		if !t.streamAuthorized(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		ch, ok := t.stream.subscribe()
+		if !ok {
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+		defer t.stream.unsubscribe(ch)
+
+		rc := http.NewResponseController(w)
+		rc.SetWriteDeadline(time.Time{}) // the stream outlives any server write timeout
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("X-Accel-Buffering", "no")
+		w.WriteHeader(http.StatusOK)
+		if rc.Flush() != nil {
+			return
+		}
+		heartbeat := time.NewTicker(streamHeartbeat)
+		defer heartbeat.Stop()
+		for {
+			var err error
+			select {
+			case <-r.Context().Done():
+				return
+			case <-t.stream.done:
+				return
+			case e := <-ch:
+				var data []byte
+				if data, err = json.Marshal(e); err == nil {
+					_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Kind, data)
+				}
+			case <-heartbeat.C:
+				_, err = io.WriteString(w, ": ping\n\n")
+			}
+			if err != nil || rc.Flush() != nil {
+				return
+			}
+		}
+	})
+}
+
+func (t *Tracker) streamAuthorized(r *http.Request) bool {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(t.config.StreamToken)) == 1
+}
@@ -0,0 +1,220 @@
+package emailtracker
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// openStream connects to the StreamHandler at url and waits until the
+// tracker has registered the client.
+func openStream(t *testing.T, tr *Tracker, url string) *http.Response {
+	t.Helper()
+	want := streamClients(tr) + 1
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s: %v", url, err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d", url, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type %q", ct)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for streamClients(tr) < want {
+		if time.Now().After(deadline) {
+			t.Fatal("stream client never registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return resp
+}
+
+// streamClients reports how many clients t is streaming to.
+func streamClients(t *Tracker) int {
+	t.stream.mu.Lock()
+	defer t.stream.mu.Unlock()
+	return len(t.stream.clients)
+}
+
+// sseEvent is one event read off a stream.
+type sseEvent struct {
+	name string
+	data OpenEvent
+}
+
+// readEvents reads n events from r, skipping heartbeats.
+func readEvents(t *testing.T, r *bufio.Reader, n int) []sseEvent {
+	t.Helper()
+	var events []sseEvent
+	var cur sseEvent
+	for len(events) < n {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("after %d events: %v", len(events), err)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case line == "":
+			if cur.name != "" {
+				events = append(events, cur)
+			}
+			cur = sseEvent{}
+		case strings.HasPrefix(line, "event: "):
+			cur.name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &cur.data); err != nil {
+				t.Fatalf("data %q: %v", line, err)
+			}
+		}
+	}
+	return events
+}
+
+func TestStreamOrder(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{StreamToken: "s3cret"})
+	srv := httptest.NewServer(tr.StreamHandler())
+	defer srv.Close()
+	resp := openStream(t, tr, srv.URL+"?token=s3cret")
+	defer resp.Body.Close()
+
+	const n = 20
+	for i := range n {
+		get(tr.Handler(), tr.GenerateLink(fmt.Sprintf("msg-%d", i)))
+	}
+	for i, e := range readEvents(t, bufio.NewReader(resp.Body), n) {
+		if want := fmt.Sprintf("msg-%d", i); e.name != "open" || e.data.ID != want {
+			t.Errorf("event %d: %s %q, want open %q", i, e.name, e.data.ID, want)
+		}
+	}
+}
+
+func TestStreamSlowClient(t *testing.T) {
+	tr, log := newTestTracker(t, Config{StreamToken: "s3cret"})
+	srv := httptest.NewServer(tr.StreamHandler())
+	defer srv.Close()
+	// A client that never reads: its buffer fills and it misses events,
+	// but the pixel must keep answering.
+	stalled := openStream(t, tr, srv.URL+"?token=s3cret")
+	defer stalled.Body.Close()
+
+	const n = streamBuffer * 4
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range n {
+			get(tr.Handler(), tr.GenerateLink(fmt.Sprintf("msg-%d", i)))
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("pixel blocked behind a stalled stream client")
+	}
+	if got := len(log.all()); got != n {
+		t.Errorf("subscriber got %d events, want %d", got, n)
+	}
+
+	// A client connecting afterwards still gets new events.
+	fresh := openStream(t, tr, srv.URL+"?token=s3cret")
+	defer fresh.Body.Close()
+	get(tr.Handler(), tr.GenerateLink("late"))
+	if e := readEvents(t, bufio.NewReader(fresh.Body), 1)[0]; e.data.ID != "late" {
+		t.Errorf("fresh client got %q, want late", e.data.ID)
+	}
+}
+
+func TestStreamDisconnect(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{StreamToken: "s3cret"})
+	srv := httptest.NewServer(tr.StreamHandler())
+	defer srv.Close()
+	resp := openStream(t, tr, srv.URL+"?token=s3cret")
+	resp.Body.Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for streamClients(tr) != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("disconnected client still registered")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	get(tr.Handler(), tr.GenerateLink("msg-1")) // publishing to nobody is fine
+}
+
+func TestStreamShutdown(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{StreamToken: "s3cret"})
+	srv := httptest.NewServer(tr.StreamHandler())
+	defer srv.Close()
+	resp := openStream(t, tr, srv.URL+"?token=s3cret")
+	defer resp.Body.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tr.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if _, err := io.ReadAll(resp.Body); err != nil {
+		t.Errorf("stream didn't end cleanly: %v", err)
+	}
+	if resp, err := http.Get(srv.URL + "?token=s3cret"); err != nil {
+		t.Errorf("GET after Shutdown: %v", err)
+	} else if resp.Body.Close(); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("status after Shutdown %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+}
+
+func TestStreamAuth(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{StreamToken: "s3cret"})
+	for _, c := range []struct {
+		name   string
+		target string
+		header []string
+		want   int
+	}{
+		{name: "none", target: "/events", want: http.StatusUnauthorized},
+		{name: "wrong query", target: "/events?token=guess", want: http.StatusUnauthorized},
+		{name: "wrong bearer", target: "/events", header: []string{"Authorization", "Bearer guess"}, want: http.StatusUnauthorized},
+		{name: "basic", target: "/events", header: []string{"Authorization", "Basic czNjcmV0"}, want: http.StatusUnauthorized},
+	} {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, c.target, nil)
+		for i := 0; i+1 < len(c.header); i += 2 {
+			r.Header.Set(c.header[i], c.header[i+1])
+		}
+		tr.StreamHandler().ServeHTTP(w, r)
+		if w.Code != c.want {
+			t.Errorf("%s: status %d, want %d", c.name, w.Code, c.want)
+		}
+		if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") != "Bearer" {
+			t.Errorf("%s: WWW-Authenticate %q", c.name, w.Header().Get("WWW-Authenticate"))
+		}
+	}
+
+	srv := httptest.NewServer(tr.StreamHandler())
+	defer srv.Close()
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("bearer token: status %d", resp.StatusCode)
+	}
+}
+
+func TestStreamDisabled(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	if w := get(tr.StreamHandler(), "/events"); w.Code != http.StatusNotFound {
+		t.Errorf("status %d without StreamToken, want 404", w.Code)
+	}
+}
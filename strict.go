@@ -0,0 +1,72 @@
+package emailtracker
+
+import "sync"
+
+// Drop reasons for requests rejected by StrictIDs.
+const (
+	DropMissingID DropReason = "missing_id"
+	DropUnknownID DropReason = "unknown_id"
+)
+
+// IDRegistry remembers the IDs links were generated for, so that with
+// StrictIDs the handler can reject IDs it never issued. Implementations must
+// be safe for concurrent use; back one with a database to survive restarts.
+type IDRegistry interface {
+	Register(id string)
+	Known(id string) bool
+}
+
+// MemoryIDRegistry is an in-memory IDRegistry. It is unbounded and forgets
+// everything on restart, so links sent before a restart become unknown.
+type MemoryIDRegistry struct {
+	mu  sync.RWMutex
+	ids map[string]struct{}
+}
+
+// NewMemoryIDRegistry returns an empty MemoryIDRegistry.
+func NewMemoryIDRegistry() *MemoryIDRegistry {
+	return &MemoryIDRegistry{ids: make(map[string]struct{})}
+}
+
+func (m *MemoryIDRegistry) Register(id string) {
+	m.mu.Lock()
+	m.ids[id] = struct{}{}
+	m.mu.Unlock()
+}
+
+func (m *MemoryIDRegistry) Known(id string) bool {
+	m.mu.RLock()
+	_, ok := m.ids[id]
+	m.mu.RUnlock()
+	return ok
+}
+
+// register records id with the IDRegistry, if any.
+func (t *Tracker) register(id string) {
+	if t.config.IDRegistry != nil {
+		t.config.IDRegistry.Register(id)
+	}
+}
+
+// rejectID applies StrictIDs to id, returning the drop reason if it must not
+// produce an event.
+func (t *Tracker) rejectID(id string) (DropReason, bool) {
+	switch {
+	case !t.config.StrictIDs:
+		return "", false
+	case id == "":
+		return DropMissingID, true
+	case (t.config.IDRegistry != nil || t.config.KnownSends) && !t.knownID(id):
+		return DropUnknownID, true
+	}
+	return "", false
+}
+
+// knownID reports whether the IDRegistry or, with KnownSends, the send
+// registry knows id.
+func (t *Tracker) knownID(id string) bool {
+	if t.config.IDRegistry != nil && t.config.IDRegistry.Known(id) {
+		return true
+	}
+	return t.config.KnownSends && t.sendKnown(id)
+}
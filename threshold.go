@@ -0,0 +1,230 @@
+package emailtracker
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// ThresholdKind selects the condition a ThresholdRule watches.
+type ThresholdKind string
+
+const (
+	// ThresholdEvents alerts on more than Limit events in a Window.
+	ThresholdEvents ThresholdKind = "events"
+	// ThresholdIDEvents alerts on more than Limit events for a single ID in
+	// a Window.
+	ThresholdIDEvents ThresholdKind = "id_events"
+	// ThresholdSilence alerts on no events for a Window while the server
+	// is up.
+	ThresholdSilence ThresholdKind = "silence"
+)
+
+const (
+	defaultThresholdWindow   = time.Minute
+	defaultThresholdCooldown = 10 * time.Minute
+
+	// silenceChecks is how many times per Window a ThresholdSilence rule
+	// is evaluated, so silence is noticed soon after it lasts a Window.
+	silenceChecks = 4
+)
+
+// ThresholdRule is a condition for OnThreshold.
+type ThresholdRule struct {
+	Name     string // identifies the rule in its Alerts
+	Kind     ThresholdKind
+	Limit    int           // alert above this many events; unused by ThresholdSilence
+	Window   time.Duration // default one minute
+	Cooldown time.Duration // least time between two Alerts, default ten minutes
+}
+
+// Alert reports that a ThresholdRule was met.
+type Alert struct {
+	Rule   ThresholdRule
+	Time   time.Time
+	Since  time.Time // start of the window counted, or of the silence
+	Events int       // events counted since Since
+	ID     string    // the busiest ID, for ThresholdIDEvents
+}
+
+// alerter evaluates one ThresholdRule on its own goroutine.
+type alerter struct {
+	t    *Tracker
+	rule ThresholdRule
+	fn   func(Alert)
+
+	mu  sync.Mutex
+	ids map[string]int // events per ID in the window, for ThresholdIDEvents
+
+	// touched only by run
+	events  uint64    // t.counters.events at the last check
+	start   time.Time // start of the window
+	quiet   time.Time // since when no events were seen with the server up
+	alerted time.Time // time of the last Alert
+
+	stopMu sync.Mutex
+	closed bool
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// OnThreshold registers fn to receive an Alert whenever rule is met: more
+// than rule.Limit events, overall or for one ID, in a Window, or none for a
+// Window while the server is up. Rates are counted over consecutive
+// windows from the built-in counters behind Metrics. fn runs on a
+// dedicated goroutine, at most once per rule.Cooldown, until Shutdown. It
+// panics for an unknown rule.Kind.
+func (t *Tracker) OnThreshold(rule ThresholdRule, fn func(Alert)) {
+	switch rule.Kind {
+	case ThresholdEvents, ThresholdIDEvents, ThresholdSilence:
+	default:
+		panic(fmt.Sprintf("emailtracker: OnThreshold: unknown kind %q", rule.Kind))
+	}
+	if fn == nil {
+		return
+	}
+	if rule.Window <= 0 {
+		rule.Window = defaultThresholdWindow
+	}
+	if rule.Cooldown <= 0 {
+		rule.Cooldown = defaultThresholdCooldown
+	}
+	a := &alerter{
+		t:      t,
+		rule:   rule,
+		fn:     fn,
+		events: t.counters.events.Load(),
+		start:  t.now(),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	t.batchMu.Lock()
+	t.alerters = append(t.alerters, a)
+	t.batchMu.Unlock()
+	if rule.Kind == ThresholdIDEvents {
+		a.ids = make(map[string]int)
+		t.SubscribeContext(func(_ context.Context, e OpenEvent) error {
+			a.add(e.ID)
+			return nil
+		})
+	}
+	go a.run()
+}
+
+func (a *alerter) add(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.ids[id]; ok || len(a.ids) < defaultMaxKeys {
+		a.ids[id]++
+	}
+}
+
+func (a *alerter) run() {
+	defer close(a.done)
+	period := a.rule.Window
+	if a.rule.Kind == ThresholdSilence {
+		period = max(period/silenceChecks, time.Millisecond)
+	}
+	tick, stop := a.t.newTicker(period)
+	defer stop()
+	for {
+		select {
+		case <-tick:
+			a.check()
+		case <-a.stop:
+			return
+		}
+	}
+}
+
+// check evaluates the rule once, alerting if it is met and out of its
+// cooldown.
+func (a *alerter) check() {
+	now := a.t.now()
+	events := a.t.counters.events.Load()
+	n := events - a.events
+	if events < a.events {
+		n = events // ResetMetrics zeroed the counter meanwhile
+	}
+	a.events = events
+	alert := Alert{Rule: a.rule, Time: now, Since: a.start}
+	switch a.rule.Kind {
+	case ThresholdEvents:
+		a.start = now
+		if n <= uint64(a.rule.Limit) {
+			return
+		}
+		alert.Events = int(n)
+	case ThresholdIDEvents:
+		a.start = now
+		a.mu.Lock()
+		ids := a.ids
+		a.ids = make(map[string]int)
+		a.mu.Unlock()
+		for id, c := range ids {
+			if c > alert.Events || c == alert.Events && id < alert.ID {
+				alert.ID, alert.Events = id, c
+			}
+		}
+		if alert.Events <= a.rule.Limit {
+			return
+		}
+	case ThresholdSilence:
+		if !a.t.ready.Load() {
+			a.quiet = time.Time{}
+			return
+		}
+		if n > 0 || a.quiet.IsZero() {
+			a.quiet = now
+			return
+		}
+		if now.Sub(a.quiet) < a.rule.Window {
+			return
+		}
+		alert.Since = a.quiet
+	}
+	if !a.alerted.IsZero() && now.Sub(a.alerted) < a.rule.Cooldown {
+		return
+	}
+	a.alerted = now
+	a.deliver(alert)
+}
+
+func (a *alerter) deliver(alert Alert) {
+	defer func() {
+		if p := recover(); p != nil {
+			a.t.reportError("callback", &PanicError{Value: p, Stack: debug.Stack()}, nil)
+		}
+	}()
+	a.fn(alert)
+}
+
+// close stops the alert goroutine.
+func (a *alerter) close(ctx context.Context) error {
+	a.stopMu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.stop)
+	}
+	a.stopMu.Unlock()
+	select {
+	case <-a.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *Tracker) closeAlerters(ctx context.Context) error {
+	t.batchMu.Lock()
+	alerters := t.alerters
+	t.batchMu.Unlock()
+	for _, a := range alerters {
+		if err := a.close(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package emailtracker
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrInvalidToken is reported through the error hook when a token can't be
+// decoded or decrypted.
+var ErrInvalidToken = errors.New("emailtracker: invalid token")
+
+// tokenParam is the query parameter carrying an encrypted token.
+const tokenParam = "t"
+
+// TokenIDKey is the payload key whose value becomes OpenEvent.ID when a token
+// link is opened.
+const TokenIDKey = "id"
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateToken encrypts payload with Config.EncryptionKey (AES-GCM) and
+// returns it base64url-encoded. The payload travels inside the link, so no
+// lookup table is needed to map opens back to recipients.
+func (t *Tracker) GenerateToken(payload map[string]string) (string, error) {
+	if t.aead == nil {
+		return "", errors.New("emailtracker: GenerateToken requires Config.EncryptionKey")
+	}
+	plain, err := json.Marshal(payload)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, t.aead.NonceSize(), t.aead.NonceSize()+len(plain)+t.aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := t.aead.Seal(nonce, nonce, plain, nil)
+	return base64.RawURLEncoding.EncodeToString(sealed), nil
+}
+
+// GenerateTokenLink returns a tracking link carrying payload as an encrypted
+// token. The payload's "id" entry, if any, becomes the event ID. With
+// Config.PathIDs the token is a path segment instead of a query parameter.
+func (t *Tracker) GenerateTokenLink(payload map[string]string) (string, error) {
+	token, err := t.GenerateToken(payload)
+	if err != nil {
+		return "", err
+	}
+	t.register(payload[TokenIDKey])
+	if t.config.PathIDs {
+		return t.scheme() + "://" + t.host() + t.pathPrefix() + tokenParam + "/" + token + t.pixel.ext, nil
+	}
+	return fmt.Sprintf("%s://%s%s?%s=%s", t.scheme(), t.host(), t.config.Path, tokenParam, url.QueryEscape(token)), nil
+}
+
+// decodeToken reverses GenerateToken.
+func (t *Tracker) decodeToken(token string) (map[string]string, error) {
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	ns := t.aead.NonceSize()
+	if len(sealed) < ns+t.aead.Overhead() {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidToken)
+	}
+	plain, err := t.aead.Open(nil, sealed[:ns], sealed[ns:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	var payload map[string]string
+	if err := json.Unmarshal(plain, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	return payload, nil
+}
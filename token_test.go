@@ -0,0 +1,87 @@
+package emailtracker
+
+import (
+	"encoding/base64"
+	"errors"
+	"maps"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+var testEncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+
+func TestTokenRoundTrip(t *testing.T) {
+	tr, log := newTestTracker(t, Config{EncryptionKey: testEncryptionKey})
+	for _, payload := range []map[string]string{
+		{TokenIDKey: "msg-1", "campaign": "spring", "recipient": "ana@example.com"},
+		{TokenIDKey: "msg-2", "name": "Zoë Ångström", "greeting": "こんにちは 👋", "rtl": "مرحبا"},
+		{TokenIDKey: "msg-3", "long": strings.Repeat("0123456789", 400)},
+		{"campaign": "no-id"},
+	} {
+		link, err := tr.GenerateTokenLink(payload)
+		if err != nil {
+			t.Fatalf("GenerateTokenLink: %v", err)
+		}
+		before := len(log.all())
+		get(tr.Handler(), link)
+		events := log.all()[before:]
+		if len(events) != 1 {
+			t.Fatalf("payload %v: %d events, want 1", payload[TokenIDKey], len(events))
+		}
+		if e := events[0]; e.ID != payload[TokenIDKey] || !maps.Equal(e.Metadata, payload) {
+			t.Errorf("event ID %q, Metadata %v; want %q, %v", e.ID, e.Metadata, payload[TokenIDKey], payload)
+		}
+	}
+}
+
+func TestTokensDiffer(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{EncryptionKey: testEncryptionKey})
+	payload := map[string]string{TokenIDKey: "msg-1"}
+	a, _ := tr.GenerateToken(payload)
+	b, _ := tr.GenerateToken(payload)
+	if a == b {
+		t.Error("two tokens for one payload are equal; nonces aren't random")
+	}
+}
+
+func TestInvalidTokens(t *testing.T) {
+	tr, log := newTestTracker(t, Config{EncryptionKey: testEncryptionKey})
+	errs := recordErrors(tr)
+	other, _ := newTestTracker(t, Config{EncryptionKey: []byte("fedcba9876543210")})
+	foreign, _ := other.GenerateToken(map[string]string{TokenIDKey: "msg-1"})
+	valid, _ := tr.GenerateToken(map[string]string{TokenIDKey: "msg-1"})
+	sealed, _ := base64.RawURLEncoding.DecodeString(valid)
+	sealed[len(sealed)-1] ^= 1
+
+	for name, token := range map[string]string{
+		"not base64":  "!!!",
+		"too short":   "AAAA",
+		"other key":   foreign,
+		"flipped bit": base64.RawURLEncoding.EncodeToString(sealed),
+	} {
+		w := get(tr.Handler(), "/pixel?t="+token)
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("%s: status %d, %d bytes; want the pixel", name, w.Code, w.Body.Len())
+		}
+	}
+	if got := len(log.all()); got != 0 {
+		t.Errorf("%d events from invalid tokens, want 0", got)
+	}
+	got := errs.all()
+	if len(got) != 4 {
+		t.Fatalf("%d errors reported, want 4", len(got))
+	}
+	for _, err := range got {
+		if !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("reported %v, want ErrInvalidToken", err)
+		}
+	}
+}
+
+func TestGenerateTokenWithoutKey(t *testing.T) {
+	tr, _ := newTestTracker(t, Config{})
+	if _, err := tr.GenerateToken(map[string]string{TokenIDKey: "msg-1"}); err == nil {
+		t.Error("GenerateToken succeeded without an EncryptionKey")
+	}
+}
@@ -0,0 +1,233 @@
+package emailtracker
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// Tracer starts spans for the tracker's work. It is an interface so the
+// tracker needs no tracing dependency; the README shows an adapter for
+// OpenTelemetry. Implementations must be safe for concurrent use.
+type Tracer interface {
+	// Start begins a span named name, a child of the span in ctx if any,
+	// or else of the remote parent TraceParentFromContext reports, and
+	// returns a context carrying the new span.
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+// Span is a unit of work started by a Tracer.
+type Span interface {
+	SetAttributes(attrs ...slog.Attr)
+	RecordError(err error)
+	End()
+}
+
+// Span names and attribute keys. Attributes never carry the raw tracking ID,
+// IP or User-Agent. The ID is recorded only as an HMAC under AnonymizeSalt,
+// and left out without one, as an unsalted hash of a guessable ID is no
+// better than the ID.
+const (
+	SpanStoreSave      = "emailtracker.store.save"
+	SpanSinkPublish    = "emailtracker.sink.publish"
+	SpanWebhookDeliver = "emailtracker.webhook.deliver"
+	SpanEnrich         = "emailtracker.enrich"
+
+	TraceKeyIDHash          = "emailtracker.id_hash"
+	TraceKeyOutcome         = "emailtracker.outcome"
+	TraceKeyBot             = "emailtracker.bot"
+	TraceKeyProxiedBy       = "emailtracker.proxied_by"
+	TraceKeyEmailClient     = "emailtracker.email_client"
+	TraceKeyPrivacyPrefetch = "emailtracker.privacy_prefetch"
+	TraceKeyPrefetch        = "emailtracker.prefetch"
+	TraceKeySink            = "emailtracker.sink"
+	TraceKeyEvents          = "emailtracker.events"
+	TraceKeyEnricher        = "emailtracker.enricher"
+)
+
+// outcomeTracked is the TraceKeyOutcome of requests that produced an event;
+// the others carry their DropReason.
+const outcomeTracked = "tracked"
+
+// TraceParent is the W3C Trace Context parent of a request, from its
+// traceparent and tracestate headers.
+type TraceParent struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Flags   byte
+	State   string // the tracestate header, unparsed
+}
+
+// Sampled reports whether the caller recorded its span.
+func (p TraceParent) Sampled() bool { return p.Flags&1 != 0 }
+
+type traceParentKey struct{}
+
+type spanKey struct{}
+
+// TraceParentFromContext returns the parent propagated by the request being
+// traced, for Tracer implementations that don't extract it themselves.
+func TraceParentFromContext(ctx context.Context) (TraceParent, bool) {
+	p, ok := ctx.Value(traceParentKey{}).(TraceParent)
+	return p, ok
+}
+
+// parseTraceParent reads a version 00 traceparent header, accepting longer
+// values from later versions as the specification requires.
+func parseTraceParent(h http.Header) (TraceParent, bool) {
+	var p TraceParent
+	v := h.Get("Traceparent")
+	if len(v) < 55 || (len(v) > 55 && v[55] != '-') || v[2] != '-' || v[35] != '-' || v[52] != '-' {
+		return p, false
+	}
+	version, err := hex.DecodeString(v[:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(v) != 55) {
+		return p, false
+	}
+	if !decodeLowerHex(p.TraceID[:], v[3:35]) || !decodeLowerHex(p.SpanID[:], v[36:52]) {
+		return p, false
+	}
+	var flags [1]byte
+	if !decodeLowerHex(flags[:], v[53:55]) {
+		return p, false
+	}
+	if p.TraceID == ([16]byte{}) || p.SpanID == ([8]byte{}) {
+		return p, false
+	}
+	p.Flags = flags[0]
+	p.State = h.Get("Tracestate")
+	return p, true
+}
+
+func decodeLowerHex(dst []byte, s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c >= 'A' && c <= 'F' {
+			return false
+		}
+	}
+	n, err := hex.Decode(dst, []byte(s))
+	return err == nil && n == len(dst)
+}
+
+// traceRequest wraps h in a span named after route.
+func (t *Tracker) traceRequest(route string, h http.HandlerFunc) http.HandlerFunc {
+	if t.tracer == nil || route == RouteHealth || route == RouteReady {
+		return h
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if p, ok := parseTraceParent(r.Header); ok {
+			ctx = context.WithValue(ctx, traceParentKey{}, p)
+		}
+		ctx, span := t.tracer.Start(ctx, "emailtracker."+route)
+		defer span.End()
+		rec := &statusRecorder{ResponseWriter: w}
+		h(rec, r.WithContext(context.WithValue(ctx, spanKey{}, span)))
+		span.SetAttributes(slog.String("http.request.method", r.Method), slog.Int("http.response.status_code", rec.code()))
+	}
+}
+
+// requestSpan returns the span traceRequest started for r, or nil.
+func (t *Tracker) requestSpan(r *http.Request) Span {
+	if t.tracer == nil {
+		return nil
+	}
+	span, _ := r.Context().Value(spanKey{}).(Span)
+	return span
+}
+
+// drop counts a request that produced no event.
+func (t *Tracker) drop(r *http.Request, reason DropReason) {
+	t.metrics.IncDropped(reason)
+	t.traceOutcome(r, string(reason))
+}
+
+func (t *Tracker) traceOutcome(r *http.Request, outcome string) {
+	if span := t.requestSpan(r); span != nil {
+		span.SetAttributes(slog.String(TraceKeyOutcome, outcome))
+	}
+}
+
+// traceID records the hashed tracking ID on r's span if AnonymizeSalt is
+// set.
+func (t *Tracker) traceID(r *http.Request, id string) {
+	if len(t.config.AnonymizeSalt) == 0 {
+		return
+	}
+	if span := t.requestSpan(r); span != nil {
+		span.SetAttributes(slog.String(TraceKeyIDHash, t.idHash(id)))
+	}
+}
+
+// traceEvent records how e was classified on r's span. In PrivacyMode the
+// classification is left out, having been derived from request headers.
+func (t *Tracker) traceEvent(r *http.Request, e *OpenEvent) {
+	span := t.requestSpan(r)
+	if span == nil || t.config.PrivacyMode || e.DNT {
+		return
+	}
+	span.SetAttributes(
+		slog.Bool(TraceKeyBot, e.IsBot),
+		slog.String(TraceKeyProxiedBy, e.ProxiedBy),
+		slog.String(TraceKeyEmailClient, e.EmailClient),
+		slog.Bool(TraceKeyPrivacyPrefetch, e.PrivacyPrefetch),
+		slog.Bool(TraceKeyPrefetch, e.Prefetch),
+	)
+}
+
+// idHash returns a short digest of id under AnonymizeSalt that can be
+// matched against but not read.
+func (t *Tracker) idHash(id string) string {
+	mac := hmac.New(sha256.New, t.config.AnonymizeSalt)
+	mac.Write([]byte(id))
+	return hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// startSpan begins a child span of ctx, returning a no-op end when tracing
+// is off. end records err, if any, and ends the span.
+func (t *Tracker) startSpan(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(err error)) {
+	if t.tracer == nil {
+		return ctx, func(error) {}
+	}
+	ctx, span := t.tracer.Start(ctx, name)
+	if len(attrs) > 0 {
+		span.SetAttributes(attrs...)
+	}
+	return ctx, func(err error) {
+		if err != nil {
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
+
+// detached carries the values of one context, such as the request's span,
+// with the lifetime of another, so queued work stays in the request's trace
+// without being cancelled when the request ends.
+type detached struct {
+	context.Context
+	values context.Context
+}
+
+func (c detached) Value(key any) any { return c.values.Value(key) }
+
+// queueContext returns what to keep of ctx for work on another goroutine:
+// nothing unless tracing is on.
+func (t *Tracker) queueContext(ctx context.Context) context.Context {
+	if t.tracer == nil {
+		return nil
+	}
+	return ctx
+}
+
+// workContext is the context for queued work, t.ctx carrying the values of
+// the queueContext it was given.
+func (t *Tracker) workContext(values context.Context) context.Context {
+	if values == nil {
+		return t.ctx
+	}
+	return detached{Context: t.ctx, values: values}
+}
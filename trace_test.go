@@ -0,0 +1,86 @@
+package emailtracker
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+)
+
+// testTracer records the attributes set on every span it starts.
+type testTracer struct {
+	mu    sync.Mutex
+	spans []*testSpan
+}
+
+type testSpan struct {
+	name  string
+	mu    sync.Mutex
+	attrs map[string]slog.Value
+}
+
+func (tt *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &testSpan{name: name, attrs: make(map[string]slog.Value)}
+	tt.mu.Lock()
+	tt.spans = append(tt.spans, s)
+	tt.mu.Unlock()
+	return ctx, s
+}
+
+// span returns the first span named name, or nil.
+func (tt *testTracer) span(name string) *testSpan {
+	tt.mu.Lock()
+	defer tt.mu.Unlock()
+	for _, s := range tt.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *testSpan) SetAttributes(attrs ...slog.Attr) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, a := range attrs {
+		s.attrs[a.Key] = a.Value
+	}
+}
+
+func (s *testSpan) attr(key string) (slog.Value, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.attrs[key]
+	return v, ok
+}
+
+func (s *testSpan) RecordError(error) {}
+func (s *testSpan) End()              {}
+
+func TestTraceIDHash(t *testing.T) {
+	for _, c := range []struct {
+		name string
+		salt []byte
+	}{
+		{"no salt", nil},
+		{"salt", []byte("salt")},
+	} {
+		tracer := &testTracer{}
+		tr, _ := newTestTracker(t, Config{Tracer: tracer, AnonymizeSalt: c.salt})
+		get(tr.Handler(), tr.GenerateLink("msg-1"))
+		span := tracer.span("emailtracker." + RoutePixel)
+		if span == nil {
+			t.Fatalf("%s: no pixel span", c.name)
+		}
+		if v, _ := span.attr(TraceKeyOutcome); v.String() != outcomeTracked {
+			t.Errorf("%s: outcome %q, want %q", c.name, v, outcomeTracked)
+		}
+		v, ok := span.attr(TraceKeyIDHash)
+		switch {
+		case c.salt == nil && ok:
+			t.Errorf("%s: span carries ID hash %q without AnonymizeSalt", c.name, v)
+		case c.salt != nil && (!ok || v.String() != tr.idHash("msg-1") || v.String() == "msg-1"):
+			t.Errorf("%s: ID hash %q, want the salted %q", c.name, v, tr.idHash("msg-1"))
+		}
+	}
+}
@@ -1,96 +1,1141 @@
 package emailtracker
 
 import (
+	"cmp"
+	"context"
+	"crypto/cipher"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"html/template"
+	"log/slog"
 	"net"
 	"net/http"
+	"net/netip"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// EventKind tells the events passed to subscribers apart.
+type EventKind string
+
+const (
+	EventOpen  EventKind = "open"  // the tracking pixel was loaded
+	EventClick EventKind = "click" // a click-tracking link was followed
+
+	EventUnsubscribe EventKind = "unsubscribe" // an unsubscribe link was used
+
+	// Kinds of events ingested by ESPWebhookHandler.
+	EventDelivered EventKind = "delivered" // the ESP delivered the message
+	EventBounce    EventKind = "bounce"    // the message bounced
+	EventComplaint EventKind = "complaint" // the recipient reported the message as spam
+)
+
 type OpenEvent struct {
-	ID            string
-	IP            string
-	XForwardedFor string
-	UserAgent     string
-	Referer       string
-	AcceptLang    string
-	Time          time.Time
+	Kind              EventKind         `json:"kind"`
+	ID                string            `json:"id"`
+	EventID           string            `json:"event_id,omitempty"`       // unique per event, a time-sortable UUIDv7; an idempotency key for deliveries
+	CorrelationID     string            `json:"correlation_id,omitempty"` // the request's X-Request-ID, if any
+	Method            string            `json:"method,omitempty"`         // HTTP method of the request, e.g. "GET"
+	Host              string            `json:"host,omitempty"`           // client-facing host, honoring X-Forwarded-Host from trusted proxies
+	Path              string            `json:"path,omitempty"`           // request path, e.g. "/pixel"
+	Source            string            `json:"source,omitempty"`         // SourceAMP for links built by GenerateAMPPixelLink, the ESP for ESP webhook events, empty for the classic pixel
+	TLS               bool              `json:"tls,omitempty"`            // arrived over HTTPS, directly or per a trusted proxy's X-Forwarded-Proto
+	TLSVersion        string            `json:"tls_version,omitempty"`    // e.g. "TLS 1.3"; empty when a proxy terminated TLS
+	CipherSuite       string            `json:"cipher_suite,omitempty"`   // e.g. "TLS_AES_128_GCM_SHA256"; empty when a proxy terminated TLS
+	CampaignID        string            `json:"campaign_id,omitempty"`    // decoded from IDs built by CampaignID
+	RecipientID       string            `json:"recipient_id,omitempty"`   // decoded from IDs built by CampaignID
+	IP                string            `json:"ip,omitempty"`
+	IPSource          string            `json:"ip_source,omitempty"` // header IP was read from, or IPSourceRemoteAddr
+	Hostname          string            `json:"hostname,omitempty"`  // reverse DNS of IP; needs ReverseDNS
+	XForwardedFor     string            `json:"x_forwarded_for,omitempty"`
+	UserAgent         string            `json:"user_agent,omitempty"`
+	Referer           string            `json:"referer,omitempty"`
+	AcceptLang        string            `json:"accept_lang,omitempty"`
+	Headers           map[string]string `json:"headers,omitempty"`             // needs CaptureHeaders or CaptureAllHeaders
+	Languages         []Locale          `json:"languages,omitempty"`           // AcceptLang parsed, best first
+	PrimaryLanguage   string            `json:"primary_language,omitempty"`    // e.g. "en"; the first non-wildcard entry of Languages
+	Time              time.Time         `json:"time"`                          // RFC 3339 with milliseconds in JSON
+	Metadata          map[string]string `json:"metadata,omitempty"`            // decrypted token payload, if any
+	Params            map[string]string `json:"params,omitempty"`              // extra query parameters on the link
+	URL               string            `json:"url,omitempty"`                 // click destination, for EventClick
+	MessageID         string            `json:"message_id,omitempty"`          // the ESP's message ID, for ESP webhook events
+	Detail            string            `json:"detail,omitempty"`              // bounce type or complaint feedback type, for ESP webhook events
+	Revalidated       bool              `json:"revalidated,omitempty"`         // a conditional request for a cached pixel
+	FirstOpen         bool              `json:"first_open,omitempty"`          // first open seen for ID; needs TrackFirstOpen
+	Repeats           int               `json:"repeats,omitempty"`             // identical requests merged into this event; needs DebounceInterval
+	IsBot             bool              `json:"is_bot,omitempty"`              // User-Agent matched a known bot or scanner
+	BotName           string            `json:"bot_name,omitempty"`            // name of the matching BotPattern
+	Proxied           bool              `json:"proxied,omitempty"`             // fetched by a mail provider's image proxy, e.g. Gmail's
+	ProxiedBy         string            `json:"proxied_by,omitempty"`          // name of the matching ImageProxy, e.g. "gmail"
+	PrivacyPrefetch   bool              `json:"privacy_prefetch,omitempty"`    // fetched by the mail client itself, e.g. Apple MPP; see PrefetchClassifier
+	Prefetch          bool              `json:"prefetch,omitempty"`            // announced as a prefetch by Sec-Purpose, Purpose or X-Moz
+	EmailClient       string            `json:"email_client,omitempty"`        // e.g. "Outlook desktop", or UnknownEmailClient; see EmailClientRules
+	EmailClientFamily string            `json:"email_client_family,omitempty"` // e.g. "Outlook", or UnknownEmailClient
+	Expired           bool              `json:"expired,omitempty"`             // link past its WithExpiry time; needs EmitExpired
+	Nonce             string            `json:"nonce,omitempty"`               // per-send nonce from WithNonce
+	Replay            bool              `json:"replay,omitempty"`              // nonce already seen from this IP; needs ReplayWindow
+	TimeToOpen        time.Duration     `json:"time_to_open,omitempty"`        // since the WithSentAt time; nanoseconds in JSON
+	TimeToOpenSkewed  bool              `json:"time_to_open_skewed,omitempty"` // sent time was in the future; TimeToOpen clamped to 0
+	Suspicious        bool              `json:"suspicious,omitempty"`          // opened within SuspiciousOpenWindow of the send
+	Confidence        Confidence        `json:"confidence,omitempty"`          // how likely a person opened it; needs Confidence
+	DNT               bool              `json:"dnt,omitempty"`                 // sent DNT or Sec-GPC; needs DoNotTrackMinimal
+	SampleRate        float64           `json:"sample_rate,omitempty"`         // Config.SampleRate the event was kept under; 0 when not sampling
+
+	UserAgentInfo // filled in when Config.UAParser is set
+
+	Geo Geo `json:"geo,omitzero"` // filled in when Config.GeoResolver is set; Country also by CDNCountry
 }
 
 type Config struct {
-	Port   int    // Port to listen on, e.g., 8080
+	Port   int    // Port to listen on, e.g., 8080; 0 picks a free port (see Addr)
 	Domain string // Domain or host, e.g., "localhost:8080" or "tracker.example.com"
 	Path   string // Tracking pixel path, e.g., "/pixel"
+
+	// SocketPath makes Start listen on a unix domain socket instead of
+	// Port, which must then be 0. A stale socket left by an earlier run is
+	// removed first, and the socket is removed again on Shutdown.
+	// SocketMode sets its permissions, 0660 by default. Requests arriving
+	// on the socket take the client IP from forwarding headers alone,
+	// trusting them whatever TrustedProxies says.
+	SocketPath string
+	SocketMode os.FileMode
+
+	// IDParam renames the query parameter carrying the tracking ID, "id" by
+	// default, e.g. to "u". The handler then ignores "id" unless
+	// AcceptDefaultIDParam is set, which helps while old links are still
+	// in inboxes.
+	IDParam              string
+	AcceptDefaultIDParam bool
+
+	// Scheme is the URL scheme of generated links, "http" or "https". When
+	// empty, links use http for localhost and loopback addresses unless the
+	// tracker serves TLS itself, and https otherwise.
+	Scheme string
+
+	// TLS settings for serving the pixel over HTTPS. When TLSCertFile and
+	// TLSKeyFile are set, or TLSConfig carries certificates, Start serves TLS
+	// and GenerateLink always emits https links.
+	TLSCertFile string
+	TLSKeyFile  string
+	TLSConfig   *tls.Config
+
+	// Limits of the server run by Start. Zero values default to a 5s
+	// ReadTimeout, 10s WriteTimeout, 60s IdleTimeout and 1 MiB
+	// MaxHeaderBytes. Negative values, timeouts over an hour and header
+	// limits over 16 MiB are rejected.
+	ReadTimeout    time.Duration
+	WriteTimeout   time.Duration
+	IdleTimeout    time.Duration
+	MaxHeaderBytes int
+
+	// Asynchronous dispatch. When Workers > 0 subscribers run on that many
+	// worker goroutines fed by a queue of QueueSize events (default 1024), so
+	// the pixel is written without waiting for it. QueuePolicy controls what
+	// happens when the queue is full.
+	Workers     int
+	QueueSize   int
+	QueuePolicy QueuePolicy
+
+	// CallbackTimeout, when positive, bounds each subscriber invocation: its
+	// context is cancelled once the timeout passes and the tracker stops
+	// waiting for it, reporting a *CallbackTimeoutError to the OnError hook.
+	// A subscriber that ignores its context keeps running in the background,
+	// but no longer holds up the pixel or a worker.
+	CallbackTimeout time.Duration
+
+	// SigningKey, when set, makes GenerateLink append an HMAC signature to
+	// every link. Requests with a missing or invalid signature still receive
+	// the pixel but produce no OpenEvent, or are answered with 403 Forbidden
+	// when RejectInvalidSignatures is true.
+	SigningKey              []byte
+	RejectInvalidSignatures bool
+
+	// SigningKeyID names SigningKey in the links it signs, so that it can
+	// later be rotated; see AddSigningKey. OldSigningKeys holds earlier keys
+	// by ID, still accepted for links already sent. Links without a key ID
+	// verify against the key whose ID is "", which is SigningKey when
+	// SigningKeyID is empty.
+	SigningKeyID   string
+	OldSigningKeys map[string][]byte
+
+	// EncryptionKey enables GenerateToken: a 16, 24 or 32 byte AES key used to
+	// seal link payloads with AES-GCM.
+	EncryptionKey []byte
+
+	// ClickPath, when set, serves the click-tracking redirect endpoint used by
+	// GenerateClickLink, e.g. "/click". It requires SigningKey.
+	ClickPath string
+
+	// UnsubscribePath, when set, serves the unsubscribe endpoint used by
+	// GenerateUnsubscribeLink, e.g. "/unsubscribe". It requires SigningKey.
+	// UnsubscribeConfirmPage replaces the page a GET of the link gets; it is
+	// executed with an UnsubscribeConfirmation and must POST to its Action.
+	// UnsubscribePage replaces the page shown once that POST unsubscribed;
+	// it is executed with the unsubscribe OpenEvent.
+	UnsubscribePath        string
+	UnsubscribeConfirmPage *template.Template
+	UnsubscribePage        *template.Template
+
+	// ESPWebhookPath, when set, serves an endpoint ingesting delivery,
+	// bounce, complaint and open webhooks from SendGrid and Amazon SES, e.g.
+	// "/esp"; see ESPWebhookHandler. ESP configures their verification.
+	ESPWebhookPath string
+	ESP            ESPConfig
+
+	// PixelFormat selects the image served by the handler: PixelGIF (the
+	// default), PixelPNG or PixelSVG. With LinkExtension set, GenerateLink
+	// appends the matching extension to Path, e.g. "/pixel.png", and the
+	// tracker serves both forms.
+	PixelFormat   PixelFormat
+	LinkExtension bool
+
+	// Links generated WithExpiry stop producing events once expired, give or
+	// take two minutes of clock skew; the pixel is still served. With
+	// EmitExpired they produce events flagged Expired instead.
+	EmitExpired bool
+
+	// PathIDs makes GenerateLink put the ID in the path instead of the query
+	// string, e.g. "/pixel/msg-42.gif", or "/pixel/<sig>/msg-42.gif" when
+	// signed, for mail filters that strip query strings. GenerateTokenLink
+	// likewise puts the token in the path: "/pixel/t/<token>.gif". The
+	// handler accepts path-style and query-style links either way, so links
+	// already sent keep working; PathIDs also routes Path + "/" to it in
+	// Start. Other paths under Path + "/" get the pixel, or 404 Not Found
+	// with RejectUnknownPaths, and are counted as DropUnknownPath.
+	PathIDs            bool
+	RejectUnknownPaths bool
+
+	// PixelData and PixelContentType replace the built-in pixel with your own
+	// image, e.g. a visible banner. Both must be set together; PixelFormat is
+	// then ignored. The tracker keeps its own copy of PixelData.
+	PixelData        []byte
+	PixelContentType string
+
+	// TrackedParams, when set, limits OpenEvent.Params to the named query
+	// parameters, e.g. []string{"v"} for an A/B variant added with
+	// GenerateLinkWithParams, so clients can't fill events with arbitrary
+	// data. Values are capped at MaxParamLen bytes (default 256); empty
+	// ones are left out. Without TrackedParams every non-reserved parameter
+	// is kept as is.
+	TrackedParams []string
+	MaxParamLen   int
+
+	// Beacon lets a request carrying mode=beacon, e.g. from
+	// navigator.sendBeacon, get 204 No Content instead of the pixel. Such
+	// requests are tracked like any other and may also be POSTs. Requests
+	// without the parameter always get the pixel, so email clients are
+	// unaffected.
+	Beacon bool
+
+	// AllowedOrigins lets pages on these origins fetch the pixel, e.g. from
+	// a "view in browser" page: matching requests get
+	// Access-Control-Allow-Origin, and CORS preflights are answered without
+	// producing an event. Entries are "*", an origin such as
+	// "https://app.example.com", a host matched under any scheme, or
+	// "*.example.com" for its subdomains.
+	AllowedOrigins []string
+
+	// HEAD requests to the pixel, sent by some scanners ahead of the GET,
+	// get the pixel's headers without a body and produce no event; they are
+	// counted as DropHead. EmitHEAD makes them produce events with Method
+	// "HEAD" instead.
+	EmitHEAD bool
+
+	// The pixel is sent with Cache-Control, Pragma and Expires headers that
+	// stop clients and proxies from caching it, so repeat opens reach the
+	// server. CacheControl replaces the default Cache-Control value;
+	// DisableCacheHeaders sends none of them, e.g. to count only first opens.
+	CacheControl        string
+	DisableCacheHeaders bool
+
+	// BotPatterns adds User-Agent patterns, checked before the built-in
+	// DefaultBotPatterns, that flag an event with IsBot and BotName.
+	BotPatterns []BotPattern
+
+	// ImageProxies adds image proxies, checked before the built-in
+	// DefaultImageProxies, that set OpenEvent.Proxied and ProxiedBy. The IP
+	// of a proxied open is the proxy's, so GeoResolver isn't consulted.
+	ImageProxies []ImageProxy
+
+	// PrefetchClassifier sets OpenEvent.PrivacyPrefetch on opens a mail
+	// client fetched by itself. Nil uses an AppleMPPClassifier with the
+	// default options; NoopPrefetchClassifier turns detection off.
+	PrefetchClassifier PrefetchClassifier
+
+	// EmailClientRules adds rules, checked before DefaultEmailClientRules,
+	// that set OpenEvent.EmailClient and EmailClientFamily.
+	EmailClientRules []EmailClientRule
+
+	// UAParser, when set, parses each User-Agent into the event's
+	// UserAgentInfo fields. SimpleUAParser is a built-in option.
+	UAParser UAParser
+
+	// GeoResolver, when set, resolves each event's IP into Geo before
+	// subscribers run. With Workers > 0 the lookup happens on the worker pool,
+	// off the request path. Lookup errors go to the OnError hook.
+	GeoResolver GeoResolver
+
+	// StrictIDs drops pixel requests without an ID instead of producing
+	// events with an empty one. With an IDRegistry, which GenerateLink and
+	// GenerateTokenLink register every ID with, IDs it doesn't know are
+	// dropped too. Rejected requests get the pixel, or 404 Not Found with
+	// RejectUnknownIDs, and are counted as DropMissingID or DropUnknownID.
+	StrictIDs        bool
+	IDRegistry       IDRegistry
+	RejectUnknownIDs bool
+
+	// KnownSends makes IDs registered by RegisterSend known to StrictIDs,
+	// alongside those the IDRegistry knows; without an IDRegistry they are
+	// the only known IDs. SendRegistrySize bounds the sends kept in memory
+	// when the Store doesn't implement SendStore (default 100000); the
+	// IDs registered first are forgotten first.
+	KnownSends       bool
+	SendRegistrySize int
+
+	// CaptureHeaders copies the named request headers, matched
+	// case-insensitively, into OpenEvent.Headers; repeated headers are
+	// joined with ", ". CaptureAllHeaders copies every header, for
+	// debugging. Credentials such as Cookie and Authorization are never
+	// captured.
+	CaptureHeaders    []string
+	CaptureAllHeaders bool
+
+	// ReverseDNS fills OpenEvent.Hostname with the PTR name of the client
+	// IP, e.g. to spot opens through *.googleusercontent.com. It requires
+	// Workers > 0 so lookups never delay the pixel. Each lookup is bounded
+	// by ReverseDNSTimeout (default 500ms) and answers are cached for the
+	// ReverseDNSCacheSize (default 10000) most recent IPs. Resolver defaults
+	// to net.DefaultResolver. Failed lookups leave Hostname empty.
+	ReverseDNS          bool
+	ReverseDNSTimeout   time.Duration
+	ReverseDNSCacheSize int
+	Resolver            *net.Resolver
+
+	// TrustedProxies lists the CIDRs (or single IPs) of proxies allowed to
+	// set forwarding headers. When set, the headers are ignored unless the
+	// direct peer is trusted, and the client IP is the right-most address in
+	// the chain that isn't itself a trusted proxy. When empty, forwarding
+	// headers are honored from any peer for the client IP and host, but
+	// X-Forwarded-Proto and CF-IPCountry are ignored.
+	TrustedProxies []string
+
+	// ClientIPHeaders sets which forwarding headers are consulted for the
+	// client IP, in order of precedence. Defaults to DefaultClientIPHeaders:
+	// Forwarded (RFC 7239), then X-Forwarded-For, then X-Real-IP.
+	ClientIPHeaders []string
+
+	// CDNClientIP takes the client IP from the header a CDN in front of the
+	// tracker sets. The client IP then comes from the first of these that
+	// holds an address: CF-Connecting-IP (Cloudflare), True-Client-IP
+	// (Akamai), Fastly-Client-IP (Fastly), the ClientIPHeaders in their
+	// order, and finally the connection. As any client can send them, the
+	// CDN headers are only read from TrustedProxies, which must list the
+	// CDN's ranges, and only when sent once. CDNCountry, likewise only from
+	// TrustedProxies, sets Geo.Country from Cloudflare's CF-IPCountry, so no
+	// GeoResolver is needed; a GeoResolver's country, when it finds one,
+	// wins.
+	CDNClientIP bool
+	CDNCountry  bool
+
+	// DenyCIDRs and AllowCIDRs, CIDRs or single IPs, filter events by the
+	// resolved client IP, e.g. to ignore opens from an in-house QA network.
+	// Requests from a denied range, or from outside the allow list when one
+	// is set, get the pixel or redirect but produce no event; they're
+	// counted as dropped with DropDeniedIP. A range in both lists is denied.
+	DenyCIDRs  []string
+	AllowCIDRs []string
+
+	// AnonymizeIP reduces OpenEvent.IP before subscribers see it, after
+	// GeoResolver has run so country-level geo still works. Any mode other
+	// than AnonymizeNone also clears OpenEvent.XForwardedFor and Hostname.
+	// Dedup, replay, rate-limit and debounce keys are built from the reduced
+	// IP as well, so no raw address reaches a SeenSet; with
+	// AnonymizeTruncate they treat a whole /24 as one client.
+	// AnonymizeHash requires AnonymizeSalt, which also keys the tracking-ID
+	// hash on Tracer spans.
+	AnonymizeIP   IPAnonymization
+	AnonymizeSalt []byte
+
+	// PrivacyMode counts opens without recording personal data: events carry
+	// only their kind, ID, EventID, time and link data. The IP and request
+	// headers are never read, so bot detection, UA parsing and geo lookups
+	// are skipped.
+	PrivacyMode bool
+
+	// RespectDoNotTrack honors DNT: 1 and Sec-GPC: 1 on pixel requests: the
+	// pixel is served but no event is produced, and the open is counted as
+	// DropDoNotTrack. With DoNotTrackMinimal an event is produced instead,
+	// carrying only its kind, ID and time, and DNT set.
+	RespectDoNotTrack bool
+	DoNotTrackMinimal bool
+
+	// Store, when set, persists every event before subscribers run, e.g.
+	// NewMemoryStore(10000). Write errors go to the OnError hook.
+	Store Store
+
+	// DedupWindow collapses rapid repeat requests: after an event for an ID,
+	// further events for it are suppressed until the window has passed. With
+	// DedupByClient the key also includes the IP and User-Agent. Zero
+	// disables deduplication. DedupMaxKeys bounds the memory used (default
+	// 100000 keys).
+	DedupWindow   time.Duration
+	DedupByClient bool
+	DedupMaxKeys  int
+
+	// DebounceInterval collapses identical pixel requests, with the same ID,
+	// IP and User-Agent: each event is held back for the interval, and
+	// identical requests arriving meanwhile are counted into its
+	// OpenEvent.Repeats rather than producing events of their own, counted
+	// as DropDebounced. Events thus reach subscribers one interval late;
+	// Shutdown releases those still held. At most DebounceMaxKeys events
+	// (default 100000) are held at once, later ones going out undelayed.
+	// Zero, the default, disables debouncing.
+	DebounceInterval time.Duration
+	DebounceMaxKeys  int
+
+	// ReplayWindow sets OpenEvent.Replay when a link built WithNonce is
+	// requested again from the same IP within the window. Nonces are kept in
+	// memory, bounded by ReplayMaxKeys (default 100000). Zero disables replay
+	// detection.
+	ReplayWindow  time.Duration
+	ReplayMaxKeys int
+
+	// TrackFirstOpen sets OpenEvent.FirstOpen on the first open of each ID.
+	// Seen IDs are kept in memory, bounded by FirstOpenMaxIDs (default
+	// 100000); without a Store an evicted ID may be reported as first again.
+	// With a Store, IDs not in memory are looked up there.
+	TrackFirstOpen  bool
+	FirstOpenMaxIDs int
+
+	// MaxOpensPerID stops emitting opens for an ID once it has produced that
+	// many, e.g. when a pixel in a reply chain keeps being reloaded. Later
+	// requests still get the pixel and are counted by OpenCapped. Counts
+	// are kept in memory for up to OpenCapMaxIDs IDs (default 100000); with
+	// a Store, the opens stored for an ID are counted too, so the cap holds
+	// across restarts and evictions, and, with an OpenCountStore, replicas.
+	// Zero means no cap.
+	MaxOpensPerID int
+	OpenCapMaxIDs int
+
+	// SeenSet, when set, shares dedup, first-open and replay state between
+	// replicas, e.g. through Redis. The in-memory sets are still kept and
+	// answer while the SeenSet is failing; its errors go to the OnError hook
+	// wrapped in ErrSeenSet. When SeenSet is nil and the Store implements
+	// SeenSet, as the redisstore, sqlitestore and pgstore stores do, the
+	// Store is used.
+	SeenSet SeenSet
+
+	// Retry, when set, retries deliveries to subscribers, sinks and the
+	// store that fail with an error, handing those that never succeed to
+	// RetryConfig.DeadLetter. Shutdown makes a last attempt at whatever is
+	// still queued.
+	Retry *RetryConfig
+
+	// Webhook, when set, POSTs every event as signed JSON to an HTTP
+	// endpoint. Delivery is asynchronous and retried with backoff; events
+	// that can't be delivered are reported to the OnError hook.
+	Webhook *WebhookConfig
+
+	// DropBots suppresses events whose User-Agent matches a bot pattern
+	// instead of only flagging them with IsBot.
+	DropBots bool
+
+	// DropPrefetch suppresses pixel requests announced as prefetches (see
+	// OpenEvent.Prefetch) instead of only flagging them, counting them as
+	// dropped with DropPrefetch. Flagged prefetches are delivered but
+	// neither deduplicated nor counted as first opens, so the real open
+	// that follows is.
+	DropPrefetch bool
+
+	// SuspiciousOpenWindow sets OpenEvent.Suspicious on opens arriving less
+	// than this long after the message was sent, which are almost always
+	// security scanners rather than people. The send time is the link's
+	// WithSentAt time, or else the SentAt of RegisterSend; opens with
+	// neither are never suspicious. Zero means
+	// DefaultSuspiciousOpenWindow; negative disables the rule.
+	SuspiciousOpenWindow time.Duration
+
+	// Confidence, when set, grades each open as human, likely bot or bot in
+	// OpenEvent.Confidence by weighing IsBot, Suspicious, the prefetch
+	// flags and Proxied; see ConfidenceWeights. DefaultConfidenceWeights is
+	// a starting point.
+	Confidence *ConfidenceWeights
+
+	// Metrics receives request, event and error counts; see the prommetrics
+	// sub-package.
+	Metrics Metrics
+
+	// Logger receives server lifecycle messages, each event at Debug level,
+	// rejected requests at Warn and internal failures at Error, with stable
+	// attribute keys (see LogKeyID and friends). Nil keeps the tracker silent.
+	Logger *slog.Logger
+
+	// Tracer, when set, traces the pixel, click and unsubscribe requests,
+	// continuing any W3C traceparent they carry, with child spans around
+	// store writes, sink publishes and webhook deliveries.
+	Tracer Tracer
+
+	// Clock, when set, replaces the real clock for event timestamps and the
+	// windows built on them; see Clock.
+	Clock Clock
+
+	// AccessLog, when set, logs every request to the tracker's routes; see
+	// AccessLogConfig.
+	AccessLog *AccessLogConfig
+
+	// StreamPath, when set, serves live events as Server-Sent Events (see
+	// StreamHandler) to clients presenting StreamToken, which is required.
+	StreamPath  string
+	StreamToken string
+
+	// AdminPath, when set, serves a JSON API for looking up stored events
+	// under that prefix (see AdminHandler) to clients presenting
+	// AdminToken, which is required, as a bearer token.
+	AdminPath  string
+	AdminToken string
+
+	// HealthPath and ReadyPath, when set, serve liveness and readiness probes
+	// (e.g. "/healthz" and "/readyz") that never count as opens. Version is
+	// reported in their JSON bodies.
+	HealthPath string
+	ReadyPath  string
+	Version    string
+
+	// RateLimit, when set, limits how many events each client IP (or other
+	// key) can produce on the pixel endpoint.
+	RateLimit *RateLimit
+
+	// SampleRate, between 0 and 1, keeps events for only that fraction of
+	// tracking IDs; the pixel is served either way. Whether an ID is in the
+	// sample is decided by hashing it, so all opens and clicks of a message
+	// are kept or dropped together. Kept events carry the rate in
+	// OpenEvent.SampleRate for scaling counts back up. 1 keeps everything,
+	// and so does 0, the default, unless Sampling is set: then it drops
+	// every event, e.g. to pause tracking without changing links.
+	SampleRate float64
+	Sampling   bool
 }
 
 type Tracker struct {
-	config   Config
-	callback func(OpenEvent)
+	config Config
+
+	subMu       sync.RWMutex
+	subscribers []Subscriber
+	filters     []func(OpenEvent) bool
+	builtins    []*builtinEnricher
+	used        []Enricher // passed to Use
+	enrichers   []Enricher // the pipeline
+	sinks       []*sinkRunner
+	retrier     *retrier
+
+	batchMu   sync.Mutex
+	batchers  []*batcher
+	events    eventChans
+	reporters []*reporter
+	alerters  []*alerter
+	buffered  atomic.Int64 // events waiting in batchers
+
+	mu       sync.Mutex
+	server   *http.Server
+	addr     net.Addr      // bound listener address, once started
+	linkPort int           // port links must carry, when it was picked by the OS
+	started  chan struct{} // closed when the listener is bound
+	closed   bool
+	tls      bool // set once StartTLS is used
+	grouped  bool // served by a Group
+	ready    atomic.Bool
+	created  time.Time
+	clock    Clock
+
+	callbacks  inflight
+	ctx        context.Context // parent of callback contexts; cancelled when Shutdown gives up
+	cancel     context.CancelFunc
+	dispatcher *dispatcher
+	webhook    *webhook
+	stream     *stream
+	metrics    Metrics
+	counters   *counters // built-in counters behind Metrics(); also t.metrics
+	log        *slog.Logger
+	tracer     Tracer
+	cors       *corsPolicy
+	seenSet    SeenSet // Config.SeenSet, or the Store if it implements SeenSet
+	storeSeen  bool    // seenSet is the Store
+	access     *accessLog
+
+	aead         cipher.AEAD
+	pixel        pixel
+	lastModified string // Last-Modified value sent with the pixel
+	bots         []botMatcher
+	proxies      []proxyMatcher
+	clients      []clientMatcher
+	prefetch     PrefetchClassifier
+	confidence   *ConfidenceWeights
+	suspicious   time.Duration // Config.SuspiciousOpenWindow, defaulted
+	trusted      []netip.Prefix
+	denyIPs      []netip.Prefix
+	allowIPs     []netip.Prefix
+	ipHeaders    []string
+	ipKeys       []string // ipHeaders in canonical form
+	idParam      string
+	prefix       string // see pathPrefix
+	loopback     bool   // Domain is a loopback host
+
+	keys         *keyring
+	dedup        *ttlSet
+	deduplicated atomic.Uint64
+	seen         *ttlSet // IDs with a recorded open, for FirstOpen
+	opens        *openCounter
+	openCapped   atomic.Uint64
+	nonces       *ttlSet // nonce and IP pairs, for Replay
+	limiter      *limiter
+	rdns         *reverseDNS
+	esp          *espIngest
+	debounce     *debouncer
+	sends        SendStore // the Store if it implements SendStore, else in memory
+
+	errMu   sync.RWMutex
+	onError func(error, *OpenEvent)
+}
+
+// New creates a Tracker from cfg and then opts, applied in order, reporting
+// an error if the result is invalid. Register callbacks with WithCallback,
+// Subscribe or SubscribeContext.
+func New(cfg Config, opts ...Option) (*Tracker, error) {
+	return newTracker(cfg, false, opts...)
+}
+
+// newTracker is New, validating cfg leniently for NewTracker.
+func newTracker(cfg Config, lenient bool, opts ...Option) (*Tracker, error) {
+	var o options
+	for _, opt := range opts {
+		if err := opt(&cfg, &o); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.validate(lenient); err != nil {
+		return nil, err
+	}
+	clock := cfg.Clock
+	if clock == nil {
+		clock = realClock{}
+	}
+	t := &Tracker{
+		config:       cfg,
+		clock:        clock,
+		lastModified: clock.Now().UTC().Format(http.TimeFormat),
+		log:          newLogger(cfg.Logger),
+		tracer:       cfg.Tracer,
+		created:      time.Now(),
+		started:      make(chan struct{}),
+	}
+	if cfg.Metrics == nil {
+		t.counters = newCounters(noopMetrics{})
+	} else {
+		t.counters = newCounters(cfg.Metrics)
+	}
+	t.metrics = t.counters
+	t.idParam = cmp.Or(cfg.IDParam, defaultIDParam)
+	t.prefix = strings.TrimSuffix(cfg.Path, "/") + "/"
+	t.loopback = isLoopbackHost(cfg.Domain)
+	px, err := cfg.pixel()
+	if err != nil {
+		return nil, err
+	}
+	t.pixel = px
+	if t.bots, err = compileBots(cfg.BotPatterns); err != nil {
+		return nil, err
+	}
+	if t.proxies, err = compileProxies(cfg.ImageProxies); err != nil {
+		return nil, err
+	}
+	if t.clients, err = compileClients(cfg.EmailClientRules); err != nil {
+		return nil, err
+	}
+	if t.prefetch = cfg.PrefetchClassifier; t.prefetch == nil {
+		if t.prefetch, err = NewAppleMPPClassifier(AppleMPPOptions{}); err != nil {
+			return nil, err
+		}
+	}
+	if t.cors, err = compileOrigins(cfg.AllowedOrigins); err != nil {
+		return nil, err
+	}
+	if t.trusted, err = parsePrefixes("TrustedProxies", cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	if t.denyIPs, err = parsePrefixes("DenyCIDRs", cfg.DenyCIDRs); err != nil {
+		return nil, err
+	}
+	if t.allowIPs, err = parsePrefixes("AllowCIDRs", cfg.AllowCIDRs); err != nil {
+		return nil, err
+	}
+	t.ipHeaders = cfg.ClientIPHeaders
+	if len(t.ipHeaders) == 0 {
+		t.ipHeaders = DefaultClientIPHeaders
+	}
+	for _, name := range t.ipHeaders {
+		t.ipKeys = append(t.ipKeys, textproto.CanonicalMIMEHeaderKey(name))
+	}
+	if t.keys, err = newKeyring(cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.EncryptionKey) > 0 {
+		aead, err := newAEAD(cfg.EncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("emailtracker: EncryptionKey: %w", err)
+		}
+		t.aead = aead
+	}
+	t.seenSet = cfg.SeenSet
+	if s, ok := cfg.Store.(SeenSet); ok && t.seenSet == nil {
+		t.seenSet, t.storeSeen = s, true
+	}
+	if s, ok := cfg.Store.(SendStore); ok {
+		t.sends = s
+	} else {
+		t.sends = newMemorySends(cfg.SendRegistrySize)
+	}
+	if t.suspicious = cfg.SuspiciousOpenWindow; t.suspicious == 0 {
+		t.suspicious = DefaultSuspiciousOpenWindow
+	}
+	if cfg.Confidence != nil {
+		w := cfg.Confidence.withDefaults()
+		t.confidence = &w
+	}
+	if cfg.DebounceInterval > 0 {
+		t.debounce = newDebouncer(t, cfg.DebounceInterval, cfg.DebounceMaxKeys)
+	}
+	if cfg.DedupWindow > 0 {
+		t.dedup = newTTLSet(cfg.DedupWindow, cfg.DedupMaxKeys)
+	}
+	if cfg.ReverseDNS {
+		t.rdns = newReverseDNS(cfg.Resolver, cfg.ReverseDNSTimeout, cfg.ReverseDNSCacheSize)
+	}
+	if t.esp, err = newESPIngest(cfg.ESP, t.now); err != nil {
+		return nil, err
+	}
+	t.builtins = []*builtinEnricher{
+		{name: EnricherReverseDNS, enabled: t.rdns != nil, fn: t.resolveHostname},
+		{name: EnricherGeo, enabled: cfg.GeoResolver != nil, fn: t.resolveGeo},
+	}
+	t.enrichers = t.pipeline()
+	if cfg.AccessLog != nil {
+		t.access = &accessLog{cfg: *cfg.AccessLog}
+	}
+	if cfg.RateLimit != nil {
+		t.limiter = newLimiter(*cfg.RateLimit)
+	}
+	if cfg.ReplayWindow > 0 {
+		t.nonces = newTTLSet(cfg.ReplayWindow, cfg.ReplayMaxKeys)
+	}
+	if cfg.MaxOpensPerID > 0 {
+		t.opens = newOpenCounter(cfg.OpenCapMaxIDs)
+	}
+	if cfg.TrackFirstOpen {
+		t.seen = newTTLSet(0, cfg.FirstOpenMaxIDs)
+	}
+	if cfg.Webhook != nil {
+		t.webhook = newWebhook(*cfg.Webhook, func(err error, e *OpenEvent) {
+			t.reportError("webhook", err, e)
+		}, t.startSpan)
+	}
+	if cfg.StreamToken != "" {
+		t.stream = newStream()
+	}
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	if cfg.Retry != nil {
+		t.retrier = newRetrier(*cfg.Retry, t.ctx, func(n int) {
+			if m, ok := cfg.Metrics.(RetryMetrics); ok {
+				m.SetRetryQueued(n)
+			}
+		})
+	}
+	if cfg.Workers > 0 {
+		t.dispatcher = newDispatcher(cfg.Workers, cfg.QueueSize, cfg.QueuePolicy, func(j job) {
+			t.process(t.workContext(j.ctx), j.e)
+		}, func() {
+			t.metrics.IncDropped(DropQueueFull)
+		})
+	}
+	for _, fn := range o.subscribers {
+		t.SubscribeContext(fn)
+	}
+	return t, nil
 }
 
+// NewTracker is like New with WithCallback(cb), but panics if cfg is
+// invalid. Unlike New, and as before Config.Validate existed, it accepts
+// a missing or malformed Port, Domain or Path and incomplete TLS settings,
+// so a tracker whose Handler is mounted elsewhere needs none of them. Links
+// of such a tracker are broken, and without a Path Start fails.
 func NewTracker(cfg Config, cb func(OpenEvent)) *Tracker {
-	return &Tracker{
-		config:   cfg,
-		callback: cb,
+	t, err := newTracker(cfg, true, WithCallback(cb))
+	if err != nil {
+		panic(err)
 	}
+	return t
+}
+
+// OnError registers fn to receive internal failures: bad signatures
+// (ErrBadSignature), undecryptable tokens (ErrInvalidToken), store errors
+// (ErrStoreWrite, ErrStoreRead), SeenSet failures (ErrSeenSet), failed
+// webhook deliveries (ErrWebhookDelivery), sink failures (ErrSinkPublish),
+// link options rejected by WithDomain or WithScheme, subscriber errors,
+// enricher failures (*EnrichError), and subscriber, filter or enricher
+// panics (*PanicError). Match them with errors.Is and errors.As.
+// The event is nil when the failure happened before one was built. Without
+// a hook, errors are logged instead.
+func (t *Tracker) OnError(fn func(err error, e *OpenEvent)) {
+	t.errMu.Lock()
+	t.onError = fn
+	t.errMu.Unlock()
 }
 
+// reportError counts a failure from source and passes it to the hook, or
+// logs it when there is none.
+func (t *Tracker) reportError(source string, err error, e *OpenEvent) {
+	t.metrics.IncError(source)
+	t.errMu.RLock()
+	fn := t.onError
+	t.errMu.RUnlock()
+	if fn != nil {
+		fn(err, e)
+		return
+	}
+	attrs := append(eventAttrs(e), slog.String(LogKeySource, source), slog.String(LogKeyError, err.Error()))
+	var pe *PanicError
+	if errors.As(err, &pe) {
+		attrs = append(attrs, slog.Any(LogKeyPanic, pe.Value), slog.String(LogKeyStack, string(pe.Stack)))
+	}
+	t.log.Error("tracker error", attrs...)
+}
+
+// Handler serves the tracking pixel, or 204 No Content to beacon requests
+// (see Config.Beacon). Methods other than GET and HEAD get 405 Method Not
+// Allowed; beacons may also POST, and with AllowedOrigins CORS preflights
+// get 204 No Content.
 func (t *Tracker) Handler() http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		event := OpenEvent{
-			ID:            r.URL.Query().Get("id"),
-			IP:            getIP(r),
-			XForwardedFor: r.Header.Get("X-Forwarded-For"),
-			UserAgent:     r.Header.Get("User-Agent"),
-			Referer:       r.Header.Get("Referer"),
-			AcceptLang:    r.Header.Get("Accept-Language"),
-			Time:          time.Now(),
+	return t.instrument(RoutePixel, func(w http.ResponseWriter, r *http.Request) {
+		if t.preflight(w, r) {
+			return
+		}
+		t.setCORSHeaders(w, r)
+		beacon := t.beacon(r)
+		if !(beacon && r.Method == http.MethodPost) && !allowGetHead(w, r) {
+			return
+		}
+		query := r.URL.Query()
+		t.setAMPHeaders(w, r, query)
+		l, known := t.requestLink(r, query)
+		if !known {
+			t.drop(r, DropUnknownPath)
+			if t.config.RejectUnknownPaths {
+				http.NotFound(w, r)
+				return
+			}
+			t.writeResponse(w, r, beacon)
+			return
+		}
+		id := l.id
+		var metadata map[string]string
+		if token := cmp.Or(query.Get(tokenParam), l.token); token != "" && t.aead != nil {
+			payload, err := t.decodeToken(token)
+			if err != nil {
+				t.warnRequest(r, "invalid token", "", err)
+				t.reportError("token", err, nil)
+				t.drop(r, DropInvalidToken)
+				t.writeResponse(w, r, beacon)
+				return
+			}
+			id, metadata = payload[TokenIDKey], payload
+		} else if t.signed() && !t.keys.verify(l.kid, l.sig, l.sigParts()...) {
+			t.warnRequest(r, "invalid signature", id, nil)
+			t.reportError("signature", fmt.Errorf("%w: id %q", ErrBadSignature, id), nil)
+			t.drop(r, DropInvalidSignature)
+			if t.config.RejectInvalidSignatures {
+				http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+				return
+			}
+			t.writeResponse(w, r, beacon)
+			return
+		}
+		t.traceID(r, id)
+		if reason, reject := t.rejectID(id); reject {
+			t.warnRequest(r, "rejected id", id, nil)
+			t.drop(r, reason)
+			if t.config.RejectUnknownIDs {
+				http.NotFound(w, r)
+				return
+			}
+			t.writeResponse(w, r, beacon)
+			return
 		}
-		if t.callback != nil {
-			t.callback(event)
+		if !t.sampled(id) {
+			t.drop(r, DropSampled)
+			t.writeResponse(w, r, beacon)
+			return
 		}
-		w.Header().Set("Content-Type", "image/gif")
-		w.WriteHeader(http.StatusOK)
-		w.Write(pixelData)
+		isExpired := l.exp != "" && expired(l.exp, t.now())
+		if isExpired && !t.config.EmitExpired {
+			t.drop(r, DropExpired)
+			t.writeResponse(w, r, beacon)
+			return
+		}
+		if r.Method == http.MethodHead && !t.config.EmitHEAD {
+			t.drop(r, DropHead)
+			t.writeResponse(w, r, beacon)
+			return
+		}
+		prefetch := isPrefetch(r)
+		if prefetch && t.config.DropPrefetch {
+			t.drop(r, DropPrefetch)
+			t.writeResponse(w, r, beacon)
+			return
+		}
+		dnt := t.config.RespectDoNotTrack && doNotTrack(r)
+		if dnt && !t.config.DoNotTrackMinimal {
+			t.drop(r, DropDoNotTrack)
+			t.writeResponse(w, r, beacon)
+			return
+		}
+		etag := t.etag(id)
+		ip, ipSource := t.clientIP(r)
+		if t.deniedIP(ip) {
+			t.drop(r, DropDeniedIP)
+			t.writeResponse(w, r, beacon)
+			return
+		}
+		if t.limiter != nil && !t.limiter.allow(id, t.anonymizeIP(ip), t.now()) {
+			t.drop(r, DropRateLimited)
+			t.writeResponse(w, r, beacon)
+			return
+		}
+		var event OpenEvent
+		if dnt {
+			event = minimalEvent(id, t.now())
+			event.Method, event.Host, event.Path = r.Method, t.requestHost(r), r.URL.Path
+			event.DNT = true
+		} else {
+			event = t.newEvent(r, id, ip, ipSource)
+			event.Metadata = metadata
+			event.Params = t.extraParams(query)
+		}
+		if query.Get(ampParam) == "1" {
+			event.Source = SourceAMP
+			if !dnt {
+				t.ampParams(&event, query)
+			}
+		}
+		event.Expired = isExpired
+		event.Prefetch = prefetch
+		if !dnt {
+			event.Nonce = l.nonce
+			event.Replay = t.replay(&event)
+			event.TimeToOpen, event.TimeToOpenSkewed = timeToOpen(l.sent, event.Time)
+			t.classifyPrefetch(&event)
+			t.classifyConfidence(&event, l.sent)
+		}
+		event.Revalidated = t.notModified(r, etag)
+		event.SampleRate = t.sampleRate()
+		t.traceEvent(r, &event)
+		claim, merged := t.debounced(&event)
+		switch {
+		case merged:
+			t.drop(r, DropDebounced)
+		case t.filtered(&event):
+			t.traceOutcome(r, string(DropFiltered))
+		case event.IsBot && t.config.DropBots:
+			t.drop(r, DropBot)
+		case !event.Prefetch && t.duplicate(&event):
+			t.drop(r, DropDedup)
+		case t.overCap(&event):
+			t.drop(r, DropOpenCap)
+		default:
+			if t.seen != nil && !event.Prefetch {
+				event.FirstOpen = t.firstOpen(&event)
+			}
+			t.traceOutcome(r, outcomeTracked)
+			t.emitDebounced(r.Context(), claim, event)
+		}
+		t.debounce.cancel(claim)
+		if beacon {
+			t.writeResponse(w, r, true)
+			return
+		}
+		setHeaders(w.Header(), "Etag", etag, "Last-Modified", t.lastModified)
+		if event.Revalidated {
+			t.setCacheHeaders(w)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		t.writeResponse(w, r, beacon)
+	})
+}
+
+// minimalEvent is an event holding no personal data.
+func minimalEvent(id string, now time.Time) OpenEvent {
+	campaign, recipient, _ := ParseCampaignID(id)
+	return OpenEvent{Kind: EventOpen, ID: id, EventID: newEventID(now), CampaignID: campaign, RecipientID: recipient, Time: now}
+}
+
+// doNotTrack reports whether r opts out of tracking via DNT or Sec-GPC.
+func doNotTrack(r *http.Request) bool {
+	// Canonical keys spare Header.Get an allocation.
+	return r.Header.Get("Dnt") == "1" || r.Header.Get("Sec-Gpc") == "1"
+}
+
+// newEvent captures the request data shared by every event kind.
+func (t *Tracker) newEvent(r *http.Request, id, ip, ipSource string) OpenEvent {
+	if t.config.PrivacyMode {
+		e := minimalEvent(id, t.now())
+		e.Method, e.Host, e.Path = r.Method, t.requestHost(r), r.URL.Path
+		e.TLS, e.TLSVersion, e.CipherSuite = t.requestTLS(r)
+		return e
+	}
+	campaign, recipient, _ := ParseCampaignID(id)
+	now := t.now()
+	e := OpenEvent{
+		Kind:          EventOpen,
+		ID:            id,
+		EventID:       newEventID(now),
+		CorrelationID: correlationID(r),
+		CampaignID:    campaign,
+		RecipientID:   recipient,
+		IP:            ip,
+		IPSource:      ipSource,
+		Method:        r.Method,
+		Host:          t.requestHost(r),
+		Path:          r.URL.Path,
+		XForwardedFor: r.Header.Get("X-Forwarded-For"),
+		UserAgent:     r.Header.Get("User-Agent"),
+		Referer:       r.Header.Get("Referer"),
+		AcceptLang:    r.Header.Get("Accept-Language"),
+		Time:          now,
+	}
+	e.TLS, e.TLSVersion, e.CipherSuite = t.requestTLS(r)
+	e.Headers = t.captureHeaders(r.Header)
+	e.Languages = ParseAcceptLanguage(e.AcceptLang)
+	e.PrimaryLanguage = primaryLanguage(e.Languages)
+	e.BotName = t.detectBot(e.UserAgent)
+	e.IsBot = e.BotName != ""
+	e.ProxiedBy = t.detectProxy(e.UserAgent, e.IP)
+	e.Proxied = e.ProxiedBy != ""
+	if t.config.CDNCountry && !e.Proxied {
+		e.Geo.Country = t.cdnCountry(r)
+	}
+	e.EmailClient, e.EmailClientFamily = t.detectClient(&e)
+	if t.config.UAParser != nil {
+		e.UserAgentInfo = t.config.UAParser.Parse(e.UserAgent)
+	}
+	return e
+}
+
+const defaultCacheControl = "no-store, no-cache, must-revalidate"
+
+func (t *Tracker) setCacheHeaders(w http.ResponseWriter) {
+	if t.config.DisableCacheHeaders {
+		return
 	}
+	cc := t.config.CacheControl
+	if cc == "" {
+		cc = defaultCacheControl
+	}
+	setHeaders(w.Header(), "Cache-Control", cc, "Pragma", "no-cache", "Expires", "0")
 }
 
-func (t *Tracker) Start() error {
-	http.HandleFunc(t.config.Path, t.Handler())
-	addr := fmt.Sprintf(":%d", t.config.Port)
-	return http.ListenAndServe(addr, nil)
+// setHeaders is Header.Set for pairs of canonical keys and values, with one
+// allocation backing all the values. Values are capped so Header.Add on one
+// can't overwrite the next.
+func setHeaders(h http.Header, kv ...string) {
+	vals := make([]string, len(kv)/2)
+	for i := range vals {
+		vals[i] = kv[2*i+1]
+		h[kv[2*i]] = vals[i : i+1 : i+1]
+	}
 }
 
-func (t *Tracker) GenerateLink(id string) string {
-	protocol := "https"
-	// Use http for localhost or 127.0.0.1
-	if t.config.Domain == "localhost" ||
-		t.config.Domain == "localhost:"+fmt.Sprint(t.config.Port) ||
-		t.config.Domain == "127.0.0.1" ||
-		t.config.Domain == "127.0.0.1:"+fmt.Sprint(t.config.Port) {
-		protocol = "http"
+func (t *Tracker) writePixel(w http.ResponseWriter, r *http.Request) {
+	t.setCacheHeaders(w)
+	setHeaders(w.Header(), "Content-Type", t.pixel.contentType, "Content-Length", t.pixel.length)
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write(t.pixel.data)
 	}
-	return fmt.Sprintf("%s://%s%s?id=%s", protocol, t.config.Domain, t.config.Path, id)
 }
 
-func getIP(r *http.Request) string {
-	// If behind proxy, prefer X-Forwarded-For
-	if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
-		return xff
+// GenerateLink returns the tracking pixel URL for id, with id URL-encoded.
+// It always returns a link: rejected options and WithParams names are left
+// out and reported to the OnError hook. BuildLink reports them instead.
+func (t *Tracker) GenerateLink(id string, opts ...LinkOption) string {
+	t.register(id)
+	o := applyLinkOptions(opts)
+	if err := errors.Join(o.err, t.checkParams(o.params)); err != nil {
+		t.reportError("link", err, nil)
 	}
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+	u := t.linkURL(id, o)
+	return u.String()
+}
+
+// host is the link host: Config.Domain, plus the bound port when the tracker
+// listens on an ephemeral port (Port 0) and Domain names none.
+func (t *Tracker) host() string { return t.hostOf(t.config.Domain) }
+
+func (t *Tracker) hostOf(domain string) string {
+	t.mu.Lock()
+	port := t.linkPort
+	t.mu.Unlock()
+	if port == 0 {
+		return domain
+	}
+	if _, _, err := net.SplitHostPort(domain); err == nil {
+		return domain
+	}
+	return net.JoinHostPort(strings.Trim(domain, "[]"), strconv.Itoa(port))
+}
+
+func (t *Tracker) scheme() string { return t.schemeOf(t.config.Domain) }
+
+func (t *Tracker) schemeOf(domain string) string {
+	if t.config.Scheme != "" {
+		return t.config.Scheme
 	}
-	return host
+	// Use http for localhost or loopback, unless we serve TLS ourselves
+	if !t.tlsEnabled() && t.isLoopback(domain) {
+		return "http"
+	}
+	return "https"
+}
+
+// origin returns the scheme and host of a link built with o.
+func (t *Tracker) origin(o linkOptions) (scheme, host string) {
+	domain := cmp.Or(o.domain, t.config.Domain)
+	return cmp.Or(o.scheme, t.schemeOf(domain)), t.hostOf(domain)
 }
 
-// 1x1 Transparent Gif
-var pixelData = []byte{
-	0x47, 0x49, 0x46, 0x38, 0x39, 0x61, 0x01, 0x00,
-	0x01, 0x00, 0x80, 0x00, 0x00, 0x00, 0x00, 0x00,
-	0xFF, 0xFF, 0xFF, 0x21, 0xF9, 0x04, 0x01, 0x00,
-	0x00, 0x00, 0x00, 0x2C, 0x00, 0x00, 0x00, 0x00,
-	0x01, 0x00, 0x01, 0x00, 0x00, 0x02, 0x02, 0x44,
-	0x01, 0x00, 0x3B,
+// isLoopback is isLoopbackHost, answered in advance for Config.Domain.
+func (t *Tracker) isLoopback(domain string) bool {
+	if domain == t.config.Domain {
+		return t.loopback
+	}
+	return isLoopbackHost(domain)
+}
+
+// isLoopbackHost reports whether domain, with or without a port, names
+// localhost or a loopback IP such as 127.0.0.1 or [::1].
+func isLoopbackHost(domain string) bool {
+	host := domain
+	if h, _, err := net.SplitHostPort(domain); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "localhost" {
+		return true
+	}
+	addr, err := netip.ParseAddr(host)
+	return err == nil && addr.IsLoopback()
 }
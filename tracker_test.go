@@ -0,0 +1,345 @@
+package emailtracker
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newTestTracker returns an unstarted tracker for cfg, Domain and Path
+// defaulting to tracker.test and /pixel, and the log of events its
+// subscriber received. The tracker is shut down when the test ends.
+func newTestTracker(t testing.TB, cfg Config, opts ...Option) (*Tracker, *eventLog) {
+	t.Helper()
+	if cfg.Domain == "" {
+		cfg.Domain = "tracker.test"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/pixel"
+	}
+	tr, err := New(cfg, opts...)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	log := newEventLog()
+	tr.Subscribe(log.add)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := tr.Shutdown(ctx); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	})
+	return tr, log
+}
+
+// eventLog records the events a subscriber receives.
+type eventLog struct {
+	mu      sync.Mutex
+	events  []OpenEvent
+	changed chan struct{} // closed and replaced on every event
+}
+
+func newEventLog() *eventLog {
+	return &eventLog{changed: make(chan struct{})}
+}
+
+func (l *eventLog) add(e OpenEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// all returns the events received so far, oldest first.
+func (l *eventLog) all() []OpenEvent {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]OpenEvent(nil), l.events...)
+}
+
+// wait returns the events once there are at least n, failing the test after
+// five seconds.
+func (l *eventLog) wait(t testing.TB, n int) []OpenEvent {
+	t.Helper()
+	deadline := time.After(5 * time.Second)
+	for {
+		l.mu.Lock()
+		events, changed := append([]OpenEvent(nil), l.events...), l.changed
+		l.mu.Unlock()
+		if len(events) >= n {
+			return events
+		}
+		select {
+		case <-changed:
+		case <-deadline:
+			t.Fatalf("got %d events, want %d", len(events), n)
+		}
+	}
+}
+
+// errorLog records the errors a tracker reports to its OnError hook.
+type errorLog struct {
+	mu   sync.Mutex
+	errs []error
+}
+
+func recordErrors(tr *Tracker) *errorLog {
+	l := &errorLog{}
+	tr.OnError(func(err error, _ *OpenEvent) {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		l.errs = append(l.errs, err)
+	})
+	return l
+}
+
+// all returns the errors reported so far.
+func (l *errorLog) all() []error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]error(nil), l.errs...)
+}
+
+// must returns v, panicking on err.
+func must[T any](v T, err error) T {
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// get serves a GET of target, a path or a full link, through h with the
+// given header name and value pairs. The request comes from 192.0.2.1.
+func get(h http.Handler, target string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, target, nil)
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Add(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewTrackerAcceptsConfigsFromBeforeValidate(t *testing.T) {
+	var got []OpenEvent
+	tr := NewTracker(Config{Port: 8080}, func(e OpenEvent) { got = append(got, e) })
+	defer tr.Shutdown(context.Background())
+
+	w := get(tr.Handler(), "/track?id=msg-1")
+	if w.Code != http.StatusOK || w.Body.Len() == 0 {
+		t.Errorf("pixel: status %d, %d bytes", w.Code, w.Body.Len())
+	}
+	if len(got) != 1 || got[0].ID != "msg-1" {
+		t.Errorf("events %+v, want one for msg-1", got)
+	}
+	if err := tr.Start(); err == nil {
+		t.Error("Start succeeded without a Path")
+	}
+	if _, err := New(Config{Port: 8080}); err == nil {
+		t.Error("New accepted a config without Domain and Path")
+	}
+}
+
+func TestNewTrackerPanicsOnInvalidConfig(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("NewTracker didn't panic")
+		}
+	}()
+	NewTracker(Config{Domain: "tracker.test", Path: "/pixel", TrustedProxies: []string{"not-a-cidr"}}, nil)
+}
+
+func BenchmarkHandler(b *testing.B) {
+	for _, bb := range []struct {
+		name string
+		cfg  Config
+		ua   string
+	}{
+		{name: "default", cfg: Config{}},
+		{name: "signed", cfg: Config{SigningKey: []byte("benchmark-key")}},
+		{name: "bot-dropped", cfg: Config{DropBots: true}, ua: "Googlebot/2.1"},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			bb.cfg.Domain, bb.cfg.Path = "tracker.test", "/pixel"
+			tr, err := New(bb.cfg)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer tr.Shutdown(context.Background())
+			h, link := tr.Handler(), tr.GenerateLink("msg-1")
+			b.ReportAllocs()
+			for b.Loop() {
+				get(h, link, "User-Agent", bb.ua)
+			}
+		})
+	}
+}
+
+func TestCacheHeaders(t *testing.T) {
+	for _, tt := range []struct {
+		name                   string
+		cfg                    Config
+		cache, pragma, expires string
+	}{
+		{"default", Config{}, "no-store, no-cache, must-revalidate", "no-cache", "0"},
+		{"override", Config{CacheControl: "private, max-age=60"}, "private, max-age=60", "no-cache", "0"},
+		{"disabled", Config{DisableCacheHeaders: true, CacheControl: "ignored"}, "", "", ""},
+	} {
+		tr, _ := newTestTracker(t, tt.cfg)
+		h := get(tr.Handler(), tr.GenerateLink("msg-1")).Header()
+		if got := h.Get("Cache-Control"); got != tt.cache {
+			t.Errorf("%s: Cache-Control %q, want %q", tt.name, got, tt.cache)
+		}
+		if got := h.Get("Pragma"); got != tt.pragma {
+			t.Errorf("%s: Pragma %q, want %q", tt.name, got, tt.pragma)
+		}
+		if got := h.Get("Expires"); got != tt.expires {
+			t.Errorf("%s: Expires %q, want %q", tt.name, got, tt.expires)
+		}
+	}
+}
+
+// failingStore is a Store whose every call fails.
+type failingStore struct{}
+
+var errBroken = errors.New("store broken")
+
+func (failingStore) Save(OpenEvent) error                { return errBroken }
+func (failingStore) ByID(string) ([]OpenEvent, error)    { return nil, errBroken }
+func (failingStore) Each(func(OpenEvent) bool) error     { return errBroken }
+func (failingStore) CountOpens(string, int) (int, error) { return 0, errBroken }
+
+func TestOnErrorSentinels(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		cfg     Config
+		request func(tr *Tracker) string
+		want    []error
+	}{
+		{
+			name:    "bad signature",
+			cfg:     Config{SigningKey: []byte("secret")},
+			request: func(*Tracker) string { return "/pixel?id=msg-1&sig=forged" },
+			want:    []error{ErrBadSignature},
+		},
+		{
+			name:    "invalid token",
+			cfg:     Config{EncryptionKey: []byte("0123456789abcdef")},
+			request: func(*Tracker) string { return "/pixel?t=garbage" },
+			want:    []error{ErrInvalidToken},
+		},
+		{
+			name:    "store write",
+			cfg:     Config{Store: failingStore{}},
+			request: func(tr *Tracker) string { return tr.GenerateLink("msg-1") },
+			want:    []error{ErrStoreWrite, errBroken},
+		},
+		{
+			name:    "store read",
+			cfg:     Config{Store: failingStore{}, TrackFirstOpen: true},
+			request: func(tr *Tracker) string { return tr.GenerateLink("msg-1") },
+			want:    []error{ErrStoreRead, errBroken},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			tr, _ := newTestTracker(t, tt.cfg)
+			errs := recordErrors(tr)
+			get(tr.Handler(), tt.request(tr))
+			got := errs.all()
+			if len(got) == 0 {
+				t.Fatal("nothing reported")
+			}
+			for _, want := range tt.want {
+				if !errors.Is(got[0], want) {
+					t.Errorf("reported %v, want it to wrap %v", got[0], want)
+				}
+			}
+		})
+	}
+}
+
+func TestErrorsWithoutHookAreLogged(t *testing.T) {
+	var buf bytes.Buffer
+	tr, _ := newTestTracker(t, Config{SigningKey: []byte("secret")},
+		WithLogger(slog.New(slog.NewTextHandler(&buf, nil))))
+	get(tr.Handler(), "/pixel?id=msg-1&sig=forged")
+	if out := buf.String(); !strings.Contains(out, "tracker error") || !strings.Contains(out, ErrBadSignature.Error()) {
+		t.Errorf("log output %q, want the signature error", out)
+	}
+}
+
+func TestDoNotTrack(t *testing.T) {
+	const ua = "Mozilla/5.0 (Windows NT 10.0; Win64; x64)"
+	for _, c := range []struct {
+		name   string
+		cfg    Config
+		header []string
+		events int  // events produced
+		dnt    bool // whether they are minimal DNT events
+	}{
+		{name: "DNT", cfg: Config{RespectDoNotTrack: true}, header: []string{"DNT", "1"}},
+		{name: "Sec-GPC", cfg: Config{RespectDoNotTrack: true}, header: []string{"Sec-GPC", "1"}},
+		{name: "DNT 0", cfg: Config{RespectDoNotTrack: true}, header: []string{"DNT", "0"}, events: 1},
+		{name: "no header", cfg: Config{RespectDoNotTrack: true}, events: 1},
+		{name: "disabled", cfg: Config{}, header: []string{"DNT", "1", "Sec-GPC", "1"}, events: 1},
+		{name: "minimal DNT", cfg: Config{RespectDoNotTrack: true, DoNotTrackMinimal: true}, header: []string{"DNT", "1"}, events: 1, dnt: true},
+		{name: "minimal Sec-GPC", cfg: Config{RespectDoNotTrack: true, DoNotTrackMinimal: true}, header: []string{"Sec-GPC", "1"}, events: 1, dnt: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			tr, log := newTestTracker(t, c.cfg)
+			w := get(tr.Handler(), tr.GenerateLink("msg-1"), append([]string{"User-Agent", ua}, c.header...)...)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/gif" {
+				t.Errorf("status %d, Content-Type %q", w.Code, w.Header().Get("Content-Type"))
+			}
+			events := log.all()
+			if len(events) != c.events {
+				t.Fatalf("%d events, want %d", len(events), c.events)
+			}
+			dropped := tr.Metrics().EventsDropped[DropDoNotTrack]
+			if want := uint64(1 - c.events); !c.dnt && dropped != want {
+				t.Errorf("%d do_not_track drops, want %d", dropped, want)
+			}
+			if c.events == 0 {
+				return
+			}
+			e := events[0]
+			if e.DNT != c.dnt || e.ID != "msg-1" || e.Time.IsZero() {
+				t.Errorf("event DNT %v, ID %q, Time %v", e.DNT, e.ID, e.Time)
+			}
+			if minimal := e.UserAgent == "" && e.IP == ""; minimal != c.dnt {
+				t.Errorf("event has User-Agent %q, IP %q", e.UserAgent, e.IP)
+			}
+		})
+	}
+}
+
+// testClock is a Clock that only moves when told to.
+type testClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newTestClock() *testClock {
+	return &testClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
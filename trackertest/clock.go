@@ -0,0 +1,74 @@
+package trackertest
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is an emailtracker.TickerClock that only moves when told to.
+// Set it as Config.Clock to make event times, dedup windows, link expiry,
+// report windows and debouncing deterministic.
+//
+//	clock := trackertest.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+//	tr := trackertest.NewTracker(t, emailtracker.Config{Clock: clock, DedupWindow: time.Minute})
+//	tr.SimulateOpen("msg-42")
+//	clock.Advance(2 * time.Minute)
+//	tr.SimulateOpen("msg-42") // outside the window, so recorded again
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+type fakeTicker struct {
+	c      chan time.Time
+	period time.Duration
+	next   time.Time
+}
+
+// NewFakeClock returns a FakeClock reading start.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the clock's time.
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock forward by d, firing the tickers that fall due.
+// Like real tickers, a ticker whose receiver hasn't kept up drops ticks.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	for _, tk := range c.tickers {
+		for !tk.next.After(c.now) {
+			select {
+			case tk.c <- tk.next:
+			default:
+			}
+			tk.next = tk.next.Add(tk.period)
+		}
+	}
+}
+
+// NewTicker implements emailtracker.TickerClock.
+func (c *FakeClock) NewTicker(d time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	tk := &fakeTicker{c: make(chan time.Time, 1), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, tk)
+	return tk.c, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for i, other := range c.tickers {
+			if other == tk {
+				c.tickers = append(c.tickers[:i:i], c.tickers[i+1:]...)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package trackertest
+
+import (
+	"testing"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+func TestFakeClockDedup(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	tr := NewTracker(t, emailtracker.Config{Clock: clock, DedupWindow: time.Minute})
+	tr.SimulateOpen("msg-42")
+	clock.Advance(30 * time.Second)
+	tr.SimulateOpen("msg-42") // within the window
+	clock.Advance(2 * time.Minute)
+	tr.SimulateOpen("msg-42")
+
+	events := tr.Events()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if want := start.Add(150 * time.Second); !events[0].Time.Equal(start) || !events[1].Time.Equal(want) {
+		t.Errorf("event times %v, %v; want %v, %v", events[0].Time, events[1].Time, start, want)
+	}
+}
+
+func TestFakeClockTicker(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	tick, stop := clock.NewTicker(time.Second)
+	ticked := func() (time.Time, bool) {
+		select {
+		case at := <-tick:
+			return at, true
+		default:
+			return time.Time{}, false
+		}
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if at, ok := ticked(); ok {
+		t.Errorf("ticked at %v before the period", at)
+	}
+	clock.Advance(500 * time.Millisecond)
+	if at, ok := ticked(); !ok || !at.Equal(clock.Now()) {
+		t.Errorf("tick = %v, %v; want one at %v", at, ok, clock.Now())
+	}
+
+	// An unread ticker keeps one tick and drops the rest.
+	clock.Advance(5 * time.Second)
+	if _, ok := ticked(); !ok {
+		t.Error("no tick after five periods")
+	}
+	if at, ok := ticked(); ok {
+		t.Errorf("second buffered tick at %v", at)
+	}
+
+	stop()
+	clock.Advance(time.Hour)
+	if at, ok := ticked(); ok {
+		t.Errorf("stopped ticker ticked at %v", at)
+	}
+}
+
+// tickers returns how many tickers c is running.
+func tickers(c *FakeClock) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.tickers)
+}
+
+func TestFakeClockDrivesReports(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewFakeClock(start)
+	tr := NewTracker(t, emailtracker.Config{Clock: clock})
+	reports := make(chan emailtracker.Report, 4)
+	tr.OnReport(time.Hour, func(r emailtracker.Report) { reports <- r })
+	for deadline := time.Now().Add(WaitTimeout); tickers(clock) == 0; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("OnReport didn't start a ticker on the clock")
+		}
+	}
+
+	tr.SimulateOpen("msg-1")
+	tr.SimulateOpen("msg-2")
+	clock.Advance(59 * time.Minute)
+	select {
+	case r := <-reports:
+		t.Fatalf("report %+v before the interval passed", r)
+	case <-time.After(20 * time.Millisecond):
+	}
+	clock.Advance(time.Minute)
+	select {
+	case r := <-reports:
+		if !r.Start.Equal(start) || !r.End.Equal(start.Add(time.Hour)) || r.Opens != 2 || r.UniqueIDs != 2 {
+			t.Errorf("report %+v, want 2 opens from %v to an hour later", r, start)
+		}
+	case <-time.After(WaitTimeout):
+		t.Fatal("no report after the interval passed")
+	}
+}
@@ -0,0 +1,213 @@
+// Package trackertest runs a real tracker for tests of code that consumes
+// OpenEvents, and simulates opens against it.
+//
+//	tr := trackertest.NewTracker(t, emailtracker.Config{SigningKey: key})
+//	tr.SimulateOpen("msg-42", trackertest.WithUserAgent(outlookUA))
+//	events := tr.WaitForEvents(1)
+//
+// Links are built by the tracker itself, so signed, expiring and encrypted
+// links verify exactly as in production.
+package trackertest
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+// DefaultUserAgent is sent by SimulateOpen unless WithUserAgent overrides it.
+const DefaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36"
+
+// WaitTimeout bounds WaitForEvents.
+var WaitTimeout = 5 * time.Second
+
+// Tracker is an emailtracker.Tracker listening on an ephemeral loopback
+// port, with a subscriber recording every event.
+type Tracker struct {
+	*emailtracker.Tracker
+
+	tb     testing.TB
+	client *http.Client
+
+	mu      sync.Mutex
+	events  []emailtracker.OpenEvent
+	changed chan struct{} // closed and replaced on every event
+}
+
+// NewTracker starts a tracker for cfg on a free port and shuts it down when
+// the test ends. Domain defaults to 127.0.0.1 and Path to /pixel; Port is
+// always 0. opts are passed to emailtracker.New. It fails the test if the
+// tracker can't start.
+func NewTracker(tb testing.TB, cfg emailtracker.Config, opts ...emailtracker.Option) *Tracker {
+	tb.Helper()
+	cfg.Port = 0
+	if cfg.Domain == "" {
+		cfg.Domain = "127.0.0.1"
+	}
+	if cfg.Path == "" {
+		cfg.Path = "/pixel"
+	}
+	et, err := emailtracker.New(cfg, opts...)
+	if err != nil {
+		tb.Fatalf("trackertest: %v", err)
+	}
+	t := &Tracker{
+		Tracker: et,
+		tb:      tb,
+		client: &http.Client{
+			Timeout: 10 * time.Second,
+			// Click links redirect to their target; report the redirect.
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+		changed: make(chan struct{}),
+	}
+	et.Subscribe(t.record)
+
+	errc := make(chan error, 1)
+	go func() { errc <- et.Start() }()
+	select {
+	case <-et.Started():
+	case err := <-errc:
+		tb.Fatalf("trackertest: start: %v", err)
+	}
+	tb.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), WaitTimeout)
+		defer cancel()
+		if err := et.Shutdown(ctx); err != nil {
+			tb.Errorf("trackertest: shutdown: %v", err)
+		}
+	})
+	return t
+}
+
+func (t *Tracker) record(e emailtracker.OpenEvent) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, e)
+	close(t.changed)
+	t.changed = make(chan struct{})
+}
+
+// OpenOption customizes a simulated open.
+type OpenOption func(*openOptions)
+
+type openOptions struct {
+	header http.Header
+	link   []emailtracker.LinkOption
+}
+
+// WithUserAgent sets the User-Agent header.
+func WithUserAgent(ua string) OpenOption {
+	return func(o *openOptions) { o.header.Set("User-Agent", ua) }
+}
+
+// WithIP makes the open come from ip, through X-Forwarded-For. With
+// Config.TrustedProxies set, it works only if 127.0.0.1 is listed.
+func WithIP(ip string) OpenOption {
+	return func(o *openOptions) { o.header.Set("X-Forwarded-For", ip) }
+}
+
+// WithHeader sets any other request header.
+func WithHeader(key, value string) OpenOption {
+	return func(o *openOptions) { o.header.Set(key, value) }
+}
+
+// WithLinkOptions builds the link with opts, e.g. emailtracker.WithExpiry.
+func WithLinkOptions(opts ...emailtracker.LinkOption) OpenOption {
+	return func(o *openOptions) { o.link = append(o.link, opts...) }
+}
+
+func applyOpenOptions(opts []OpenOption) openOptions {
+	o := openOptions{header: http.Header{
+		"User-Agent":      {DefaultUserAgent},
+		"Accept":          {"image/avif,image/webp,image/apng,image/*,*/*;q=0.8"},
+		"Accept-Language": {"en-US,en;q=0.9"},
+	}}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
+
+// SimulateOpen loads the pixel link the tracker generates for id, as a mail
+// client would. The returned response's body has been read and closed.
+func (t *Tracker) SimulateOpen(id string, opts ...OpenOption) *http.Response {
+	t.tb.Helper()
+	o := applyOpenOptions(opts)
+	return t.Get(t.GenerateLink(id, o.link...), opts...)
+}
+
+// SimulateTokenOpen loads an encrypted token link for payload; it needs
+// Config.EncryptionKey.
+func (t *Tracker) SimulateTokenOpen(payload map[string]string, opts ...OpenOption) *http.Response {
+	t.tb.Helper()
+	link, err := t.GenerateTokenLink(payload)
+	if err != nil {
+		t.tb.Fatalf("trackertest: %v", err)
+	}
+	return t.Get(link, opts...)
+}
+
+// Get requests link, which may be any link the tracker generated, including
+// click and unsubscribe links, with the headers from opts. Redirects are not
+// followed. It fails the test if the request fails.
+func (t *Tracker) Get(link string, opts ...OpenOption) *http.Response {
+	t.tb.Helper()
+	req, err := http.NewRequest(http.MethodGet, link, nil)
+	if err != nil {
+		t.tb.Fatalf("trackertest: %v", err)
+	}
+	req.Header = applyOpenOptions(opts).header
+	resp, err := t.client.Do(req)
+	if err != nil {
+		t.tb.Fatalf("trackertest: %v", err)
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp
+}
+
+// Events returns the events recorded so far, oldest first. It shadows
+// emailtracker.Tracker.Events; call t.Tracker.Events for a channel.
+func (t *Tracker) Events() []emailtracker.OpenEvent {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]emailtracker.OpenEvent(nil), t.events...)
+}
+
+// WaitForEvents waits until at least n events have been recorded and returns
+// them, failing the test after WaitTimeout. Use it when Config.Workers
+// delivers events asynchronously.
+func (t *Tracker) WaitForEvents(n int) []emailtracker.OpenEvent {
+	t.tb.Helper()
+	deadline := time.NewTimer(WaitTimeout)
+	defer deadline.Stop()
+	for {
+		t.mu.Lock()
+		if len(t.events) >= n {
+			events := append([]emailtracker.OpenEvent(nil), t.events...)
+			t.mu.Unlock()
+			return events
+		}
+		changed, got := t.changed, len(t.events)
+		t.mu.Unlock()
+		select {
+		case <-changed:
+		case <-deadline.C:
+			t.tb.Fatalf("trackertest: got %d events after %v, want %d", got, WaitTimeout, n)
+			return nil
+		}
+	}
+}
+
+// Reset forgets the recorded events.
+func (t *Tracker) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = nil
+}
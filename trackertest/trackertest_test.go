@@ -0,0 +1,120 @@
+package trackertest
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	emailtracker "github.com/jasnrathore/trackingmail"
+)
+
+const outlookUA = "Microsoft Office/16.0 (Windows NT 10.0; Microsoft Outlook 16.0.17029; Pro)"
+
+func TestSimulateOpen(t *testing.T) {
+	tr := NewTracker(t, emailtracker.Config{})
+	if !strings.HasPrefix(tr.GenerateLink("x"), "http://127.0.0.1:") {
+		t.Fatalf("link %q isn't on the loopback listener", tr.GenerateLink("x"))
+	}
+
+	if resp := tr.SimulateOpen("msg-1"); resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "image/gif" {
+		t.Errorf("SimulateOpen: %d %q, want a 200 GIF", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	tr.SimulateOpen("msg-2",
+		WithUserAgent(outlookUA),
+		WithIP("8.8.8.8"),
+		WithHeader("Referer", "https://mail.example.com/"),
+	)
+
+	events := tr.Events()
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if e := events[0]; e.ID != "msg-1" || e.UserAgent != DefaultUserAgent || e.IP != "127.0.0.1" || e.AcceptLang != "en-US,en;q=0.9" {
+		t.Errorf("default open = %+v", e)
+	}
+	if e := events[1]; e.ID != "msg-2" || e.UserAgent != outlookUA || e.IP != "8.8.8.8" || e.Referer != "https://mail.example.com/" {
+		t.Errorf("customized open = %+v", e)
+	}
+
+	tr.Reset()
+	if events := tr.Events(); len(events) != 0 {
+		t.Errorf("after Reset: %d events, want none", len(events))
+	}
+}
+
+func TestSignedLinks(t *testing.T) {
+	clock := NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	tr := NewTracker(t, emailtracker.Config{SigningKey: []byte("secret"), Clock: clock})
+	other := NewTracker(t, emailtracker.Config{SigningKey: []byte("other")})
+
+	tr.SimulateOpen("signed")
+	tr.Get(other.GenerateLink("forged"))
+	tr.SimulateOpen("expiring", WithLinkOptions(emailtracker.WithExpiry(clock.Now().Add(time.Hour))))
+	clock.Advance(48 * time.Hour)
+	tr.SimulateOpen("expired", WithLinkOptions(emailtracker.WithExpiry(clock.Now().Add(-time.Hour))))
+
+	var ids []string
+	for _, e := range tr.Events() {
+		ids = append(ids, e.ID)
+	}
+	if got := strings.Join(ids, ","); got != "signed,expiring" {
+		t.Errorf("recorded %q, want signed,expiring", got)
+	}
+}
+
+func TestSimulateTokenOpen(t *testing.T) {
+	tr := NewTracker(t, emailtracker.Config{EncryptionKey: []byte("0123456789abcdef0123456789abcdef")})
+	payload := map[string]string{emailtracker.TokenIDKey: "msg-1", "campaign": "spring"}
+	tr.SimulateTokenOpen(payload, WithUserAgent(outlookUA))
+
+	events := tr.WaitForEvents(1)
+	if e := events[0]; e.ID != "msg-1" || e.UserAgent != outlookUA || !maps.Equal(e.Metadata, payload) {
+		t.Errorf("token open = %+v", e)
+	}
+}
+
+func TestWaitForEvents(t *testing.T) {
+	tr := NewTracker(t, emailtracker.Config{Workers: 4})
+	for i := range 20 {
+		tr.SimulateOpen(fmt.Sprint("msg-", i))
+	}
+	if events := tr.WaitForEvents(20); len(events) != 20 {
+		t.Errorf("WaitForEvents(20) returned %d events", len(events))
+	}
+}
+
+// fatalTB records Fatalf and ends the goroutine, as testing.T does.
+type fatalTB struct {
+	testing.TB
+	msg string
+}
+
+func (tb *fatalTB) Helper() {}
+
+func (tb *fatalTB) Fatalf(format string, args ...any) {
+	tb.msg = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func TestWaitForEventsTimeout(t *testing.T) {
+	defer func(d time.Duration) { WaitTimeout = d }(WaitTimeout)
+	WaitTimeout = 50 * time.Millisecond
+
+	tr := NewTracker(t, emailtracker.Config{})
+	tr.SimulateOpen("msg-1")
+	tb := &fatalTB{TB: t}
+	tr.tb = tb
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		tr.WaitForEvents(2)
+	}()
+	<-done
+	if want := "got 1 events"; !strings.Contains(tb.msg, want) {
+		t.Errorf("WaitForEvents failed with %q, want it to mention %q", tb.msg, want)
+	}
+}
@@ -0,0 +1,79 @@
+package emailtracker
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// defaultMaxKeys bounds the in-memory sets used for dedup and similar
+// bookkeeping when no explicit limit is configured.
+const defaultMaxKeys = 100_000
+
+// ttlSet remembers keys for a fixed duration, or until evicted if ttl is not
+// positive. Keys are kept in insertion order, which with a fixed ttl is also
+// expiry order, so expired keys are dropped from the front as the set is
+// used, and when the set is full the oldest key is forgotten to make room.
+// Each add costs O(1) amortized.
+type ttlSet struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	max   int
+	keys  map[string]*list.Element // key -> element holding a ttlEntry
+	order *list.List               // oldest first
+}
+
+type ttlEntry struct {
+	key string
+	exp time.Time // zero for never
+}
+
+func newTTLSet(ttl time.Duration, max int) *ttlSet {
+	if max <= 0 {
+		max = defaultMaxKeys
+	}
+	return &ttlSet{ttl: ttl, max: max, keys: make(map[string]*list.Element), order: list.New()}
+}
+
+// add records key unless it is already present, reporting whether it was.
+// A present key keeps its original expiry.
+func (s *ttlSet) add(key string, now time.Time) (seen bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.keys[key]; ok {
+		if exp := el.Value.(ttlEntry).exp; exp.IsZero() || now.Before(exp) {
+			return true
+		}
+		s.remove(el)
+	}
+	s.sweep(now)
+	var exp time.Time
+	if s.ttl > 0 {
+		exp = now.Add(s.ttl)
+	}
+	s.keys[key] = s.order.PushBack(ttlEntry{key: key, exp: exp})
+	return false
+}
+
+// sweep drops expired keys from the front, then the oldest keys while the
+// set is full.
+func (s *ttlSet) sweep(now time.Time) {
+	for el := s.order.Front(); el != nil; el = s.order.Front() {
+		exp := el.Value.(ttlEntry).exp
+		if len(s.keys) < s.max && (exp.IsZero() || now.Before(exp)) {
+			return
+		}
+		s.remove(el)
+	}
+}
+
+func (s *ttlSet) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.keys, el.Value.(ttlEntry).key)
+}
+
+func (s *ttlSet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.keys)
+}
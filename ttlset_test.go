@@ -0,0 +1,63 @@
+package emailtracker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTTLSetEvictsOldest(t *testing.T) {
+	now := newTestClock().Now()
+	s := newTTLSet(time.Hour, 3)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		s.add(k, now)
+	}
+	if s.len() != 3 {
+		t.Fatalf("len = %d, want 3", s.len())
+	}
+	for _, k := range []string{"b", "c", "d"} {
+		if !s.add(k, now) {
+			t.Errorf("%q forgotten, want only the oldest key evicted", k)
+		}
+	}
+	if s.add("a", now) {
+		t.Error("oldest key still present at capacity")
+	}
+}
+
+func TestTTLSetExpiry(t *testing.T) {
+	clock := newTestClock()
+	s := newTTLSet(time.Hour, 0)
+	s.add("a", clock.Now())
+	clock.advance(30 * time.Minute)
+	s.add("b", clock.Now())
+	clock.advance(31 * time.Minute)
+	s.add("c", clock.Now())
+	if s.len() != 2 {
+		t.Errorf("len = %d, want the expired key swept", s.len())
+	}
+	if s.add("a", clock.Now()) {
+		t.Error("expired key still reported seen")
+	}
+	if !s.add("b", clock.Now()) {
+		t.Error("live key forgotten")
+	}
+	clock.advance(30 * time.Minute)
+	if !s.add("a", clock.Now()) {
+		t.Error("re-added key didn't get a fresh expiry")
+	}
+}
+
+// BenchmarkTTLSetFull measures adds to a set at capacity, which must not
+// scan the set.
+func BenchmarkTTLSetFull(b *testing.B) {
+	now := newTestClock().Now()
+	s := newTTLSet(time.Hour, defaultMaxKeys)
+	for i := range defaultMaxKeys {
+		s.add(fmt.Sprint(i), now)
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		s.add(fmt.Sprint("new-", i), now)
+	}
+}
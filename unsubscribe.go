@@ -0,0 +1,121 @@
+package emailtracker
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"net/url"
+)
+
+// RouteUnsubscribe is the Metrics route label of the unsubscribe endpoint.
+const RouteUnsubscribe = "unsubscribe"
+
+// defaultUnsubscribeConfirmPage asks a visitor of an unsubscribe link to
+// confirm. It is executed with an UnsubscribeConfirmation.
+var defaultUnsubscribeConfirmPage = template.Must(template.New("unsubscribe-confirm").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Unsubscribe</title></head>
+<body><form method="post" action="{{.Action}}"><p>Unsubscribe from these emails?</p><button type="submit">Unsubscribe</button></form></body></html>
+`))
+
+// defaultUnsubscribePage is shown after a successful unsubscribe. It is
+// executed with the unsubscribe OpenEvent.
+var defaultUnsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="viewport" content="width=device-width"><title>Unsubscribed</title></head>
+<body><p>You have been unsubscribed.</p></body></html>
+`))
+
+// UnsubscribeConfirmation is the data Config.UnsubscribeConfirmPage is
+// executed with.
+type UnsubscribeConfirmation struct {
+	ID     string // tracking ID of the link
+	Action string // the signed link, for the form's action; POST to it
+}
+
+// GenerateUnsubscribeLink returns a signed link to the unsubscribe endpoint
+// for id, suitable both for the email body and the List-Unsubscribe header.
+// It requires Config.UnsubscribePath and Config.SigningKey.
+func (t *Tracker) GenerateUnsubscribeLink(id string) (string, error) {
+	if t.config.UnsubscribePath == "" {
+		return "", errors.New("emailtracker: GenerateUnsubscribeLink requires Config.UnsubscribePath")
+	}
+	q := url.Values{}
+	q.Set(t.idParam, id)
+	t.signQuery(q, string(EventUnsubscribe), id)
+	u := url.URL{Scheme: t.scheme(), Host: t.host(), Path: t.config.UnsubscribePath, RawQuery: q.Encode()}
+	return u.String(), nil
+}
+
+// UnsubscribeHandler records an EventUnsubscribe for a signed unsubscribe
+// link. A GET only gets a confirmation page whose form POSTs back to the
+// link, so link scanners and prefetches unsubscribe no one. The event is
+// recorded for that form's POST, with a page saying so, and for an RFC 8058
+// one-click POST (body "List-Unsubscribe=One-Click"), with an empty 200.
+// POSTs go through the same checks as opens: prefetches are always
+// dropped, and denied IPs, rate limits, DropBots and filters apply, without
+// changing the response. Links with a missing or invalid signature get 400
+// Bad Request.
+func (t *Tracker) UnsubscribeHandler() http.HandlerFunc {
+	return t.instrument(RouteUnsubscribe, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodPost {
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		query := r.URL.Query()
+		id := t.queryID(query)
+		if !t.verifyQuery(query, string(EventUnsubscribe), id) {
+			t.warnRequest(r, "invalid unsubscribe signature", id, nil)
+			t.reportError("signature", fmt.Errorf("%w: unsubscribe id %q", ErrBadSignature, id), nil)
+			t.drop(r, DropInvalidSignature)
+			http.Error(w, http.StatusText(http.StatusBadRequest), http.StatusBadRequest)
+			return
+		}
+		t.traceID(r, id)
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == http.MethodGet {
+			page := cmp.Or(t.config.UnsubscribeConfirmPage, defaultUnsubscribeConfirmPage)
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			if err := page.Execute(w, UnsubscribeConfirmation{ID: id, Action: r.URL.RequestURI()}); err != nil {
+				t.log.Error("unsubscribe confirmation page failed", slog.String(LogKeyID, id), slog.String(LogKeyError, err.Error()))
+			}
+			return
+		}
+		event := t.unsubscribe(r, id)
+		if r.PostFormValue("List-Unsubscribe") == "One-Click" {
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		page := cmp.Or(t.config.UnsubscribePage, defaultUnsubscribePage)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := page.Execute(w, event); err != nil {
+			t.log.Error("unsubscribe page failed", slog.String(LogKeyID, id), slog.String(LogKeyError, err.Error()))
+		}
+	})
+}
+
+// unsubscribe builds and, unless a check drops it, emits the unsubscribe
+// event for a confirmed request.
+func (t *Tracker) unsubscribe(r *http.Request, id string) OpenEvent {
+	ip, ipSource := t.clientIP(r)
+	event := t.newEvent(r, id, ip, ipSource)
+	event.Kind = EventUnsubscribe
+	switch {
+	case isPrefetch(r):
+		t.drop(r, DropPrefetch)
+	case t.deniedIP(ip):
+		t.drop(r, DropDeniedIP)
+	case t.limiter != nil && !t.limiter.allow(id, t.anonymizeIP(ip), t.now()):
+		t.drop(r, DropRateLimited)
+	case event.IsBot && t.config.DropBots:
+		t.drop(r, DropBot)
+	case t.filtered(&event):
+		t.traceOutcome(r, string(DropFiltered))
+	default:
+		t.traceOutcome(r, outcomeTracked)
+		t.emit(r.Context(), event)
+	}
+	return event
+}
@@ -0,0 +1,128 @@
+package emailtracker
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// post serves a form POST of body to target through h with the given header
+// name and value pairs.
+func post(h http.Handler, target, body string, header ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Add(header[i], header[i+1])
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func unsubscribeTracker(t *testing.T, cfg Config) (*Tracker, *eventLog, string) {
+	t.Helper()
+	cfg.SigningKey, cfg.UnsubscribePath = []byte("secret"), "/unsubscribe"
+	tr, log := newTestTracker(t, cfg)
+	return tr, log, must(tr.GenerateUnsubscribeLink("user-7"))
+}
+
+func TestUnsubscribeGetOnlyConfirms(t *testing.T) {
+	tr, log, link := unsubscribeTracker(t, Config{})
+	w := get(tr.UnsubscribeHandler(), link)
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `<form method="post"`) {
+		t.Fatalf("GET: %d %q, want the confirmation form", w.Code, w.Body.String())
+	}
+	if n := len(log.all()); n != 0 {
+		t.Fatalf("GET recorded %d events, want none", n)
+	}
+
+	// The form posts back to the signed link.
+	action := formAction(t, w.Body.String())
+	u := must(url.Parse(link))
+	if action != u.RequestURI() {
+		t.Fatalf("form action %q, want %q", action, u.RequestURI())
+	}
+	w = post(tr.UnsubscribeHandler(), action, "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "You have been unsubscribed") {
+		t.Errorf("confirming POST: %d %q, want the unsubscribed page", w.Code, w.Body.String())
+	}
+	if events := log.all(); len(events) != 1 || events[0].Kind != EventUnsubscribe || events[0].ID != "user-7" {
+		t.Errorf("confirming POST recorded %+v, want one unsubscribe for user-7", events)
+	}
+}
+
+// formAction returns the action of the form in page.
+func formAction(t *testing.T, page string) string {
+	t.Helper()
+	_, rest, ok := strings.Cut(page, `action="`)
+	action, _, ok2 := strings.Cut(rest, `"`)
+	if !ok || !ok2 {
+		t.Fatalf("no form action in %q", page)
+	}
+	return strings.ReplaceAll(action, "&amp;", "&")
+}
+
+func TestUnsubscribeOneClick(t *testing.T) {
+	tr, log, link := unsubscribeTracker(t, Config{})
+	w := post(tr.UnsubscribeHandler(), link, "List-Unsubscribe=One-Click")
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("one-click POST: %d %q, want an empty 200", w.Code, w.Body.String())
+	}
+	if events := log.all(); len(events) != 1 || events[0].Kind != EventUnsubscribe {
+		t.Errorf("one-click POST recorded %+v, want one unsubscribe", events)
+	}
+
+	forged := strings.Replace(link, "user-7", "user-8", 1)
+	if w := post(tr.UnsubscribeHandler(), forged, "List-Unsubscribe=One-Click"); w.Code != http.StatusBadRequest {
+		t.Errorf("forged link: %d, want 400", w.Code)
+	}
+	if w := get(tr.UnsubscribeHandler(), forged); w.Code != http.StatusBadRequest {
+		t.Errorf("GET of a forged link: %d, want 400", w.Code)
+	}
+	if n := len(log.all()); n != 1 {
+		t.Errorf("forged link recorded %d more events", n-1)
+	}
+}
+
+func TestUnsubscribeChecks(t *testing.T) {
+	const scanner = "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)"
+	for _, c := range []struct {
+		name   string
+		cfg    Config
+		header []string
+		reason DropReason
+	}{
+		{"prefetch", Config{}, []string{"Sec-Purpose", "prefetch"}, DropPrefetch},
+		{"denied IP", Config{DenyCIDRs: []string{"192.0.2.0/24"}}, nil, DropDeniedIP},
+		{"bot", Config{DropBots: true}, []string{"User-Agent", scanner}, DropBot},
+	} {
+		tr, log, link := unsubscribeTracker(t, c.cfg)
+		w := post(tr.UnsubscribeHandler(), link, "List-Unsubscribe=One-Click", c.header...)
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: %d, want the usual 200", c.name, w.Code)
+		}
+		if n := len(log.all()); n != 0 {
+			t.Errorf("%s: recorded %d events, want none", c.name, n)
+		}
+		if n := tr.Metrics().EventsDropped[c.reason]; n != 1 {
+			t.Errorf("%s: %d drops with reason %s, want 1", c.name, n, c.reason)
+		}
+	}
+
+	tr, log, link := unsubscribeTracker(t, Config{RateLimit: &RateLimit{Rate: 0.001}})
+	for range 3 {
+		post(tr.UnsubscribeHandler(), link, "List-Unsubscribe=One-Click")
+	}
+	if n := len(log.all()); n != 1 || tr.Metrics().EventsDropped[DropRateLimited] != 2 {
+		t.Errorf("rate limited: %d events, %d drops; want 1 and 2", n, tr.Metrics().EventsDropped[DropRateLimited])
+	}
+
+	tr, log, link = unsubscribeTracker(t, Config{})
+	tr.Filter(func(e OpenEvent) bool { return e.Kind != EventUnsubscribe })
+	post(tr.UnsubscribeHandler(), link, "List-Unsubscribe=One-Click")
+	if n := len(log.all()); n != 0 {
+		t.Errorf("filtered: recorded %d events, want none", n)
+	}
+}
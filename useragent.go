@@ -0,0 +1,125 @@
+package emailtracker
+
+import (
+	"regexp"
+	"strings"
+)
+
+// DeviceType classifies the device that loaded the pixel.
+type DeviceType string
+
+const (
+	DeviceDesktop DeviceType = "desktop"
+	DeviceMobile  DeviceType = "mobile"
+	DeviceTablet  DeviceType = "tablet"
+)
+
+// UserAgentInfo is the structured form of a User-Agent header. Fields a
+// parser can't determine are left empty.
+type UserAgentInfo struct {
+	DeviceType    DeviceType `json:"device_type,omitempty"`
+	OS            string     `json:"os,omitempty"`
+	OSVersion     string     `json:"os_version,omitempty"`
+	Client        string     `json:"client,omitempty"`
+	ClientVersion string     `json:"client_version,omitempty"`
+}
+
+// UAParser turns a User-Agent header into UserAgentInfo. Implementations
+// must be safe for concurrent use and should return the zero value rather
+// than fail on input they don't understand.
+type UAParser interface {
+	Parse(ua string) UserAgentInfo
+}
+
+// UAParserFunc adapts a function to the UAParser interface.
+type UAParserFunc func(ua string) UserAgentInfo
+
+func (f UAParserFunc) Parse(ua string) UserAgentInfo { return f(ua) }
+
+// SimpleUAParser is a small regexp-based parser covering common browsers,
+// mail clients and operating systems. Plug in a dedicated library through
+// UAParser if you need more detail.
+type SimpleUAParser struct{}
+
+var (
+	windowsRe = regexp.MustCompile(`Windows NT (\d+\.\d+)`)
+	iosRe     = regexp.MustCompile(`(?:iPhone|CPU) OS (\d+(?:_\d+)*)`)
+	macRe     = regexp.MustCompile(`Mac OS X (\d+(?:[_.]\d+)*)`)
+	androidRe = regexp.MustCompile(`Android (\d+(?:\.\d+)*)`)
+	crosRe    = regexp.MustCompile(`CrOS \S+ (\d+(?:\.\d+)*)`)
+)
+
+var windowsVersions = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.1":  "XP",
+}
+
+// clientRules are tried in order; the first token found names the client.
+var clientRules = []struct {
+	name string
+	re   *regexp.Regexp
+}{
+	{"Outlook", regexp.MustCompile(`Microsoft Outlook[ /](\d+(?:\.\d+)*)`)},
+	{"Thunderbird", regexp.MustCompile(`Thunderbird/(\d+(?:\.\d+)*)`)},
+	{"Edge", regexp.MustCompile(`Edg(?:e|A|iOS)?/(\d+(?:\.\d+)*)`)},
+	{"Opera", regexp.MustCompile(`OPR/(\d+(?:\.\d+)*)`)},
+	{"Samsung Internet", regexp.MustCompile(`SamsungBrowser/(\d+(?:\.\d+)*)`)},
+	{"Chrome", regexp.MustCompile(`(?:Chrome|CriOS)/(\d+(?:\.\d+)*)`)},
+	{"Firefox", regexp.MustCompile(`(?:Firefox|FxiOS)/(\d+(?:\.\d+)*)`)},
+	{"Safari", regexp.MustCompile(`Version/(\d+(?:\.\d+)*).*Safari/`)},
+}
+
+// Parse implements UAParser.
+func (SimpleUAParser) Parse(ua string) UserAgentInfo {
+	var info UserAgentInfo
+	if ua == "" {
+		return info
+	}
+	switch {
+	case strings.Contains(ua, "iPad"):
+		info.OS, info.DeviceType = "iPadOS", DeviceTablet
+		info.OSVersion = dotted(submatch(iosRe, ua))
+	case strings.Contains(ua, "iPhone") || strings.Contains(ua, "iPod"):
+		info.OS, info.DeviceType = "iOS", DeviceMobile
+		info.OSVersion = dotted(submatch(iosRe, ua))
+	case strings.Contains(ua, "Android"):
+		info.OS, info.OSVersion = "Android", submatch(androidRe, ua)
+		info.DeviceType = DeviceTablet
+		if strings.Contains(ua, "Mobile") {
+			info.DeviceType = DeviceMobile
+		}
+	case strings.Contains(ua, "Windows"):
+		info.OS, info.DeviceType = "Windows", DeviceDesktop
+		info.OSVersion = windowsVersions[submatch(windowsRe, ua)]
+	case strings.Contains(ua, "Mac OS X") || strings.Contains(ua, "Macintosh"):
+		info.OS, info.DeviceType = "macOS", DeviceDesktop
+		info.OSVersion = dotted(submatch(macRe, ua))
+	case strings.Contains(ua, "CrOS"):
+		info.OS, info.DeviceType = "ChromeOS", DeviceDesktop
+		info.OSVersion = submatch(crosRe, ua)
+	case strings.Contains(ua, "Linux"):
+		info.OS, info.DeviceType = "Linux", DeviceDesktop
+	}
+	for _, rule := range clientRules {
+		if m := rule.re.FindStringSubmatch(ua); m != nil {
+			info.Client, info.ClientVersion = rule.name, m[1]
+			break
+		}
+	}
+	return info
+}
+
+func submatch(re *regexp.Regexp, s string) string {
+	if m := re.FindStringSubmatch(s); m != nil {
+		return m[1]
+	}
+	return ""
+}
+
+func dotted(v string) string {
+	return strings.ReplaceAll(v, "_", ".")
+}
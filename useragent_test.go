@@ -0,0 +1,71 @@
+package emailtracker
+
+import "testing"
+
+var userAgents = []struct {
+	ua   string
+	want UserAgentInfo
+}{
+	{"", UserAgentInfo{}},
+	{"not a user agent", UserAgentInfo{}},
+	{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.91 Safari/537.36",
+		UserAgentInfo{DeviceDesktop, "Windows", "10", "Chrome", "124.0.6367.91"}},
+	{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36 Edg/124.0.2478.67",
+		UserAgentInfo{DeviceDesktop, "Windows", "10", "Edge", "124.0.2478.67"}},
+	{"Microsoft Office/16.0 (Windows NT 6.1; Microsoft Outlook 16.0.5422; Pro)",
+		UserAgentInfo{DeviceDesktop, "Windows", "7", "Outlook", "16.0.5422"}},
+	{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.4.1 Safari/605.1.15",
+		UserAgentInfo{DeviceDesktop, "macOS", "10.15.7", "Safari", "17.4.1"}},
+	{"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:125.0) Gecko/20100101 Thunderbird/115.10.1",
+		UserAgentInfo{DeviceDesktop, "macOS", "10.15", "Thunderbird", "115.10.1"}},
+	{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Mobile/15E148",
+		UserAgentInfo{DeviceMobile, "iOS", "17.4.1", "", ""}},
+	{"Mozilla/5.0 (iPhone; CPU iPhone OS 17_4 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/124.0.6367.88 Mobile/15E148 Safari/604.1",
+		UserAgentInfo{DeviceMobile, "iOS", "17.4", "Chrome", "124.0.6367.88"}},
+	{"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.6 Mobile/15E148 Safari/604.1",
+		UserAgentInfo{DeviceTablet, "iPadOS", "16.6", "Safari", "16.6"}},
+	{"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0.6367.82 Mobile Safari/537.36",
+		UserAgentInfo{DeviceMobile, "Android", "14", "Chrome", "124.0.6367.82"}},
+	{"Mozilla/5.0 (Linux; Android 13; SM-X700) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/24.0 Chrome/117.0 Safari/537.36",
+		UserAgentInfo{DeviceTablet, "Android", "13", "Samsung Internet", "24.0"}},
+	{"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36",
+		UserAgentInfo{DeviceDesktop, "ChromeOS", "14541.0.0", "Chrome", "124.0"}},
+	{"Mozilla/5.0 (X11; Ubuntu; Linux x86_64; rv:125.0) Gecko/20100101 Firefox/125.0",
+		UserAgentInfo{DeviceDesktop, "Linux", "", "Firefox", "125.0"}},
+	{"Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/124.0 Safari/537.36 OPR/109.0.0.0",
+		UserAgentInfo{DeviceDesktop, "Windows", "10", "Opera", "109.0.0.0"}},
+	{"Mozilla/5.0 (Windows NT 11.0)", UserAgentInfo{DeviceDesktop, "Windows", "", "", ""}},
+}
+
+func TestSimpleUAParser(t *testing.T) {
+	for _, tt := range userAgents {
+		if got := (SimpleUAParser{}).Parse(tt.ua); got != tt.want {
+			t.Errorf("Parse(%q)\n got %+v\nwant %+v", tt.ua, got, tt.want)
+		}
+	}
+}
+
+func TestUAParserConfig(t *testing.T) {
+	tr, log := newTestTracker(t, Config{})
+	ua := userAgents[2].ua
+	get(tr.Handler(), tr.GenerateLink("msg-1"), "User-Agent", ua)
+	if info := log.wait(t, 1)[0].UserAgentInfo; info != (UserAgentInfo{}) {
+		t.Errorf("parsed %+v without a UAParser", info)
+	}
+
+	custom := UAParserFunc(func(string) UserAgentInfo { return UserAgentInfo{Client: "custom"} })
+	tr, log = newTestTracker(t, Config{UAParser: custom})
+	get(tr.Handler(), tr.GenerateLink("msg-1"), "User-Agent", ua)
+	if info := log.wait(t, 1)[0].UserAgentInfo; info.Client != "custom" {
+		t.Errorf("Client %q, want the custom parser's", info.Client)
+	}
+}
+
+func BenchmarkSimpleUAParser(b *testing.B) {
+	b.ReportAllocs()
+	for b.Loop() {
+		for _, tt := range userAgents {
+			(SimpleUAParser{}).Parse(tt.ua)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package emailtracker
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// checkDomain validates a link domain set by field.
+func checkDomain(field, d string) error {
+	switch {
+	case d == "":
+		return fmt.Errorf("emailtracker: %s is required", field)
+	case strings.Contains(d, "://"):
+		return fmt.Errorf("emailtracker: %s %q must not include a scheme; set Scheme instead", field, d)
+	case !validHost(d) || strings.TrimSpace(d) != d:
+		return fmt.Errorf("emailtracker: %s %q is not a host or host:port", field, d)
+	}
+	return nil
+}
+
+func checkScheme(field, s string) error {
+	if s != "http" && s != "https" {
+		return fmt.Errorf("emailtracker: %s must be \"http\" or \"https\", got %q", field, s)
+	}
+	return nil
+}
+
+// Validate checks cfg for settings New would reject, or that would only
+// fail later as broken links or a failing Start. Each problem is reported
+// with the field it concerns; the returned error joins all of them.
+func (c Config) Validate() error {
+	return c.validate(false)
+}
+
+// validate is Validate, except that lenient skips the checks NewTracker
+// didn't make before Validate existed: those of Port, Domain, Path and the
+// TLS files.
+func (c Config) validate(lenient bool) error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if !lenient {
+		add(c.validateAddress())
+	}
+	if c.SocketPath != "" && c.Port != 0 {
+		add(errors.New("emailtracker: Port and SocketPath are mutually exclusive"))
+	}
+	if c.SocketMode&^os.ModePerm != 0 {
+		add(fmt.Errorf("emailtracker: SocketMode %v has bits other than permissions", c.SocketMode))
+	}
+	for _, p := range []struct{ name, v string }{
+		{"ClickPath", c.ClickPath},
+		{"UnsubscribePath", c.UnsubscribePath},
+		{"ESPWebhookPath", c.ESPWebhookPath},
+		{"StreamPath", c.StreamPath},
+		{"AdminPath", c.AdminPath},
+		{"HealthPath", c.HealthPath},
+		{"ReadyPath", c.ReadyPath},
+	} {
+		if p.v != "" && !strings.HasPrefix(p.v, "/") {
+			add(fmt.Errorf("emailtracker: %s %q must start with \"/\"", p.name, p.v))
+		}
+	}
+
+	if c.Scheme != "" {
+		add(checkScheme("Scheme", c.Scheme))
+	}
+	add(validateServerLimits(c))
+
+	if c.IDParam != "" {
+		add(validIDParam(c.IDParam))
+		if c.Beacon && c.IDParam == modeParam {
+			add(errors.New("emailtracker: IDParam \"mode\" is reserved with Beacon"))
+		}
+	}
+	_, err := c.pixel()
+	add(err)
+	_, err = compileBots(c.BotPatterns)
+	add(err)
+	_, err = compileProxies(c.ImageProxies)
+	add(err)
+	_, err = compileClients(c.EmailClientRules)
+	add(err)
+	_, err = compileOrigins(c.AllowedOrigins)
+	add(err)
+	for _, p := range []struct {
+		name string
+		v    []string
+	}{{"TrustedProxies", c.TrustedProxies}, {"DenyCIDRs", c.DenyCIDRs}, {"AllowCIDRs", c.AllowCIDRs}} {
+		_, err = parsePrefixes(p.name, p.v)
+		add(err)
+	}
+
+	if c.AnonymizeIP == AnonymizeHash && len(c.AnonymizeSalt) == 0 {
+		add(errors.New("emailtracker: AnonymizeHash requires AnonymizeSalt"))
+	}
+	_, err = newKeyring(c)
+	add(err)
+	if c.ClickPath != "" && len(c.SigningKey) == 0 {
+		add(errors.New("emailtracker: ClickPath requires SigningKey"))
+	}
+	if c.UnsubscribePath != "" && len(c.SigningKey) == 0 {
+		add(errors.New("emailtracker: UnsubscribePath requires SigningKey"))
+	}
+	if c.ESP.SendGridPublicKey != "" {
+		_, err := parseSendGridKey(c.ESP.SendGridPublicKey)
+		add(err)
+	}
+	if len(c.EncryptionKey) > 0 {
+		if _, err := newAEAD(c.EncryptionKey); err != nil {
+			add(fmt.Errorf("emailtracker: EncryptionKey: %w", err))
+		}
+	}
+	if c.ReverseDNS && c.Workers <= 0 {
+		add(errors.New("emailtracker: ReverseDNS requires Workers > 0"))
+	}
+	if c.CallbackTimeout < 0 {
+		add(fmt.Errorf("emailtracker: negative CallbackTimeout %s", c.CallbackTimeout))
+	}
+	if c.SampleRate < 0 || c.SampleRate > 1 {
+		add(fmt.Errorf("emailtracker: SampleRate must be between 0 and 1, got %g", c.SampleRate))
+	}
+	if c.RateLimit != nil && c.RateLimit.Rate <= 0 {
+		add(errors.New("emailtracker: RateLimit.Rate must be positive"))
+	}
+	if (c.CDNClientIP || c.CDNCountry) && len(c.TrustedProxies) == 0 {
+		add(errors.New("emailtracker: CDNClientIP and CDNCountry require TrustedProxies"))
+	}
+	if c.Confidence != nil {
+		add(c.Confidence.validate())
+	}
+	if c.Webhook != nil && c.Webhook.URL == "" {
+		add(errors.New("emailtracker: Webhook.URL is required"))
+	}
+	if c.AccessLog != nil {
+		add(c.AccessLog.validate())
+	}
+	if c.StreamPath != "" && c.StreamToken == "" {
+		add(errors.New("emailtracker: StreamPath requires StreamToken"))
+	}
+	if c.AdminPath != "" && c.AdminToken == "" {
+		add(errors.New("emailtracker: AdminPath requires AdminToken"))
+	}
+	if admin := strings.TrimSuffix(c.AdminPath, "/"); admin != "" && (c.Path == admin || strings.HasPrefix(c.Path, admin+"/")) {
+		add(fmt.Errorf("emailtracker: Path %q must not be under AdminPath %q", c.Path, c.AdminPath))
+	}
+	return errors.Join(errs...)
+}
+
+// validateAddress checks where the tracker listens and what its links look
+// like: Port, Domain, Path and the TLS files.
+func (c Config) validateAddress() error {
+	var errs []error
+	if c.Port < 0 || c.Port > 65535 {
+		errs = append(errs, fmt.Errorf("emailtracker: Port %d out of range [0, 65535]", c.Port))
+	}
+	errs = append(errs, checkDomain("Domain", c.Domain))
+	if !strings.HasPrefix(c.Path, "/") {
+		errs = append(errs, fmt.Errorf("emailtracker: Path %q must start with \"/\"", c.Path))
+	}
+	if (c.TLSCertFile == "") != (c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("emailtracker: TLSCertFile and TLSKeyFile must be set together"))
+	}
+	if c.Scheme == "http" && (c.TLSCertFile != "" || (c.TLSConfig != nil && len(c.TLSConfig.Certificates) > 0)) {
+		errs = append(errs, errors.New("emailtracker: Scheme \"http\" conflicts with the TLS settings"))
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,251 @@
+package emailtracker
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WebhookConfig configures delivery of events as signed HTTP POSTs.
+type WebhookConfig struct {
+	URL    string // endpoint receiving the events
+	Secret []byte // HMAC-SHA256 key for the X-Signature header
+
+	MaxAttempts    int           // delivery attempts per event, default 5
+	InitialBackoff time.Duration // wait after the first failure, default 500ms
+	MaxBackoff     time.Duration // cap on the doubling backoff, default 30s
+	QueueSize      int           // events buffered for delivery, default 1024
+	Client         *http.Client  // default: http.Client with a 10s timeout
+
+	// BatchSize, when above 1, POSTs events as a JSON array of up to
+	// BatchSize events, sent when full or when the oldest event has waited
+	// BatchMaxAge (default 5s). The signature covers the whole array. A
+	// failed batch is retried as a whole, so the receiver may see events
+	// again after a partial failure on its side and should deduplicate.
+	BatchSize   int
+	BatchMaxAge time.Duration
+}
+
+// ErrWebhookDelivery is reported through the error hook for events the
+// webhook gave up on, after retries or because its queue was full.
+var ErrWebhookDelivery = errors.New("emailtracker: webhook delivery failed")
+
+// SignatureHeader carries "sha256=" followed by the hex HMAC-SHA256 of the
+// request body under WebhookConfig.Secret.
+const SignatureHeader = "X-Signature"
+
+// webhook delivers events on a background goroutine so the pixel response
+// never waits on the receiving endpoint.
+type webhook struct {
+	cfg    WebhookConfig
+	report func(error, *OpenEvent)
+	span   spanStarter
+	queue  chan OpenEvent
+
+	ctx    context.Context // cancelled when shutdown runs out of time
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+// spanStarter is the signature of Tracker.startSpan.
+type spanStarter func(ctx context.Context, name string, attrs ...slog.Attr) (context.Context, func(error))
+
+func newWebhook(cfg WebhookConfig, report func(error, *OpenEvent), span spanStarter) *webhook {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 5
+	}
+	if cfg.InitialBackoff <= 0 {
+		cfg.InitialBackoff = 500 * time.Millisecond
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = 30 * time.Second
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.BatchMaxAge <= 0 {
+		cfg.BatchMaxAge = 5 * time.Second
+	}
+	w := &webhook{
+		cfg:    cfg,
+		report: report,
+		span:   span,
+		queue:  make(chan OpenEvent, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	if cfg.BatchSize > 1 {
+		go w.runBatches()
+	} else {
+		go w.run()
+	}
+	return w
+}
+
+// enqueue schedules e for delivery, reporting it as failed if the queue is
+// full or closed.
+func (w *webhook) enqueue(e OpenEvent) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	if !w.closed {
+		select {
+		case w.queue <- e:
+			return
+		default:
+		}
+	}
+	w.report(fmt.Errorf("%w: queue full", ErrWebhookDelivery), &e)
+}
+
+func (w *webhook) run() {
+	defer close(w.done)
+	for e := range w.queue {
+		if err := w.send(e); err != nil {
+			w.report(fmt.Errorf("%w: %w", ErrWebhookDelivery, err), &e)
+		}
+	}
+}
+
+// runBatches collects events into batches, sending each when it is full or
+// its oldest event reaches BatchMaxAge, and the remainder once the queue is
+// closed.
+func (w *webhook) runBatches() {
+	defer close(w.done)
+	batch := make([]OpenEvent, 0, w.cfg.BatchSize)
+	timer := time.NewTimer(w.cfg.BatchMaxAge)
+	timer.Stop()
+	flush := func() {
+		timer.Stop()
+		if len(batch) == 0 {
+			return
+		}
+		if err := w.sendBatch(batch); err != nil {
+			err = fmt.Errorf("%w: batch of %d: %w", ErrWebhookDelivery, len(batch), err)
+			for i := range batch {
+				w.report(err, &batch[i])
+			}
+		}
+		batch = make([]OpenEvent, 0, w.cfg.BatchSize)
+	}
+	for {
+		select {
+		case e, ok := <-w.queue:
+			if !ok {
+				flush()
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(w.cfg.BatchMaxAge)
+			}
+			batch = append(batch, e)
+			if len(batch) >= w.cfg.BatchSize {
+				flush()
+			}
+		case <-timer.C:
+			flush()
+		}
+	}
+}
+
+func (w *webhook) sendBatch(events []OpenEvent) error {
+	body, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	return w.post(body, len(events))
+}
+
+// send POSTs e, retrying network errors, 408, 429 and 5xx responses with
+// exponential backoff.
+func (w *webhook) send(e OpenEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return w.post(body, 1)
+}
+
+// post delivers body, carrying n events, in a SpanWebhookDeliver span.
+func (w *webhook) post(body []byte, n int) (err error) {
+	ctx, end := w.span(w.ctx, SpanWebhookDeliver, slog.Int(TraceKeyEvents, n))
+	defer func() { end(err) }()
+	mac := hmac.New(sha256.New, w.cfg.Secret)
+	mac.Write(body)
+	sig := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	backoff := w.cfg.InitialBackoff
+	var lastErr error
+	for attempt := 1; attempt <= w.cfg.MaxAttempts; attempt++ {
+		retry, err := w.attempt(ctx, body, sig)
+		if err == nil {
+			return nil
+		}
+		lastErr = err
+		if !retry || attempt == w.cfg.MaxAttempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-w.ctx.Done():
+			return fmt.Errorf("giving up after %d attempts: %w", attempt, lastErr)
+		}
+		backoff = min(backoff*2, w.cfg.MaxBackoff)
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", w.cfg.MaxAttempts, lastErr)
+}
+
+func (w *webhook) attempt(ctx context.Context, body []byte, sig string) (retry bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, sig)
+	resp, err := w.cfg.Client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s responded %s", w.cfg.URL, resp.Status)
+	retry = resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests ||
+		resp.StatusCode == http.StatusRequestTimeout
+	return retry, err
+}
+
+// close stops accepting events and waits for queued deliveries. If ctx ends
+// first, pending retries are abandoned.
+func (w *webhook) close(ctx context.Context) error {
+	w.mu.Lock()
+	if !w.closed {
+		w.closed = true
+		close(w.queue)
+	}
+	w.mu.Unlock()
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.cancel()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,250 @@
+package emailtracker
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// delivery is one request received by a webhook test server.
+type delivery struct {
+	sig  string
+	body []byte
+}
+
+// webhookServer answers POSTs with the statuses in order, then 200, sending
+// each request it receives on the returned channel.
+func webhookServer(t *testing.T, statuses ...int) (*httptest.Server, <-chan delivery, *atomic.Int32) {
+	t.Helper()
+	deliveries := make(chan delivery, 16)
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		n := int(calls.Add(1))
+		deliveries <- delivery{r.Header.Get(SignatureHeader), body}
+		if n <= len(statuses) {
+			w.WriteHeader(statuses[n-1])
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv, deliveries, &calls
+}
+
+func receive(t *testing.T, c <-chan delivery) delivery {
+	t.Helper()
+	select {
+	case d := <-c:
+		return d
+	case <-time.After(5 * time.Second):
+		t.Fatal("no webhook delivery")
+		return delivery{}
+	}
+}
+
+func sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestWebhookRetriesThenSucceeds(t *testing.T) {
+	srv, deliveries, calls := webhookServer(t, http.StatusServiceUnavailable, http.StatusInternalServerError)
+	secret := []byte("webhook-secret")
+	tr, _ := newTestTracker(t, Config{Webhook: &WebhookConfig{URL: srv.URL, Secret: secret, InitialBackoff: time.Millisecond}})
+	errs := make(chan error, 1)
+	tr.OnError(func(err error, _ *OpenEvent) { errs <- err })
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+
+	for range 3 {
+		d := receive(t, deliveries)
+		if d.sig != sign(secret, d.body) {
+			t.Errorf("signature %q doesn't match the body", d.sig)
+		}
+		var e OpenEvent
+		if err := json.Unmarshal(d.body, &e); err != nil || e.ID != "msg-1" {
+			t.Errorf("body %s: %v", d.body, err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+	select {
+	case err := <-errs:
+		t.Errorf("reported %v after a successful retry", err)
+	default:
+	}
+}
+
+func TestWebhookGivesUp(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		status   int
+		attempts int32
+	}{
+		{"server errors", http.StatusBadGateway, 3},
+		{"client error", http.StatusBadRequest, 1},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, calls := webhookServer(t, tt.status, tt.status, tt.status)
+			tr, _ := newTestTracker(t, Config{Webhook: &WebhookConfig{URL: srv.URL, MaxAttempts: 3, InitialBackoff: time.Millisecond}})
+			type failure struct {
+				err error
+				e   *OpenEvent
+			}
+			failed := make(chan failure, 1)
+			tr.OnError(func(err error, e *OpenEvent) { failed <- failure{err, e} })
+			get(tr.Handler(), tr.GenerateLink("msg-1"))
+
+			select {
+			case f := <-failed:
+				if !errors.Is(f.err, ErrWebhookDelivery) || f.e == nil || f.e.ID != "msg-1" {
+					t.Errorf("reported %v for %+v, want ErrWebhookDelivery for msg-1", f.err, f.e)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("failed delivery not reported")
+			}
+			if n := calls.Load(); n != tt.attempts {
+				t.Errorf("%d attempts, want %d", n, tt.attempts)
+			}
+		})
+	}
+}
+
+func TestWebhookDoesNotDelayPixel(t *testing.T) {
+	received, release := make(chan struct{}), make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		close(received)
+		<-release
+	}))
+	defer srv.Close()
+	tr, _ := newTestTracker(t, Config{Webhook: &WebhookConfig{URL: srv.URL}})
+
+	start := time.Now()
+	if w := get(tr.Handler(), tr.GenerateLink("msg-1")); w.Code != http.StatusOK {
+		t.Fatalf("status %d", w.Code)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("pixel took %v while the webhook hung", d)
+	}
+	<-received
+	close(release)
+}
+
+// batchIDs decodes a batch delivery into its event IDs.
+func batchIDs(t *testing.T, d delivery) []string {
+	t.Helper()
+	var batch []OpenEvent
+	if err := json.Unmarshal(d.body, &batch); err != nil {
+		t.Fatalf("batch body %s: %v", d.body, err)
+	}
+	ids := make([]string, len(batch))
+	for i, e := range batch {
+		ids[i] = e.ID
+	}
+	return ids
+}
+
+func TestWebhookBatches(t *testing.T) {
+	srv, deliveries, calls := webhookServer(t)
+	secret := []byte("webhook-secret")
+	tr, _ := newTestTracker(t, Config{Webhook: &WebhookConfig{URL: srv.URL, Secret: secret, BatchSize: 3, BatchMaxAge: time.Hour}})
+	for i := range 7 {
+		get(tr.Handler(), tr.GenerateLink(fmt.Sprintf("msg-%d", i)))
+	}
+	for _, want := range []string{"msg-0 msg-1 msg-2", "msg-3 msg-4 msg-5"} {
+		d := receive(t, deliveries)
+		if got := strings.Join(batchIDs(t, d), " "); got != want {
+			t.Errorf("batch %s, want %s", got, want)
+		}
+		if d.sig != sign(secret, d.body) {
+			t.Errorf("signature %q doesn't cover the bytes sent", d.sig)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Errorf("%d POSTs before the last batch filled, want 2", n)
+	}
+
+	// Shutdown sends the partial batch.
+	shutdown(t, tr)
+	if got := batchIDs(t, receive(t, deliveries)); len(got) != 1 || got[0] != "msg-6" {
+		t.Errorf("flushed batch %v, want msg-6", got)
+	}
+}
+
+func TestWebhookBatchMaxAge(t *testing.T) {
+	srv, deliveries, _ := webhookServer(t)
+	tr, _ := newTestTracker(t, Config{Webhook: &WebhookConfig{URL: srv.URL, BatchSize: 100, BatchMaxAge: 20 * time.Millisecond}})
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	get(tr.Handler(), tr.GenerateLink("msg-2"))
+	if got := batchIDs(t, receive(t, deliveries)); strings.Join(got, " ") != "msg-1 msg-2" {
+		t.Errorf("aged batch %v", got)
+	}
+	// The age counts from the oldest event of the next batch.
+	get(tr.Handler(), tr.GenerateLink("msg-3"))
+	if got := batchIDs(t, receive(t, deliveries)); strings.Join(got, " ") != "msg-3" {
+		t.Errorf("second aged batch %v", got)
+	}
+}
+
+func TestWebhookBatchRetriesThenSucceeds(t *testing.T) {
+	srv, deliveries, calls := webhookServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	secret := []byte("webhook-secret")
+	tr, _ := newTestTracker(t, Config{Webhook: &WebhookConfig{URL: srv.URL, Secret: secret, BatchSize: 2, InitialBackoff: time.Millisecond}})
+	errs := recordErrors(tr)
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	get(tr.Handler(), tr.GenerateLink("msg-2"))
+
+	first := receive(t, deliveries)
+	for range 2 {
+		d := receive(t, deliveries)
+		if string(d.body) != string(first.body) || d.sig != first.sig {
+			t.Errorf("retry sent %s, want the whole batch %s again", d.body, first.body)
+		}
+	}
+	if first.sig != sign(secret, first.body) {
+		t.Errorf("signature %q doesn't cover the bytes sent", first.sig)
+	}
+	shutdown(t, tr)
+	if n := calls.Load(); n != 3 {
+		t.Errorf("%d attempts, want 3", n)
+	}
+	if got := errs.all(); len(got) != 0 {
+		t.Errorf("reported %v after a successful retry", got)
+	}
+}
+
+func TestWebhookBatchGivesUp(t *testing.T) {
+	srv, _, _ := webhookServer(t, http.StatusBadGateway, http.StatusBadGateway)
+	tr, _ := newTestTracker(t, Config{Webhook: &WebhookConfig{URL: srv.URL, BatchSize: 2, MaxAttempts: 2, InitialBackoff: time.Millisecond}})
+	var (
+		mu     sync.Mutex
+		failed []string
+	)
+	tr.OnError(func(err error, e *OpenEvent) {
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(err, ErrWebhookDelivery) && e != nil {
+			failed = append(failed, e.ID)
+		}
+	})
+	get(tr.Handler(), tr.GenerateLink("msg-1"))
+	get(tr.Handler(), tr.GenerateLink("msg-2"))
+	shutdown(t, tr)
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(failed, " ") != "msg-1 msg-2" {
+		t.Errorf("failures reported for %v, want every event of the batch", failed)
+	}
+}